@@ -1,27 +1,102 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
+
+	"github.com/celskeggs/vacuum-table/clock"
 )
 
 type Config struct {
 	BearerToken string `json:"token"`
+	// TokenSource, if not nil, supplies the token for each request in place of BearerToken, such as an OAuthSource.
+	TokenSource TokenSource `json:"-"`
+	// Retries is how many times a request is retried after a transient failure, such as a 429 or 5xx response.
+	Retries int `json:"retries"`
+	// WriteBatchSize is the number of records sent in each create or update request, at most
+	// DefaultWriteBatchSize; zero means DefaultWriteBatchSize.
+	WriteBatchSize int `json:"write-batch-size,omitempty"`
+	// RequestsPerSecond is the rate limit that requests to each base are expected to stay under; zero means
+	// DefaultRequestsPerSecond.
+	RequestsPerSecond float64 `json:"requests-per-second,omitempty"`
+	// Typecast asks AirTable to convert written values to the types of their fields, creating select options as
+	// needed.
+	Typecast bool `json:"typecast,omitempty"`
+	// Clock is used for retry delays, rate tracking, and timestamps; nil means the wall clock.
+	Clock clock.Clock `json:"-"`
+	// UnknownFields says what to do with the fields of AirTable's responses that this version does not know:
+	// UnknownFieldsIgnore them (the default), UnknownFieldsLog them once each, or fail with UnknownFieldsError, which
+	// returns an UnknownFieldError.
+	UnknownFields string `json:"unknown-fields,omitempty"`
+	// OnRetry, if not nil, is called with the error of each failed request that is about to be retried.
+	OnRetry func(err error) `json:"-"`
+	// OnPage, if not nil, is called after each page of a table is listed, with the number of records listed so far.
+	OnPage func(table string, records int) `json:"-"`
+	// RateMonitors, if not nil, lets the Clerks made with this configuration track their request rate together, for
+	// each base; if nil, each Clerk only tracks its own requests.
+	RateMonitors *RateMonitors `json:"-"`
+}
+
+func (c Config) Validate() error {
+	if c.TokenSource == nil {
+		if _, err := ClassifyToken(c.BearerToken); err != nil {
+			return err
+		}
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("invalid retries: %d", c.Retries)
+	}
+	if c.WriteBatchSize < 0 || c.WriteBatchSize > DefaultWriteBatchSize {
+		return fmt.Errorf("invalid write-batch-size: %d (AirTable accepts 1 to %d records per request)",
+			c.WriteBatchSize, DefaultWriteBatchSize)
+	}
+	if c.RequestsPerSecond < 0 {
+		return fmt.Errorf("invalid requests-per-second: %v", c.RequestsPerSecond)
+	}
+	switch c.UnknownFields {
+	case "", UnknownFieldsIgnore, UnknownFieldsLog, UnknownFieldsError:
+	default:
+		return fmt.Errorf("invalid unknown-fields: %q", c.UnknownFields)
+	}
+	return nil
+}
+
+func (c Config) RateLimit() float64 {
+	if c.RequestsPerSecond == 0 {
+		return DefaultRequestsPerSecond
+	}
+	return c.RequestsPerSecond
+}
+
+func (c Config) BatchSize() int {
+	if c.WriteBatchSize == 0 {
+		return DefaultWriteBatchSize
+	}
+	return c.WriteBatchSize
 }
 
 type Clerk struct {
 	Config
 	App    string
 	Client *http.Client
+
+	// monitor is nil for Clerks not made by NewClerk, which do not track their request rate.
+	monitor *RateMonitor
 }
 
 func NewClerk(app string, config Config, client *http.Client) *Clerk {
+	monitors := config.RateMonitors
+	if monitors == nil {
+		monitors = &RateMonitors{}
+	}
 	return &Clerk{
-		App:    app,
-		Config: config,
-		Client: client,
+		App:     app,
+		Config:  config,
+		Client:  client,
+		monitor: monitors.For(app, config.RateLimit()),
 	}
 }
 
@@ -48,55 +123,204 @@ type Record struct {
 	Fields      map[string]interface{} `json:"fields"`
 }
 
-func (c *Clerk) ListRecordsPage(table, offset string) (*ListRecordsReply, error) {
-	if !strings.HasPrefix(c.BearerToken, "key") || !IsAirTableId(c.BearerToken) {
-		return nil, fmt.Errorf("invalid API key")
+func (c *Clerk) checkTable(table string) error {
+	if err := c.checkToken(); err != nil {
+		return err
 	}
 	if !IsAirTableId(c.App) {
-		return nil, fmt.Errorf("not a valid app ID: %q", c.App)
+		return fmt.Errorf("not a valid app ID: %q", c.App)
 	}
 	if !IsAirTableId(table) {
-		return nil, fmt.Errorf("not a valid table ID: %q", table)
+		return fmt.Errorf("not a valid table ID: %q", table)
 	}
-	var suffix string
+	return nil
+}
+
+// MaxPageSize is the largest number of records that AirTable returns in one page.
+const MaxPageSize = 100
+
+// SortField orders listed records by a field, in the "asc" (the default) or "desc" Direction.
+type SortField struct {
+	Field     string
+	Direction string
+}
+
+// ListRecordsOptions selects which records are listed and how. The zero value lists every field of every record.
+type ListRecordsOptions struct {
+	// Formula, if not empty, only lists the records for which this AirTable formula evaluates to true.
+	Formula string
+	// View, if not empty, only lists the records in this view, by name or ID, in the view's order.
+	View string
+	// Fields, if not empty, only includes these fields, by name or ID, in each record.
+	Fields []string
+	// Sort orders the records by these fields, in turn, overriding the order of the view.
+	Sort []SortField
+	// PageSize is the number of records in each page; zero means MaxPageSize.
+	PageSize int
+	// MaxRecords, if not zero, stops listing after this many records.
+	MaxRecords int
+}
+
+func (o ListRecordsOptions) validate() error {
+	if o.PageSize < 0 || o.PageSize > MaxPageSize {
+		return fmt.Errorf("invalid page size %d: must be at most %d", o.PageSize, MaxPageSize)
+	}
+	if o.MaxRecords < 0 {
+		return fmt.Errorf("invalid maximum number of records: %d", o.MaxRecords)
+	}
+	for _, sort := range o.Sort {
+		if sort.Field == "" {
+			return fmt.Errorf("no field given to sort by")
+		}
+		if sort.Direction != "" && sort.Direction != "asc" && sort.Direction != "desc" {
+			return fmt.Errorf("invalid sort direction %q for field %q", sort.Direction, sort.Field)
+		}
+	}
+	return nil
+}
+
+func (o ListRecordsOptions) query() url.Values {
+	query := url.Values{}
+	if o.Formula != "" {
+		query.Set("filterByFormula", o.Formula)
+	}
+	if o.View != "" {
+		query.Set("view", o.View)
+	}
+	for _, field := range o.Fields {
+		query.Add("fields[]", field)
+	}
+	for i, sort := range o.Sort {
+		query.Set(fmt.Sprintf("sort[%d][field]", i), sort.Field)
+		if sort.Direction != "" {
+			query.Set(fmt.Sprintf("sort[%d][direction]", i), sort.Direction)
+		}
+	}
+	if o.PageSize != 0 {
+		query.Set("pageSize", strconv.Itoa(o.PageSize))
+	}
+	if o.MaxRecords != 0 {
+		query.Set("maxRecords", strconv.Itoa(o.MaxRecords))
+	}
+	return query
+}
+
+// ListRecordsPage fetches one page of records, retrying transient failures up to Retries times.
+func (c *Clerk) ListRecordsPage(ctx context.Context, table, offset string) (*ListRecordsReply, error) {
+	return c.listRecordsPage(ctx, table, offset, ListRecordsOptions{})
+}
+
+func (c *Clerk) listRecordsPage(ctx context.Context, table, offset string,
+	opts ListRecordsOptions) (*ListRecordsReply, error) {
+	if err := c.checkTable(table); err != nil {
+		return nil, err
+	}
+	var result *ListRecordsReply
+	err := c.retry(ctx, true, func() (err error) {
+		result, err = c.listRecordsPageOnce(ctx, table, offset, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Clerk) listRecordsPageOnce(ctx context.Context, table, offset string,
+	opts ListRecordsOptions) (*ListRecordsReply, error) {
+	query := opts.query()
 	if offset != "" {
-		suffix = "?offset=" + offset
+		query.Set("offset", offset)
 	}
-	req, err := http.NewRequest(http.MethodGet, "https://api.airtable.com/v0/"+c.App+"/"+table+suffix, nil)
+	var suffix string
+	if len(query) > 0 {
+		suffix = "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.airtable.com/v0/"+c.App+"/"+table+suffix, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+c.BearerToken)
-	response, err := c.Client.Do(req)
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	response, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		_ = response.Body.Close()
 	}()
+	checkDeprecation(response)
 	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("status code was not 200, but rather %d %q", response.StatusCode, response.Status)
+		return nil, newStatusError(response, clock.Or(c.Clock).Now())
 	}
 	var result ListRecordsReply
-	decoder := json.NewDecoder(response.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&result); err != nil {
+	if err := c.decodeResponse(response, &result); err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (c *Clerk) ListRecordsAll(table string) ([]Record, error) {
+// do sends a request against the Clerk's base, keeping track of the request rate.
+func (c *Clerk) do(req *http.Request) (*http.Response, error) {
+	if c.monitor != nil {
+		c.monitor.Request(clock.Or(c.Clock).Now())
+	}
+	response, err := c.Client.Do(req)
+	if err == nil && response.StatusCode == http.StatusTooManyRequests && c.monitor != nil {
+		c.monitor.Throttled(clock.Or(c.Clock).Now())
+	}
+	return response, err
+}
+
+// ListRecordsAll fetches every page of records in a table. It stops early with the context's error if ctx is
+// cancelled.
+func (c *Clerk) ListRecordsAll(ctx context.Context, table string) ([]Record, error) {
+	return c.ListRecordsFiltered(ctx, table, "")
+}
+
+// ListRecordsFiltered fetches every record in a table for which the AirTable formula evaluates to true. An empty
+// formula matches every record.
+func (c *Clerk) ListRecordsFiltered(ctx context.Context, table, formula string) ([]Record, error) {
+	return c.ListRecords(ctx, table, ListRecordsOptions{Formula: formula})
+}
+
+// ListRecords fetches every page of the records in a table that the options select.
+func (c *Clerk) ListRecords(ctx context.Context, table string, opts ListRecordsOptions) ([]Record, error) {
 	var records []Record
+	err := c.ListRecordsPages(ctx, table, opts, func(page []Record) error {
+		records = append(records, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ListRecordsPages calls fn with each page of the records in a table that the options select, as it is fetched, so
+// that the whole table never has to be held in memory. An error from fn stops the listing and is returned.
+func (c *Clerk) ListRecordsPages(ctx context.Context, table string, opts ListRecordsOptions,
+	fn func(page []Record) error) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
 	var offset string
+	listed := 0
 	for {
-		reply, err := c.ListRecordsPage(table, offset)
+		reply, err := c.listRecordsPage(ctx, table, offset, opts)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(reply.Records); err != nil {
+			return err
+		}
+		listed += len(reply.Records)
+		if c.OnPage != nil {
+			c.OnPage(table, listed)
 		}
-		records = append(records, reply.Records...)
 		if reply.Offset == "" {
-			return records, nil
+			return nil
 		}
 		offset = reply.Offset
 	}
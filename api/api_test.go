@@ -1,6 +1,22 @@
 package api
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
 
 func TestIsAirTableId(t *testing.T) {
 	if !IsAirTableId("fldpjJ6SlAbLkrapJ") {
@@ -19,3 +35,342 @@ func TestIsAirTableId(t *testing.T) {
 		t.Error("should not be an airtable ID")
 	}
 }
+
+func TestDeprecationHeaderWarnsOnce(t *testing.T) {
+	var warnings bytes.Buffer
+	Logger, deprecationWarning = slog.New(slog.NewTextHandler(&warnings, nil)), sync.Once{}
+	defer func() {
+		Logger, deprecationWarning = nil, sync.Once{}
+	}()
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Sunset", "Sat, 01 Jun 2024 00:00:00 GMT")
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	for i := 0; i < 3; i++ {
+		if _, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Count(warnings.String(), "level=WARN") != 1 {
+		t.Errorf("expected exactly one warning, got %q", warnings.String())
+	}
+	if !strings.Contains(warnings.String(), "Sunset: Sat, 01 Jun 2024 00:00:00 GMT") {
+		t.Errorf("warning should include the header value: %q", warnings.String())
+	}
+}
+
+func TestUnknownResponseFields(t *testing.T) {
+	var warnings bytes.Buffer
+	Logger, unknownFieldWarnings = slog.New(slog.NewTextHandler(&warnings, nil)), sync.Map{}
+	defer func() {
+		Logger, unknownFieldWarnings = nil, sync.Map{}
+	}()
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "commentCount": 2,
+			"fields": {"Name": "a"}}], "OFFSET": "", "cursor": 3}`))
+	})
+	for _, mode := range []string{"", UnknownFieldsLog, UnknownFieldsLog, UnknownFieldsError} {
+		clerk.UnknownFields = mode
+		requests = 0
+		records, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA")
+		if mode == UnknownFieldsError {
+			var unknownErr *UnknownFieldError
+			if !errors.As(err, &unknownErr) || !reflect.DeepEqual(unknownErr.Fields, []string{"commentCount", "cursor"}) ||
+				requests != 1 {
+				t.Errorf("expected the unknown fields to fail in strict mode without retrying, got %v after %d requests",
+					err, requests)
+			}
+			// the records were created, which is not ambiguous
+			_, err := clerk.CreateRecords(context.Background(), "tblAAAAAAAAAAAAAA",
+				[]map[string]interface{}{{"Name": "a"}})
+			if !errors.As(err, &unknownErr) || errors.Is(err, ErrAmbiguousCreate) {
+				t.Errorf("expected the created records' unknown field to be reported as such, got %v", err)
+			}
+			continue
+		}
+		if err != nil || len(records) != 1 || records[0].Fields["Name"] != "a" {
+			t.Errorf("expected the record to be decoded with mode %q, got %+v: %v", mode, records, err)
+		}
+	}
+	logged := warnings.String()
+	if strings.Count(logged, "field=commentCount") != 1 || strings.Count(logged, "field=cursor") != 1 ||
+		strings.Contains(logged, "OFFSET") {
+		t.Errorf("expected one warning naming each unknown field, got %q", logged)
+	}
+}
+
+func TestRateMonitorWarnsNearLimit(t *testing.T) {
+	var warnings bytes.Buffer
+	Logger = slog.New(slog.NewTextHandler(&warnings, nil))
+	defer func() {
+		Logger = nil
+	}()
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	monitor := NewRateMonitor("appAAAAAAAAAAAAAA", 5)
+	// two requests a second is comfortably under the limit
+	for i := 0; i < 6; i++ {
+		monitor.Request(fakeClock.Now())
+		fakeClock.Advance(time.Second / 2)
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("unexpected warning: %q", warnings.String())
+	}
+	// a burst of four requests within a second is 80% of the limit
+	for i := 0; i < 4; i++ {
+		monitor.Request(fakeClock.Now())
+		fakeClock.Advance(time.Millisecond)
+	}
+	if !strings.Contains(warnings.String(), "approaching the limit\" base=appAAAAAAAAAAAAAA") {
+		t.Errorf("expected a warning about the request rate, got %q", warnings.String())
+	}
+	warnings.Reset()
+	monitor.Throttled(fakeClock.Now())
+	if warnings.Len() != 0 {
+		t.Error("warnings should be rate limited themselves")
+	}
+	fakeClock.Advance(time.Hour)
+	monitor.Throttled(fakeClock.Now())
+	if !strings.Contains(warnings.String(), "throttled by AirTable") || !strings.Contains(warnings.String(), "limit=5") {
+		t.Errorf("expected a warning about throttling, got %q", warnings.String())
+	}
+}
+
+func TestRateMonitorsAreSharedByBaseAndLimit(t *testing.T) {
+	config := Config{RateMonitors: &RateMonitors{}}
+	first := NewClerk("appAAAAAAAAAAAAAA", config, nil)
+	if NewClerk("appAAAAAAAAAAAAAA", config, nil).monitor != first.monitor {
+		t.Error("Clerks for the same base should share a monitor")
+	}
+	if NewClerk("appBBBBBBBBBBBBBB", config, nil).monitor == first.monitor {
+		t.Error("Clerks for different bases should not share a monitor")
+	}
+	config.RequestsPerSecond = 2
+	if limited := NewClerk("appAAAAAAAAAAAAAA", config, nil).monitor; limited == first.monitor || limited.Limit != 2 {
+		t.Error("a Clerk with a different rate limit should have its own monitor")
+	}
+	config.RateMonitors = nil
+	if NewClerk("appAAAAAAAAAAAAAA", config, nil).monitor == NewClerk("appAAAAAAAAAAAAAA", config, nil).monitor {
+		t.Error("without RateMonitors, each Clerk should track its own requests")
+	}
+}
+
+func TestClassifyToken(t *testing.T) {
+	pat := "patAbCdEfGhIjKlMn." + strings.Repeat("0123456789abcdef", 4)
+	cases := map[string]TokenKind{
+		"keyAAAAAAAAAAAAAA": TokenLegacyAPIKey,
+		pat:                 TokenPersonalAccess,
+		"oaa1b2c3d4e5f6.v1.refreshable-access-token": TokenOAuth,
+	}
+	for token, expected := range cases {
+		if kind, err := ClassifyToken(token); err != nil || kind != expected {
+			t.Errorf("token %q: expected %q, got %q (%v)", token, expected, kind, err)
+		}
+	}
+	for _, token := range []string{"", "patShort.abc", "key:AAAAAAAAAAAAA", "has space", pat + "\n"} {
+		if _, err := ClassifyToken(token); err == nil {
+			t.Errorf("token %q should have been rejected", token)
+		}
+	}
+}
+
+func TestPersonalAccessTokenIsSentAsBearer(t *testing.T) {
+	pat := "patAbCdEfGhIjKlMn." + strings.Repeat("0123456789abcdef", 4)
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+pat {
+			t.Errorf("unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	clerk.BearerToken = pat
+	if _, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListRetriesTransientFailures(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			// a date is taken relative to the clerk's clock
+			w.Header().Set("Retry-After", "Sun, 01 Jan 2023 00:00:07 GMT")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}}]}`))
+		}
+	})
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	clerk.Clock = fakeClock
+	records, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || requests != 3 {
+		t.Errorf("expected 1 record after 3 requests, got %d after %d", len(records), requests)
+	}
+	sleeps := fakeClock.Sleeps()
+	if len(sleeps) != 2 || sleeps[0] != 7*time.Second || sleeps[1] < time.Second || sleeps[1] > 2*time.Second {
+		t.Errorf("expected to honor Retry-After and then back off, but slept %v", sleeps)
+	}
+	// the request rate is tracked by the clock of the Clerk making the requests
+	tracked := clerk.monitor.requests
+	if len(tracked) == 0 || !tracked[len(tracked)-1].Equal(fakeClock.Now()) || clerk.monitor.lastWarning.Year() != 2023 {
+		t.Errorf("expected requests to be tracked by the fake clock, but saw %v", tracked)
+	}
+}
+
+func TestListGivesUpOnPermanentFailure(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+	})
+	if _, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if requests != 1 {
+		t.Errorf("a 403 should not be retried, but saw %d requests", requests)
+	}
+}
+
+func TestListRecordsOptionsAreSentAsQueryParameters(t *testing.T) {
+	var query url.Values
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	_, err := clerk.ListRecords(context.Background(), "tblAAAAAAAAAAAAAA", ListRecordsOptions{
+		Formula:    "{Done}",
+		View:       "Grid view",
+		Fields:     []string{"Name", "Notes"},
+		Sort:       []SortField{{Field: "Name"}, {Field: "Due", Direction: "desc"}},
+		PageSize:   20,
+		MaxRecords: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := url.Values{
+		"filterByFormula":    {"{Done}"},
+		"view":               {"Grid view"},
+		"fields[]":           {"Name", "Notes"},
+		"sort[0][field]":     {"Name"},
+		"sort[1][field]":     {"Due"},
+		"sort[1][direction]": {"desc"},
+		"pageSize":           {"20"},
+		"maxRecords":         {"50"},
+	}
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("expected query %v, got %v", expected, query)
+	}
+}
+
+func TestListRecordsOptionsAreValidated(t *testing.T) {
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request should be made")
+	})
+	for _, opts := range []ListRecordsOptions{
+		{PageSize: MaxPageSize + 1},
+		{MaxRecords: -1},
+		{Sort: []SortField{{Field: "Name", Direction: "up"}}},
+		{Sort: []SortField{{Direction: "asc"}}},
+	} {
+		if _, err := clerk.ListRecords(context.Background(), "tblAAAAAAAAAAAAAA", opts); err == nil {
+			t.Errorf("expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("30", now); d != 30*time.Second {
+		t.Errorf("expected 30s, got %v", d)
+	}
+	if d := parseRetryAfter("Sun, 01 Jan 2023 00:01:00 GMT", now); d != time.Minute {
+		t.Errorf("expected 1m, got %v", d)
+	}
+	if d := parseRetryAfter("soon", now); d != 0 {
+		t.Errorf("expected no delay for an unparseable header, got %v", d)
+	}
+}
+
+func TestListCommentsFetchesEveryPage(t *testing.T) {
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0/appAAAAAAAAAAAAAA/tblAAAAAAAAAAAAAA/recAAAAAAAAAAAAAA/comments" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("offset") == "" {
+			_, _ = w.Write([]byte(`{"comments": [{"id": "comA", "author": {"id": "usrA", "name": "Ann"},
+				"text": "Second", "createdTime": "2023-01-02T00:00:00.000Z"}], "offset": "next"}`))
+		} else {
+			_, _ = w.Write([]byte(`{"comments": [{"id": "comB", "author": {"id": "usrB", "name": "Bob"},
+				"text": "First", "createdTime": "2023-01-01T00:00:00.000Z"}], "offset": null}`))
+		}
+	})
+	comments, err := clerk.ListComments(context.Background(), "tblAAAAAAAAAAAAAA", "recAAAAAAAAAAAAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 || comments[0].Text != "Second" || comments[1].Author.Name != "Bob" {
+		t.Errorf("unexpected comments: %+v", comments)
+	}
+	if _, err := clerk.ListComments(context.Background(), "tblAAAAAAAAAAAAAA", "bad"); err == nil {
+		t.Error("expected an invalid record ID to be rejected")
+	}
+}
+
+func TestWithClientOptions(t *testing.T) {
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.UserAgent())
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	var hooked []int
+	var connections int
+	client := WithClientOptions(&http.Client{}, ClientOptions{
+		UserAgent: "test/1.0",
+		OnRequest: func(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+			if err != nil || elapsed < 0 {
+				t.Errorf("unexpected request outcome: %v after %s", err, elapsed)
+			}
+			hooked = append(hooked, resp.StatusCode)
+		},
+		Trace: func(req *http.Request) *httptrace.ClientTrace {
+			return &httptrace.ClientTrace{GotConn: func(httptrace.GotConnInfo) {
+				connections++
+			}}
+		},
+	})
+	for _, userAgent := range []string{"", "custom/2.0"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userAgent != "" {
+			req.Header.Set("User-Agent", userAgent)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if userAgent == "" && req.Header.Get("User-Agent") != "" {
+			t.Error("expected the caller's request to be left unchanged")
+		}
+	}
+	if !reflect.DeepEqual(userAgents, []string{"test/1.0", "custom/2.0"}) {
+		t.Errorf("expected the user agent to be set unless the request has its own, got %v", userAgents)
+	}
+	if !reflect.DeepEqual(hooked, []int{http.StatusTeapot, http.StatusTeapot}) || connections != 2 {
+		t.Errorf("expected each request to be hooked and traced, got %v and %d connections", hooked, connections)
+	}
+	if !strings.HasPrefix(DefaultUserAgent(), "vacuum-table/") {
+		t.Errorf("unexpected default user agent %q", DefaultUserAgent())
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// Comment is a comment on a record.
+type Comment struct {
+	Id              string       `json:"id"`
+	Author          Collaborator `json:"author"`
+	Text            string       `json:"text"`
+	CreatedTime     string       `json:"createdTime"`
+	LastUpdatedTime string       `json:"lastUpdatedTime,omitempty"`
+	// ParentCommentId is set on replies in a thread.
+	ParentCommentId string `json:"parentCommentId,omitempty"`
+	// Mentioned describes the users and groups mentioned in Text, keyed by the ID used in the text.
+	Mentioned map[string]interface{} `json:"mentioned,omitempty"`
+}
+
+type ListCommentsReply struct {
+	Comments []Comment `json:"comments"`
+	Offset   string    `json:"offset"`
+}
+
+func (c *Clerk) listCommentsPageOnce(ctx context.Context, table, record, offset string) (*ListCommentsReply, error) {
+	query := url.Values{}
+	query.Set("pageSize", "100")
+	if offset != "" {
+		query.Set("offset", offset)
+	}
+	link := "https://api.airtable.com/v0/" + c.App + "/" + table + "/" + record + "/comments?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	response, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	checkDeprecation(response)
+	if response.StatusCode != 200 {
+		return nil, newStatusError(response, clock.Or(c.Clock).Now())
+	}
+	var result ListCommentsReply
+	if err := c.decodeResponse(response, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListComments fetches every comment on a record, newest first. Each page is a separate request, so listing the
+// comments of every record in a table takes at least one request per record.
+func (c *Clerk) ListComments(ctx context.Context, table, record string) ([]Comment, error) {
+	if err := c.checkTable(table); err != nil {
+		return nil, err
+	}
+	if !IsAirTableId(record) {
+		return nil, fmt.Errorf("not a valid record ID: %q", record)
+	}
+	var comments []Comment
+	var offset string
+	for {
+		var reply *ListCommentsReply
+		err := c.retry(ctx, true, func() (err error) {
+			reply, err = c.listCommentsPageOnce(ctx, table, record, offset)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, reply.Comments...)
+		if reply.Offset == "" {
+			return comments, nil
+		}
+		offset = reply.Offset
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// How responses with fields that this version does not know are handled; see Config.UnknownFields. AirTable adds
+// fields to its responses from time to time, which older versions can safely ignore.
+const (
+	UnknownFieldsIgnore = "ignore"
+	UnknownFieldsLog    = "log"
+	UnknownFieldsError  = "error"
+)
+
+// unknownFieldWarnings holds the unknown fields that have been logged, by the type they were left out of, so that each
+// is only logged once.
+var unknownFieldWarnings sync.Map
+
+// UnknownFieldError reports a response with fields that this version does not know, when Config.UnknownFields is
+// UnknownFieldsError. It is permanent, so the request is not retried; a request that wrote records has already been
+// applied by the time it is returned.
+type UnknownFieldError struct {
+	Method string
+	Path   string
+	Fields []string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("response to %s %s has unknown fields %q", e.Method, e.Path, e.Fields)
+}
+
+func (c Config) unknownFields() string {
+	if c.UnknownFields == "" {
+		return UnknownFieldsIgnore
+	}
+	return c.UnknownFields
+}
+
+// decodeResponse decodes the JSON body of a successful response into result, handling any fields that result has no
+// place for as the Clerk's UnknownFields says.
+func (c *Clerk) decodeResponse(response *http.Response, result interface{}) error {
+	mode := c.unknownFields()
+	if mode == UnknownFieldsIgnore {
+		return json.NewDecoder(response.Body).Decode(result)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return err
+	}
+	resultType := reflect.TypeOf(result).Elem()
+	fields := map[string]bool{}
+	findUnknownFields(data, resultType, fields)
+	if len(fields) == 0 {
+		return nil
+	}
+	var unknown []string
+	for field := range fields {
+		unknown = append(unknown, field)
+	}
+	sort.Strings(unknown)
+	if mode == UnknownFieldsError {
+		return &UnknownFieldError{Method: response.Request.Method, Path: response.Request.URL.Path, Fields: unknown}
+	}
+	for _, field := range unknown {
+		key := resultType.String() + " " + field
+		if _, logged := unknownFieldWarnings.LoadOrStore(key, true); !logged {
+			logger().Warn("AirTable's response has a field that this version does not know; it is left out",
+				"method", response.Request.Method, "path", response.Request.URL.Path, "field", field)
+		}
+	}
+	return nil
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// findUnknownFields adds to unknown the names of the object keys in data, at any depth, that have no field to be
+// decoded into in a value of type t. Types that decode themselves, and maps and interfaces, accept every key.
+func findUnknownFields(data json.RawMessage, t reflect.Type, unknown map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(data, &object) != nil {
+			return
+		}
+		known := jsonFields(t)
+		for key, value := range object {
+			field, found := known[key]
+			if !found {
+				// like encoding/json, fall back to a case-insensitive match
+				field, found = known[strings.ToLower(key)]
+			}
+			if !found {
+				unknown[key] = true
+				continue
+			}
+			findUnknownFields(value, field, unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return
+		}
+		for _, item := range items {
+			findUnknownFields(item, t.Elem(), unknown)
+		}
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return
+		}
+		for _, value := range values {
+			findUnknownFields(value, t.Elem(), unknown)
+		}
+	}
+}
+
+// jsonFields returns the types of the fields of a struct type by the keys they are decoded from, both as written and
+// in lower case, including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for key, fieldType := range jsonFields(embedded) {
+					if _, shadowed := fields[key]; !shadowed {
+						fields[key] = fieldType
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+		if _, found := fields[strings.ToLower(name)]; !found {
+			fields[strings.ToLower(name)] = field.Type
+		}
+	}
+	return fields
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Logger receives warnings about the API itself, such as upcoming deprecations, and about the request rate; nil means
+// slog.Default().
+var Logger *slog.Logger
+
+func logger() *slog.Logger {
+	if Logger == nil {
+		return slog.Default()
+	}
+	return Logger
+}
+
+var deprecationWarning sync.Once
+
+// deprecationHeaders signal that an endpoint is going away; see RFC 8594 and RFC 9745.
+var deprecationHeaders = []string{"Deprecation", "Sunset"}
+
+// checkDeprecation warns, at most once per process, if a response says that its endpoint is deprecated.
+func checkDeprecation(response *http.Response) {
+	var found []string
+	for _, header := range deprecationHeaders {
+		if value := response.Header.Get(header); value != "" {
+			found = append(found, fmt.Sprintf("%s: %s", header, value))
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	deprecationWarning.Do(func() {
+		attrs := []any{"method", response.Request.Method, "path", response.Request.URL.Path,
+			"headers", strings.Join(found, "; ")}
+		if link := response.Header.Get("Link"); link != "" {
+			attrs = append(attrs, "link", link)
+		}
+		logger().Warn("AirTable reports that this endpoint is deprecated", attrs...)
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// The enterprise metadata endpoints need an Enterprise Scale plan, and a token with the scopes to read workspaces,
+// bases, and their shares. Their replies are kept as AirTable sends them, since which details they include varies
+// with the plan and the permissions of the token's user.
+
+// collaboratorsQuery asks for the collaborators and invite links of a base or workspace.
+var collaboratorsQuery = url.Values{"include": {"collaborators", "inviteLinks"}}
+
+// GetBaseCollaborators fetches the details of the Clerk's base along with its collaborators, the groups and
+// workspace members with access to it, and its invite links.
+func (c *Clerk) GetBaseCollaborators(ctx context.Context) (map[string]interface{}, error) {
+	if !IsAirTableId(c.App) {
+		return nil, fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	var base map[string]interface{}
+	if err := c.doMeta(ctx, http.MethodGet, "bases/"+c.App, collaboratorsQuery, nil, &base); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// ListShares fetches the share links of the Clerk's base and of its views.
+func (c *Clerk) ListShares(ctx context.Context) ([]map[string]interface{}, error) {
+	if !IsAirTableId(c.App) {
+		return nil, fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	var reply struct {
+		Shares []map[string]interface{} `json:"shares"`
+	}
+	if err := c.doMeta(ctx, http.MethodGet, "bases/"+c.App+"/shares", nil, nil, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Shares, nil
+}
+
+// GetWorkspace fetches the details of a workspace along with its collaborators and invite links. The Clerk's App is
+// not used.
+func (c *Clerk) GetWorkspace(ctx context.Context, workspace string) (map[string]interface{}, error) {
+	if !IsAirTableId(workspace) {
+		return nil, fmt.Errorf("not a valid workspace ID: %q", workspace)
+	}
+	var details map[string]interface{}
+	if err := c.doMeta(ctx, http.MethodGet, "workspaces/"+workspace, collaboratorsQuery, nil, &details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxErrorBody bounds how much of an error response is read when looking for AirTable's explanation.
+const maxErrorBody = 64 * 1024
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// StatusError describes a response other than 200 OK, including AirTable's explanation when the body had one.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Type and Message come from AirTable's error body, such as INVALID_VALUE_FOR_COLUMN.
+	Type    string
+	Message string
+	// FieldErrors lists the fields that a validation error (typically a 422) refers to.
+	FieldErrors []FieldError
+	// RetryAfter is how long the server asked us to wait before trying again, if it said.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("status code was not 200, but rather %d %q", e.StatusCode, e.Status)
+	if e.Type != "" {
+		msg += ": " + e.Type
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Transient reports whether the request might succeed if repeated.
+func (e *StatusError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+var fieldReferencePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[Ff]ield "((?:[^"\\]|\\.)*)"`),
+	regexp.MustCompile(`[Ff]ield name: "((?:[^"\\]|\\.)*)"`),
+}
+
+// fieldErrorsFromMessage extracts the fields named by an AirTable validation message, such as
+// `Field "Count" cannot accept the provided value`.
+func fieldErrorsFromMessage(message string) []FieldError {
+	var fieldErrors []FieldError
+	seen := map[string]bool{}
+	for _, sentence := range strings.Split(message, "\n") {
+		for _, pattern := range fieldReferencePatterns {
+			for _, match := range pattern.FindAllStringSubmatch(sentence, -1) {
+				field := strings.ReplaceAll(match[1], `\"`, `"`)
+				if !seen[field] {
+					seen[field] = true
+					fieldErrors = append(fieldErrors, FieldError{Field: field, Message: strings.TrimSpace(sentence)})
+				}
+			}
+		}
+	}
+	return fieldErrors
+}
+
+type errorBody struct {
+	Error json.RawMessage `json:"error"`
+}
+
+type errorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// newStatusError builds a StatusError from a failed response received at now, consuming (part of) its body.
+func newStatusError(response *http.Response, now time.Time) *StatusError {
+	statusErr := &StatusError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), now),
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+	if err != nil {
+		return statusErr
+	}
+	var body errorBody
+	if json.Unmarshal(data, &body) != nil || len(body.Error) == 0 {
+		return statusErr
+	}
+	// AirTable sends either {"error": "NOT_FOUND"} or {"error": {"type": ..., "message": ...}}.
+	var detail errorDetail
+	if json.Unmarshal(body.Error, &detail.Type) != nil {
+		if json.Unmarshal(body.Error, &detail) != nil {
+			return statusErr
+		}
+	}
+	statusErr.Type = detail.Type
+	statusErr.Message = detail.Message
+	statusErr.FieldErrors = fieldErrorsFromMessage(detail.Message)
+	return statusErr
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Attachment is a file in an attachment field.
+type Attachment struct {
+	Id       string `json:"id"`
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Type     string `json:"type"`
+	// Width and Height are only set for images.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// Collaborator is a user in a collaborator field, or the user who created or last modified a record.
+type Collaborator struct {
+	Id    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// decodeFieldValue converts a value as listed into the Go type for its field type: a string, float64, bool,
+// time.Time, []string of choices or linked record IDs, []Attachment, Collaborator, or []Collaborator. Values of other
+// types, such as formulas and lookups, whose values could be of any type, are returned unchanged.
+func decodeFieldValue(fieldType string, value interface{}) (interface{}, error) {
+	var decoded interface{}
+	var ok bool
+	switch fieldType {
+	case "singleLineText", "multilineText", "richText", "email", "url", "phoneNumber", "singleSelect":
+		decoded, ok = value.(string)
+	case "number", "percent", "currency", "rating", "duration", "count", "autoNumber":
+		decoded, ok = value.(float64)
+	case "checkbox":
+		decoded, ok = value.(bool)
+	case "date", "dateTime", "createdTime", "lastModifiedTime":
+		decoded, ok = parseTime(value)
+	case "multipleSelects", "multipleRecordLinks":
+		decoded, ok = stringList(value)
+	case "multipleAttachments":
+		var attachments []Attachment
+		ok = remarshal(value, &attachments)
+		decoded = attachments
+	case "singleCollaborator", "createdBy", "lastModifiedBy":
+		var collaborator Collaborator
+		ok = remarshal(value, &collaborator)
+		decoded = collaborator
+	case "multipleCollaborators":
+		var collaborators []Collaborator
+		ok = remarshal(value, &collaborators)
+		decoded = collaborators
+	default:
+		return value, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("unexpected value for a %s field: %v", fieldType, value)
+	}
+	return decoded, nil
+}
+
+// DecodeRecord returns a copy of a record of the table whose field values have the Go types for their fields' types
+// in the schema, as described for the Record.Get methods. Fields that are not in the schema are left unchanged.
+func (t *TableSchema) DecodeRecord(record Record) (Record, error) {
+	types := map[string]string{}
+	for _, field := range t.Fields {
+		types[field.Name] = field.Type
+	}
+	decoded := Record{Id: record.Id, CreatedTime: record.CreatedTime, Fields: map[string]interface{}{}}
+	for name, value := range record.Fields {
+		fieldType, found := types[name]
+		if !found {
+			decoded.Fields[name] = value
+			continue
+		}
+		typed, err := decodeFieldValue(fieldType, value)
+		if err != nil {
+			return Record{}, fmt.Errorf("record %s, field %q: %w", record.Id, name, err)
+		}
+		decoded.Fields[name] = typed
+	}
+	return decoded, nil
+}
+
+// GetString returns the value of a text field, or ok=false if the field is empty or not text.
+func (r Record) GetString(field string) (value string, ok bool) {
+	value, ok = r.Fields[field].(string)
+	return value, ok
+}
+
+// GetNumber returns the value of a numeric field, or ok=false if the field is empty or not a number.
+func (r Record) GetNumber(field string) (value float64, ok bool) {
+	value, ok = r.Fields[field].(float64)
+	return value, ok
+}
+
+// GetBool returns whether a checkbox field is checked. AirTable leaves unchecked boxes out of records entirely.
+func (r Record) GetBool(field string) bool {
+	value, _ := r.Fields[field].(bool)
+	return value
+}
+
+// GetTime returns the value of a date or date-time field, or ok=false if the field is empty or not a date.
+func (r Record) GetTime(field string) (time.Time, bool) {
+	return parseTime(r.Fields[field])
+}
+
+// GetStrings returns the choices of a multiple select field, or ok=false if the field is empty or not a list of
+// strings.
+func (r Record) GetStrings(field string) ([]string, bool) {
+	return stringList(r.Fields[field])
+}
+
+// GetLinks returns the IDs of the records linked by a linked record field, or ok=false if the field is empty or not
+// a list of record IDs.
+func (r Record) GetLinks(field string) ([]string, bool) {
+	ids, ok := stringList(r.Fields[field])
+	for _, id := range ids {
+		if !IsAirTableId(id) {
+			return nil, false
+		}
+	}
+	return ids, ok
+}
+
+// GetAttachments returns the files in an attachment field, or ok=false if the field is empty or not attachments.
+func (r Record) GetAttachments(field string) ([]Attachment, bool) {
+	switch value := r.Fields[field].(type) {
+	case []Attachment:
+		return value, true
+	case []interface{}:
+		var attachments []Attachment
+		return attachments, remarshal(value, &attachments)
+	default:
+		return nil, false
+	}
+}
+
+// GetCollaborator returns the user in a single collaborator field, or ok=false if the field is empty or not a
+// collaborator.
+func (r Record) GetCollaborator(field string) (Collaborator, bool) {
+	switch value := r.Fields[field].(type) {
+	case Collaborator:
+		return value, true
+	case map[string]interface{}:
+		var collaborator Collaborator
+		return collaborator, remarshal(value, &collaborator)
+	default:
+		return Collaborator{}, false
+	}
+}
+
+// GetCollaborators returns the users in a multiple collaborator field, or ok=false if the field is empty or not
+// collaborators.
+func (r Record) GetCollaborators(field string) ([]Collaborator, bool) {
+	switch value := r.Fields[field].(type) {
+	case []Collaborator:
+		return value, true
+	case []interface{}:
+		var collaborators []Collaborator
+		return collaborators, remarshal(value, &collaborators)
+	default:
+		return nil, false
+	}
+}
+
+// parseTime accepts a time.Time, or a string with either a date or a date and time, as AirTable lists them.
+func parseTime(value interface{}) (time.Time, bool) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func stringList(value interface{}) ([]string, bool) {
+	switch value := value.(type) {
+	case []string:
+		return value, true
+	case []interface{}:
+		strings := make([]string, len(value))
+		for i, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			strings[i] = s
+		}
+		return strings, true
+	default:
+		return nil, false
+	}
+}
+
+// remarshal converts a decoded JSON value into a typed one, by way of JSON.
+func remarshal(value interface{}, typed interface{}) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, typed) == nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeRecord(t *testing.T) {
+	var record Record
+	err := json.Unmarshal([]byte(`{"id": "recAAAAAAAAAAAAAA", "createdTime": "2024-01-01T00:00:00.000Z", "fields": {
+		"Name": "Widget",
+		"Count": 3,
+		"Done": true,
+		"Due": "2024-02-29",
+		"Tags": ["a", "b"],
+		"Parts": ["recBBBBBBBBBBBBBB"],
+		"Files": [{"id": "attAAAAAAAAAAAAAA", "url": "https://example.com/a", "filename": "a.png", "size": 11,
+			"type": "image/png", "width": 4, "height": 3}],
+		"Owner": {"id": "usrAAAAAAAAAAAAAA", "email": "a@example.com", "name": "A"},
+		"Computed": {"anything": 1}
+	}}`), &record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := TableSchema{Fields: []FieldSchema{
+		{Name: "Name", Type: "singleLineText"},
+		{Name: "Count", Type: "number"},
+		{Name: "Done", Type: "checkbox"},
+		{Name: "Due", Type: "date"},
+		{Name: "Tags", Type: "multipleSelects"},
+		{Name: "Parts", Type: "multipleRecordLinks"},
+		{Name: "Files", Type: "multipleAttachments"},
+		{Name: "Owner", Type: "singleCollaborator"},
+		{Name: "Computed", Type: "formula"},
+	}}
+	decoded, err := schema.DecodeRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, ok := decoded.Fields["Files"].([]Attachment); !ok || files[0].Width != 4 || files[0].Size != 11 {
+		t.Errorf("attachments should have been decoded: %#v", decoded.Fields["Files"])
+	}
+	if _, ok := decoded.Fields["Due"].(time.Time); !ok {
+		t.Errorf("dates should have been decoded: %#v", decoded.Fields["Due"])
+	}
+	if _, ok := decoded.Fields["Computed"].(map[string]interface{}); !ok {
+		t.Errorf("formulas should have been left alone: %#v", decoded.Fields["Computed"])
+	}
+	// the accessors work the same on decoded and raw records
+	for _, r := range []Record{decoded, record} {
+		if name, ok := r.GetString("Name"); !ok || name != "Widget" {
+			t.Errorf("unexpected name %q", name)
+		}
+		if count, ok := r.GetNumber("Count"); !ok || count != 3 {
+			t.Errorf("unexpected count %v", count)
+		}
+		if !r.GetBool("Done") || r.GetBool("Missing") {
+			t.Error("unexpected checkbox values")
+		}
+		if due, ok := r.GetTime("Due"); !ok || !due.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected date %v", due)
+		}
+		if tags, ok := r.GetStrings("Tags"); !ok || !reflect.DeepEqual(tags, []string{"a", "b"}) {
+			t.Errorf("unexpected tags %v", tags)
+		}
+		if parts, ok := r.GetLinks("Parts"); !ok || !reflect.DeepEqual(parts, []string{"recBBBBBBBBBBBBBB"}) {
+			t.Errorf("unexpected links %v", parts)
+		}
+		if files, ok := r.GetAttachments("Files"); !ok || len(files) != 1 || files[0].Filename != "a.png" {
+			t.Errorf("unexpected attachments %v", files)
+		}
+		if owner, ok := r.GetCollaborator("Owner"); !ok || owner.Email != "a@example.com" {
+			t.Errorf("unexpected collaborator %v", owner)
+		}
+		if _, ok := r.GetNumber("Name"); ok {
+			t.Error("a text field is not a number")
+		}
+	}
+	record.Fields["Count"] = "three"
+	if _, err := schema.DecodeRecord(record); err == nil {
+		t.Error("values of the wrong type should be rejected")
+	}
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+type Base struct {
+	Id              string `json:"id"`
+	Name            string `json:"name"`
+	PermissionLevel string `json:"permissionLevel"`
+}
+
+type ListBasesReply struct {
+	Bases  []Base `json:"bases"`
+	Offset string `json:"offset"`
+}
+
+// doMeta makes a request against the meta API. Only GET requests are retried.
+func (c *Clerk) doMeta(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	return c.doJSON(ctx, method, "meta/"+path, query, body, result)
+}
+
+// doJSON makes a request with a JSON body and reply against any part of the API, by its path after the version.
+// Only GET requests are retried.
+func (c *Clerk) doJSON(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	if err := c.checkToken(); err != nil {
+		return err
+	}
+	return c.retry(ctx, method == http.MethodGet, func() error {
+		return c.doJSONOnce(ctx, method, path, query, body, result)
+	})
+}
+
+func (c *Clerk) doJSONOnce(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	target := "https://api.airtable.com/v0/" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return err
+	}
+	if err := c.authorize(req); err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	response, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	checkDeprecation(response)
+	if response.StatusCode != 200 {
+		return newStatusError(response, clock.Or(c.Clock).Now())
+	}
+	if result == nil {
+		return nil
+	}
+	return c.decodeResponse(response, result)
+}
+
+// ListBases lists every base that the token grants access to. The Clerk's App is not used.
+func (c *Clerk) ListBases(ctx context.Context) ([]Base, error) {
+	var bases []Base
+	query := url.Values{}
+	for {
+		var reply ListBasesReply
+		if err := c.doMeta(ctx, http.MethodGet, "bases", query, nil, &reply); err != nil {
+			return nil, err
+		}
+		bases = append(bases, reply.Bases...)
+		if reply.Offset == "" {
+			return bases, nil
+		}
+		query.Set("offset", reply.Offset)
+	}
+}
+
+type FieldSpec struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Description string                 `json:"description,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+}
+
+// TableSpec describes a table to create. The first field becomes the primary field, so it must be of a type that
+// AirTable allows as a primary field.
+type TableSpec struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Fields      []FieldSpec `json:"fields"`
+}
+
+type CreatedField struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type CreatedTable struct {
+	Id             string         `json:"id"`
+	Name           string         `json:"name"`
+	PrimaryFieldId string         `json:"primaryFieldId"`
+	Fields         []CreatedField `json:"fields"`
+}
+
+// CreateTable creates a table in the Clerk's base.
+func (c *Clerk) CreateTable(ctx context.Context, spec TableSpec) (*CreatedTable, error) {
+	if !IsAirTableId(c.App) {
+		return nil, fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	if len(spec.Fields) == 0 {
+		return nil, fmt.Errorf("table %q must have at least one field", spec.Name)
+	}
+	var created CreatedTable
+	if err := c.doMeta(ctx, http.MethodPost, "bases/"+c.App+"/tables", nil, spec, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+type FieldSchema struct {
+	Id          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Description string                 `json:"description,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+}
+
+type ViewSchema struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type TableSchema struct {
+	Id             string        `json:"id"`
+	Name           string        `json:"name"`
+	Description    string        `json:"description,omitempty"`
+	PrimaryFieldId string        `json:"primaryFieldId"`
+	Fields         []FieldSchema `json:"fields"`
+	Views          []ViewSchema  `json:"views"`
+}
+
+// BaseSchema describes every table in a base: its name, its fields with their types and options (such as the
+// choices of select fields), and its views.
+type BaseSchema struct {
+	Tables []TableSchema `json:"tables"`
+}
+
+// GetBaseSchema fetches the schema of the Clerk's base. The token needs the schema.bases:read scope.
+func (c *Clerk) GetBaseSchema(ctx context.Context) (*BaseSchema, error) {
+	if !IsAirTableId(c.App) {
+		return nil, fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	var schema BaseSchema
+	if err := c.doMeta(ctx, http.MethodGet, "bases/"+c.App+"/tables", nil, nil, &schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// CreateField adds a field to a table in the Clerk's base, and returns it as AirTable created it, with its options
+// filled in: a new linked record field's options name the inverse field created alongside it.
+func (c *Clerk) CreateField(ctx context.Context, table string, spec FieldSpec) (*FieldSchema, error) {
+	if !IsAirTableId(c.App) {
+		return nil, fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	var created FieldSchema
+	if err := c.doMeta(ctx, http.MethodPost, "bases/"+c.App+"/tables/"+table+"/fields", nil, spec, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// RenameField changes the name of a field of a table in the Clerk's base.
+func (c *Clerk) RenameField(ctx context.Context, table, field, name string) error {
+	if !IsAirTableId(c.App) {
+		return fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	var updated FieldSchema
+	return c.doMeta(ctx, http.MethodPatch, "bases/"+c.App+"/tables/"+table+"/fields/"+field, nil,
+		map[string]string{"name": name}, &updated)
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+const (
+	OAuthAuthorizeURL = "https://airtable.com/oauth2/v1/authorize"
+	OAuthTokenURL     = "https://airtable.com/oauth2/v1/token"
+)
+
+// oauthRenewMargin is how long before an access token expires that it is renewed, so that it does not expire in the
+// middle of a request.
+const oauthRenewMargin = time.Minute
+
+// TokenSource supplies the token for each request, for tokens that change over time.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// OAuthConfig identifies an OAuth integration registered with AirTable. ClientSecret is only set for confidential
+// clients.
+type OAuthConfig struct {
+	ClientId     string   `json:"client-id"`
+	ClientSecret string   `json:"client-secret,omitempty"`
+	RedirectURI  string   `json:"redirect-uri"`
+	Scopes       []string `json:"scopes"`
+}
+
+// OAuthToken is an access token and the refresh token that renews it. AirTable only accepts each refresh token once,
+// so the token must be saved again every time it is renewed.
+type OAuthToken struct {
+	AccessToken   string    `json:"access-token"`
+	RefreshToken  string    `json:"refresh-token"`
+	Expiry        time.Time `json:"expiry"`
+	RefreshExpiry time.Time `json:"refresh-expiry,omitempty"`
+	Scope         string    `json:"scope,omitempty"`
+}
+
+type oauthTokenReply struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	TokenType        string `json:"token_type"`
+	Scope            string `json:"scope"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewCodeVerifier returns a random PKCE code verifier, which AirTable requires for every authorization.
+func NewCodeVerifier() (string, error) {
+	random := make([]byte, 48)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// AuthorizeURL returns the page where a user grants the integration access, which then redirects to RedirectURI
+// with the state and a code to pass to Exchange along with the same code verifier.
+func (c OAuthConfig) AuthorizeURL(state, codeVerifier string) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"client_id":             {c.ClientId},
+		"redirect_uri":          {c.RedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {strings.Join(c.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	return OAuthAuthorizeURL + "?" + query.Encode()
+}
+
+// Exchange trades the code from an authorization for a token.
+func (c OAuthConfig) Exchange(ctx context.Context, client *http.Client, code, codeVerifier string,
+	now time.Time) (*OAuthToken, error) {
+	return c.requestToken(ctx, client, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {codeVerifier},
+		"redirect_uri":  {c.RedirectURI},
+	}, now)
+}
+
+// Refresh renews a token with its refresh token.
+func (c OAuthConfig) Refresh(ctx context.Context, client *http.Client, refreshToken string,
+	now time.Time) (*OAuthToken, error) {
+	return c.requestToken(ctx, client, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}, now)
+}
+
+func (c OAuthConfig) requestToken(ctx context.Context, client *http.Client, form url.Values,
+	now time.Time) (*OAuthToken, error) {
+	if client == nil {
+		client = &http.Client{}
+	}
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientId)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, OAuthTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientId), url.QueryEscape(c.ClientSecret))
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	var reply oauthTokenReply
+	if err := json.NewDecoder(response.Body).Decode(&reply); err != nil && response.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if response.StatusCode != http.StatusOK || reply.Error != "" {
+		return nil, fmt.Errorf("token request failed with status %d: %s: %s", response.StatusCode, reply.Error,
+			reply.ErrorDescription)
+	}
+	if reply.AccessToken == "" || reply.RefreshToken == "" {
+		return nil, errors.New("token response did not include both an access token and a refresh token")
+	}
+	token := &OAuthToken{
+		AccessToken:  reply.AccessToken,
+		RefreshToken: reply.RefreshToken,
+		Expiry:       now.Add(time.Duration(reply.ExpiresIn) * time.Second),
+		Scope:        reply.Scope,
+	}
+	if reply.RefreshExpiresIn > 0 {
+		token.RefreshExpiry = now.Add(time.Duration(reply.RefreshExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// TokenStore persists the current OAuth token between runs.
+type TokenStore interface {
+	// Load returns the saved token, or nil if none has been saved.
+	Load() (*OAuthToken, error)
+	Save(token *OAuthToken) error
+}
+
+// FileTokenStore keeps the token in a JSON file readable only by its owner.
+type FileTokenStore struct {
+	Path string
+}
+
+func (s FileTokenStore) Load() (*OAuthToken, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var token OAuthToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("invalid OAuth token file %q: %w", s.Path, err)
+	}
+	return &token, nil
+}
+
+// Save replaces the file atomically, so that a crash cannot lose the only valid refresh token.
+func (s FileTokenStore) Save(token *OAuthToken) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.Path), ".oauth-token-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.Path)
+}
+
+// OAuthSource supplies the access token kept in Store, renewing it shortly before it expires and saving the renewed
+// token back to Store. It is safe for concurrent use.
+type OAuthSource struct {
+	Config OAuthConfig
+	Store  TokenStore
+	// Client sends the token requests; nil means a default http.Client.
+	Client *http.Client
+	// Clock decides when the token expires; nil means the wall clock.
+	Clock clock.Clock
+
+	mu    sync.Mutex
+	token *OAuthToken
+}
+
+func (s *OAuthSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil {
+		token, err := s.Store.Load()
+		if err != nil {
+			return "", err
+		}
+		if token == nil {
+			return "", errors.New("no OAuth token has been saved; authorize the integration first")
+		}
+		s.token = token
+	}
+	now := clock.Or(s.Clock).Now()
+	if now.Add(oauthRenewMargin).Before(s.token.Expiry) {
+		return s.token.AccessToken, nil
+	}
+	if !s.token.RefreshExpiry.IsZero() && !now.Before(s.token.RefreshExpiry) {
+		return "", errors.New("the OAuth refresh token has expired; authorize the integration again")
+	}
+	token, err := s.Config.Refresh(ctx, s.Client, s.token.RefreshToken, now)
+	if err != nil {
+		return "", fmt.Errorf("renewing OAuth token: %w", err)
+	}
+	if err := s.Store.Save(token); err != nil {
+		return "", fmt.Errorf("saving renewed OAuth token: %w", err)
+	}
+	s.token = token
+	return token.AccessToken, nil
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+type memoryTokenStore struct {
+	token *OAuthToken
+	saves int
+}
+
+func (s *memoryTokenStore) Load() (*OAuthToken, error) {
+	return s.token, nil
+}
+
+func (s *memoryTokenStore) Save(token *OAuthToken) error {
+	s.token = token
+	s.saves++
+	return nil
+}
+
+func TestOAuthSourceRenewsExpiringTokens(t *testing.T) {
+	refreshes := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/v1/token" {
+			refreshes++
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			user, password, _ := r.BasicAuth()
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh1" ||
+				user != "client" || password != "secret" {
+				t.Errorf("unexpected refresh request: %v", r.PostForm)
+			}
+			_, _ = w.Write([]byte(`{"access_token": "access2", "refresh_token": "refresh2", "token_type": "Bearer",
+				"expires_in": 3600, "refresh_expires_in": 5184000}`))
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer access2" {
+			t.Errorf("expected the renewed token, got %q", auth)
+		}
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := &memoryTokenStore{token: &OAuthToken{
+		AccessToken:  "access1",
+		RefreshToken: "refresh1",
+		Expiry:       fakeClock.Now().Add(30 * time.Second),
+	}}
+	clerk.BearerToken = ""
+	clerk.TokenSource = &OAuthSource{
+		Config: OAuthConfig{ClientId: "client", ClientSecret: "secret"},
+		Store:  store,
+		Client: clerk.Client,
+		Clock:  fakeClock,
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refreshes != 1 || store.saves != 1 || store.token.RefreshToken != "refresh2" {
+		t.Errorf("expected one refresh to be saved, got %d refreshes and %d saves", refreshes, store.saves)
+	}
+	if expected := fakeClock.Now().Add(time.Hour); !store.token.Expiry.Equal(expected) {
+		t.Errorf("expected the token to expire at %v, not %v", expected, store.token.Expiry)
+	}
+}
+
+func TestOAuthSourceWithoutTokenIsNotRetried(t *testing.T) {
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("no request should be made")
+	})
+	clerk.TokenSource = &OAuthSource{Store: &memoryTokenStore{}}
+	_, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA")
+	if err == nil || !strings.Contains(err.Error(), "authorize") {
+		t.Errorf("expected an error asking for authorization, got %v", err)
+	}
+}
+
+func TestAuthorizeURLHasCodeChallenge(t *testing.T) {
+	config := OAuthConfig{ClientId: "client", RedirectURI: "http://localhost/done", Scopes: []string{"data.records:read",
+		"schema.bases:read"}}
+	// the example from RFC 7636, appendix B
+	authorize := config.AuthorizeURL("state", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	parsed, err := url.Parse(authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge") != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" ||
+		query.Get("scope") != "data.records:read schema.bases:read" || query.Get("state") != "state" {
+		t.Errorf("unexpected authorization URL: %s", authorize)
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	store := FileTokenStore{Path: path.Join(t.TempDir(), "token.json")}
+	if token, err := store.Load(); err != nil || token != nil {
+		t.Fatalf("expected no token yet, got %v, %v", token, err)
+	}
+	token := &OAuthToken{AccessToken: "a", RefreshToken: "r", Expiry: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := store.Save(token); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, token) {
+		t.Errorf("expected %v, got %v", token, loaded)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultRequestsPerSecond is AirTable's documented rate limit for each base.
+const DefaultRequestsPerSecond = 5
+
+const (
+	// rateWarningThreshold is the fraction of the configured rate at which warnings begin.
+	rateWarningThreshold = 0.8
+	rateWindow           = time.Second
+	rateWarningInterval  = time.Minute
+)
+
+// RateMonitor watches the requests made against one base and warns when they come close to the rate limit, or when
+// AirTable throttles them anyway. Each request is timed by the clock of the Clerk that makes it.
+type RateMonitor struct {
+	Base  string
+	Limit float64
+
+	mu          sync.Mutex
+	requests    []time.Time
+	lastWarning time.Time
+}
+
+func NewRateMonitor(base string, limit float64) *RateMonitor {
+	return &RateMonitor{
+		Base:  base,
+		Limit: limit,
+	}
+}
+
+// warn must be called with mu held.
+func (m *RateMonitor) warn(now time.Time, msg string, attrs ...any) {
+	if !m.lastWarning.IsZero() && now.Sub(m.lastWarning) < rateWarningInterval {
+		return
+	}
+	m.lastWarning = now
+	logger().Warn(msg, append([]any{"base", m.Base}, attrs...)...)
+}
+
+// observedRate must be called with mu held.
+func (m *RateMonitor) observedRate(now time.Time) float64 {
+	cutoff := now.Add(-rateWindow)
+	kept := m.requests[:0]
+	for _, t := range m.requests {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	m.requests = kept
+	return float64(len(kept)) / rateWindow.Seconds()
+}
+
+// Request records that a request is about to be sent at the given time.
+func (m *RateMonitor) Request(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, now)
+	if rate := m.observedRate(now); rate >= m.Limit*rateWarningThreshold {
+		m.warn(now, "request rate is approaching the limit", "rate", rate, "limit", m.Limit)
+	}
+}
+
+// Throttled records that AirTable answered with 429 Too Many Requests at the given time.
+func (m *RateMonitor) Throttled(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.warn(now, "throttled by AirTable; the real limit may be lower than the configured one",
+		"rate", m.observedRate(now), "limit", m.Limit)
+}
+
+// RateMonitors shares a RateMonitor between the Clerks for each base that have the same rate limit. The zero value is
+// ready to use.
+type RateMonitors struct {
+	mu       sync.Mutex
+	monitors map[rateMonitorKey]*RateMonitor
+}
+
+type rateMonitorKey struct {
+	base  string
+	limit float64
+}
+
+// For returns the monitor for a base and rate limit, creating it if needed.
+func (r *RateMonitors) For(base string, limit float64) *RateMonitor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := rateMonitorKey{base: base, limit: limit}
+	monitor, found := r.monitors[key]
+	if !found {
+		if r.monitors == nil {
+			r.monitors = map[rateMonitorKey]*RateMonitor{}
+		}
+		monitor = NewRateMonitor(base, limit)
+		r.monitors[key] = monitor
+	}
+	return monitor
+}
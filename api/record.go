@@ -0,0 +1,290 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the secrets in recorded exchanges.
+const Redacted = "REDACTED"
+
+// redactedHeaders carry credentials, and are left out of recorded exchanges entirely. X-Amz-Security-Token carries
+// the session token of temporary AWS credentials, on requests to S3 buckets.
+var redactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Amz-Security-Token"}
+
+// redactedParameters are the query parameters that carry credentials and signatures in presigned S3 URLs.
+var redactedParameters = []string{"X-Amz-Credential", "X-Amz-Security-Token", "X-Amz-Signature"}
+
+// redactedKeys are the top-level keys of JSON and form bodies whose values are secrets: OAuth tokens, client
+// secrets, and webhook MAC secrets. Nested keys are left alone, since they hold the records' own fields.
+var redactedKeys = map[string]bool{
+	"access_token":    true,
+	"refresh_token":   true,
+	"client_secret":   true,
+	"code":            true,
+	"code_verifier":   true,
+	"macSecretBase64": true,
+}
+
+// RecordedMessage is a sanitized request or response. Body is only recorded for JSON, form, and text bodies; others,
+// such as attachments, are passed through unrecorded.
+type RecordedMessage struct {
+	Method string      `json:"method,omitempty"`
+	URL    string      `json:"url,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedExchange is a request and the response it received, as saved by a recording transport.
+type RecordedExchange struct {
+	Request  RecordedMessage `json:"request"`
+	Response RecordedMessage `json:"response"`
+}
+
+func recordedBody(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "application/json" || mediaType == "application/x-www-form-urlencoded" ||
+		strings.HasPrefix(mediaType, "text/")
+}
+
+// readRecordedBody reads a body to record it, and returns a replacement for the body that was read.
+func readRecordedBody(body io.ReadCloser) (string, io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return "", body, nil
+	}
+	data, err := io.ReadAll(body)
+	_ = body.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data), io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func sanitizeHeader(header http.Header) http.Header {
+	sanitized := header.Clone()
+	for _, name := range redactedHeaders {
+		sanitized.Del(name)
+	}
+	return sanitized
+}
+
+// sanitizeURL redacts the credentials in the query of a URL.
+func sanitizeURL(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range redactedParameters {
+		if query.Has(name) {
+			query.Set(name, Redacted)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	sanitized := *u
+	sanitized.RawQuery = query.Encode()
+	return sanitized.String()
+}
+
+// sanitizeBody redacts the secrets in a JSON or form body.
+func sanitizeBody(header http.Header, body string) string {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var object map[string]json.RawMessage
+		if json.Unmarshal([]byte(body), &object) != nil {
+			return body
+		}
+		redacted := false
+		for key := range object {
+			if redactedKeys[key] {
+				object[key], redacted = json.RawMessage(`"`+Redacted+`"`), true
+			}
+		}
+		if !redacted {
+			return body
+		}
+		encoded, _ := json.Marshal(object)
+		return string(encoded)
+	case "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return body
+		}
+		for key := range form {
+			if redactedKeys[key] {
+				form.Set(key, Redacted)
+			}
+		}
+		return form.Encode()
+	}
+	return body
+}
+
+type recordingTransport struct {
+	base http.RoundTripper
+	dir  string
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewRecordingTransport returns a transport that sends requests with base, and saves each request and its response
+// in dir, as a RecordedExchange in a numbered JSON file, with credentials and secrets removed. The exchanges can be
+// replayed with NewReplayTransport.
+func NewRecordingTransport(base http.RoundTripper, dir string) (http.RoundTripper, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	existing, err := recordedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingTransport{base: base, dir: dir, next: len(existing)}, nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	exchange := RecordedExchange{Request: RecordedMessage{Method: req.Method, URL: sanitizeURL(req.URL),
+		Header: sanitizeHeader(req.Header)}}
+	if recordedBody(req.Header) && req.Body != nil {
+		// a RoundTripper must not modify the request it is given
+		body, replacement, err := readRecordedBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = replacement
+		exchange.Request.Body = sanitizeBody(req.Header, body)
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	exchange.Response = RecordedMessage{Status: resp.StatusCode, Header: sanitizeHeader(resp.Header)}
+	if recordedBody(resp.Header) {
+		body, replacement, err := readRecordedBody(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = replacement
+		exchange.Response.Body = sanitizeBody(resp.Header, body)
+	}
+	if err := t.save(exchange); err != nil {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("recording %s %s: %w", req.Method, req.URL, err)
+	}
+	return resp, nil
+}
+
+func (t *recordingTransport) save(exchange RecordedExchange) error {
+	encoded, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// other transports may be recording into the same directory, so names that are taken are skipped
+	for {
+		t.next++
+		f, err := os.OpenFile(path.Join(t.dir, fmt.Sprintf("%06d.json", t.next)), os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+			0o600)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := f.Write(encoded); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+func recordedFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && path.Ext(entry.Name()) == ".json" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type replayTransport struct {
+	mu sync.Mutex
+	// exchanges holds the exchanges not yet replayed for each method and URL, in the order they were recorded.
+	exchanges map[string][]RecordedExchange
+}
+
+// NewReplayTransport returns a transport that answers requests from the exchanges that NewRecordingTransport saved
+// in dir, without sending them anywhere. Each request is answered with the earliest exchange for the same method and
+// URL that has not been replayed yet, so that retried and repeated requests receive their responses in order.
+func NewReplayTransport(dir string) (http.RoundTripper, error) {
+	names, err := recordedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &replayTransport{exchanges: map[string][]RecordedExchange{}}
+	for _, name := range names {
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var exchange RecordedExchange
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			return nil, fmt.Errorf("invalid recorded exchange %s: %w", name, err)
+		}
+		key := exchange.Request.Method + " " + exchange.Request.URL
+		t.exchanges[key] = append(t.exchanges[key], exchange)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	// recorded URLs had their credentials redacted
+	key := req.Method + " " + sanitizeURL(req.URL)
+	t.mu.Lock()
+	remaining := t.exchanges[key]
+	if len(remaining) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response left for %s", key)
+	}
+	t.exchanges[key] = remaining[1:]
+	t.mu.Unlock()
+	recorded := remaining[0].Response
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
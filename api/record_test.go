@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/s3"
+	"github.com/celskeggs/vacuum-table/s3/s3test"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token": "secret-access", "expires_in": 3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "fields": {"code": "kept"}}]}`))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	recorder, err := NewRecordingTransport(nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(client *http.Client, target, form string) (string, error) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+target, strings.NewReader(form))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret-token")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+	recorded := &http.Client{Transport: recorder}
+	token, err := send(recorded, "/token", "grant_type=refresh_token&refresh_token=secret-refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(token, "secret-access") {
+		t.Errorf("expected the response to be passed through unchanged, got %q", token)
+	}
+	records, err := send(recorded, "/records", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := recordedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected a file for each exchange, got %v", files)
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "secret") {
+			t.Errorf("expected the credentials to be removed from %s, got %s", name, data)
+		}
+	}
+
+	replayer, err := NewReplayTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := &http.Client{Transport: replayer}
+	if body, err := send(replayed, "/records", ""); err != nil || body != records {
+		t.Errorf("expected the recorded response %q, got %q: %v", records, body, err)
+	}
+	if body, err := send(replayed, "/token", ""); err != nil || !strings.Contains(body, Redacted) {
+		t.Errorf("expected the redacted token, got %q: %v", body, err)
+	}
+	if _, err := send(replayed, "/records", ""); err == nil {
+		t.Error("expected each recorded response to be replayed only once")
+	}
+}
+
+func TestRecordingRedactsS3Credentials(t *testing.T) {
+	server := s3test.NewServer(t)
+	dir := t.TempDir()
+	recorder, err := NewRecordingTransport(server.Client().Transport, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := server.ClientConfig()
+	config.SessionToken = "secret-session-token"
+	client := s3.NewClient(config, &http.Client{Transport: recorder})
+	if err := client.Put(context.Background(), "bucket", "notes.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// as sent to a presigned URL
+	presigned := server.URL + "/bucket/notes.txt?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=secret-credential" +
+		"&X-Amz-Security-Token=secret-session-token&X-Amz-Signature=secret-signature"
+	resp, err := (&http.Client{Transport: recorder}).Get(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	files, err := recordedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected a file for each exchange, got %v", files)
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "secret") || strings.Contains(string(data), config.AccessKeyId) {
+			t.Errorf("expected the credentials to be removed from %s, got %s", name, data)
+		}
+	}
+}
+
+func TestRecordersShareDirectory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	// a gap left by an exchange that was deleted
+	if err := os.WriteFile(path.Join(dir, "000002.json"), []byte(`{"request": {}, "response": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var clients []*http.Client
+	for i := 0; i < 2; i++ {
+		recorder, err := NewRecordingTransport(nil, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, &http.Client{Transport: recorder})
+	}
+	for i, target := range []string{"/first", "/second", "/third"} {
+		resp, err := clients[i%2].Get(server.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	files, err := recordedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("expected every exchange to be kept, got %v", files)
+	}
+}
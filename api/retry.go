@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+const (
+	// DefaultRetries is how many times a request that failed transiently is retried, unless configured otherwise.
+	DefaultRetries = 5
+	// retryBaseDelay is the delay before the first retry; it doubles with each further retry, up to retryMaxDelay.
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// parseRetryAfter interprets a Retry-After header, which holds either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+// backoff returns the delay before retry number attempt (counting from zero): exponential, capped, and with jitter
+// so that parallel workers do not retry in lockstep. A server-provided Retry-After is always honored in full.
+func backoff(attempt int, retryAfter time.Duration) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = retryBaseDelay << attempt
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// retry runs op until it succeeds, fails permanently, or runs out of retries. Requests that are not idempotent are
+// only retried when AirTable has rejected them outright with 429 Too Many Requests; any other transient failure might
+// have been applied, and is reported as ErrAmbiguousCreate instead of being repeated.
+func (c *Clerk) retry(ctx context.Context, idempotent bool, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		var statusErr *StatusError
+		isStatus := errors.As(err, &statusErr)
+		if isStatus && !statusErr.Transient() {
+			return err
+		}
+		var tokenErr *TokenError
+		var unknownErr *UnknownFieldError
+		if errors.As(err, &tokenErr) || errors.As(err, &unknownErr) {
+			return err
+		}
+		if !idempotent && !(isStatus && statusErr.StatusCode == http.StatusTooManyRequests) {
+			return fmt.Errorf("%w: %v", ErrAmbiguousCreate, err)
+		}
+		if attempt >= c.Retries {
+			return err
+		}
+		if c.OnRetry != nil {
+			c.OnRetry(err)
+		}
+		var retryAfter time.Duration
+		if isStatus {
+			retryAfter = statusErr.RetryAfter
+		}
+		select {
+		case <-clock.Or(c.Clock).After(backoff(attempt, retryAfter)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type TokenKind string
+
+const (
+	// TokenLegacyAPIKey is a deprecated account-wide API key, such as keyXXXXXXXXXXXXXX.
+	TokenLegacyAPIKey TokenKind = "legacy-api-key"
+	// TokenPersonalAccess is a personal access token or service account token, such as patXXXXXXXXXXXXXX.<hex>.
+	TokenPersonalAccess TokenKind = "personal-access-token"
+	// TokenOAuth is an opaque OAuth access token issued to an integration.
+	TokenOAuth TokenKind = "oauth"
+)
+
+var personalAccessTokenPattern = regexp.MustCompile(`^pat[0-9A-Za-z]{14}\.[0-9a-f]{64}$`)
+
+// ClassifyToken determines what kind of credential a token is, rejecting tokens that cannot be valid.
+func ClassifyToken(token string) (TokenKind, error) {
+	if token == "" {
+		return "", errors.New("no API token configured")
+	}
+	for _, c := range []byte(token) {
+		if c <= ' ' || c >= 0x7f {
+			return "", errors.New("invalid API token: contains whitespace or non-ASCII characters")
+		}
+	}
+	switch {
+	case strings.HasPrefix(token, "pat"):
+		if !personalAccessTokenPattern.MatchString(token) {
+			return "", errors.New("invalid personal access token: expected pat<14 characters>.<64 hex digits>")
+		}
+		return TokenPersonalAccess, nil
+	case strings.HasPrefix(token, "key") && len(token) == 17:
+		if !IsAirTableId(token) {
+			return "", fmt.Errorf("invalid API key")
+		}
+		return TokenLegacyAPIKey, nil
+	default:
+		return TokenOAuth, nil
+	}
+}
+
+// TokenError reports that a TokenSource could not supply a token, so that the request was never sent. It is not
+// retried.
+type TokenError struct {
+	Err error
+}
+
+func (e *TokenError) Error() string {
+	return "could not obtain API token: " + e.Err.Error()
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Clerk) checkToken() error {
+	if c.TokenSource != nil {
+		return nil
+	}
+	_, err := ClassifyToken(c.BearerToken)
+	return err
+}
+
+// authorize adds the Clerk's credentials to a request. Every kind of token is sent as a bearer token.
+func (c *Clerk) authorize(req *http.Request) error {
+	token := c.BearerToken
+	if c.TokenSource != nil {
+		var err error
+		if token, err = c.TokenSource.Token(req.Context()); err != nil {
+			return &TokenError{Err: err}
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"runtime/debug"
+	"time"
+)
+
+// ModulePath is the path of this module, by which its version is found in the build information.
+const ModulePath = "github.com/celskeggs/vacuum-table"
+
+// ModuleVersion returns the version of this module that the running binary was built with, whether as its main
+// module or as a dependency of a program embedding it, or "unknown".
+func ModuleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == ModulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == ModulePath && dep.Version != "" {
+			return dep.Version
+		}
+	}
+	if info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// DefaultUserAgent identifies this tool and its version to AirTable, so that its requests can be told apart from
+// those of other integrations.
+func DefaultUserAgent() string {
+	return "vacuum-table/" + ModuleVersion() + " (+https://" + ModulePath + ")"
+}
+
+// RequestHook is called after each request that a client sends, with its response or error, and how long it took to
+// receive the response's headers. It must not read or close the response's body.
+type RequestHook func(req *http.Request, resp *http.Response, err error, elapsed time.Duration)
+
+// ClientOptions instrument the requests of an http.Client; see WithClientOptions.
+type ClientOptions struct {
+	// UserAgent is sent with every request that does not set its own; empty leaves requests unchanged.
+	UserAgent string
+	// OnRequest, if not nil, is called after each request, such as to log it.
+	OnRequest RequestHook
+	// Trace, if not nil, returns the hooks that trace the connection of each request, such as for OpenTelemetry.
+	Trace func(req *http.Request) *httptrace.ClientTrace
+}
+
+// WithClientOptions returns a copy of client whose requests are instrumented as opts says.
+func WithClientOptions(client *http.Client, opts ClientOptions) *http.Client {
+	if opts.UserAgent == "" && opts.OnRequest == nil && opts.Trace == nil {
+		return client
+	}
+	instrumented := *client
+	instrumented.Transport = instrumentedTransport{base: client.Transport, opts: opts}
+	return &instrumented
+}
+
+type instrumentedTransport struct {
+	base http.RoundTripper
+	opts ClientOptions
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	if t.opts.UserAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.opts.UserAgent)
+	}
+	if t.opts.Trace != nil {
+		if trace := t.opts.Trace(req); trace != nil {
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		}
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	if t.opts.OnRequest != nil {
+		t.opts.OnRequest(req, resp, err, time.Since(start))
+	}
+	return resp, err
+}
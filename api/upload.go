@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// MaxUploadSize is the largest file that UploadAttachment accepts. Larger attachments must be attached by URL.
+const MaxUploadSize = 5 << 20
+
+// Upload is a file to attach to a record.
+type Upload struct {
+	ContentType string `json:"contentType"`
+	// File is sent base64-encoded, as encoding/json does for byte slices.
+	File     []byte `json:"file"`
+	Filename string `json:"filename"`
+}
+
+func (c *Clerk) uploadOnce(ctx context.Context, record, field string, body []byte) (*Record, error) {
+	link := "https://content.airtable.com/v0/" + c.App + "/" + record + "/" + url.PathEscape(field) + "/uploadAttachment"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, link, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	response, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != 200 {
+		return nil, newStatusError(response, clock.Or(c.Clock).Now())
+	}
+	var result Record
+	if err := c.decodeResponse(response, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UploadAttachment adds a file to the attachments in a field of an existing record, through the content upload API,
+// without the file having to be reachable at a URL. The field may be given by name or ID. It returns the record's
+// attachment fields after the upload. Like a create, it is only retried if it is rejected outright for exceeding the
+// rate limit, since a retry could attach the file twice.
+func (c *Clerk) UploadAttachment(ctx context.Context, record, field string, upload Upload) (*Record, error) {
+	if !IsAirTableId(record) {
+		return nil, fmt.Errorf("not a valid record ID: %q", record)
+	}
+	if len(upload.File) > MaxUploadSize {
+		return nil, fmt.Errorf("cannot upload %d bytes; at most %d bytes can be uploaded", len(upload.File),
+			MaxUploadSize)
+	}
+	body, err := json.Marshal(upload)
+	if err != nil {
+		return nil, err
+	}
+	var reply *Record
+	err = c.retry(ctx, false, func() (err error) {
+		reply, err = c.uploadOnce(ctx, record, field, body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
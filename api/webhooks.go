@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WebhookMACHeader carries the signature of each notification that AirTable sends to a webhook's notification URL.
+const WebhookMACHeader = "X-Airtable-Content-MAC"
+
+// Data types that a webhook can be notified of.
+const (
+	WebhookTableData   = "tableData"
+	WebhookTableFields = "tableFields"
+)
+
+type WebhookFilters struct {
+	DataTypes []string `json:"dataTypes"`
+	// RecordChangeScope restricts the webhook to the changes in one table, by ID.
+	RecordChangeScope string `json:"recordChangeScope,omitempty"`
+}
+
+type WebhookSpecification struct {
+	Options struct {
+		Filters WebhookFilters `json:"filters"`
+	} `json:"options"`
+}
+
+type Webhook struct {
+	Id                   string               `json:"id"`
+	NotificationURL      string               `json:"notificationUrl"`
+	CursorForNextPayload int                  `json:"cursorForNextPayload"`
+	IsHookEnabled        bool                 `json:"isHookEnabled"`
+	ExpirationTime       string               `json:"expirationTime"`
+	Specification        WebhookSpecification `json:"specification"`
+}
+
+type CreatedWebhook struct {
+	Id string `json:"id"`
+	// MACSecretBase64 is the secret that notifications are signed with. AirTable only reveals it when the webhook
+	// is created.
+	MACSecretBase64 string `json:"macSecretBase64"`
+	ExpirationTime  string `json:"expirationTime"`
+}
+
+// WebhookTableChanges describes what changed in one table. Only the IDs of the records and fields are used here,
+// since cell values in payloads are not in the same format as listed records.
+type WebhookTableChanges struct {
+	CreatedRecordsById map[string]interface{} `json:"createdRecordsById,omitempty"`
+	ChangedRecordsById map[string]interface{} `json:"changedRecordsById,omitempty"`
+	DestroyedRecordIds []string               `json:"destroyedRecordIds,omitempty"`
+	CreatedFieldsById  map[string]interface{} `json:"createdFieldsById,omitempty"`
+	ChangedFieldsById  map[string]interface{} `json:"changedFieldsById,omitempty"`
+	DestroyedFieldIds  []string               `json:"destroyedFieldIds,omitempty"`
+}
+
+// FieldsChanged reports whether any of the table's fields were created, changed, or destroyed.
+func (c WebhookTableChanges) FieldsChanged() bool {
+	return len(c.CreatedFieldsById) > 0 || len(c.ChangedFieldsById) > 0 || len(c.DestroyedFieldIds) > 0
+}
+
+type WebhookPayload struct {
+	Timestamp             string                         `json:"timestamp"`
+	BaseTransactionNumber int                            `json:"baseTransactionNumber"`
+	ChangedTablesById     map[string]WebhookTableChanges `json:"changedTablesById,omitempty"`
+	// DestroyedTableIds lists the tables that were deleted.
+	DestroyedTableIds []string `json:"destroyedTableIds,omitempty"`
+}
+
+type WebhookPayloads struct {
+	Payloads      []WebhookPayload `json:"payloads"`
+	Cursor        int              `json:"cursor"`
+	MightHaveMore bool             `json:"mightHaveMore"`
+}
+
+// WebhookNotification is the body of the notification that AirTable sends when a webhook has new payloads. The
+// payloads themselves must be fetched with ListWebhookPayloads.
+type WebhookNotification struct {
+	Base struct {
+		Id string `json:"id"`
+	} `json:"base"`
+	Webhook struct {
+		Id string `json:"id"`
+	} `json:"webhook"`
+	Timestamp string `json:"timestamp"`
+}
+
+func (c *Clerk) webhooksPath() (string, error) {
+	if !IsAirTableId(c.App) {
+		return "", fmt.Errorf("not a valid app ID: %q", c.App)
+	}
+	return "bases/" + c.App + "/webhooks", nil
+}
+
+// CreateWebhook registers a webhook on the Clerk's base, which notifies notificationURL of the changes that filters
+// select. Webhooks expire seven days after they are created or last refreshed.
+func (c *Clerk) CreateWebhook(ctx context.Context, notificationURL string, filters WebhookFilters) (*CreatedWebhook,
+	error) {
+	webhooks, err := c.webhooksPath()
+	if err != nil {
+		return nil, err
+	}
+	var spec WebhookSpecification
+	spec.Options.Filters = filters
+	body := map[string]interface{}{"notificationUrl": notificationURL, "specification": spec}
+	var created CreatedWebhook
+	if err := c.doJSON(ctx, http.MethodPost, webhooks, nil, body, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// ListWebhooks lists the webhooks registered on the Clerk's base by the token's user.
+func (c *Clerk) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	webhooks, err := c.webhooksPath()
+	if err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, webhooks, nil, nil, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Webhooks, nil
+}
+
+// DeleteWebhook removes a webhook from the Clerk's base.
+func (c *Clerk) DeleteWebhook(ctx context.Context, webhook string) error {
+	webhooks, err := c.webhooksPath()
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, http.MethodDelete, webhooks+"/"+url.PathEscape(webhook), nil, nil, nil)
+}
+
+// RefreshWebhook extends the life of a webhook by another seven days, and returns its new expiration time.
+func (c *Clerk) RefreshWebhook(ctx context.Context, webhook string) (string, error) {
+	webhooks, err := c.webhooksPath()
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		ExpirationTime string `json:"expirationTime"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, webhooks+"/"+url.PathEscape(webhook)+"/refresh", nil, nil,
+		&reply); err != nil {
+		return "", err
+	}
+	return reply.ExpirationTime, nil
+}
+
+// ListWebhookPayloads fetches the payloads of a webhook, starting at cursor, which begins at 1. While MightHaveMore
+// is set, the rest are fetched by calling it again with the returned Cursor. AirTable keeps payloads for seven days.
+func (c *Clerk) ListWebhookPayloads(ctx context.Context, webhook string, cursor int) (*WebhookPayloads, error) {
+	webhooks, err := c.webhooksPath()
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"cursor": {strconv.Itoa(cursor)}}
+	var payloads WebhookPayloads
+	if err := c.doJSON(ctx, http.MethodGet, webhooks+"/"+url.PathEscape(webhook)+"/payloads", query, nil,
+		&payloads); err != nil {
+		return nil, err
+	}
+	return &payloads, nil
+}
+
+// VerifyWebhookNotification checks the WebhookMACHeader of a notification against its body, using the secret that
+// the webhook was created with.
+func VerifyWebhookNotification(macSecretBase64 string, body []byte, header string) error {
+	secret, err := base64.StdEncoding.DecodeString(macSecretBase64)
+	if err != nil {
+		return fmt.Errorf("invalid webhook secret: %w", err)
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "hmac-sha256="))
+	if err != nil || !strings.HasPrefix(header, "hmac-sha256=") {
+		return fmt.Errorf("invalid %s: %q", WebhookMACHeader, header)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return fmt.Errorf("%s does not match the notification", WebhookMACHeader)
+	}
+	return nil
+}
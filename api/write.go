@@ -0,0 +1,282 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// DefaultWriteBatchSize is AirTable's current limit on the number of records in a single create or update request.
+const DefaultWriteBatchSize = 10
+
+// ErrAmbiguousCreate is returned when a plain create fails in a way that leaves it unknown whether the records were
+// created. Plain creates are never retried automatically, since a retry could duplicate the records; use
+// UpsertRecords when retries must be safe.
+var ErrAmbiguousCreate = errors.New("create may or may not have been applied; not retrying a non-idempotent write")
+
+type writeRecord struct {
+	Id     string                 `json:"id,omitempty"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+type performUpsert struct {
+	FieldsToMergeOn []string `json:"fieldsToMergeOn"`
+}
+
+type writeRequest struct {
+	Records       []writeRecord  `json:"records"`
+	PerformUpsert *performUpsert `json:"performUpsert,omitempty"`
+	Typecast      bool           `json:"typecast,omitempty"`
+}
+
+type WriteRecordsReply struct {
+	Records        []Record `json:"records"`
+	CreatedRecords []string `json:"createdRecords,omitempty"`
+	UpdatedRecords []string `json:"updatedRecords,omitempty"`
+}
+
+func (c *Clerk) writeOnce(ctx context.Context, method, table string, body []byte) (*WriteRecordsReply, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "https://api.airtable.com/v0/"+c.App+"/"+table,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	response, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != 200 {
+		return nil, newStatusError(response, clock.Or(c.Clock).Now())
+	}
+	var result WriteRecordsReply
+	if err := c.decodeResponse(response, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Clerk) write(ctx context.Context, method, table string, request writeRequest, idempotent bool) (*WriteRecordsReply, error) {
+	if err := c.checkTable(table); err != nil {
+		return nil, err
+	}
+	if len(request.Records) > c.BatchSize() {
+		return nil, fmt.Errorf("cannot write %d records in one request; the batch size is %d",
+			len(request.Records), c.BatchSize())
+	}
+	request.Typecast = c.Typecast
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var reply *WriteRecordsReply
+	err = c.retry(ctx, idempotent, func() (err error) {
+		reply, err = c.writeOnce(ctx, method, table, body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// CreateRecords creates up to BatchSize() records. It is only retried if it is rejected outright for exceeding the
+// rate limit; see ErrAmbiguousCreate.
+func (c *Clerk) CreateRecords(ctx context.Context, table string, fields []map[string]interface{}) ([]Record, error) {
+	request := writeRequest{}
+	for _, f := range fields {
+		request.Records = append(request.Records, writeRecord{Fields: f})
+	}
+	reply, err := c.write(ctx, http.MethodPost, table, request, false)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Records, nil
+}
+
+// UpsertRecords creates or updates up to BatchSize() records, matching existing records on the values of the
+// mergeOn fields. Because repeating an upsert has no further effect, transient failures are retried up to
+// Retries times.
+func (c *Clerk) UpsertRecords(ctx context.Context, table string, mergeOn []string, fields []map[string]interface{}) (*WriteRecordsReply, error) {
+	if len(mergeOn) == 0 {
+		return nil, errors.New("upsert requires at least one field to merge on")
+	}
+	request := writeRequest{PerformUpsert: &performUpsert{FieldsToMergeOn: mergeOn}}
+	for _, f := range fields {
+		request.Records = append(request.Records, writeRecord{Fields: f})
+	}
+	return c.write(ctx, http.MethodPatch, table, request, true)
+}
+
+// UpdateRecords changes the given fields of up to BatchSize() existing records, leaving their other fields as they
+// are. Because repeating an update has no further effect, transient failures are retried up to Retries times.
+func (c *Clerk) UpdateRecords(ctx context.Context, table string, records []Record) ([]Record, error) {
+	request := writeRequest{}
+	for _, record := range records {
+		if !IsAirTableId(record.Id) {
+			return nil, fmt.Errorf("not a valid record ID: %q", record.Id)
+		}
+		request.Records = append(request.Records, writeRecord{Id: record.Id, Fields: record.Fields})
+	}
+	reply, err := c.write(ctx, http.MethodPatch, table, request, true)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Records, nil
+}
+
+type deletedRecord struct {
+	Id      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+}
+
+type deleteRecordsReply struct {
+	Records []deletedRecord `json:"records"`
+}
+
+func (c *Clerk) deleteOnce(ctx context.Context, table string, ids []string) ([]string, error) {
+	query := url.Values{}
+	for _, id := range ids {
+		query.Add("records[]", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete,
+		"https://api.airtable.com/v0/"+c.App+"/"+table+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	response, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != 200 {
+		return nil, newStatusError(response, clock.Or(c.Clock).Now())
+	}
+	var result deleteRecordsReply
+	if err := c.decodeResponse(response, &result); err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, record := range result.Records {
+		if record.Deleted {
+			deleted = append(deleted, record.Id)
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteRecords deletes up to BatchSize() records by ID, and returns the IDs of the records deleted. Transient
+// failures are retried up to Retries times, but a retry fails if the lost attempt did delete the records, since they
+// no longer exist.
+func (c *Clerk) DeleteRecords(ctx context.Context, table string, ids []string) ([]string, error) {
+	if err := c.checkTable(table); err != nil {
+		return nil, err
+	}
+	if len(ids) > c.BatchSize() {
+		return nil, fmt.Errorf("cannot delete %d records in one request; the batch size is %d", len(ids), c.BatchSize())
+	}
+	for _, id := range ids {
+		if !IsAirTableId(id) {
+			return nil, fmt.Errorf("not a valid record ID: %q", id)
+		}
+	}
+	var deleted []string
+	err := c.retry(ctx, true, func() (err error) {
+		deleted, err = c.deleteOnce(ctx, table, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// splitBatches splits items into groups of at most size items.
+func splitBatches[T any](items []T, size int) [][]T {
+	var batches [][]T
+	for len(items) > size {
+		batches = append(batches, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		batches = append(batches, items)
+	}
+	return batches
+}
+
+// batches splits records into groups of at most BatchSize() records.
+func (c *Clerk) batches(fields []map[string]interface{}) [][]map[string]interface{} {
+	return splitBatches(fields, c.BatchSize())
+}
+
+// CreateAllRecords creates any number of records, BatchSize() at a time. If a batch fails, the records created by
+// earlier batches are returned along with the error.
+func (c *Clerk) CreateAllRecords(ctx context.Context, table string, fields []map[string]interface{}) ([]Record, error) {
+	var created []Record
+	for _, batch := range c.batches(fields) {
+		records, err := c.CreateRecords(ctx, table, batch)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, records...)
+	}
+	return created, nil
+}
+
+// UpsertAllRecords upserts any number of records, BatchSize() at a time.
+func (c *Clerk) UpsertAllRecords(ctx context.Context, table string, mergeOn []string, fields []map[string]interface{}) ([]Record, error) {
+	var upserted []Record
+	for _, batch := range c.batches(fields) {
+		reply, err := c.UpsertRecords(ctx, table, mergeOn, batch)
+		if err != nil {
+			return upserted, err
+		}
+		upserted = append(upserted, reply.Records...)
+	}
+	return upserted, nil
+}
+
+// UpdateAllRecords updates any number of records, BatchSize() at a time. If a batch fails, the records updated by
+// earlier batches are returned along with the error.
+func (c *Clerk) UpdateAllRecords(ctx context.Context, table string, records []Record) ([]Record, error) {
+	var updated []Record
+	for _, batch := range splitBatches(records, c.BatchSize()) {
+		reply, err := c.UpdateRecords(ctx, table, batch)
+		if err != nil {
+			return updated, err
+		}
+		updated = append(updated, reply...)
+	}
+	return updated, nil
+}
+
+// DeleteAllRecords deletes any number of records, BatchSize() at a time. If a batch fails, the IDs deleted by earlier
+// batches are returned along with the error.
+func (c *Clerk) DeleteAllRecords(ctx context.Context, table string, ids []string) ([]string, error) {
+	var deleted []string
+	for _, batch := range splitBatches(ids, c.BatchSize()) {
+		reply, err := c.DeleteRecords(ctx, table, batch)
+		if err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, reply...)
+	}
+	return deleted, nil
+}
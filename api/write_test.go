@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestClerk(t *testing.T, handler http.HandlerFunc) *Clerk {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: redirectTransport{target: target}}
+	return NewClerk("appAAAAAAAAAAAAAA", Config{
+		BearerToken: "keyAAAAAAAAAAAAAA",
+		Retries:     2,
+		Clock:       clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}, client)
+}
+
+func TestRetriedUpsertDoesNotDuplicate(t *testing.T) {
+	// a fake table that applies every upsert, but loses the response to the first one
+	stored := map[string]map[string]interface{}{}
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var request writeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		if r.Method != http.MethodPatch || request.PerformUpsert == nil {
+			t.Fatalf("expected an upsert, got %s %v", r.Method, request)
+		}
+		for _, record := range request.Records {
+			stored[record.Fields["Name"].(string)] = record.Fields
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [], "updatedRecords": ["recAAAAAAAAAAAAAA"]}`))
+	})
+	_, err := clerk.UpsertRecords(context.Background(), "tblAAAAAAAAAAAAAA", []string{"Name"},
+		[]map[string]interface{}{{"Name": "Widget", "Count": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected one retry, got %d requests", requests)
+	}
+	if len(stored) != 1 {
+		t.Errorf("expected one stored record, got %d", len(stored))
+	}
+}
+
+func TestCreateIsNotRetried(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	_, err := clerk.CreateRecords(context.Background(), "tblAAAAAAAAAAAAAA",
+		[]map[string]interface{}{{"Name": "Widget"}})
+	if !errors.Is(err, ErrAmbiguousCreate) {
+		t.Errorf("expected an ambiguous create error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("create should not have been retried, got %d requests", requests)
+	}
+}
+
+func TestRateLimitedCreateIsRetried(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}}]}`))
+	})
+	records, err := clerk.CreateRecords(context.Background(), "tblAAAAAAAAAAAAAA",
+		[]map[string]interface{}{{"Name": "Widget"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || len(records) != 1 {
+		t.Errorf("expected the rejected create to be repeated once, got %d requests", requests)
+	}
+}
+
+func TestCreateReportsFieldErrors(t *testing.T) {
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error": {"type": "INVALID_VALUE_FOR_COLUMN",
+			"message": "Field \"Count\" cannot accept the provided value"}}`))
+	})
+	_, err := clerk.CreateRecords(context.Background(), "tblAAAAAAAAAAAAAA",
+		[]map[string]interface{}{{"Count": "three"}})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected a status error, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusUnprocessableEntity || statusErr.Type != "INVALID_VALUE_FOR_COLUMN" {
+		t.Errorf("unexpected status error: %+v", statusErr)
+	}
+	if len(statusErr.FieldErrors) != 1 || statusErr.FieldErrors[0].Field != "Count" {
+		t.Errorf("expected a field error for Count, got %+v", statusErr.FieldErrors)
+	}
+	if errors.Is(err, ErrAmbiguousCreate) {
+		t.Error("a validation failure is not ambiguous")
+	}
+}
+
+func TestStatusErrorWithPlainErrorBody(t *testing.T) {
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": "NOT_FOUND"}`))
+	})
+	_, err := clerk.ListRecordsPage(context.Background(), "tblAAAAAAAAAAAAAA", "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Type != "NOT_FOUND" || len(statusErr.FieldErrors) != 0 {
+		t.Errorf("unexpected error: %#v", err)
+	}
+}
+
+func TestConfiguredBatchSize(t *testing.T) {
+	var sizes []int
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		var request writeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(request.Records))
+		reply := WriteRecordsReply{}
+		for range request.Records {
+			reply.Records = append(reply.Records, Record{Id: "recAAAAAAAAAAAAAA"})
+		}
+		_ = json.NewEncoder(w).Encode(reply)
+	})
+	var fields []map[string]interface{}
+	for i := 0; i < 10; i++ {
+		fields = append(fields, map[string]interface{}{"N": i})
+	}
+	clerk.WriteBatchSize = 4
+	created, err := clerk.CreateAllRecords(context.Background(), "tblAAAAAAAAAAAAAA", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 10 || !reflect.DeepEqual(sizes, []int{4, 4, 2}) {
+		t.Errorf("unexpected batches %v for %d records", sizes, len(created))
+	}
+	clerk.WriteBatchSize = 0
+	sizes = nil
+	if _, err := clerk.CreateAllRecords(context.Background(), "tblAAAAAAAAAAAAAA", append(fields, fields...)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sizes, []int{10, 10}) {
+		t.Errorf("default batch size should be 10, got batches %v", sizes)
+	}
+}
+
+func TestWriteBatchSizeIsValidated(t *testing.T) {
+	for size, valid := range map[int]bool{-1: false, 0: true, 1: true, 10: true, 11: false} {
+		config := Config{BearerToken: "keyAAAAAAAAAAAAAA", WriteBatchSize: size}
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("write-batch-size %d: expected valid=%v, got %v", size, valid, err)
+		}
+	}
+}
+
+func TestUpsertBackoffTiming(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	clerk.Clock = fakeClock
+	clerk.Retries = 3
+	_, err := clerk.UpsertRecords(context.Background(), "tblAAAAAAAAAAAAAA", []string{"Name"},
+		[]map[string]interface{}{{"Name": "Widget"}})
+	if err == nil {
+		t.Fatal("expected the upsert to fail")
+	}
+	if requests != 4 {
+		t.Errorf("expected 4 attempts, got %d", requests)
+	}
+	sleeps := fakeClock.Sleeps()
+	if len(sleeps) != 3 {
+		t.Fatalf("expected 3 backoff delays, got %v", sleeps)
+	}
+	for i, sleep := range sleeps {
+		ceiling := time.Second << i
+		if sleep < ceiling/2 || sleep > ceiling {
+			t.Errorf("delay %d should be between %v and %v, but was %v", i, ceiling/2, ceiling, sleep)
+		}
+	}
+}
+
+func TestCreateIsRetriedWhenRateLimited(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}}]}`))
+	})
+	records, err := clerk.CreateRecords(context.Background(), "tblAAAAAAAAAAAAAA",
+		[]map[string]interface{}{{"Name": "Widget"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || requests != 2 {
+		t.Errorf("a rejected create should be retried once, got %d records after %d requests", len(records), requests)
+	}
+}
+
+func TestUpdateRecordsIsRetried(t *testing.T) {
+	requests := 0
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var request writeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		if r.Method != http.MethodPatch || request.PerformUpsert != nil || len(request.Records) != 1 ||
+			request.Records[0].Id != "recAAAAAAAAAAAAAA" {
+			t.Errorf("expected an update, got %s %v", r.Method, request)
+		}
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(WriteRecordsReply{Records: []Record{
+			{Id: "recAAAAAAAAAAAAAA", Fields: request.Records[0].Fields},
+		}})
+	})
+	updated, err := clerk.UpdateRecords(context.Background(), "tblAAAAAAAAAAAAAA", []Record{
+		{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Count": 4.0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 || len(updated) != 1 || updated[0].Fields["Count"] != 4.0 {
+		t.Errorf("unexpected result after %d requests: %v", requests, updated)
+	}
+	if _, err := clerk.UpdateRecords(context.Background(), "tblAAAAAAAAAAAAAA", []Record{{Id: "../x"}}); err == nil {
+		t.Error("invalid record IDs should be rejected")
+	}
+}
+
+func TestDeleteAllRecords(t *testing.T) {
+	var batches [][]string
+	clerk := newTestClerk(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("expected a delete, got %s", r.Method)
+		}
+		ids := r.URL.Query()["records[]"]
+		batches = append(batches, ids)
+		reply := deleteRecordsReply{}
+		for _, id := range ids {
+			reply.Records = append(reply.Records, deletedRecord{Id: id, Deleted: true})
+		}
+		_ = json.NewEncoder(w).Encode(reply)
+	})
+	clerk.WriteBatchSize = 2
+	ids := []string{"recAAAAAAAAAAAAAA", "recBBBBBBBBBBBBBB", "recCCCCCCCCCCCCCC"}
+	deleted, err := clerk.DeleteAllRecords(context.Background(), "tblAAAAAAAAAAAAAA", ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, ids) || len(batches) != 2 || len(batches[1]) != 1 {
+		t.Errorf("unexpected deletes %v in batches %v", deleted, batches)
+	}
+}
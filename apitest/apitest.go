@@ -0,0 +1,353 @@
+// Package apitest runs an in-process fake of the AirTable API, for testing programs that back up or sync bases
+// without reaching AirTable. It serves the records of the tables added to it, in pages, along with their schemas and
+// attachments, and can enforce a rate limit like AirTable's.
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// AttachmentHost is the host that the links of attachments added with AddAttachment point at.
+const AttachmentHost = "v5.airtableusercontent.com"
+
+type table struct {
+	name    string
+	records []api.Record
+}
+
+type attachment struct {
+	filename    string
+	contentType string
+	content     []byte
+}
+
+// Server is a fake AirTable API. Its zero value is not usable; create one with NewServer.
+type Server struct {
+	// Token, if not empty, is the only bearer token that the server accepts.
+	Token string
+	// PageSize is the most records returned in each page; zero means api.MaxPageSize.
+	PageSize int
+	// RequestsPerSecond, if not zero, is the most requests to each base that are answered in any second; the rest
+	// receive a 429 response, as from AirTable.
+	RequestsPerSecond int
+	// Fallback, if not nil, handles the requests that the server does not fake itself, such as writes.
+	Fallback http.Handler
+
+	server *httptest.Server
+
+	mu          sync.Mutex
+	bases       map[string]map[string]*table
+	attachments map[string]attachment
+	requests    int
+	throttled   int
+	recent      map[string][]time.Time
+}
+
+// NewServer starts a fake AirTable API with no bases. It must be closed when no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		bases:       map[string]map[string]*table{},
+		attachments: map[string]attachment{},
+		recent:      map[string][]time.Time{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns a client that sends every request to the server, whatever host it is addressed to, so that the
+// usual AirTable and attachment links can be used unchanged.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.server.URL)
+	return &http.Client{Transport: redirectTransport{target: target, base: s.server.Client().Transport}}
+}
+
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return t.base.RoundTrip(req)
+}
+
+// AddTable adds a table to a base, which is created if needed, or replaces the table and its records if it exists.
+func (s *Server) AddTable(app, tableId, name string, records []api.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bases[app] == nil {
+		s.bases[app] = map[string]*table{}
+	}
+	s.bases[app][tableId] = &table{name: name, records: append([]api.Record(nil), records...)}
+}
+
+// SetRecords replaces the records of a table added with AddTable.
+func (s *Server) SetRecords(app, tableId string, records []api.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bases[app][tableId].records = append([]api.Record(nil), records...)
+}
+
+// AddAttachment serves a file, and returns the value of an attachment field holding it, to add to a record.
+func (s *Server) AddAttachment(filename, contentType string, content []byte) []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := fmt.Sprintf("att%014d", len(s.attachments)+1)
+	s.attachments[id] = attachment{filename: filename, contentType: contentType, content: content}
+	return []interface{}{map[string]interface{}{
+		"id":       id,
+		"url":      "https://" + AttachmentHost + "/apitest/" + id + "/" + url.PathEscape(filename),
+		"filename": filename,
+		"size":     float64(len(content)),
+		"type":     contentType,
+	}}
+}
+
+// Requests returns how many requests the server has received, and how many of them were refused by the rate limit.
+func (s *Server) Requests() (received, throttled int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.throttled
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, kind, message string) {
+	writeJSON(w, status, map[string]interface{}{"error": map[string]string{"type": kind, "message": message}})
+}
+
+// allow records a request to a base, and reports whether it is within the rate limit.
+func (s *Server) allow(app string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.RequestsPerSecond == 0 {
+		return true
+	}
+	recent := s.recent[app][:0]
+	for _, t := range s.recent[app] {
+		if now.Sub(t) < time.Second {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= s.RequestsPerSecond {
+		s.recent[app] = recent
+		s.throttled++
+		return false
+	}
+	s.recent[app] = append(recent, now)
+	return true
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/apitest/") {
+		s.serveAttachment(w, r)
+		return
+	}
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "AUTHENTICATION_REQUIRED", "Authentication required")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v0/"), "/")
+	app := parts[0]
+	if app == "meta" && len(parts) > 2 {
+		app = parts[2]
+	}
+	if !s.allow(app, time.Now()) {
+		writeError(w, http.StatusTooManyRequests, "RATE_LIMIT_REACHED", "Rate limit exceeded. Please try again later")
+		return
+	}
+	switch {
+	case r.Method != http.MethodGet:
+	case len(parts) == 2 && parts[0] == "meta" && parts[1] == "bases":
+		s.serveBases(w)
+		return
+	case len(parts) == 4 && parts[0] == "meta" && parts[1] == "bases" && parts[3] == "tables":
+		if s.serveSchema(w, parts[2]) {
+			return
+		}
+	case len(parts) == 2:
+		if s.serveRecords(w, r, parts[0], parts[1]) {
+			return
+		}
+	}
+	if s.Fallback != nil {
+		s.Fallback.ServeHTTP(w, r)
+		return
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "Could not find what you are looking for")
+}
+
+func (s *Server) serveBases(w http.ResponseWriter) {
+	s.mu.Lock()
+	reply := api.ListBasesReply{Bases: []api.Base{}}
+	for app := range s.bases {
+		reply.Bases = append(reply.Bases, api.Base{Id: app, Name: app, PermissionLevel: "create"})
+	}
+	s.mu.Unlock()
+	sort.Slice(reply.Bases, func(i, j int) bool {
+		return reply.Bases[i].Id < reply.Bases[j].Id
+	})
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// fieldType infers the type of a field from one of its values.
+func fieldType(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return "checkbox"
+	case float64:
+		return "number"
+	case []interface{}:
+		if len(v) > 0 {
+			if item, ok := v[0].(map[string]interface{}); ok && item["url"] != nil {
+				return "multipleAttachments"
+			}
+		}
+		return "multipleSelects"
+	case string:
+		if strings.Contains(v, "\n") {
+			return "multilineText"
+		}
+	}
+	return "singleLineText"
+}
+
+// serveSchema describes the tables of a base, with a field for each field name found in their records. The IDs of
+// the fields and views are made up from the order of the tables and fields.
+func (s *Server) serveSchema(w http.ResponseWriter, app string) bool {
+	s.mu.Lock()
+	tables, found := s.bases[app]
+	ids := make([]string, 0, len(tables))
+	for id := range tables {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	schema := api.BaseSchema{Tables: []api.TableSchema{}}
+	for n, id := range ids {
+		types := map[string]string{}
+		for _, record := range tables[id].records {
+			for name, value := range record.Fields {
+				if _, found := types[name]; !found {
+					types[name] = fieldType(value)
+				}
+			}
+		}
+		names := make([]string, 0, len(types))
+		for name := range types {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tableSchema := api.TableSchema{Id: id, Name: tables[id].name, Fields: []api.FieldSchema{},
+			Views: []api.ViewSchema{{Id: fmt.Sprintf("viw%014d", n+1), Name: "Grid view", Type: "grid"}}}
+		for i, name := range names {
+			tableSchema.Fields = append(tableSchema.Fields,
+				api.FieldSchema{Id: fmt.Sprintf("fld%07d%07d", n+1, i+1), Name: name, Type: types[name]})
+		}
+		if len(tableSchema.Fields) > 0 {
+			tableSchema.PrimaryFieldId = tableSchema.Fields[0].Id
+		}
+		schema.Tables = append(schema.Tables, tableSchema)
+	}
+	s.mu.Unlock()
+	if !found {
+		return false
+	}
+	writeJSON(w, http.StatusOK, schema)
+	return true
+}
+
+// serveRecords lists a page of a table's records. Formulas, views, and sorting are not supported: every record is
+// listed, in the order it was added.
+func (s *Server) serveRecords(w http.ResponseWriter, r *http.Request, app, tableId string) bool {
+	query := r.URL.Query()
+	s.mu.Lock()
+	t, found := s.bases[app][tableId]
+	var records []api.Record
+	if found {
+		records = t.records
+	}
+	s.mu.Unlock()
+	if !found {
+		return false
+	}
+	pageSize := s.PageSize
+	if pageSize == 0 {
+		pageSize = api.MaxPageSize
+	}
+	if requested, err := strconv.Atoi(query.Get("pageSize")); err == nil && requested > 0 && requested < pageSize {
+		pageSize = requested
+	}
+	if maxRecords, err := strconv.Atoi(query.Get("maxRecords")); err == nil && maxRecords > 0 &&
+		maxRecords < len(records) {
+		records = records[:maxRecords]
+	}
+	start := 0
+	if offset := query.Get("offset"); offset != "" {
+		var err error
+		if start, err = strconv.Atoi(offset); err != nil || start < 0 || start > len(records) {
+			writeError(w, http.StatusUnprocessableEntity, "LIST_RECORDS_ITERATOR_NOT_AVAILABLE",
+				"The offset is not valid")
+			return true
+		}
+	}
+	reply := api.ListRecordsReply{Records: []api.Record{}}
+	end := start + pageSize
+	if end < len(records) {
+		reply.Offset = strconv.Itoa(end)
+	} else {
+		end = len(records)
+	}
+	fields := query["fields[]"]
+	for _, record := range records[start:end] {
+		if len(fields) > 0 {
+			selected := map[string]interface{}{}
+			for _, name := range fields {
+				if value, found := record.Fields[name]; found {
+					selected[name] = value
+				}
+			}
+			record.Fields = selected
+		}
+		reply.Records = append(reply.Records, record)
+	}
+	writeJSON(w, http.StatusOK, reply)
+	return true
+}
+
+func (s *Server) serveAttachment(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/apitest/"), "/", 2)
+	s.mu.Lock()
+	file, found := s.attachments[parts[0]]
+	s.requests++
+	s.mu.Unlock()
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", file.contentType)
+	http.ServeContent(w, r, file.filename, time.Time{}, bytes.NewReader(file.content))
+}
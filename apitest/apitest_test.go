@@ -0,0 +1,95 @@
+package apitest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+const testToken = "keyAAAAAAAAAAAAAA"
+
+func TestServerListsPages(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Token, server.PageSize = testToken, 2
+	var records []api.Record
+	for _, id := range []string{"recAAAAAAAAAAAAAA", "recBBBBBBBBBBBBBB", "recCCCCCCCCCCCCCC"} {
+		records = append(records, api.Record{Id: id, Fields: map[string]interface{}{"Name": id, "Done": true}})
+	}
+	server.AddTable("appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA", "Tasks", records)
+
+	clerk := api.NewClerk("appAAAAAAAAAAAAAA", api.Config{BearerToken: testToken}, server.Client())
+	listed, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 || listed[2].Id != "recCCCCCCCCCCCCCC" {
+		t.Errorf("expected every record, in order, got %+v", listed)
+	}
+	if received, _ := server.Requests(); received != 2 {
+		t.Errorf("expected two pages, got %d requests", received)
+	}
+	schema, err := clerk.GetBaseSchema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Tables) != 1 || len(schema.Tables[0].Fields) != 2 || schema.Tables[0].Fields[0].Type != "checkbox" {
+		t.Errorf("expected the fields of the records in the schema, got %+v", schema)
+	}
+
+	clerk = api.NewClerk("appAAAAAAAAAAAAAA", api.Config{BearerToken: "keyBBBBBBBBBBBBBB"}, server.Client())
+	if _, err := clerk.ListRecordsAll(context.Background(), "tblAAAAAAAAAAAAAA"); err == nil {
+		t.Error("expected another token to be refused")
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.RequestsPerSecond = 1
+	server.AddTable("appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA", "Tasks", nil)
+	var statuses []int
+	for i := 0; i < 2; i++ {
+		resp, err := server.Client().Get("https://api.airtable.com/v0/appAAAAAAAAAAAAAA/tblAAAAAAAAAAAAAA")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("expected the second request to be throttled, got %v", statuses)
+	}
+	if _, throttled := server.Requests(); throttled != 1 {
+		t.Errorf("expected one throttled request, got %d", throttled)
+	}
+}
+
+func TestServerAttachments(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	value := server.AddAttachment("notes.txt", "text/plain", []byte("hello, world"))
+	link := value[0].(map[string]interface{})["url"].(string)
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=7-")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "world" {
+		t.Errorf("expected the rest of the attachment, got %d %q", resp.StatusCode, body)
+	}
+}
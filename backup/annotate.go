@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+const (
+	DefaultAnnotateBaseKey  = "_base"
+	DefaultAnnotateTableKey = "_table"
+)
+
+type AnnotateOptions struct {
+	// AnnotateSource adds the ID of the base and table that each record came from to its fields.
+	AnnotateSource bool   `json:"annotate-source,omitempty"`
+	BaseKey        string `json:"annotate-base-key,omitempty"`
+	TableKey       string `json:"annotate-table-key,omitempty"`
+}
+
+func (o AnnotateOptions) keys() (baseKey, tableKey string) {
+	baseKey, tableKey = o.BaseKey, o.TableKey
+	if baseKey == "" {
+		baseKey = DefaultAnnotateBaseKey
+	}
+	if tableKey == "" {
+		tableKey = DefaultAnnotateTableKey
+	}
+	return baseKey, tableKey
+}
+
+// AnnotateRecords adds source metadata to the fields of each record in place. It refuses to overwrite a real field
+// that happens to share a name with one of the metadata keys.
+func AnnotateRecords(records []api.Record, app, table string, opts AnnotateOptions) error {
+	if !opts.AnnotateSource {
+		return nil
+	}
+	baseKey, tableKey := opts.keys()
+	for i := range records {
+		if records[i].Fields == nil {
+			records[i].Fields = map[string]interface{}{}
+		}
+		for _, key := range []string{baseKey, tableKey} {
+			if _, found := records[i].Fields[key]; found {
+				return fmt.Errorf("record %s in table %s already has a field named %q; "+
+					"choose a different annotation key", records[i].Id, table, key)
+			}
+		}
+		records[i].Fields[baseKey] = app
+		records[i].Fields[tableKey] = table
+	}
+	return nil
+}
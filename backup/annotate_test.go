@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestAnnotatedRecordsCarrySource(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "x"}}]}`))
+	})
+	config := Config{
+		Config: api.Config{BearerToken: testToken},
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"},
+			"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+		},
+		AnnotateOptions: AnnotateOptions{AnnotateSource: true, TableKey: "__table"},
+	}
+	tables, err := ExtractAllTables(context.Background(), config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for app, appTables := range config.Tables {
+		for _, table := range appTables {
+			fields := tables[table][0].Fields
+			if fields["_base"] != app || fields["__table"] != table || fields["Name"] != "x" {
+				t.Errorf("unexpected fields for %s/%s: %v", app, table, fields)
+			}
+		}
+	}
+}
+
+func TestAnnotationRefusesToOverwriteFields(t *testing.T) {
+	records := []api.Record{{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"_table": "real data"}}}
+	err := AnnotateRecords(records, "appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA", AnnotateOptions{AnnotateSource: true})
+	if err == nil || !strings.Contains(err.Error(), "_table") {
+		t.Errorf("expected a collision error, got %v", err)
+	}
+}
@@ -0,0 +1,1318 @@
+// Package backup copies the tables of AirTable apps, and the attachments they reference, to local files. Run takes a
+// backup, and Load reads one back.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+	"github.com/hashicorp/go-multierror"
+)
+
+// DefaultAttachmentPrefixes are the hosts AirTable has served attachments from, used unless attachment-prefixes is
+// configured.
+var DefaultAttachmentPrefixes = []string{
+	"https://v5.airtableusercontent.com/",
+	"https://v4.airtableusercontent.com/",
+	"https://v3.airtableusercontent.com/",
+	"https://dl.airtable.com/",
+}
+
+const DefaultSizeMismatchRetries = 2
+
+type Config struct {
+	api.Config
+	// TokenFile names a file holding the API token, relative to the configuration file, and TokenCommand is a
+	// command (such as a password manager) that prints it. If neither they nor the token are set, the token is taken
+	// from TokenEnv.
+	TokenFile    string   `json:"token-file,omitempty"`
+	TokenCommand []string `json:"token-command,omitempty"`
+	// AppCredentials gives the token for the apps that are not accessed with the token above, such as bases in
+	// another workspace, by app ID.
+	AppCredentials map[string]Credential `json:"app-credentials,omitempty"`
+	// OAuth, if set, accesses the apps through an OAuth integration instead of with a token.
+	OAuth *OAuthSettings `json:"oauth,omitempty"`
+	// Tables lists the tables to back up in each app, by ID or by name. Names are resolved to IDs through the app's
+	// schema. An app with an empty list has its tables discovered through its schema, filtered by IncludeTables and
+	// ExcludeTables.
+	Tables         map[string][]string `json:"app-tables"`
+	DataDictionary string              `json:"data-dictionary,omitempty"`
+	ListWorkers    int                 `json:"list-workers"`
+	// AppRequestsPerSecond limits the list requests made against each app; zero means DefaultAppRequestsPerSecond.
+	AppRequestsPerSecond float64             `json:"app-requests-per-second,omitempty"`
+	TableTimeout         Duration            `json:"table-timeout,omitempty"`
+	TableTimeouts        map[string]Duration `json:"table-timeouts,omitempty"`
+	// Views lists records of the given tables from a view, by name or ID, so that only the records matching the
+	// view's filters are backed up, in the view's order.
+	Views map[string]string `json:"views,omitempty"`
+	// TableFields restricts the fields backed up from the given tables, such as to skip large or sensitive fields.
+	TableFields  map[string]FieldFilter `json:"table-fields,omitempty"`
+	DedupRecords bool                   `json:"dedup-records,omitempty"`
+	// Incremental only fetches the records modified since the last backup in the output directory's catalog, and
+	// merges them into that backup. Records deleted in the meantime are not noticed, so an occasional full backup is
+	// still needed.
+	Incremental bool   `json:"incremental,omitempty"`
+	ScopeCheck  string `json:"scope-check,omitempty"`
+	// SkipSchema leaves the schema of each base out of the backup, for tokens without the schema.bases:read scope.
+	SkipSchema bool `json:"skip-schema,omitempty"`
+	// IncludeTables restricts discovered tables to those with these names or IDs; empty means every table.
+	IncludeTables []string `json:"include-tables,omitempty"`
+	// ExcludeTables leaves discovered tables with these names or IDs out of the backup.
+	ExcludeTables []string `json:"exclude-tables,omitempty"`
+	// EncryptionKey, if set, encrypts the backup and the downloaded attachments with this base64 AES-256 key. If it
+	// is empty, the key is taken from EncryptionKeyEnv, if that is set. The data dictionary is not encrypted.
+	EncryptionKey string `json:"encryption-key,omitempty"`
+	// Retain, if enabled, writes each backup as a new snapshot with a timestamp in its name, and prunes the snapshots
+	// in the output directory's catalog that the policy does not keep.
+	Retain RetentionPolicy `json:"retain,omitempty"`
+	// Schedule is a cron-style schedule (see Schedule) on which the serve command backs up the apps that do not have
+	// their own schedule in Schedules.
+	Schedule  string            `json:"schedule,omitempty"`
+	Schedules map[string]string `json:"schedules,omitempty"`
+	// Layout is LayoutCombined (the default) to write the whole backup as one file, or LayoutPerTable or LayoutNDJSON
+	// to write each table to its own file next to it.
+	Layout string `json:"layout,omitempty"`
+	// StreamOutput writes the records of each table into the backup as they are listed, instead of collecting every
+	// table in memory first. Tables are then listed one at a time, the records are kept in the order AirTable lists
+	// them, and no content hash is recorded. It cannot be combined with dedup-records, incremental, data-dictionary,
+	// canonical-output, or the per-table layout.
+	StreamOutput bool `json:"stream-output,omitempty"`
+	// CanonicalOutput leaves out the expiring links to attachments, so that backups of unchanged data are
+	// byte-identical, as for keeping them in version control. Attachments in such backups can only be restored from
+	// the downloaded files.
+	CanonicalOutput bool `json:"canonical-output,omitempty"`
+	// KeepGoing records the tables that fail to be listed in the backup's metadata, with their errors, and backs up
+	// the rest, instead of failing the whole backup.
+	KeepGoing bool `json:"keep-going,omitempty"`
+	// Comments backs up the comments on every record. That takes at least one request per record, which is far more
+	// than listing the records, so it is not done by default. It cannot be combined with stream-output.
+	Comments bool `json:"comments,omitempty"`
+	// Permissions backs up the collaborators, invite links, share links, and workspace membership of each base, for
+	// auditing. It needs the enterprise metadata endpoints, and cannot be combined with stream-output.
+	Permissions bool `json:"permissions,omitempty"`
+	// HTTP configures the HTTP client: its timeouts and connections, and its proxy or the certificates of a proxy that
+	// intercepts TLS.
+	HTTP HTTPSettings `json:"http,omitempty"`
+	ExtractOptions
+	DownloadOptions
+	AnnotateOptions
+	RedactOptions
+	NotifyOptions
+
+	// only holds the table filters of SelectOnly, which DiscoverTables applies.
+	only *selection
+}
+
+// Duration is a time.Duration that is written in JSON as a string like "90s" or "5m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return fmt.Errorf("negative duration: %q", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// listOptions returns how to list a table, with records selected by formula, unless it is empty.
+func (c Config) listOptions(table, formula string) api.ListRecordsOptions {
+	return api.ListRecordsOptions{Formula: formula, View: c.Views[table], Fields: c.TableFields[table].Include}
+}
+
+// views returns the views that the configured tables are listed from, if any.
+func (c Config) views() map[string]string {
+	views := map[string]string{}
+	for _, tables := range c.Tables {
+		for _, table := range tables {
+			if view, found := c.Views[table]; found {
+				views[table] = view
+			}
+		}
+	}
+	if len(views) == 0 {
+		return nil
+	}
+	return views
+}
+
+// TimeoutFor returns the time budget for listing a table, or zero if the table may take as long as it needs.
+func (c Config) TimeoutFor(table string) time.Duration {
+	if timeout, found := c.TableTimeouts[table]; found {
+		return time.Duration(timeout)
+	}
+	return time.Duration(c.TableTimeout)
+}
+
+// LoadConfig reads and validates a configuration file in JSON, YAML, or TOML, filling in defaults for the settings it
+// leaves out.
+func LoadConfig(path string) (Config, error) {
+	config := Config{
+		Config: api.Config{
+			Retries:      api.DefaultRetries,
+			RateMonitors: &api.RateMonitors{},
+		},
+		ListWorkers: DefaultListWorkers,
+		DownloadOptions: DownloadOptions{
+			SizeMismatchRetries: DefaultSizeMismatchRetries,
+			Workers:             DefaultDownloadWorkers,
+		},
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if data, err = configJSON(path, data); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	if err := checkConfigJSON(data); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, err
+	}
+	if err := config.resolveToken(filepath.Dir(path)); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Key returns the key that the backup is to be encrypted with, or nil if it is not to be encrypted.
+func (c Config) Key() (EncryptionKey, error) {
+	if c.EncryptionKey != "" {
+		return ParseEncryptionKey(c.EncryptionKey)
+	}
+	return KeyFromEnvironment()
+}
+
+func (c Config) Validate() error {
+	if err := c.checkEntries(); err != nil {
+		return err
+	}
+	if err := c.validateCredentials(); err != nil {
+		return err
+	}
+	if _, err := c.Key(); err != nil {
+		return err
+	}
+	if err := c.ExtractOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.Retain.validate(); err != nil {
+		return err
+	}
+	if err := c.validateTableFields(); err != nil {
+		return err
+	}
+	if err := c.RedactOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.NotifyOptions.validate(); err != nil {
+		return err
+	}
+	if c.Layout != "" && c.Layout != LayoutCombined && c.Layout != LayoutPerTable && c.Layout != LayoutNDJSON {
+		return fmt.Errorf("invalid layout: %q", c.Layout)
+	}
+	if c.StreamOutput && (c.DedupRecords || c.Incremental || c.DataDictionary != "" || c.CanonicalOutput ||
+		c.Layout == LayoutPerTable || c.Layout == LayoutNDJSON) {
+		return errors.New("stream-output cannot be combined with dedup-records, incremental, data-dictionary, " +
+			"canonical-output, or the per-table or ndjson layouts")
+	}
+	if c.StreamOutput && (c.Comments || c.Permissions) {
+		return errors.New("stream-output cannot be combined with comments or permissions")
+	}
+	if _, err := c.jobs(); err != nil {
+		return err
+	}
+	if c.SizeMismatchRetries < 0 {
+		return fmt.Errorf("invalid size-mismatch-retries: %d", c.SizeMismatchRetries)
+	}
+	if c.ScopeCheck != ScopeCheckOff && c.ScopeCheck != ScopeCheckWarn && c.ScopeCheck != ScopeCheckError {
+		return fmt.Errorf("invalid scope-check: %q", c.ScopeCheck)
+	}
+	if baseKey, tableKey := c.AnnotateOptions.keys(); baseKey == tableKey {
+		return fmt.Errorf("annotate-base-key and annotate-table-key must differ, but are both %q", baseKey)
+	}
+	if c.ListWorkers < 1 {
+		return fmt.Errorf("invalid list-workers: %d", c.ListWorkers)
+	}
+	if c.AppRequestsPerSecond < 0 {
+		return fmt.Errorf("invalid app-requests-per-second: %v", c.AppRequestsPerSecond)
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("invalid download-workers: %d", c.Workers)
+	}
+	if c.DownloadOptions.RequestsPerSecond < 0 {
+		return fmt.Errorf("invalid download-requests-per-second: %v", c.DownloadOptions.RequestsPerSecond)
+	}
+	if c.BytesPerSecond < 0 {
+		return fmt.Errorf("invalid download-bytes-per-second: %v", c.BytesPerSecond)
+	}
+	if err := c.HTTP.validate(); err != nil {
+		return err
+	}
+	switch c.SizeMismatchPolicy {
+	case "", SizeMismatchAbort, SizeMismatchRedownload, SizeMismatchWarn:
+	default:
+		return fmt.Errorf("invalid size-mismatch-policy: %q", c.SizeMismatchPolicy)
+	}
+	return nil
+}
+
+type Backup struct {
+	Config      map[string][]string     `json:"config"`
+	Tables      map[string][]api.Record `json:"tables"`
+	Attachments []Attachment            `json:"attachments"`
+	// Schemas holds the schema of each backed-up base, keyed by app ID.
+	Schemas map[string]*api.BaseSchema `json:"schemas,omitempty"`
+	// Views holds the view that each table listed from a view was listed from. The records of those tables are kept in
+	// the view's order.
+	Views    map[string]string `json:"views,omitempty"`
+	Metadata *BackupMetadata   `json:"metadata,omitempty"`
+	// TableFiles names the file holding the records of each table, relative to the backup, when the backup was
+	// written with LayoutPerTable or LayoutNDJSON. Those records are loaded into Tables along with the backup.
+	TableFiles map[string]string `json:"table-files,omitempty"`
+	// Comments holds the comments on each record that has any, by record ID, when comments are backed up.
+	Comments map[string][]api.Comment `json:"comments,omitempty"`
+	// Permissions records who had access to the backed-up bases, when permissions are backed up.
+	Permissions *Permissions `json:"permissions,omitempty"`
+}
+
+// Load reads a backup written by Run. Encrypted backups are decrypted with the key from EncryptionKeyEnv.
+func Load(backupPath string) (*Backup, error) {
+	return LoadWithKey(backupPath, nil)
+}
+
+// LoadWithKey reads a backup written by Run, decrypting it with key if it is encrypted. A nil key means the key from
+// EncryptionKeyEnv.
+func LoadWithKey(backupPath string, key EncryptionKey) (*Backup, error) {
+	return loadBackup(context.Background(), LocalStorage(path.Dir(backupPath)), path.Base(backupPath), key)
+}
+
+func loadBackup(ctx context.Context, st Storage, name string, key EncryptionKey) (*Backup, error) {
+	f, err := st.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	plaintext, err := openMaybeEncrypted(f, key)
+	if err != nil {
+		return nil, fmt.Errorf("backup in %q: %w", st.Location(name), err)
+	}
+	var backup Backup
+	if err := json.NewDecoder(plaintext).Decode(&backup); err != nil {
+		return nil, fmt.Errorf("invalid backup in %q: %w", st.Location(name), err)
+	}
+	if err := backup.loadTableFiles(ctx, st, name, key); err != nil {
+		return nil, err
+	}
+	if err := backup.check(); err != nil {
+		return nil, fmt.Errorf("backup in %q: %w", st.Location(name), err)
+	}
+	return &backup, nil
+}
+
+// Save writes the backup to outputPath, encrypted with key unless it is nil. Tables, records, and attachments are
+// sorted, so that the same data is always written the same way.
+func (b *Backup) Save(outputPath string, key EncryptionKey) error {
+	_, err := b.save(context.Background(), LocalStorage(path.Dir(outputPath)), path.Base(outputPath), key)
+	return err
+}
+
+// save writes the backup into a Storage as a single file, in its canonical order, and returns the size of the file
+// written.
+func (b *Backup) save(ctx context.Context, st Storage, name string, key EncryptionKey) (int64, error) {
+	combined := b.canonical()
+	combined.Metadata = b.Metadata
+	return putJSON(ctx, st, name, key, &combined)
+}
+
+// putJSON writes a value as indented JSON into a Storage, encrypted with key unless it is nil, and returns the size of
+// the file written.
+func putJSON(ctx context.Context, st Storage, name string, key EncryptionKey, value interface{}) (int64, error) {
+	return putMaybeEncrypted(ctx, st, name, key, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	})
+}
+
+// putMaybeEncrypted is putEncoded, but encrypted with key unless it is nil.
+func putMaybeEncrypted(ctx context.Context, st Storage, name string, key EncryptionKey, encode func(w io.Writer) error) (int64, error) {
+	return putEncoded(ctx, st, name, func(w io.Writer) error {
+		if key == nil {
+			return encode(w)
+		}
+		encrypter, err := newEncryptingWriter(w, key)
+		if err != nil {
+			return err
+		}
+		if err := encode(encrypter); err != nil {
+			return err
+		}
+		return encrypter.Close()
+	})
+}
+
+// listTable lists the records of a table that the options select.
+func listTable(ctx context.Context, clerk *api.Clerk, table string, opts api.ListRecordsOptions,
+	timeout time.Duration) ([]api.Record, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	records, err := clerk.ListRecords(ctx, table, opts)
+	if err != nil {
+		return nil, &TableError{App: clerk.App, Table: table, Err: err}
+	}
+	return records, nil
+}
+
+// TableError is the failure of a single table.
+type TableError struct {
+	App   string
+	Table string
+	Err   error
+}
+
+func (e *TableError) Error() string {
+	return fmt.Sprintf("app %s -> table %s: %v", e.App, e.Table, e.Err)
+}
+
+func (e *TableError) Unwrap() error {
+	return e.Err
+}
+
+// tableFailures separates the failures of individual tables, which a keep-going backup records and moves past, from
+// any other error, which still fails the backup. The failures are returned as error messages by table.
+func tableFailures(ctx context.Context, err error) (map[string]string, error) {
+	if err == nil || ctx.Err() != nil {
+		return nil, err
+	}
+	errs := []error{err}
+	var merr *multierror.Error
+	if errors.As(err, &merr) {
+		errs = merr.Errors
+	}
+	failed := map[string]string{}
+	var fatal error
+	for _, err := range errs {
+		var tableErr *TableError
+		if !errors.As(err, &tableErr) {
+			fatal = multierror.Append(fatal, err)
+			continue
+		}
+		failed[tableErr.Table] = tableErr.Err.Error()
+		loggerFrom(ctx).Error("Table failed; backing up the others", "app", tableErr.App, "table", tableErr.Table,
+			"error", tableErr.Err)
+	}
+	return failed, fatal
+}
+
+// ExtractAllTables lists every configured table. If any table fails, the tables that did succeed are still returned
+// alongside the combined error.
+func ExtractAllTables(ctx context.Context, config Config, client *http.Client) (map[string][]api.Record, error) {
+	return extractTables(ctx, config, client, nil, nil, nil)
+}
+
+type SizeMismatchError struct {
+	Link     string
+	Received int64
+	Expected int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("mismatch on download for %q: received %d bytes but expected attachment to have %d",
+		e.Link, e.Received, e.Expected)
+}
+
+type Attachment struct {
+	Link string `json:"link"`
+	Id   string `json:"id"`
+	Size int64  `json:"size"`
+	// UnexpectedPrefix marks attachments whose link does not have one of the accepted prefixes. They are kept in
+	// the backup, but not downloaded.
+	UnexpectedPrefix bool `json:"unexpected-prefix,omitempty"`
+	// SHA256 is the hex SHA-256 of the downloaded attachment.
+	SHA256 string `json:"sha256,omitempty"`
+	// Filename and Type are the original filename and MIME type of the attachment, as reported by AirTable.
+	Filename string `json:"filename,omitempty"`
+	Type     string `json:"type,omitempty"`
+	// File is the name the attachment was downloaded as, within the download directory.
+	File string `json:"file,omitempty"`
+	// StoredSize and ModTime (in Unix nanoseconds) describe File as it was when SHA256 was computed from it. They are
+	// only recorded in the manifest of a local download directory, and let later runs trust SHA256 for as long as the
+	// file is unchanged.
+	StoredSize int64 `json:"stored-size,omitempty"`
+	ModTime    int64 `json:"mod-time,omitempty"`
+}
+
+type ExtractOptions struct {
+	// AttachmentPrefixes are the link prefixes that attachments are downloaded from; empty means
+	// DefaultAttachmentPrefixes.
+	AttachmentPrefixes []string `json:"attachment-prefixes,omitempty"`
+	// LenientPrefixes records attachments with unrecognized link prefixes instead of aborting the backup.
+	LenientPrefixes bool `json:"lenient-attachment-prefixes,omitempty"`
+	// SkipInvalidAttachments leaves attachments that cannot be extracted out of the backup, instead of failing it.
+	// Either way, they are listed in a report at the end of the run.
+	SkipInvalidAttachments bool `json:"skip-invalid-attachments,omitempty"`
+}
+
+var (
+	ErrUnexpectedPrefix      = errors.New("unexpected attachment link prefix")
+	ErrInvalidAttachmentId   = errors.New("invalid attachment ID")
+	ErrInvalidAttachmentSize = errors.New("invalid attachment size")
+)
+
+// AttachmentError describes an attachment that could not be extracted from a record. Err wraps ErrUnexpectedPrefix,
+// ErrInvalidAttachmentId, or ErrInvalidAttachmentSize.
+type AttachmentError struct {
+	Table  string
+	Record string
+	Field  string
+	Err    error
+}
+
+func (e *AttachmentError) Error() string {
+	return fmt.Sprintf("table %s -> record %s -> field %q: %v", e.Table, e.Record, e.Field, e.Err)
+}
+
+func (e *AttachmentError) Unwrap() error {
+	return e.Err
+}
+
+func (o ExtractOptions) prefixes() []string {
+	if len(o.AttachmentPrefixes) > 0 {
+		return o.AttachmentPrefixes
+	}
+	return DefaultAttachmentPrefixes
+}
+
+func (o ExtractOptions) hasKnownPrefix(link string) bool {
+	for _, prefix := range o.prefixes() {
+		if strings.HasPrefix(link, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o ExtractOptions) validate() error {
+	for _, prefix := range o.AttachmentPrefixes {
+		parsed, err := url.Parse(prefix)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("invalid attachment-prefixes entry %q: must be an https URL", prefix)
+		}
+	}
+	return nil
+}
+
+// IsAttachment reports whether an object in a field value has the shape of an AirTable attachment, whichever host
+// its link points to.
+func IsAttachment(itemMap map[string]interface{}) bool {
+	for _, key := range []string{"id", "url", "size", "filename"} {
+		if _, found := itemMap[key]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractAttachment recognizes an attachment by its shape. Attachments whose details do not make sense are reported
+// as errors, with found still set.
+func ExtractAttachment(itemMap map[string]interface{}, opts ExtractOptions) (found bool, attachment Attachment, err error) {
+	if !IsAttachment(itemMap) {
+		return false, Attachment{}, nil
+	}
+	urlStr, ok := itemMap["url"].(string)
+	if !ok {
+		return true, Attachment{}, fmt.Errorf("%w: link is %v, not a string", ErrUnexpectedPrefix, itemMap["url"])
+	}
+	unexpectedPrefix := !opts.hasKnownPrefix(urlStr)
+	if unexpectedPrefix && !opts.LenientPrefixes {
+		return true, Attachment{}, fmt.Errorf("%w: link=%q prefixes=%q", ErrUnexpectedPrefix, urlStr, opts.prefixes())
+	}
+	// This ID is used as a filename, so it had better not be anything odd.
+	idStr, ok := itemMap["id"].(string)
+	if !ok || !api.IsAirTableId(idStr) || !strings.HasPrefix(idStr, "att") {
+		return true, Attachment{}, fmt.Errorf("%w: %v", ErrInvalidAttachmentId, itemMap["id"])
+	}
+	size, ok := itemMap["size"].(float64)
+	if !ok || size < 0 || size != float64(int64(size)) {
+		return true, Attachment{}, fmt.Errorf("%w: %v", ErrInvalidAttachmentSize, itemMap["size"])
+	}
+	// the filename and type are informational, so odd values are left out rather than reported
+	filename, _ := itemMap["filename"].(string)
+	mimeType, _ := itemMap["type"].(string)
+	return true, Attachment{
+		Link:             urlStr,
+		Id:               idStr,
+		Size:             int64(size),
+		UnexpectedPrefix: unexpectedPrefix,
+		Filename:         filename,
+		Type:             mimeType,
+	}, nil
+}
+
+func ExtractRecordAttachments(table string, record api.Record, opts ExtractOptions) (attachments []Attachment, problems []*AttachmentError) {
+	for field, value := range record.Fields {
+		if contents, ok := value.([]interface{}); ok {
+			for _, item := range contents {
+				if itemMap, okMap := item.(map[string]interface{}); okMap {
+					found, attachment, err := ExtractAttachment(itemMap, opts)
+					if err != nil {
+						problems = append(problems, &AttachmentError{
+							Table:  table,
+							Record: record.Id,
+							Field:  field,
+							Err:    err,
+						})
+					} else if found {
+						attachments = append(attachments, attachment)
+					}
+				}
+			}
+		}
+	}
+	return attachments, problems
+}
+
+func ExtractAttachments(tables map[string][]api.Record, opts ExtractOptions) (attachments []Attachment, report AttachmentReport) {
+	for table, records := range tables {
+		for _, record := range records {
+			recordAttachments, problems := ExtractRecordAttachments(table, record, opts)
+			attachments = append(attachments, recordAttachments...)
+			report = append(report, problems...)
+		}
+	}
+	return attachments, report
+}
+
+// AttachmentReport lists the attachments that could not be extracted during a run.
+type AttachmentReport []*AttachmentError
+
+func (r AttachmentReport) Print(w io.Writer) {
+	for _, problem := range r.sorted() {
+		_, _ = fmt.Fprintf(w, "Invalid attachment: %s\n", problem.Error())
+	}
+}
+
+func (r AttachmentReport) sorted() AttachmentReport {
+	sorted := append(AttachmentReport(nil), r...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Record != b.Record {
+			return a.Record < b.Record
+		}
+		return a.Field < b.Field
+	})
+	return sorted
+}
+
+// Check logs the report, if it is not empty, and fails unless invalid attachments are being skipped.
+func (r AttachmentReport) Check(logger *slog.Logger, opts ExtractOptions) error {
+	if len(r) == 0 {
+		return nil
+	}
+	for _, problem := range r.sorted() {
+		logger.Warn("Invalid attachment", "table", problem.Table, "record", problem.Record, "field", problem.Field,
+			"error", problem.Err)
+	}
+	if opts.SkipInvalidAttachments {
+		logger.Warn("Skipped invalid attachments", "count", len(r))
+		return nil
+	}
+	return fmt.Errorf("found %d invalid attachment(s); set skip-invalid-attachments to back up everything else",
+		len(r))
+}
+
+// resumeOffset returns where a download can pick up from a partial temporary file left by an earlier attempt, or
+// zero if it has to start over.
+func resumeOffset(tempPath string, size int64) int64 {
+	fi, err := os.Stat(tempPath)
+	if err != nil || fi.Size() >= size {
+		return 0
+	}
+	return fi.Size()
+}
+
+// contentRangeStart parses the first byte position out of a Content-Range header such as "bytes 100-199/200".
+func contentRangeStart(header string) (int64, bool) {
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(header, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+// DownloadAttachment downloads a single attachment into outputDir, encrypting it with key unless that is nil. The
+// download goes to a temporary file first. If the transfer is interrupted, the temporary file is kept, and the next
+// attempt asks the server for only the remaining bytes with a Range request; servers that ignore the Range header
+// just send the whole file again. Encrypted downloads always start over.
+func DownloadAttachment(ctx context.Context, attachment Attachment, outputDir, outputFilename string, client *http.Client, key EncryptionKey) (errOut error) {
+	tempPath := path.Join(outputDir, "TEMP."+outputFilename)
+	outputPath := path.Join(outputDir, outputFilename)
+	var offset int64
+	if key == nil {
+		offset = resumeOffset(tempPath, attachment.Size)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.Link, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	if offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// the partial download no longer fits the file on the server, so start over without it
+		_ = resp.Body.Close()
+		loggerFrom(ctx).Warn("Discarding partial download", "link", attachment.Link, "offset", offset)
+		if err := os.Remove(tempPath); err != nil {
+			return err
+		}
+		return DownloadAttachment(ctx, attachment, outputDir, outputFilename, client, key)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			errOut = multierror.Append(errOut, err)
+		}
+	}()
+	var output *os.File
+	switch start, ok := contentRangeStart(resp.Header.Get("Content-Range")); {
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+		output, err = os.Create(tempPath)
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && ok && start == offset:
+		loggerFrom(ctx).Info("Resuming download", "link", attachment.Link, "offset", offset)
+		output, err = os.OpenFile(tempPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	default:
+		return fmt.Errorf("download of %q failed with HTTP status %d", attachment.Link, resp.StatusCode)
+	}
+	if err != nil {
+		return err
+	}
+	needsClose, needsRemove := true, true
+	defer func() {
+		if needsClose {
+			if err := output.Close(); err != nil {
+				errOut = multierror.Append(errOut, err)
+			}
+		}
+		if needsRemove {
+			if err := os.Remove(tempPath); err != nil {
+				errOut = multierror.Append(errOut, err)
+			}
+		}
+	}()
+	var w io.Writer = output
+	var encrypter io.WriteCloser
+	if key != nil {
+		if encrypter, err = newEncryptingWriter(output, key); err != nil {
+			return err
+		}
+		w = encrypter
+	}
+	if size, err := io.Copy(w, resp.Body); err != nil {
+		// keep what was received, so that the next attempt can resume from it
+		needsRemove = key != nil
+		return err
+	} else if offset+size != attachment.Size {
+		return &SizeMismatchError{Link: attachment.Link, Received: offset + size, Expected: attachment.Size}
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return err
+		}
+	}
+	needsClose = false
+	if err := output.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, outputPath); err != nil {
+		return err
+	}
+	needsRemove = false
+	return nil
+}
+
+// DownloadAttachmentRetrying retries downloads that come back with the wrong size, since the CDN occasionally serves
+// truncated bodies. If every attempt returns the same wrong size, the attachment metadata is more likely to be wrong
+// than the download, and the error says so.
+func DownloadAttachmentRetrying(ctx context.Context, attachment Attachment, outputDir, outputFilename string, client *http.Client, retries int, key EncryptionKey) error {
+	return retryOnSizeMismatch(ctx, attachment, retries, func() error {
+		return DownloadAttachment(ctx, attachment, outputDir, outputFilename, client, key)
+	})
+}
+
+func retryOnSizeMismatch(ctx context.Context, attachment Attachment, retries int, download func() error) error {
+	var sizes []int64
+	for attempt := 0; ; attempt++ {
+		err := download()
+		var mismatch *SizeMismatchError
+		if !errors.As(err, &mismatch) {
+			return err
+		}
+		sizes = append(sizes, mismatch.Received)
+		if attempt >= retries {
+			break
+		}
+		loggerFrom(ctx).Warn("Retrying download after size mismatch", "link", attachment.Link,
+			"attempt", attempt+1, "retries", retries)
+	}
+	for _, size := range sizes[1:] {
+		if size != sizes[0] {
+			return fmt.Errorf("download of %q was truncated on all %d attempts (received sizes %v, expected %d)",
+				attachment.Link, len(sizes), sizes, attachment.Size)
+		}
+	}
+	return fmt.Errorf("persistent size mismatch for %q: consistently received %d bytes over %d attempts, "+
+		"but metadata says %d; the attachment metadata may be wrong", attachment.Link, sizes[0], len(sizes), attachment.Size)
+}
+
+// sizeCheckingReader fails with a SizeMismatchError, instead of reaching the end, if the attachment it reads is not
+// of the expected size.
+type sizeCheckingReader struct {
+	r          io.Reader
+	attachment Attachment
+	received   int64
+}
+
+func (s *sizeCheckingReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.received += int64(n)
+	if err == io.EOF && s.received != s.attachment.Size {
+		return n, &SizeMismatchError{Link: s.attachment.Link, Received: s.received, Expected: s.attachment.Size}
+	}
+	return n, err
+}
+
+// streamAttachment downloads an attachment straight into a Storage, encrypting it with key unless that is nil, and
+// returns the SHA-256 of what was stored.
+func streamAttachment(ctx context.Context, attachment Attachment, st Storage, filename string, client *http.Client, key EncryptionKey) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.Link, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download of %q failed with HTTP status %d", attachment.Link, resp.StatusCode)
+	}
+	body := &sizeCheckingReader{r: resp.Body, attachment: attachment}
+	hash := sha256.New()
+	_, err = putEncoded(ctx, st, filename, func(w io.Writer) error {
+		w = io.MultiWriter(w, hash)
+		if key == nil {
+			_, err := io.Copy(w, body)
+			return err
+		}
+		encrypter, err := newEncryptingWriter(w, key)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(encrypter, body); err != nil {
+			return err
+		}
+		return encrypter.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func DownloadAttachments(ctx context.Context, attachments []Attachment, downloadDir string, client *http.Client, opts DownloadOptions) error {
+	pool, err := StartDownloadPool(ctx, downloadDir, client, opts)
+	if err != nil {
+		return err
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].Id < attachments[j].Id
+	})
+	for _, attachment := range attachments {
+		pool.Add(attachment)
+	}
+	return pool.Wait()
+}
+
+// SelectApps restricts the configuration to a subset of its apps, so that a large configuration can be split across
+// several invocations.
+func (c Config) SelectApps(apps []string) (Config, error) {
+	selected := map[string][]string{}
+	for _, app := range apps {
+		tables, found := c.Tables[app]
+		if !found {
+			return Config{}, fmt.Errorf("selected app %q is not in the configuration", app)
+		}
+		selected[app] = tables
+	}
+	c.Tables = selected
+	return c, nil
+}
+
+// SelectOnly restricts the configuration to the apps and tables matched by filters like "app:appXXXXXXXXXXXXXX" and
+// "table:tblXXXXXXXXXXXXXX", so that part of a backup can be run again after a failure. The apps matched by an app
+// filter are kept whole. Table filters match the tables of the other apps by name or ID once DiscoverTables has
+// resolved them, including the tables it discovers in the apps that list none.
+func (c Config) SelectOnly(filters []string) (Config, error) {
+	only := &selection{apps: map[string]bool{}, tables: map[string]bool{}}
+	for _, filter := range filters {
+		kind, value, _ := strings.Cut(filter, ":")
+		switch {
+		case kind == "app" && value != "":
+			if _, found := c.Tables[value]; !found {
+				return Config{}, fmt.Errorf("selected app %q is not in the configuration", value)
+			}
+			only.apps[value] = true
+		case kind == "table" && value != "":
+			only.tables[value] = true
+		default:
+			return Config{}, fmt.Errorf("invalid filter %q: expected app:<app ID> or table:<table name or ID>", filter)
+		}
+	}
+	// tables listed the same way as they are filtered are matched now; the rest can only be matched once resolved
+	matched := map[string]bool{}
+	for _, appTables := range c.Tables {
+		for _, table := range appTables {
+			if only.tables[table] {
+				matched[table] = true
+			}
+		}
+	}
+	var unmatched []string
+	for table := range only.tables {
+		if !matched[table] {
+			unmatched = append(unmatched, table)
+		}
+	}
+	sort.Strings(unmatched)
+	unmatchedIds := false
+	for _, table := range unmatched {
+		unmatchedIds = unmatchedIds || isTableId(table)
+	}
+	selected := map[string][]string{}
+	discovering, naming := false, false
+	for app, appTables := range c.Tables {
+		if only.apps[app] {
+			selected[app] = appTables
+			continue
+		}
+		// the tables that an app discovers may match any filter, and those it lists by name may match table IDs
+		discovers, names := len(appTables) == 0, !allTableIds(appTables)
+		keep := (len(unmatched) > 0 && discovers) || (unmatchedIds && names)
+		for _, table := range appTables {
+			keep = keep || only.tables[table]
+		}
+		if keep {
+			selected[app] = appTables
+			discovering, naming = discovering || discovers, naming || names
+		}
+	}
+	for _, table := range unmatched {
+		if !discovering && !(naming && isTableId(table)) {
+			return Config{}, fmt.Errorf("selected tables are not in the configuration: %s",
+				strings.Join(unmatched, ", "))
+		}
+	}
+	c.Tables = selected
+	if len(only.tables) > 0 {
+		c.only = only
+	}
+	return c, nil
+}
+
+func allTableIds(tables []string) bool {
+	for _, table := range tables {
+		if !isTableId(table) {
+			return false
+		}
+	}
+	return true
+}
+
+// selection holds the filters of Config.SelectOnly, for DiscoverTables to match tables against once they are resolved
+// to their IDs.
+type selection struct {
+	apps   map[string]bool
+	tables map[string]bool
+}
+
+// apply restricts the resolved tables of the apps that were not selected whole to those that a table filter matches
+// by ID, or by one of the names in names, which are keyed by table ID.
+func (s *selection) apply(resolved, names map[string][]string) (map[string][]string, error) {
+	selected := map[string][]string{}
+	matched := map[string]bool{}
+	for app, tables := range resolved {
+		if s.apps[app] {
+			selected[app] = tables
+			continue
+		}
+		for _, table := range tables {
+			found := false
+			for _, name := range append([]string{table}, names[table]...) {
+				if s.tables[name] {
+					matched[name], found = true, true
+				}
+			}
+			if found {
+				selected[app] = append(selected[app], table)
+			}
+		}
+	}
+	var unmatched []string
+	for table := range s.tables {
+		if !matched[table] {
+			unmatched = append(unmatched, table)
+		}
+	}
+	if len(unmatched) > 0 {
+		sort.Strings(unmatched)
+		return nil, fmt.Errorf("selected tables are not in the configuration: %s", strings.Join(unmatched, ", "))
+	}
+	return selected, nil
+}
+
+// ParseList splits a comma-separated list, such as of app IDs, ignoring empty entries.
+func ParseList(list string) []string {
+	var apps []string
+	for _, app := range strings.Split(list, ",") {
+		if app = strings.TrimSpace(app); app != "" {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
+
+// Overrides are settings given on the command line, which take precedence over the configuration file.
+type Overrides struct {
+	// Apps, if not empty, restricts the backup to these apps from the configuration.
+	Apps            []string
+	ListWorkers     int
+	DownloadWorkers int
+	// BytesPerSecond, if positive, replaces the download bandwidth limit of the configuration.
+	BytesPerSecond int64
+	// Retain, if enabled, replaces the retention policy of the configuration.
+	Retain RetentionPolicy
+	// KeepGoing, if set, backs up the other tables when some fail.
+	KeepGoing bool
+	// FullVerify, if set, hashes every attachment already downloaded; see DownloadOptions.FullVerify.
+	FullVerify bool
+	// Only, if not empty, restricts the backup to the apps and tables matched by these filters; see
+	// Config.SelectOnly.
+	Only []string
+	// Progress, if not nil, displays the progress of the backup.
+	Progress *Progress
+}
+
+// RunConfigFile runs a backup using the configuration at configPath.
+func RunConfigFile(ctx context.Context, configPath, outputPath, downloadPath string, opts Overrides) error {
+	options, err := LoadOptions(configPath, outputPath, downloadPath, opts)
+	if err != nil {
+		return err
+	}
+	return Run(ctx, options)
+}
+
+// LoadOptions describes a backup using the configuration at configPath.
+func LoadOptions(configPath, outputPath, downloadPath string, opts Overrides) (Options, error) {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return Options{}, err
+	}
+	if len(opts.Apps) > 0 {
+		if config, err = config.SelectApps(opts.Apps); err != nil {
+			return Options{}, err
+		}
+	}
+	if len(opts.Only) > 0 {
+		if config, err = config.SelectOnly(opts.Only); err != nil {
+			return Options{}, err
+		}
+	}
+	if opts.ListWorkers > 0 {
+		config.ListWorkers = opts.ListWorkers
+	}
+	if opts.DownloadWorkers > 0 {
+		config.Workers = opts.DownloadWorkers
+	}
+	if opts.BytesPerSecond > 0 {
+		config.BytesPerSecond = opts.BytesPerSecond
+	}
+	if opts.Retain.Enabled() {
+		config.Retain = opts.Retain
+	}
+	if opts.KeepGoing {
+		config.KeepGoing = true
+	}
+	if opts.FullVerify {
+		config.FullVerify = true
+	}
+	return Options{Config: config, OutputPath: outputPath, DownloadPath: downloadPath, Progress: opts.Progress}, nil
+}
+
+// Options describes a single backup run.
+type Options struct {
+	Config Config
+	// Client is used for every request to AirTable and its attachment host; nil means a client with the
+	// configuration's HTTP settings.
+	Client *http.Client
+	// OutputPath is where the backup is written; its directory also holds the catalog of earlier backups. It may be
+	// an s3:// or gs:// URL, such as s3://bucket/prefix/name.json. With a retention policy, a timestamp is added to
+	// the name.
+	OutputPath string
+	// DownloadPath is the directory that attachments are downloaded into, or an s3:// or gs:// URL of a prefix.
+	DownloadPath string
+	// Metrics, if not nil, accumulates statistics about the run.
+	Metrics *Metrics
+	// Progress, if not nil, displays the progress of the run.
+	Progress *Progress
+}
+
+// Run lists the configured tables, downloads their attachments, and writes the backup. Cancelling ctx aborts the
+// backup without writing the output file. Either way, a summary of the run is sent to the configured notifications.
+func Run(ctx context.Context, opts Options) error {
+	if opts.Client == nil {
+		var err error
+		if opts.Client, err = opts.Config.HTTP.Client(); err != nil {
+			return err
+		}
+	}
+	ctx = withRunId(ctx)
+	summary := &RunSummary{Output: opts.OutputPath, Started: clock.Or(opts.Config.Clock).Now()}
+	err := run(ctx, opts, summary)
+	summary.finish(clock.Or(opts.Config.Clock).Now(), err)
+	opts.Config.NotifyOptions.send(ctx, opts.Client, summary)
+	return err
+}
+
+func run(ctx context.Context, opts Options, summary *RunSummary) error {
+	config, client, outputPath, downloadPath := opts.Config, opts.Client, opts.OutputPath, opts.DownloadPath
+	if client == nil {
+		client = &http.Client{}
+	}
+	startTime := summary.Started
+	client = withMetrics(client, opts.Metrics, config.Clock)
+	if opts.Metrics != nil {
+		onRetry := config.OnRetry
+		config.OnRetry = func(err error) {
+			opts.Metrics.recordRetry(err)
+			if onRetry != nil {
+				onRetry(err)
+			}
+		}
+	}
+	key, err := config.Key()
+	if err != nil {
+		return err
+	}
+	output, outputName, err := splitLocation(outputPath, client)
+	if err != nil {
+		return err
+	}
+	if config.Retain.Enabled() {
+		outputName = snapshotName(outputName, startTime)
+	}
+	summary.Output = output.Location(outputName)
+	if err := CheckTokenScope(ctx, config, client); err != nil {
+		return err
+	}
+	var schemas map[string]*api.BaseSchema
+	if !config.SkipSchema {
+		var err error
+		if schemas, err = FetchSchemas(ctx, config, client); err != nil {
+			return err
+		}
+	}
+	config, err = DiscoverTables(ctx, config, client, schemas)
+	if err != nil {
+		return err
+	}
+	var permissions *Permissions
+	if config.Permissions {
+		if permissions, err = FetchPermissions(ctx, config, client); err != nil {
+			return err
+		}
+	}
+	// Attachments are downloaded while the remaining tables are still being listed.
+	downloadOptions := config.DownloadOptions
+	downloadOptions.clock = config.Clock
+	downloadOptions.key = key
+	downloadOptions.metrics = opts.Metrics
+	downloadOptions.progress = opts.Progress
+	pool, err := StartDownloadPool(ctx, downloadPath, client, downloadOptions)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		summary.BytesDownloaded = pool.BytesDownloaded()
+		summary.SizeMismatches = pool.SizeMismatches()
+	}()
+	if config.StreamOutput {
+		stream := &backupStream{
+			config:   config,
+			client:   client,
+			pool:     pool,
+			schemas:  schemas,
+			metrics:  opts.Metrics,
+			progress: opts.Progress,
+		}
+		err := stream.run(ctx, output, outputName, key, startTime)
+		summary.Tables, summary.Records = stream.tables, stream.records
+		if len(stream.metadata.FailedTables) > 0 {
+			summary.FailedTables = stream.metadata.FailedTables
+		}
+		return err
+	}
+	var base *incrementalBase
+	if config.Incremental {
+		if base, err = loadIncrementalBase(ctx, output, key); err != nil {
+			return err
+		}
+	}
+	var reportMu sync.Mutex
+	var report AttachmentReport
+	tables, err := extractTables(ctx, config, client, base, opts.Progress, func(app, table string, records []api.Record) {
+		opts.Metrics.recordRecords(app, table, len(records))
+		for _, record := range records {
+			attachments, problems := ExtractRecordAttachments(table, record, config.ExtractOptions)
+			for _, attachment := range attachments {
+				pool.Add(attachment)
+			}
+			reportMu.Lock()
+			report = append(report, problems...)
+			reportMu.Unlock()
+		}
+	})
+	if config.KeepGoing {
+		summary.FailedTables, err = tableFailures(ctx, err)
+	}
+	downloadErr := pool.Wait()
+	opts.Progress.Finish()
+	summary.Tables = len(tables)
+	for _, records := range tables {
+		summary.Records += len(records)
+	}
+	if err != nil {
+		return multierror.Append(err, downloadErr)
+	}
+	if err := report.Check(loggerFrom(ctx), config.ExtractOptions); err != nil {
+		return multierror.Append(err, downloadErr)
+	}
+	if config.DedupRecords {
+		var report DedupReport
+		tables, report, err = DedupRecords(tables)
+		if err != nil {
+			return err
+		}
+		report.Log(loggerFrom(ctx))
+	}
+	// any invalid attachments were already reported as their tables were listed
+	attachments, _ := ExtractAttachments(tables, config.ExtractOptions)
+	backup := Backup{
+		Config:      config.Tables,
+		Tables:      tables,
+		Attachments: attachments,
+		Schemas:     schemas,
+		Views:       config.views(),
+		Permissions: permissions,
+	}
+	if config.Comments {
+		if backup.Comments, err = fetchComments(ctx, config, client, tables); err != nil {
+			return multierror.Append(err, downloadErr)
+		}
+	}
+	for i := range backup.Attachments {
+		if downloaded, found := pool.Downloaded(backup.Attachments[i].Id); found {
+			backup.Attachments[i].SHA256 = downloaded.SHA256
+			backup.Attachments[i].File = downloaded.File
+		}
+	}
+	if config.CanonicalOutput {
+		backup = backup.withoutLinks()
+	}
+	contentHash, err := backup.ContentHash()
+	if err != nil {
+		return err
+	}
+	backup.Metadata = newMetadata(backup.Tables)
+	backup.Metadata.ContentHash = contentHash
+	backup.Metadata.FailedTables = summary.FailedTables
+	if !config.CanonicalOutput {
+		finished := clock.Or(config.Clock).Now()
+		backup.Metadata.Started, backup.Metadata.Finished = &startTime, &finished
+	}
+	save := backup.save
+	switch config.Layout {
+	case LayoutPerTable:
+		save = backup.savePerTable
+	case LayoutNDJSON:
+		save = backup.saveNDJSON
+	}
+	size, err := save(ctx, output, outputName, key)
+	if err != nil {
+		return err
+	}
+	if config.DataDictionary != "" {
+		if err := BuildDataDictionary(tables).Save(config.DataDictionary); err != nil {
+			return err
+		}
+	}
+	if downloadErr != nil {
+		return downloadErr
+	}
+	if err := appendToCatalog(ctx, output, outputName, size, &backup, startTime); err != nil {
+		return err
+	}
+	opts.Metrics.recordSuccess(config.Tables, clock.Or(config.Clock).Now())
+	return pruneBackups(ctx, output, config.Retain)
+}
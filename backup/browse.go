@@ -0,0 +1,297 @@
+package backup
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// BrowsePageSize is the number of records on each page of a table in the browser.
+const BrowsePageSize = 50
+
+// browseCellLength is the length that values are cut to in the list of a table's records.
+const browseCellLength = 100
+
+var browseTemplates = template.Must(template.New("").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+td { white-space: pre-wrap; }
+img { max-width: 30em; max-height: 20em; display: block; }
+</style></head><body>
+<p><a href="/">Tables</a></p>
+{{end}}
+{{define "footer"}}</body></html>
+{{end}}
+{{define "cell"}}{{if .Attachments}}{{range .Attachments}}<div>
+{{- if .Downloaded}}<a href="{{.Link}}">{{.Name}}</a>{{else}}{{.Name}} (not downloaded){{end}}</div>
+{{- end}}{{else}}{{.Text}}{{end}}{{end}}
+{{define "tables"}}{{template "header" "Backup"}}
+<h1>Backup</h1>
+<table><tr><th>Table</th><th>ID</th><th>Records</th></tr>
+{{range .}}<tr><td><a href="/tables/{{.Id}}">{{.Name}}</a></td><td>{{.Id}}</td><td>{{.Records}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+{{define "table"}}{{template "header" .Name}}
+<h1>{{.Name}}</h1>
+<p>Records {{.First}} to {{.Last}} of {{.Records}}.
+{{if .Prev}}<a href="?page={{.Prev}}">Previous</a>{{end}} {{if .Next}}<a href="?page={{.Next}}">Next</a>{{end}}</p>
+<table><tr><th>ID</th>{{range .Fields}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td><a href="/records/{{$.Id}}/{{.Id}}">{{.Id}}</a></td>{{range .Cells}}<td>
+{{- template "cell" .}}</td>{{end}}</tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+{{define "record"}}{{template "header" .Id}}
+<h1>{{.Id}}</h1>
+<p>In table <a href="/tables/{{.Table}}">{{.TableName}}</a>{{if .CreatedTime}}, created {{.CreatedTime}}{{end}}.</p>
+<table>{{range .Fields}}<tr><th>{{.Name}}</th><td>{{template "cell" .Cell}}
+{{- range .Cell.Attachments}}{{if and .Downloaded .Image}}<img src="{{.Link}}" alt="{{.Name}}">{{end}}{{end}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+`))
+
+type browseAttachment struct {
+	Name       string
+	Link       string
+	Downloaded bool
+	Image      bool
+}
+
+type browseCell struct {
+	Text        string
+	Attachments []browseAttachment
+}
+
+// Browser serves a read-only web interface over a backup: its tables, their records, and the downloaded attachments.
+type Browser struct {
+	backup       *Backup
+	downloadPath string
+	key          EncryptionKey
+	names        map[string]string
+	dictionary   DataDictionary
+	attachments  map[string]Attachment
+}
+
+// NewBrowser returns a browser over a backup, whose attachments were downloaded into downloadPath, if it is not
+// empty. Encrypted attachments are decrypted with key, or the key from the environment if it is nil.
+func NewBrowser(backup *Backup, downloadPath string, key EncryptionKey) *Browser {
+	b := &Browser{
+		backup:       backup,
+		downloadPath: downloadPath,
+		key:          key,
+		names:        backup.tableNames(),
+		dictionary:   BuildDataDictionary(backup.Tables),
+		attachments:  map[string]Attachment{},
+	}
+	for _, attachment := range backup.Attachments {
+		b.attachments[attachment.Id] = attachment
+	}
+	return b
+}
+
+// Handler returns the handler serving the browser's pages. It only answers GET and HEAD requests, since nothing in a
+// backup can be changed.
+func (b *Browser) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", b.serveTables)
+	mux.HandleFunc("/tables/", b.serveTable)
+	mux.HandleFunc("/records/", b.serveRecord)
+	mux.HandleFunc("/attachments/", b.serveAttachment)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (b *Browser) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := browseTemplates.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (b *Browser) serveTables(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	type tableView struct {
+		Id, Name string
+		Records  int
+	}
+	var tables []tableView
+	for table, records := range b.backup.Tables {
+		tables = append(tables, tableView{Id: table, Name: b.names[table], Records: len(records)})
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+	b.render(w, "tables", tables)
+}
+
+func (b *Browser) serveTable(w http.ResponseWriter, r *http.Request) {
+	table := strings.TrimPrefix(r.URL.Path, "/tables/")
+	records, found := b.backup.Tables[table]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	start := min((page-1)*BrowsePageSize, len(records))
+	end := min(start+BrowsePageSize, len(records))
+	type rowView struct {
+		Id    string
+		Cells []browseCell
+	}
+	view := struct {
+		Id, Name                         string
+		Records, First, Last, Prev, Next int
+		Fields                           []string
+		Rows                             []rowView
+	}{Id: table, Name: b.names[table], Records: len(records), First: start + 1, Last: end}
+	if page > 1 {
+		view.Prev = page - 1
+	}
+	if end < len(records) {
+		view.Next = page + 1
+	}
+	for _, field := range b.dictionary[table] {
+		view.Fields = append(view.Fields, field.Name)
+	}
+	for _, record := range records[start:end] {
+		row := rowView{Id: record.Id}
+		for _, field := range b.dictionary[table] {
+			cell := b.cell(record.Fields[field.Name])
+			cell.Text = truncateRunes(cell.Text, browseCellLength)
+			row.Cells = append(row.Cells, cell)
+		}
+		view.Rows = append(view.Rows, row)
+	}
+	b.render(w, "table", view)
+}
+
+func (b *Browser) serveRecord(w http.ResponseWriter, r *http.Request) {
+	table, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/records/"), "/")
+	var record *api.Record
+	for i := range b.backup.Tables[table] {
+		if b.backup.Tables[table][i].Id == id {
+			record = &b.backup.Tables[table][i]
+		}
+	}
+	if record == nil {
+		http.NotFound(w, r)
+		return
+	}
+	type fieldView struct {
+		Name string
+		Cell browseCell
+	}
+	view := struct {
+		Id, Table, TableName, CreatedTime string
+		Fields                            []fieldView
+	}{Id: record.Id, Table: table, TableName: b.names[table], CreatedTime: record.CreatedTime}
+	names := make([]string, 0, len(record.Fields))
+	for name := range record.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		view.Fields = append(view.Fields, fieldView{Name: name, Cell: b.cell(record.Fields[name])})
+	}
+	b.render(w, "record", view)
+}
+
+// cell converts a field value into its text, or the attachments it lists.
+func (b *Browser) cell(value interface{}) browseCell {
+	if attachments, ok := listedAttachments(value, b.attachments); ok {
+		var cell browseCell
+		for _, attachment := range attachments {
+			name := attachment.Filename
+			if name == "" {
+				name = attachment.Id
+			}
+			_, downloaded := b.downloaded(attachment)
+			cell.Attachments = append(cell.Attachments, browseAttachment{
+				Name:       name,
+				Link:       "/attachments/" + attachment.Id,
+				Downloaded: downloaded,
+				Image:      strings.HasPrefix(attachment.Type, "image/"),
+			})
+		}
+		return cell
+	}
+	text, err := csvCell(value)
+	if err != nil {
+		text = err.Error()
+	}
+	return browseCell{Text: text}
+}
+
+// downloaded returns the path of a downloaded attachment, or false if it was not downloaded.
+func (b *Browser) downloaded(attachment Attachment) (string, bool) {
+	if b.downloadPath == "" || attachment.UnexpectedPrefix {
+		return "", false
+	}
+	file := attachment.File
+	if file == "" {
+		file = attachment.DownloadFilename(false)
+	}
+	// the file comes from the backup, which must not be able to point outside the download directory
+	file = filepath.FromSlash(file)
+	if !filepath.IsLocal(file) {
+		return "", false
+	}
+	filename := filepath.Join(b.downloadPath, file)
+	if _, err := os.Stat(filename); err != nil {
+		return "", false
+	}
+	return filename, true
+}
+
+// serveAttachment serves a downloaded attachment to be shown in the browser, decrypting it if needed.
+func (b *Browser) serveAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, found := b.attachments[strings.TrimPrefix(r.URL.Path, "/attachments/")]
+	filename, downloaded := b.downloaded(attachment)
+	if !found || !downloaded {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	plaintext, err := openMaybeEncrypted(f, b.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if attachment.Type != "" {
+		w.Header().Set("Content-Type", attachment.Type)
+	}
+	// attachments are shown inline, but never run as pages of the browser itself
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", "inline; filename="+strconv.Quote(sanitizeFilename(attachment.Filename)))
+	_, _ = io.Copy(w, plaintext)
+}
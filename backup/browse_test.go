@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestBrowser(t *testing.T) {
+	attachment := map[string]interface{}{"id": "attAAAAAAAAAAAAAA", "url": "https://dl.airtable.com/a",
+		"filename": "photo.png", "type": "image/png"}
+	var records []api.Record
+	for i := 0; i < BrowsePageSize+1; i++ {
+		records = append(records, api.Record{Id: fmt.Sprintf("rec%014d", i),
+			Fields: map[string]interface{}{"Name": fmt.Sprintf("<item %d>", i)}})
+	}
+	records[0].Fields["Photo"] = []interface{}{attachment}
+	backup := &Backup{
+		Tables: map[string][]api.Record{"tblAAAAAAAAAAAAAA": records},
+		Attachments: []Attachment{{Link: "https://dl.airtable.com/a", Id: "attAAAAAAAAAAAAAA", Filename: "photo.png",
+			Type: "image/png", File: "attAAAAAAAAAAAAAA"}},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets"}}},
+		},
+	}
+	downloadDir := t.TempDir()
+	if err := os.WriteFile(path.Join(downloadDir, "attAAAAAAAAAAAAAA"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewBrowser(backup, downloadDir, nil).Handler())
+	defer server.Close()
+	get := func(p string) (int, http.Header, string) {
+		resp, err := http.Get(server.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, resp.Header, string(body)
+	}
+
+	if status, _, body := get("/"); status != http.StatusOK || !strings.Contains(body, `href="/tables/tblAAAAAAAAAAAAAA"`) {
+		t.Errorf("expected the tables to be listed, got %d: %s", status, body)
+	}
+	last := fmt.Sprintf("rec%014d", BrowsePageSize)
+	_, _, body := get("/tables/tblAAAAAAAAAAAAAA")
+	if !strings.Contains(body, "&lt;item 0&gt;") || strings.Contains(body, "<item") {
+		t.Errorf("expected escaped values on the first page: %s", body)
+	}
+	if !strings.Contains(body, `href="?page=2"`) || strings.Contains(body, last) {
+		t.Errorf("expected the last record on the second page: %s", body)
+	}
+	if _, _, body := get("/tables/tblAAAAAAAAAAAAAA?page=2"); !strings.Contains(body, last) {
+		t.Errorf("expected the last record on the second page: %s", body)
+	}
+	_, _, body = get("/records/tblAAAAAAAAAAAAAA/" + records[0].Id)
+	if !strings.Contains(body, `<img src="/attachments/attAAAAAAAAAAAAAA"`) {
+		t.Errorf("expected the attachment to be shown: %s", body)
+	}
+	status, header, body := get("/attachments/attAAAAAAAAAAAAAA")
+	if status != http.StatusOK || body != "data" || header.Get("Content-Type") != "image/png" {
+		t.Errorf("expected the attachment to be served, got %d %v: %q", status, header, body)
+	}
+	for _, p := range []string{"/tables/tblBBBBBBBBBBBBBB", "/records/tblAAAAAAAAAAAAAA/recBBBBBBBBBBBBBB",
+		"/attachments/attBBBBBBBBBBBBBB", "/other"} {
+		if status, _, _ := get(p); status != http.StatusNotFound {
+			t.Errorf("expected %s to not be found, got %d", p, status)
+		}
+	}
+	resp, err := http.Post(server.URL+"/", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected the browser to be read-only, got %d", resp.StatusCode)
+	}
+}
+
+func TestBrowserOnlyServesDownloadedFiles(t *testing.T) {
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "downloads")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var attachments []Attachment
+	for i, file := range []string{"../secret", path.Join(dir, "secret"), "sub/../../secret"} {
+		attachments = append(attachments, Attachment{Link: "https://dl.airtable.com/a",
+			Id: fmt.Sprintf("att%014d", i), Filename: "secret", File: file})
+	}
+	server := httptest.NewServer(NewBrowser(&Backup{Attachments: attachments}, downloadDir, nil).Handler())
+	defer server.Close()
+	for _, attachment := range attachments {
+		resp, err := http.Get(server.URL + "/attachments/" + attachment.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected %q outside the download directory to not be served, got %d", attachment.File,
+				resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const CatalogFilename = "catalog.json"
+
+type CatalogEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	Tables      int       `json:"tables"`
+	Records     int       `json:"records"`
+	Attachments int       `json:"attachments"`
+	Bases       []string  `json:"bases"`
+	ContentHash string    `json:"content-hash,omitempty"`
+	// Files lists the other files that the backup was saved in, such as its table files, relative to Path.
+	Files []string `json:"files,omitempty"`
+}
+
+// Catalog lists every successful backup written to a directory, oldest first.
+type Catalog struct {
+	Backups []CatalogEntry `json:"backups"`
+}
+
+func LoadCatalog(dir string) (Catalog, error) {
+	return loadCatalog(context.Background(), LocalStorage(dir))
+}
+
+func loadCatalog(ctx context.Context, st Storage) (Catalog, error) {
+	var catalog Catalog
+	f, err := st.Open(ctx, CatalogFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Catalog{}, nil
+	} else if err != nil {
+		return Catalog{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&catalog); err != nil {
+		return Catalog{}, fmt.Errorf("invalid catalog in %q: %w", st.Location(CatalogFilename), err)
+	}
+	return catalog, nil
+}
+
+// Save replaces the catalog in a directory. The new catalog is written to a temporary file first, so that a failed
+// write never loses the history of earlier backups.
+func (c *Catalog) Save(dir string) error {
+	return c.save(context.Background(), LocalStorage(dir))
+}
+
+func (c *Catalog) save(ctx context.Context, st Storage) error {
+	_, err := putEncoded(ctx, st, CatalogFilename, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	})
+	return err
+}
+
+// AppendToCatalog records a freshly saved backup in the catalog of the directory containing it.
+func AppendToCatalog(outputPath string, backup *Backup, timestamp time.Time) error {
+	fi, err := os.Stat(outputPath)
+	if err != nil {
+		return err
+	}
+	return appendToCatalog(context.Background(), LocalStorage(path.Dir(outputPath)), path.Base(outputPath), fi.Size(),
+		backup, timestamp)
+}
+
+// appendToCatalog records the backup saved as name, of the given size, in the catalog of its Storage.
+func appendToCatalog(ctx context.Context, st Storage, name string, size int64, backup *Backup, timestamp time.Time) error {
+	return addCatalogEntry(ctx, st, newCatalogEntry(name, size, backup, timestamp))
+}
+
+func newCatalogEntry(name string, size int64, backup *Backup, timestamp time.Time) CatalogEntry {
+	entry := CatalogEntry{
+		Timestamp:   timestamp.UTC(),
+		Path:        name,
+		Size:        size,
+		Tables:      len(backup.Tables),
+		Attachments: len(backup.Attachments),
+		Files:       backup.files(),
+	}
+	if backup.Metadata != nil {
+		entry.ContentHash = backup.Metadata.ContentHash
+	}
+	for _, records := range backup.Tables {
+		entry.Records += len(records)
+	}
+	for base := range backup.Config {
+		entry.Bases = append(entry.Bases, base)
+	}
+	sort.Strings(entry.Bases)
+	return entry
+}
+
+func addCatalogEntry(ctx context.Context, st Storage, entry CatalogEntry) error {
+	catalog, err := loadCatalog(ctx, st)
+	if err != nil {
+		return err
+	}
+	catalog.Backups = append(catalog.Backups, entry)
+	return catalog.save(ctx, st)
+}
+
+// inTimeRange reports whether t is within a time range, including its ends. A zero time leaves that end open.
+func inTimeRange(t, since, until time.Time) bool {
+	return (since.IsZero() || !t.Before(since)) && (until.IsZero() || !t.After(until))
+}
+
+// Between returns the backups in the catalog that were taken within a time range. A zero time leaves that end open.
+func (c Catalog) Between(since, until time.Time) Catalog {
+	var selected Catalog
+	for _, entry := range c.Backups {
+		if inTimeRange(entry.Timestamp, since, until) {
+			selected.Backups = append(selected.Backups, entry)
+		}
+	}
+	return selected
+}
+
+// PrintJSON writes each backup in the catalog as a line of JSON, for scripts.
+func (c Catalog) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, entry := range c.Backups {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Catalog) Print(w io.Writer) {
+	for _, entry := range c.Backups {
+		_, _ = fmt.Fprintf(w, "%s  %-30s %12d bytes  %4d tables  %8d records  %6d attachments  %s\n",
+			entry.Timestamp.Format(time.RFC3339), entry.Path, entry.Size, entry.Tables, entry.Records,
+			entry.Attachments, strings.Join(entry.Bases, ","))
+	}
+}
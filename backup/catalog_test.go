@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestCatalogAppendsEachRun(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": [
+			{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}},
+			{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {}}
+		]}`))
+	})
+	config := Config{
+		Config: api.Config{BearerToken: testToken},
+		Tables: map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"first.json", "second.json"} {
+		opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, name), DownloadPath: downloadDir}
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Backups) != 2 {
+		t.Fatalf("expected 2 catalog entries, got %d", len(catalog.Backups))
+	}
+	for i, name := range []string{"first.json", "second.json"} {
+		entry := catalog.Backups[i]
+		if entry.Path != name || entry.Tables != 1 || entry.Records != 2 || entry.Size == 0 {
+			t.Errorf("unexpected catalog entry %d: %+v", i, entry)
+		}
+		if len(entry.Bases) != 1 || entry.Bases[0] != "appAAAAAAAAAAAAAA" {
+			t.Errorf("unexpected bases in entry %d: %v", i, entry.Bases)
+		}
+	}
+	if catalog.Backups[1].Timestamp.Before(catalog.Backups[0].Timestamp) {
+		t.Error("entries should be in chronological order")
+	}
+}
+
+func TestCatalogBetween(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var catalog Catalog
+	for i := 0; i < 4; i++ {
+		catalog.Backups = append(catalog.Backups, CatalogEntry{Timestamp: start.AddDate(0, 0, i)})
+	}
+	selected := catalog.Between(start.AddDate(0, 0, 1), start.AddDate(0, 0, 2))
+	if len(selected.Backups) != 2 || !selected.Backups[0].Timestamp.Equal(start.AddDate(0, 0, 1)) {
+		t.Errorf("expected the middle two backups, got %+v", selected.Backups)
+	}
+	if selected := catalog.Between(time.Time{}, start); len(selected.Backups) != 1 {
+		t.Errorf("expected a zero time to leave the range open, got %+v", selected.Backups)
+	}
+	var out bytes.Buffer
+	if err := selected.PrintJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 {
+		t.Errorf("expected a line of JSON for each backup, got %q", out.String())
+	}
+}
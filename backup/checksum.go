@@ -0,0 +1,256 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ChecksumFilename is the manifest of attachment hashes kept in the download directory. It uses the format of
+// sha256sum, so `sha256sum -c SHA256SUMS` can check the directory too.
+const ChecksumFilename = "SHA256SUMS"
+
+// Checksums maps the filenames in a download directory to the hex SHA-256 of their contents.
+type Checksums map[string]string
+
+func LoadChecksums(dir string) (Checksums, error) {
+	return loadChecksums(context.Background(), LocalStorage(dir))
+}
+
+func loadChecksums(ctx context.Context, st Storage) (Checksums, error) {
+	checksums := Checksums{}
+	f, err := st.Open(ctx, ChecksumFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return checksums, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		sum, filename, found := strings.Cut(scanner.Text(), "  ")
+		if !found || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid line %d in %s", line, st.Location(ChecksumFilename))
+		}
+		checksums[filename] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
+
+// Save replaces the manifest in a directory, by way of a temporary file.
+func (c Checksums) Save(dir string) error {
+	return c.save(context.Background(), LocalStorage(dir))
+}
+
+func (c Checksums) save(ctx context.Context, st Storage) error {
+	filenames := make([]string, 0, len(c))
+	for filename := range c {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	_, err := putEncoded(ctx, st, ChecksumFilename, func(w io.Writer) error {
+		for _, filename := range filenames {
+			if _, err := fmt.Fprintf(w, "%s  %s\n", c[filename], filename); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// downloadedFiles lists the attachments in a download directory, including those in its ContentDirectory, as
+// slash-separated names relative to it. Its manifests and incomplete downloads are left out.
+func downloadedFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relative)
+		if entry.IsDir() {
+			if name != "." && name != ContentDirectory && !isContentFilename(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if name != ChecksumFilename && name != ManifestFilename && !strings.HasPrefix(entry.Name(), "TEMP.") {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// VerifyDownloads checks every file in a download directory against its manifest, reporting each problem to w. Files
+// that the manifest does not know about are reported, but are not errors. Unless full is set, files that have the size
+// and modification time recorded when they were downloaded are trusted without being read.
+func VerifyDownloads(dir string, full bool, w io.Writer) error {
+	checksums, err := LoadChecksums(dir)
+	if err != nil {
+		return err
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	recorded := map[string]Attachment{}
+	for _, attachment := range manifest {
+		recorded[attachment.File] = attachment
+	}
+	files, err := downloadedFiles(dir)
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for _, name := range files {
+		present[name] = true
+		if _, found := checksums[name]; !found {
+			_, _ = fmt.Fprintf(w, "%s: no recorded checksum\n", name)
+		}
+	}
+	var problems error
+	filenames := make([]string, 0, len(checksums))
+	for filename := range checksums {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	verified := 0
+	for _, filename := range filenames {
+		if !present[filename] {
+			problems = multierror.Append(problems, fmt.Errorf("%s: missing", filename))
+			continue
+		}
+		sum, err := checksums[filename], error(nil)
+		if entry, found := recorded[filename]; full || !found || entry.SHA256 != sum || !unchanged(dir, entry) {
+			sum, err = hashFile(path.Join(dir, filename))
+		}
+		if err != nil {
+			problems = multierror.Append(problems, err)
+		} else if sum != checksums[filename] {
+			problems = multierror.Append(problems, fmt.Errorf("%s: checksum mismatch: expected %s, found %s",
+				filename, checksums[filename], sum))
+		} else {
+			verified++
+		}
+	}
+	_, _ = fmt.Fprintf(w, "Verified %d of %d attachments.\n", verified, len(checksums))
+	return problems
+}
+
+// unchanged reports whether the file of a manifest entry still has the size and modification time it was hashed with.
+func unchanged(dir string, recorded Attachment) bool {
+	current, err := LocalStorage(dir).stamp(recorded.File)
+	return err == nil && current == recorded.stamp()
+}
+
+// VerifyBackup checks a backup and its download directory without contacting AirTable: that the backup parses in a
+// format this version can read, that its record counts and content hash match its metadata, and that every
+// attachment it references was downloaded, with the size and hash it was recorded with. Encrypted backups and
+// attachments are decrypted with key, or the key from EncryptionKeyEnv if it is nil. Every problem found is reported,
+// rather than only the first.
+func VerifyBackup(backupPath, downloadDir string, key EncryptionKey, w io.Writer) error {
+	b, err := LoadWithKey(backupPath, key)
+	if err != nil {
+		return err
+	}
+	checksums, err := LoadChecksums(downloadDir)
+	if err != nil {
+		return err
+	}
+	records := 0
+	for _, table := range b.Tables {
+		records += len(table)
+	}
+	_, _ = fmt.Fprintf(w, "Loaded %d records in %d tables.\n", records, len(b.Tables))
+	var problems error
+	verified, expected := 0, 0
+	for _, attachment := range b.Attachments {
+		if attachment.UnexpectedPrefix {
+			continue
+		}
+		expected++
+		if err := verifyAttachment(attachment, downloadDir, checksums, key); err != nil {
+			problems = multierror.Append(problems, err)
+		} else {
+			verified++
+		}
+	}
+	_, _ = fmt.Fprintf(w, "Verified %d of %d attachments.\n", verified, expected)
+	return problems
+}
+
+// verifyAttachment checks the downloaded copy of one attachment of a backup.
+func verifyAttachment(attachment Attachment, downloadDir string, checksums Checksums, key EncryptionKey) error {
+	filename := attachment.File
+	if filename == "" {
+		// backups from before File was recorded always used the attachment ID
+		filename = attachment.DownloadFilename(false)
+	}
+	f, err := os.Open(path.Join(downloadDir, filename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s: missing attachment %s", filename, attachment.Id)
+	} else if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	hash := sha256.New()
+	plaintext, err := openMaybeEncrypted(io.TeeReader(f, hash), key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	size, err := io.Copy(io.Discard, plaintext)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if size != attachment.Size {
+		return fmt.Errorf("%s: size mismatch: expected %d bytes, found %d", filename, attachment.Size, size)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if attachment.SHA256 != "" && sum != attachment.SHA256 {
+		return fmt.Errorf("%s: checksum mismatch with backup: expected %s, found %s", filename, attachment.SHA256, sum)
+	}
+	if recorded, found := checksums[filename]; found && sum != recorded {
+		return fmt.Errorf("%s: checksum mismatch with %s: expected %s, found %s", filename, ChecksumFilename,
+			recorded, sum)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestChecksumsDetectCorruption(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	if err := DownloadAttachments(context.Background(), []Attachment{attachment}, dir, server.Client(), DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := LoadChecksums(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256("hello world")
+	if checksums[attachment.Id] != "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Errorf("unexpected checksum %q", checksums[attachment.Id])
+	}
+	if err := VerifyDownloads(dir, false, io.Discard); err != nil {
+		t.Errorf("intact directory should verify: %v", err)
+	}
+	// same size, different contents
+	if err := os.WriteFile(path.Join(dir, attachment.Id), []byte("hello WORLD"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDownloads(dir, false, io.Discard); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected verify to find the corruption, got %v", err)
+	}
+	err = DownloadAttachments(context.Background(), []Attachment{attachment}, dir, server.Client(), DownloadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected the next run to find the corruption, got %v", err)
+	}
+}
+
+func TestVerifyBackupChecksAttachmentsOffline(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v0/") {
+			_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+				{"id": "attAAAAAAAAAAAAAA", "url": "https://dl.airtable.com/a", "size": 11, "filename": "a.txt"}]}}]}`))
+		} else {
+			_, _ = w.Write([]byte("hello world"))
+		}
+	})
+	dir := t.TempDir()
+	backupPath := path.Join(dir, "backup.json")
+	downloads := path.Join(dir, "downloads")
+	if err := os.Mkdir(downloads, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := Run(context.Background(), Options{
+		Config: Config{
+			Config:     api.Config{BearerToken: testToken},
+			Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   backupPath,
+		DownloadPath: downloads,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := VerifyBackup(backupPath, downloads, nil, &out); err != nil {
+		t.Fatalf("intact backup should verify: %v", err)
+	}
+	if !strings.Contains(out.String(), "Verified 1 of 1 attachments.") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	attachmentPath := path.Join(downloads, "attAAAAAAAAAAAAAA")
+	for contents, expected := range map[string]string{
+		"hello WORLD": "checksum mismatch with backup",
+		"hello":       "size mismatch: expected 11 bytes, found 5",
+	} {
+		if err := os.WriteFile(attachmentPath, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyBackup(backupPath, downloads, nil, io.Discard); err == nil ||
+			!strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error about the %s, got %v", expected, err)
+		}
+	}
+	if err := os.Remove(attachmentPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBackup(backupPath, downloads, nil, io.Discard); err == nil ||
+		!strings.Contains(err.Error(), "missing attachment attAAAAAAAAAAAAAA") {
+		t.Errorf("expected the missing attachment to be reported, got %v", err)
+	}
+}
+
+// countingStorage counts the attachments read back from a local directory.
+type countingStorage struct {
+	LocalStorage
+	reads *atomic.Int64
+}
+
+func (c countingStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if name != ChecksumFilename && name != ManifestFilename {
+		c.reads.Add(1)
+	}
+	return c.LocalStorage.Open(ctx, name)
+}
+
+func TestUnchangedAttachmentsAreNotHashedAgain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	st := countingStorage{LocalStorage: LocalStorage(dir), reads: &atomic.Int64{}}
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	download := func(opts DownloadOptions) int64 {
+		st.reads.Store(0)
+		pool, err := NewDownloadPool(context.Background(), st, server.Client(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Add(attachment)
+		if err := pool.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		return st.reads.Load()
+	}
+	download(DownloadOptions{})
+	if reads := download(DownloadOptions{}); reads != 0 {
+		t.Errorf("expected the unchanged attachment to be trusted, but it was read %d times", reads)
+	}
+	if reads := download(DownloadOptions{FullVerify: true}); reads != 1 {
+		t.Errorf("expected a full verification to read the attachment once, but it was read %d times", reads)
+	}
+
+	// same size and modification time, different contents, which only a full verification can find
+	filePath := path.Join(dir, attachment.Id)
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath, []byte("hello WORLD"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDownloads(dir, false, io.Discard); err != nil {
+		t.Errorf("expected the unchanged file to be trusted, got %v", err)
+	}
+	if err := VerifyDownloads(dir, true, io.Discard); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a full verification to find the corruption, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+type commentJob struct {
+	app    string
+	table  string
+	record string
+}
+
+// fetchComments lists the comments on every record of the listed tables, on a pool of config.ListWorkers workers.
+// Every record takes at least one request, so the requests share the per-app rate limit of listing, and a large base
+// takes a while: a table of 10,000 records takes nearly an hour at the default rate. The comments are returned by
+// record ID, leaving out the records without any. The first failure stops the rest.
+func fetchComments(ctx context.Context, config Config, client *http.Client,
+	tables map[string][]api.Record) (map[string][]api.Comment, error) {
+	total := 0
+	for _, records := range tables {
+		total += len(records)
+	}
+	loggerFrom(ctx).Info("Fetching comments", "records", total,
+		"estimate", time.Duration(float64(total)/config.AppRateLimit()*float64(time.Second)).Round(time.Second))
+	client = withAppRateLimit(client, config.AppRateLimit(), config.Clock)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan commentJob)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	comments := map[string][]api.Comment{}
+	workers := config.ListWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				clerk := api.NewClerk(job.app, config.ClerkConfig(job.app), client)
+				listed, err := clerk.ListComments(ctx, job.table, job.record)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = &TableError{App: job.app, Table: job.table, Err: err}
+					cancel()
+				} else if err == nil && len(listed) > 0 {
+					comments[job.record] = listed
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for app, appTables := range config.Tables {
+		for _, table := range appTables {
+			for _, record := range tables[table] {
+				select {
+				case jobs <- commentJob{app: app, table: table, record: record.Id}:
+				case <-ctx.Done():
+					break dispatch
+				}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	loggerFrom(ctx).Info("Fetched comments", "records", len(comments))
+	return comments, nil
+}
@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestBackupComments(t *testing.T) {
+	var commentRequests int
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/comments") {
+			commentRequests++
+			if strings.Contains(r.URL.Path, "/recAAAAAAAAAAAAAA/") {
+				_, _ = w.Write([]byte(`{"comments": [{"id": "comAAAAAAAAAAAAAA", "text": "Looks good",
+					"author": {"id": "usrAAAAAAAAAAAAAA", "email": "a@example.com", "name": "A"},
+					"createdTime": "2023-01-01T00:00:00.000Z"}]}`))
+			} else {
+				_, _ = w.Write([]byte(`{"comments": []}`))
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "a"}},
+			{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {"Name": "b"}}]}`))
+	})
+	dir := t.TempDir()
+	opts := Options{
+		Config: Config{
+			Config:               api.Config{BearerToken: testToken},
+			Tables:               map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema:           true,
+			AppRequestsPerSecond: 1000,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if commentRequests != 0 {
+		t.Errorf("comments should only be fetched when asked for, but %d requests were made", commentRequests)
+	}
+	opts.Config.Comments = true
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if commentRequests != 2 {
+		t.Errorf("expected one request per record, got %d", commentRequests)
+	}
+	backup, err := Load(opts.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.Comments) != 1 || backup.Comments["recAAAAAAAAAAAAAA"][0].Author.Email != "a@example.com" {
+		t.Errorf("expected the comments of the commented record, got %+v", backup.Comments)
+	}
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/hashicorp/go-multierror"
+)
+
+// ConfigProblem is a problem with a configuration setting, located by a JSON pointer such as
+// "/app-tables/appXXXXXXXXXXXXXX/0".
+type ConfigProblem struct {
+	Pointer string
+	Message string
+}
+
+func (p *ConfigProblem) Error() string {
+	return p.Pointer + ": " + p.Message
+}
+
+// jsonPointer joins path segments into a JSON pointer, escaping them as RFC 6901 requires.
+func jsonPointer(segments ...string) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(segment))
+	}
+	return b.String()
+}
+
+// configFields returns the type of each top-level setting of a Config, by its JSON name, including the settings of
+// embedded structs.
+func configFields(t reflect.Type, fields map[string]reflect.Type) map[string]reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			configFields(field.Type, fields)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// checkConfigJSON checks that a configuration file only has known settings, each with a value of the right type, and
+// reports every setting that does not, rather than only the first.
+func checkConfigJSON(data []byte) error {
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	fields := configFields(reflect.TypeOf(Config{}), map[string]reflect.Type{})
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems error
+	for _, name := range names {
+		fieldType, found := fields[name]
+		if !found {
+			problems = multierror.Append(problems, &ConfigProblem{Pointer: jsonPointer(name), Message: "unknown setting"})
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(settings[name]))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(reflect.New(fieldType).Interface()); err != nil {
+			problems = multierror.Append(problems, decodeProblem(name, err))
+		}
+	}
+	return problems
+}
+
+// decodeProblem locates an error from decoding the value of a setting.
+func decodeProblem(name string, err error) *ConfigProblem {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		segments := []string{name}
+		if typeErr.Field != "" {
+			segments = append(segments, strings.Split(typeErr.Field, ".")...)
+		}
+		return &ConfigProblem{
+			Pointer: jsonPointer(segments...),
+			Message: fmt.Sprintf("expected %s, not %s", typeErr.Type, typeErr.Value),
+		}
+	}
+	// unknown fields of nested settings are reported as `json: unknown field "name"`
+	if unknown, found := strings.CutPrefix(err.Error(), "json: unknown field "); found {
+		if field, err := strconv.Unquote(unknown); err == nil {
+			return &ConfigProblem{Pointer: jsonPointer(name, field), Message: "unknown setting"}
+		}
+	}
+	return &ConfigProblem{Pointer: jsonPointer(name), Message: err.Error()}
+}
+
+// checkEntries checks the token and the app and table lists, and reports every problem found in them.
+func (c Config) checkEntries() error {
+	var problems error
+	problem := func(message string, segments ...string) {
+		problems = multierror.Append(problems, &ConfigProblem{Pointer: jsonPointer(segments...), Message: message})
+	}
+	if c.BearerToken != "" {
+		if _, err := api.ClassifyToken(c.BearerToken); err != nil {
+			problem(err.Error(), "token")
+		}
+	}
+	if len(c.Tables) == 0 {
+		problem("no apps configured", "app-tables")
+	}
+	apps := make([]string, 0, len(c.Tables))
+	for app := range c.Tables {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	// table IDs are unique across apps, so the same ID in two apps is a mistake too
+	tableIds := map[string]string{}
+	for _, app := range apps {
+		if !api.IsAirTableId(app) {
+			problem("not a valid app ID", "app-tables", app)
+		}
+		if len(c.Tables[app]) == 0 && c.SkipSchema {
+			problem("empty table list, but tables cannot be discovered with skip-schema", "app-tables", app)
+		}
+		seen := map[string]int{}
+		for i, table := range c.Tables[app] {
+			index := strconv.Itoa(i)
+			switch {
+			case table == "":
+				problem("empty table name", "app-tables", app, index)
+			case strings.HasPrefix(table, "tbl") && len(table) == 17 && !isTableId(table):
+				problem("not a valid table ID", "app-tables", app, index)
+			}
+			if first, found := seen[table]; found {
+				problem(fmt.Sprintf("duplicate of entry %d", first), "app-tables", app, index)
+				continue
+			}
+			seen[table] = i
+			if isTableId(table) {
+				if other, found := tableIds[table]; found {
+					problem("table is also listed under app "+other, "app-tables", app, index)
+				}
+				tableIds[table] = app
+			}
+		}
+	}
+	credentialApps := make([]string, 0, len(c.AppCredentials))
+	for app := range c.AppCredentials {
+		credentialApps = append(credentialApps, app)
+	}
+	sort.Strings(credentialApps)
+	for _, app := range credentialApps {
+		if !api.IsAirTableId(app) {
+			problem("not a valid app ID", "app-credentials", app)
+		} else if token := c.AppCredentials[app].Token; token != "" {
+			if _, err := api.ClassifyToken(token); err != nil {
+				problem(err.Error(), "app-credentials", app, "token")
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+func configProblems(t *testing.T, err error) []string {
+	var merr *multierror.Error
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected a list of problems, got %v", err)
+	}
+	var pointers []string
+	for _, err := range merr.Errors {
+		var problem *ConfigProblem
+		if !errors.As(err, &problem) {
+			t.Fatalf("expected a located problem, got %v", err)
+		}
+		pointers = append(pointers, problem.Pointer)
+	}
+	sort.Strings(pointers)
+	return pointers
+}
+
+func TestConfigFileReportsEveryUnknownOrMistypedSetting(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.json")
+	contents := `{"token": "keyAAAAAAAAAAAAAA", "app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"]},
+		"retries": "3", "list-wokers": 2, "retain": {"daily": 7, "weekly": "4"},
+		"table-fields": {"tblAAAAAAAAAAAAAA": {"inclde": ["Name"]}}}`
+	if err := os.WriteFile(configPath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfig(configPath)
+	expected := []string{"/list-wokers", "/retain/weekly", "/retries", "/table-fields/inclde"}
+	if actual := configProblems(t, err); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected problems at %v, got %v", expected, actual)
+	}
+}
+
+func TestCheckEntriesReportsEveryProblem(t *testing.T) {
+	config := Config{
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "Widgets", "tblAAAAAAAAAAAAAA", "tblTOOSHORT"},
+			"appBBBBBBBBBBBBBB": {"tblAAAAAAAAAAAAAA", ""},
+			"app/bad":           {},
+		},
+		AppCredentials: map[string]Credential{"appBBBBBBBBBBBBBB": {Token: "pat-invalid"}},
+		SkipSchema:     true,
+	}
+	config.BearerToken = "key with spaces"
+	expected := []string{
+		"/app-credentials/appBBBBBBBBBBBBBB/token",
+		"/app-tables/appAAAAAAAAAAAAAA/2",
+		"/app-tables/appBBBBBBBBBBBBBB/0", "/app-tables/appBBBBBBBBBBBBBB/1",
+		"/app-tables/app~1bad", "/app-tables/app~1bad",
+		"/token",
+	}
+	if actual := configProblems(t, config.Validate()); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected problems at %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configJSON converts the contents of a configuration file to JSON, according to its extension: .yaml or .yml for
+// YAML, .toml for TOML, and anything else for JSON itself. YAML and TOML use the same setting names as JSON, and
+// unlike JSON, allow comments.
+func configJSON(path string, data []byte) ([]byte, error) {
+	var settings map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+	default:
+		return data, nil
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return json.Marshal(settings)
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"token": "keyAAAAAAAAAAAAAA", "table-timeout": "5m",
+			"app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA", "Widgets"]},
+			"retain": {"daily": 7}}`,
+		"config.yaml": `
+token: keyAAAAAAAAAAAAAA
+table-timeout: 5m
+app-tables:
+  # the inventory base
+  appAAAAAAAAAAAAAA:
+    - tblAAAAAAAAAAAAAA
+    - Widgets  # kept for the audit
+retain:
+  daily: 7
+`,
+		"config.toml": `
+token = "keyAAAAAAAAAAAAAA"
+table-timeout = "5m"
+
+# the inventory base
+[app-tables]
+appAAAAAAAAAAAAAA = ["tblAAAAAAAAAAAAAA", "Widgets"]
+
+[retain]
+daily = 7
+`,
+	}
+	var configs []Config
+	for name, contents := range files {
+		if err := os.WriteFile(path.Join(dir, name), []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if time.Duration(config.TableTimeout) != 5*time.Minute || config.Retain.Daily != 7 {
+			t.Errorf("%s: unexpected settings: %+v", name, config)
+		}
+		configs = append(configs, config)
+	}
+	for _, config := range configs[1:] {
+		if !reflect.DeepEqual(config.Tables, configs[0].Tables) {
+			t.Errorf("expected every format to configure the same tables, got %v and %v", config.Tables,
+				configs[0].Tables)
+		}
+	}
+}
+
+func TestConfigFormatProblemsAreLocated(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.yml")
+	contents := "token: keyAAAAAAAAAAAAAA\napp-tables:\n  appAAAAAAAAAAAAAA: []\nretries: many\n"
+	if err := os.WriteFile(configPath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfig(configPath)
+	if actual := configProblems(t, err); !reflect.DeepEqual(actual, []string{"/retries"}) {
+		t.Errorf("expected a problem with retries, got %v", actual)
+	}
+}
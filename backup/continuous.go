@@ -0,0 +1,558 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+	"github.com/hashicorp/go-multierror"
+)
+
+// WebhookStateSuffix is appended to the path of a continuous backup to name the file recording its webhooks. The
+// file holds the secrets that notifications are signed with, so it is only readable by its owner.
+const WebhookStateSuffix = ".webhooks"
+
+// webhookRefreshInterval is how often webhooks are refreshed; AirTable expires them after seven days.
+const webhookRefreshInterval = 24 * time.Hour
+
+// maxNotificationSize bounds the body of a notification, which only names the base and webhook.
+const maxNotificationSize = 64 << 10
+
+// recordIdsPerRequest is how many records are fetched by ID in each list request, keeping the formula well within
+// the length of a URL.
+const recordIdsPerRequest = 50
+
+// AppWebhook is the webhook that notifies a continuous backup of the changes in one app.
+type AppWebhook struct {
+	Webhook   string `json:"webhook"`
+	MACSecret string `json:"mac-secret"`
+	// Cursor is the first payload of the webhook that has not been applied to the backup yet.
+	Cursor  int    `json:"cursor"`
+	Expires string `json:"expires,omitempty"`
+}
+
+// WebhookState records the webhook registered for each app of a continuous backup, by app ID.
+type WebhookState struct {
+	Apps map[string]*AppWebhook `json:"apps"`
+}
+
+func loadWebhookState(statePath string) (*WebhookState, error) {
+	state := &WebhookState{Apps: map[string]*AppWebhook{}}
+	data, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid webhook state in %q: %w", statePath, err)
+	}
+	if state.Apps == nil {
+		state.Apps = map[string]*AppWebhook{}
+	}
+	return state, nil
+}
+
+// save replaces the state file atomically. Temporary files are created readable only by their owner.
+func (s *WebhookState) save(statePath string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeWebhookState(statePath, data)
+}
+
+func writeWebhookState(statePath string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(statePath), ".webhooks-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), statePath)
+}
+
+// ContinuousOptions configures a backup that is kept up to date through webhooks.
+type ContinuousOptions struct {
+	Config Config
+	Client *http.Client
+	// BackupPath is a local backup of the configured tables, taken beforehand, which is updated in place.
+	BackupPath string
+	// NotificationURL is the public URL at which AirTable reaches Handler.
+	NotificationURL string
+}
+
+// ContinuousBackup applies the changes that webhooks report to a local backup as they happen. A webhook is registered
+// on each configured app the first time, and recorded next to the backup; the changes made since are caught up on
+// at every start. Changed records are fetched again through the API, since webhook payloads do not describe cell
+// values the same way. Attachments of changed records are recorded in the backup, but not downloaded: use the
+// download command for that.
+type ContinuousBackup struct {
+	opts      ContinuousOptions
+	client    *http.Client
+	key       EncryptionKey
+	statePath string
+
+	// mu guards the fields below, and is never held across requests or saves, so that Handler does not wait on them.
+	// The backup and the webhook state are only replaced by one goroutine at a time: StartContinuous, then Run.
+	mu     sync.Mutex
+	backup *Backup
+	state  *WebhookState
+	// dirty holds the apps with payloads that have not been fetched yet, and wake is signalled when one is added.
+	dirty map[string]bool
+	wake  chan struct{}
+}
+
+// StartContinuous loads a backup and registers webhooks for the apps that do not have one yet. The tables of newly
+// registered apps are listed again in full, to pick up any changes made since the backup was taken.
+func StartContinuous(ctx context.Context, opts ContinuousOptions) (*ContinuousBackup, error) {
+	if opts.NotificationURL == "" {
+		return nil, errors.New("a notification URL is required")
+	}
+	var err error
+	if opts.Client == nil {
+		if opts.Client, err = opts.Config.HTTP.Client(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := opts.Config.Key()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Config, err = DiscoverTables(ctx, opts.Config, opts.Client, nil); err != nil {
+		return nil, err
+	}
+	backup, err := LoadWithKey(opts.BackupPath, key)
+	if err != nil {
+		return nil, fmt.Errorf("loading the backup to keep up to date (take a full backup first): %w", err)
+	}
+	c := &ContinuousBackup{
+		opts:      opts,
+		client:    withAppRateLimit(opts.Client, opts.Config.AppRateLimit(), opts.Config.Clock),
+		key:       key,
+		statePath: opts.BackupPath + WebhookStateSuffix,
+		backup:    backup,
+		dirty:     map[string]bool{},
+		wake:      make(chan struct{}, 1),
+	}
+	if c.state, err = loadWebhookState(c.statePath); err != nil {
+		return nil, err
+	}
+	var registered []string
+	for app := range opts.Config.Tables {
+		if c.state.Apps[app] != nil {
+			continue
+		}
+		created, err := c.clerk(app).CreateWebhook(ctx, opts.NotificationURL, api.WebhookFilters{
+			DataTypes: []string{api.WebhookTableData, api.WebhookTableFields},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("registering a webhook on app %s: %w", app, err)
+		}
+		c.state.Apps[app] = &AppWebhook{Webhook: created.Id, MACSecret: created.MACSecretBase64, Cursor: 1,
+			Expires: created.ExpirationTime}
+		registered = append(registered, app)
+		loggerFrom(ctx).Info("Registered webhook", "app", app, "webhook", created.Id)
+	}
+	if err := c.saveState(); err != nil {
+		return nil, err
+	}
+	for _, app := range registered {
+		if err := c.relistApp(ctx, app); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *ContinuousBackup) clerk(app string) *api.Clerk {
+	return api.NewClerk(app, c.opts.Config.ClerkConfig(app), c.client)
+}
+
+// Handler receives the notifications of the registered webhooks, and has Run fetch their payloads.
+func (c *ContinuousBackup) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "notifications are posted", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxNotificationSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var notification api.WebhookNotification
+		if err := json.Unmarshal(body, &notification); err != nil {
+			http.Error(w, "invalid notification", http.StatusBadRequest)
+			return
+		}
+		app := notification.Base.Id
+		c.mu.Lock()
+		webhook := c.state.Apps[app]
+		c.mu.Unlock()
+		if webhook == nil || webhook.Webhook != notification.Webhook.Id {
+			http.Error(w, "unknown webhook", http.StatusNotFound)
+			return
+		}
+		if err := api.VerifyWebhookNotification(webhook.MACSecret, body, r.Header.Get(api.WebhookMACHeader)); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		c.notify(app)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c *ContinuousBackup) notify(app string) {
+	c.mu.Lock()
+	c.dirty[app] = true
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run applies the payloads of the webhooks to the backup as notifications arrive, and keeps the webhooks from
+// expiring, until ctx is cancelled. The payloads that arrived while it was not running are applied first. A failure
+// to apply an app's payloads is logged, and tried again on its next notification.
+func (c *ContinuousBackup) Run(ctx context.Context) error {
+	for app := range c.opts.Config.Tables {
+		c.notify(app)
+	}
+	if err := c.refreshWebhooks(ctx); err != nil {
+		return err
+	}
+	refresh := clock.Or(c.opts.Config.Clock).After(webhookRefreshInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-refresh:
+			if err := c.refreshWebhooks(ctx); err != nil {
+				loggerFrom(ctx).Error("Could not refresh webhooks", "error", err)
+			}
+			refresh = clock.Or(c.opts.Config.Clock).After(webhookRefreshInterval)
+		case <-c.wake:
+			c.mu.Lock()
+			apps := make([]string, 0, len(c.dirty))
+			for app := range c.dirty {
+				apps = append(apps, app)
+			}
+			c.dirty = map[string]bool{}
+			c.mu.Unlock()
+			sort.Strings(apps)
+			for _, app := range apps {
+				if err := c.applyPayloads(ctx, app); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					loggerFrom(ctx).Error("Could not apply changes", "app", app, "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (c *ContinuousBackup) refreshWebhooks(ctx context.Context) error {
+	c.mu.Lock()
+	webhooks := map[string]string{}
+	for app, webhook := range c.state.Apps {
+		webhooks[app] = webhook.Webhook
+	}
+	c.mu.Unlock()
+	expires := map[string]string{}
+	for app, webhook := range webhooks {
+		var err error
+		if expires[app], err = c.clerk(app).RefreshWebhook(ctx, webhook); err != nil {
+			return fmt.Errorf("refreshing the webhook of app %s: %w", app, err)
+		}
+	}
+	c.mu.Lock()
+	for app, webhook := range c.state.Apps {
+		if webhook.Webhook == webhooks[app] {
+			webhook.Expires = expires[app]
+		}
+	}
+	c.mu.Unlock()
+	return c.saveState()
+}
+
+// saveState copies the webhook state under the lock, and writes it without holding it.
+func (c *ContinuousBackup) saveState() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeWebhookState(c.statePath, data)
+}
+
+// tableChanges collects the changes to one table across a run of payloads.
+type tableChanges struct {
+	changed   map[string]bool
+	destroyed map[string]bool
+	// relist is set when the table's fields changed, so that every record must be listed again.
+	relist bool
+}
+
+// applyPayloads fetches the new payloads of an app's webhook and applies them to the backup.
+func (c *ContinuousBackup) applyPayloads(ctx context.Context, app string) error {
+	c.mu.Lock()
+	webhook := *c.state.Apps[app]
+	c.mu.Unlock()
+	clerk := c.clerk(app)
+	configured := map[string]bool{}
+	for _, table := range c.opts.Config.Tables[app] {
+		configured[table] = true
+	}
+	changes := map[string]*tableChanges{}
+	cursor, applied := webhook.Cursor, 0
+	for {
+		reply, err := clerk.ListWebhookPayloads(ctx, webhook.Webhook, cursor)
+		if err != nil {
+			return err
+		}
+		for _, payload := range reply.Payloads {
+			for table, tableChange := range payload.ChangedTablesById {
+				if !configured[table] {
+					continue
+				}
+				if changes[table] == nil {
+					changes[table] = &tableChanges{changed: map[string]bool{}, destroyed: map[string]bool{}}
+				}
+				collectChanges(changes[table], tableChange)
+			}
+			for _, table := range payload.DestroyedTableIds {
+				if configured[table] {
+					loggerFrom(ctx).Warn("Backed-up table was deleted; keeping its records", "app", app,
+						"table", table)
+				}
+			}
+		}
+		applied += len(reply.Payloads)
+		cursor = reply.Cursor
+		if !reply.MightHaveMore {
+			break
+		}
+	}
+	if applied == 0 {
+		return nil
+	}
+	if err := c.applyChanges(ctx, app, changes); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.state.Apps[app].Cursor = cursor
+	c.mu.Unlock()
+	if err := c.saveState(); err != nil {
+		return err
+	}
+	loggerFrom(ctx).Info("Applied changes", "app", app, "payloads", applied, "tables", len(changes))
+	return nil
+}
+
+// collectChanges adds a payload's changes to a table into the changes collected so far. A record that is created
+// again after being destroyed cannot happen, since record IDs are never reused.
+func collectChanges(changes *tableChanges, change api.WebhookTableChanges) {
+	for id := range change.CreatedRecordsById {
+		changes.changed[id] = true
+	}
+	for id := range change.ChangedRecordsById {
+		changes.changed[id] = true
+	}
+	for _, id := range change.DestroyedRecordIds {
+		delete(changes.changed, id)
+		changes.destroyed[id] = true
+	}
+	if change.FieldsChanged() {
+		changes.relist = true
+	}
+}
+
+// relistApp lists every configured table of an app again in full, and saves the backup.
+func (c *ContinuousBackup) relistApp(ctx context.Context, app string) error {
+	changes := map[string]*tableChanges{}
+	for _, table := range c.opts.Config.Tables[app] {
+		changes[table] = &tableChanges{relist: true}
+	}
+	return c.applyChanges(ctx, app, changes)
+}
+
+// applyChanges fetches the changed records of each table, replaces them in the backup, and saves it. Changed records
+// that can no longer be listed, such as those that left the table's view, are removed like destroyed ones.
+func (c *ContinuousBackup) applyChanges(ctx context.Context, app string, changes map[string]*tableChanges) error {
+	config, clerk := c.opts.Config, c.clerk(app)
+	c.mu.Lock()
+	updated := *c.backup
+	c.mu.Unlock()
+	tables := map[string][]api.Record{}
+	for table, records := range updated.Tables {
+		tables[table] = records
+	}
+	for table, change := range changes {
+		if change.relist {
+			records, err := listTable(ctx, clerk, table, config.listOptions(table, ""), config.TimeoutFor(table))
+			if err == nil {
+				err = config.prepareRecords(ctx, app, table, records)
+			}
+			if err != nil {
+				return &TableError{App: app, Table: table, Err: err}
+			}
+			tables[table] = records
+			continue
+		}
+		ids := make([]string, 0, len(change.changed))
+		for id := range change.changed {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		var fetched []api.Record
+		for _, batch := range splitIds(ids, recordIdsPerRequest) {
+			records, err := listTable(ctx, clerk, table, config.listOptions(table, recordIdsFormula(batch)),
+				config.TimeoutFor(table))
+			if err == nil {
+				err = config.prepareRecords(ctx, app, table, records)
+			}
+			if err != nil {
+				return &TableError{App: app, Table: table, Err: err}
+			}
+			fetched = append(fetched, records...)
+		}
+		listed := map[string]bool{}
+		for _, record := range fetched {
+			listed[record.Id] = true
+		}
+		var kept []api.Record
+		for _, record := range mergeRecords(tables[table], fetched) {
+			if !change.destroyed[record.Id] && (!change.changed[record.Id] || listed[record.Id]) {
+				kept = append(kept, record)
+			}
+		}
+		tables[table] = kept
+	}
+	updated.Tables = tables
+	if err := updated.refresh(config, clock.Or(config.Clock).Now()); err != nil {
+		return err
+	}
+	save := updated.save
+	switch config.Layout {
+	case LayoutPerTable:
+		save = updated.savePerTable
+	case LayoutNDJSON:
+		save = updated.saveNDJSON
+	}
+	if _, err := save(ctx, LocalStorage(path.Dir(c.opts.BackupPath)), path.Base(c.opts.BackupPath), c.key); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.backup = &updated
+	c.mu.Unlock()
+	return nil
+}
+
+// refresh brings the attachments and metadata of a backup up to date with its tables, after they have changed. The
+// downloaded files of attachments that were already in the backup are kept.
+func (b *Backup) refresh(config Config, now time.Time) error {
+	downloaded := map[string]Attachment{}
+	for _, attachment := range b.Attachments {
+		downloaded[attachment.Id] = attachment
+	}
+	b.Attachments, _ = ExtractAttachments(b.Tables, config.ExtractOptions)
+	for i := range b.Attachments {
+		if previous, found := downloaded[b.Attachments[i].Id]; found {
+			b.Attachments[i].SHA256, b.Attachments[i].File = previous.SHA256, previous.File
+		}
+	}
+	if config.CanonicalOutput {
+		*b = b.withoutLinks()
+	}
+	contentHash, err := b.ContentHash()
+	if err != nil {
+		return err
+	}
+	var started *time.Time
+	if b.Metadata != nil {
+		started = b.Metadata.Started
+	}
+	b.Metadata = newMetadata(b.Tables)
+	b.Metadata.ContentHash = contentHash
+	if !config.CanonicalOutput {
+		b.Metadata.Started, b.Metadata.Finished = started, &now
+	}
+	return nil
+}
+
+// recordIdsFormula matches the records with the given IDs.
+func recordIdsFormula(ids []string) string {
+	matches := make([]string, len(ids))
+	for i, id := range ids {
+		matches[i] = fmt.Sprintf("RECORD_ID() = '%s'", id)
+	}
+	return "OR(" + strings.Join(matches, ", ") + ")"
+}
+
+func splitIds(ids []string, size int) [][]string {
+	var batches [][]string
+	for len(ids) > size {
+		batches = append(batches, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+// UnregisterContinuous deletes the webhooks registered for a continuous backup, and the file recording them.
+func UnregisterContinuous(ctx context.Context, config Config, client *http.Client, backupPath string) error {
+	if client == nil {
+		var err error
+		if client, err = config.HTTP.Client(); err != nil {
+			return err
+		}
+	}
+	statePath := backupPath + WebhookStateSuffix
+	state, err := loadWebhookState(statePath)
+	if err != nil {
+		return err
+	}
+	var allErrors error
+	for app, webhook := range state.Apps {
+		clerk := api.NewClerk(app, config.ClerkConfig(app), client)
+		if err := clerk.DeleteWebhook(ctx, webhook.Webhook); err != nil {
+			allErrors = multierror.Append(allErrors, fmt.Errorf("deleting the webhook of app %s: %w", app, err))
+			continue
+		}
+		delete(state.Apps, app)
+	}
+	if allErrors != nil {
+		return multierror.Append(allErrors, state.save(statePath))
+	}
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
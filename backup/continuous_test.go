@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestContinuousBackup(t *testing.T) {
+	var mu sync.Mutex
+	records := map[string]string{"recAAAAAAAAAAAAAA": "a", "recBBBBBBBBBBBBBB": "b"}
+	var payloads []api.WebhookPayload
+	webhooks := "/v0/bases/appAAAAAAAAAAAAAA/webhooks"
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == webhooks:
+			_, _ = w.Write([]byte(`{"id": "achAAAAAAAAAAAAAA", "macSecretBase64": "c2VjcmV0",
+				"expirationTime": "2023-01-08T00:00:00.000Z"}`))
+		case r.URL.Path == webhooks+"/achAAAAAAAAAAAAAA/refresh":
+			_, _ = w.Write([]byte(`{"expirationTime": "2023-01-09T00:00:00.000Z"}`))
+		case r.URL.Path == webhooks+"/achAAAAAAAAAAAAAA/payloads":
+			cursor := r.URL.Query().Get("cursor")
+			reply := api.WebhookPayloads{Cursor: 1 + len(payloads)}
+			if cursor == "1" {
+				reply.Payloads = payloads
+			}
+			_ = json.NewEncoder(w).Encode(reply)
+		case r.URL.Path == "/v0/appAAAAAAAAAAAAAA/tblAAAAAAAAAAAAAA":
+			formula := r.URL.Query().Get("filterByFormula")
+			var reply api.ListRecordsReply
+			for id, name := range records {
+				if formula == "" || strings.Contains(formula, id) {
+					reply.Records = append(reply.Records, api.Record{Id: id, Fields: map[string]interface{}{"Name": name}})
+				}
+			}
+			sort.Slice(reply.Records, func(i, j int) bool {
+				return reply.Records[i].Id < reply.Records[j].Id
+			})
+			_ = json.NewEncoder(w).Encode(reply)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	config := Config{
+		Config:               api.Config{BearerToken: testToken},
+		Tables:               map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		AppRequestsPerSecond: 1000,
+	}
+	backupPath := path.Join(t.TempDir(), "backup.json")
+	initial := &Backup{Config: config.Tables, Tables: map[string][]api.Record{"tblAAAAAAAAAAAAAA": {
+		{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "a"}},
+	}}}
+	if err := initial.Save(backupPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	continuous, err := StartContinuous(context.Background(), ContinuousOptions{
+		Config:          config,
+		Client:          client,
+		BackupPath:      backupPath,
+		NotificationURL: "https://example.com/notify",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func() map[string]interface{} {
+		backup, err := Load(backupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := map[string]interface{}{}
+		for _, record := range backup.Tables["tblAAAAAAAAAAAAAA"] {
+			found[record.Id] = record.Fields["Name"]
+		}
+		return found
+	}
+	// the records changed before the webhook was registered are listed when it is
+	if found := names(); len(found) != 2 || found["recBBBBBBBBBBBBBB"] != "b" {
+		t.Errorf("expected the table to be listed again on registering, got %v", found)
+	}
+	if info, err := os.Stat(backupPath + WebhookStateSuffix); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the webhook state to be private, got %v", err)
+	}
+
+	mu.Lock()
+	records["recAAAAAAAAAAAAAA"] = "a2"
+	delete(records, "recBBBBBBBBBBBBBB")
+	records["recCCCCCCCCCCCCCC"] = "c"
+	payloads = append(payloads, api.WebhookPayload{ChangedTablesById: map[string]api.WebhookTableChanges{
+		"tblAAAAAAAAAAAAAA": {
+			ChangedRecordsById: map[string]interface{}{"recAAAAAAAAAAAAAA": map[string]interface{}{}},
+			CreatedRecordsById: map[string]interface{}{"recCCCCCCCCCCCCCC": map[string]interface{}{}},
+			DestroyedRecordIds: []string{"recBBBBBBBBBBBBBB"},
+		},
+	}})
+	mu.Unlock()
+	notify := func(signature string) int {
+		body := []byte(`{"base": {"id": "appAAAAAAAAAAAAAA"}, "webhook": {"id": "achAAAAAAAAAAAAAA"}}`)
+		if signature == "" {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write(body)
+			signature = "hmac-sha256=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set(api.WebhookMACHeader, signature)
+		recorder := httptest.NewRecorder()
+		continuous.Handler().ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	if code := notify("hmac-sha256=" + hex.EncodeToString([]byte("forged"))); code != http.StatusUnauthorized {
+		t.Errorf("expected a forged notification to be rejected, got %d", code)
+	}
+	if code := notify(""); code != http.StatusNoContent {
+		t.Errorf("expected the notification to be accepted, got %d", code)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- continuous.Run(ctx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	expected := map[string]interface{}{"recAAAAAAAAAAAAAA": "a2", "recCCCCCCCCCCCCCC": "c"}
+	for found := names(); !mapsEqual(found, expected); found = names() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the changes to be applied, got %v", found)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected Run to stop when cancelled, got %v", err)
+	}
+	state, err := loadWebhookState(backupPath + WebhookStateSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook := state.Apps["appAAAAAAAAAAAAAA"]; webhook.Cursor != 2 || webhook.Expires != "2023-01-09T00:00:00.000Z" {
+		t.Errorf("expected the cursor to advance and the webhook to be refreshed, got %+v", webhook)
+	}
+	if _, err := base64.StdEncoding.DecodeString(state.Apps["appAAAAAAAAAAAAAA"].MACSecret); err != nil {
+		t.Errorf("expected the secret to be kept: %v", err)
+	}
+}
+
+func mapsEqual(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestContinuousHandlerDoesNotWaitForFetches(t *testing.T) {
+	webhooks := "/v0/bases/appAAAAAAAAAAAAAA/webhooks"
+	var blocking sync.Once
+	block := make(chan struct{})
+	listing, release := make(chan struct{}), make(chan struct{})
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == webhooks:
+			_, _ = w.Write([]byte(`{"id": "achAAAAAAAAAAAAAA", "macSecretBase64": "c2VjcmV0"}`))
+		case r.URL.Path == webhooks+"/achAAAAAAAAAAAAAA/refresh":
+			_, _ = w.Write([]byte(`{"expirationTime": "2023-01-09T00:00:00.000Z"}`))
+		case r.URL.Path == webhooks+"/achAAAAAAAAAAAAAA/payloads":
+			_ = json.NewEncoder(w).Encode(api.WebhookPayloads{Cursor: 2, Payloads: []api.WebhookPayload{{
+				ChangedTablesById: map[string]api.WebhookTableChanges{"tblAAAAAAAAAAAAAA": {
+					ChangedRecordsById: map[string]interface{}{"recAAAAAAAAAAAAAA": map[string]interface{}{}},
+				}},
+			}}})
+		case r.URL.Path == "/v0/appAAAAAAAAAAAAAA/tblAAAAAAAAAAAAAA":
+			select {
+			case <-block:
+				blocking.Do(func() { close(listing) })
+				<-release
+			default:
+			}
+			_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "fields": {"Name": "a"}}]}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	config := Config{
+		Config:               api.Config{BearerToken: testToken},
+		Tables:               map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		AppRequestsPerSecond: 1000,
+	}
+	backupPath := path.Join(t.TempDir(), "backup.json")
+	if err := (&Backup{Config: config.Tables, Tables: map[string][]api.Record{}}).Save(backupPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	continuous, err := StartContinuous(context.Background(), ContinuousOptions{
+		Config:          config,
+		Client:          client,
+		BackupPath:      backupPath,
+		NotificationURL: "https://example.com/notify",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(block)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- continuous.Run(ctx)
+	}()
+	<-listing
+	body := []byte(`{"base": {"id": "appAAAAAAAAAAAAAA"}, "webhook": {"id": "achAAAAAAAAAAAAAA"}}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	answered := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set(api.WebhookMACHeader, "hmac-sha256="+hex.EncodeToString(mac.Sum(nil)))
+		recorder := httptest.NewRecorder()
+		continuous.Handler().ServeHTTP(recorder, req)
+		answered <- recorder.Code
+	}()
+	select {
+	case code := <-answered:
+		if code != http.StatusNoContent {
+			t.Errorf("expected the notification to be accepted, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the notification to be answered while records are being fetched")
+	}
+	close(release)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected Run to stop when cancelled, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// TokenEnv names the environment variable that holds the API token when the configuration does not set one.
+const TokenEnv = "AIRTABLE_TOKEN"
+
+// Credential is the token for accessing an app, given in the same ways as the token of the configuration itself.
+type Credential struct {
+	Token        string   `json:"token,omitempty"`
+	TokenFile    string   `json:"token-file,omitempty"`
+	TokenCommand []string `json:"token-command,omitempty"`
+}
+
+// OAuthSettings configures an OAuth integration, whose current token is kept in the TokenStore file, relative to the
+// configuration file. The file is written by the oauth-login command, and then again every time the token is renewed.
+type OAuthSettings struct {
+	api.OAuthConfig
+	TokenStore string `json:"token-store"`
+}
+
+// Store returns where the token is kept.
+func (s *OAuthSettings) Store() api.FileTokenStore {
+	return api.FileTokenStore{Path: s.TokenStore}
+}
+
+// resolveToken fills in the API tokens of a configuration loaded from a file in dir, from the token files or token
+// commands that it names, or else from TokenEnv, so that no token has to be kept in the configuration itself.
+func (c *Config) resolveToken(dir string) error {
+	if c.OAuth != nil {
+		if c.BearerToken != "" || c.TokenFile != "" || len(c.TokenCommand) > 0 {
+			return errors.New("oauth cannot be combined with token, token-file, or token-command")
+		}
+		if c.OAuth.ClientId == "" || c.OAuth.TokenStore == "" {
+			return errors.New("oauth requires client-id and token-store")
+		}
+		if !filepath.IsAbs(c.OAuth.TokenStore) {
+			c.OAuth.TokenStore = filepath.Join(dir, c.OAuth.TokenStore)
+		}
+		c.TokenSource = &api.OAuthSource{Config: c.OAuth.OAuthConfig, Store: c.OAuth.Store()}
+	}
+	token, err := readToken(c.BearerToken, c.TokenFile, c.TokenCommand, dir)
+	if err != nil {
+		return err
+	}
+	if token == "" && c.OAuth == nil {
+		token = os.Getenv(TokenEnv)
+	}
+	c.BearerToken = token
+	for app, credential := range c.AppCredentials {
+		credential.Token, err = readToken(credential.Token, credential.TokenFile, credential.TokenCommand, dir)
+		if err != nil {
+			return fmt.Errorf("app-credentials for %s: %w", app, err)
+		}
+		c.AppCredentials[app] = credential
+	}
+	return nil
+}
+
+// ClerkConfig returns the API settings for accessing an app, with the token from its credential, if it has one.
+func (c Config) ClerkConfig(app string) api.Config {
+	config := c.Config
+	if credential, found := c.AppCredentials[app]; found {
+		config.BearerToken, config.TokenSource = credential.Token, nil
+	}
+	return config
+}
+
+// validateCredentials checks the API settings of every app. The configuration's own token is only required if some
+// configured app has no credential of its own.
+func (c Config) validateCredentials() error {
+	for app, credential := range c.AppCredentials {
+		if !api.IsAirTableId(app) {
+			return fmt.Errorf("not a valid app ID in app-credentials: %q", app)
+		}
+		if _, err := api.ClassifyToken(credential.Token); err != nil {
+			return fmt.Errorf("app-credentials for %s: %w", app, err)
+		}
+	}
+	if len(c.Tables) == 0 {
+		return c.Config.Validate()
+	}
+	for app := range c.Tables {
+		if err := c.ClerkConfig(app).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readToken returns the token, or the contents of the token file, resolved relative to dir, or the output of the
+// token command. At most one of them may be set. It returns an empty token if none of them are.
+func readToken(token, file string, command []string, dir string) (string, error) {
+	set := 0
+	for _, isSet := range []bool{token != "", file != "", len(command) > 0} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("only one of token, token-file, and token-command may be set")
+	}
+	switch {
+	case file != "":
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("reading token-file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	case len(command) > 0:
+		var stderr bytes.Buffer
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("running token-command %q: %w: %s", command[0], err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return strings.TrimSpace(string(output)), nil
+	default:
+		return token, nil
+	}
+}
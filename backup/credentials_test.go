@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestTokenSources(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "token"), []byte("keyFFFFFFFFFFFFFF\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(TokenEnv, "keyEEEEEEEEEEEEEE")
+	for source, expected := range map[string]string{
+		`"token": "keyAAAAAAAAAAAAAA"`:                   "keyAAAAAAAAAAAAAA",
+		`"token-file": "token"`:                          "keyFFFFFFFFFFFFFF",
+		`"token-command": ["echo", "keyCCCCCCCCCCCCCC"]`: "keyCCCCCCCCCCCCCC",
+		`"retries": 1`:                                   "keyEEEEEEEEEEEEEE",
+	} {
+		configPath := path.Join(dir, "config.json")
+		contents := `{` + source + `, "app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"]}}`
+		if err := os.WriteFile(configPath, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+		} else if config.BearerToken != expected {
+			t.Errorf("%s: expected token %s, got %s", source, expected, config.BearerToken)
+		}
+	}
+}
+
+func TestTokenSourcesAreExclusive(t *testing.T) {
+	if _, err := readToken("keyAAAAAAAAAAAAAA", "token", nil, ""); err == nil {
+		t.Error("expected token and token-file together to be rejected")
+	}
+	if _, err := readToken("", "", []string{"false"}, ""); err == nil {
+		t.Error("expected a failing token-command to be reported")
+	}
+}
+
+func TestAppCredentialsPickTheTokenForEachApp(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		expected := "Bearer keyAAAAAAAAAAAAAA"
+		if strings.Contains(r.URL.Path, "/appBBBBBBBBBBBBBB/") {
+			expected = "Bearer keyBBBBBBBBBBBBBB"
+		}
+		if auth := r.Header.Get("Authorization"); auth != expected {
+			t.Errorf("expected %q for %s, got %q", expected, r.URL.Path, auth)
+		}
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	config := Config{
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"},
+			"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+		},
+		AppCredentials: map[string]Credential{
+			"appAAAAAAAAAAAAAA": {Token: "keyAAAAAAAAAAAAAA"},
+			"appBBBBBBBBBBBBBB": {Token: "keyBBBBBBBBBBBBBB"},
+		},
+		ListWorkers:     1,
+		DownloadOptions: DownloadOptions{Workers: 1},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("no default token should be needed when every app has its own: %v", err)
+	}
+	if _, err := ExtractAllTables(context.Background(), config, client); err != nil {
+		t.Fatal(err)
+	}
+	delete(config.AppCredentials, "appBBBBBBBBBBBBBB")
+	if err := config.Validate(); err == nil {
+		t.Error("expected an app without a credential to need the default token")
+	}
+}
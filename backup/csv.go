@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// csvCell flattens a field value into a spreadsheet cell. Text, numbers, and checkboxes are written as they are;
+// everything else, like linked records and attachments, is written as JSON.
+func csvCell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// WriteTableCSV writes the records of one table as CSV, with a column for the record ID, its creation time, and each
+// of the fields.
+func WriteTableCSV(w io.Writer, fields []DictionaryField, records []api.Record) error {
+	writer := csv.NewWriter(w)
+	header := []string{"id", "createdTime"}
+	for _, field := range fields {
+		header = append(header, field.Name)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, record := range records {
+		row := []string{record.Id, record.CreatedTime}
+		for _, field := range fields {
+			cell, err := csvCell(record.Fields[field.Name])
+			if err != nil {
+				return err
+			}
+			row = append(row, cell)
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvFilename turns a table name into a filename that cannot escape the export directory.
+func csvFilename(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(name)
+	if strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name
+}
+
+// ExportCSV writes each table of the backup to its own CSV file in the directory exportPath, which is created if
+// needed.
+func ExportCSV(backup *Backup, exportPath string) error {
+	if err := os.MkdirAll(exportPath, 0o755); err != nil {
+		return err
+	}
+	return WriteCSV(backup, PathOpener(exportPath))
+}
+
+// WriteCSV writes each table of the backup as its own CSV file, opened with open.
+func WriteCSV(backup *Backup, open Opener) error {
+	dictionary := BuildDataDictionary(backup.Tables)
+	names := backup.tableNames()
+	used := uniqueNames{}
+	for _, table := range dictionary.sortedTables() {
+		filename := used.assign(csvFilename(names[table])) + ".csv"
+		err := open(filename, func(w io.Writer) error {
+			return WriteTableCSV(w, dictionary[table], backup.Tables[table])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
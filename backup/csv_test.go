@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestExportCSV(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z", Fields: map[string]interface{}{
+					"Name":  "Widget, large",
+					"Count": 3.0,
+					"Links": []interface{}{"recBBBBBBBBBBBBBB"},
+				}},
+				{Id: "recBBBBBBBBBBBBBB", CreatedTime: "2023-01-02T00:00:00.000Z", Fields: map[string]interface{}{
+					"Name": "Gadget",
+				}},
+			},
+		},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "../Widgets"}}},
+		},
+	}
+	dir := path.Join(t.TempDir(), "csv")
+	if err := ExportCSV(backup, dir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path.Join(dir, "_.._Widgets.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "id,createdTime,Count,Links,Name\n" +
+		"recAAAAAAAAAAAAAA,2023-01-01T00:00:00.000Z,3,\"[\"\"recBBBBBBBBBBBBBB\"\"]\",\"Widget, large\"\n" +
+		"recBBBBBBBBBBBBBB,2023-01-02T00:00:00.000Z,,,Gadget\n"
+	if string(data) != expected {
+		t.Errorf("unexpected CSV:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestWriteCSVToBuffer(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z", Fields: map[string]interface{}{
+					"Name": "Widget",
+				}},
+			},
+		},
+	}
+	var buffer bytes.Buffer
+	var names []string
+	err := WriteCSV(backup, func(name string, write func(w io.Writer) error) error {
+		names = append(names, name)
+		return WriterOpener(&buffer)(name, write)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "tblAAAAAAAAAAAAAA.csv" {
+		t.Errorf("expected one file named after the table, got %v", names)
+	}
+	expected := "id,createdTime,Name\nrecAAAAAAAAAAAAAA,2023-01-01T00:00:00.000Z,Widget\n"
+	if buffer.String() != expected {
+		t.Errorf("unexpected CSV:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+}
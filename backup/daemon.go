@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// DefaultJob names the scheduled job that backs up every app without a schedule of its own.
+const DefaultJob = "default"
+
+// scheduledJob is a backup that the daemon runs on a schedule: either the apps on the default schedule, or a single
+// app with its own.
+type scheduledJob struct {
+	name     string
+	schedule *Schedule
+	apps     []string
+}
+
+func (j scheduledJob) key() string {
+	return j.name + " " + j.schedule.String()
+}
+
+// jobs returns the scheduled jobs of a configuration, in order of name.
+func (c Config) jobs() ([]scheduledJob, error) {
+	var jobs []scheduledJob
+	var defaultApps []string
+	for app := range c.Tables {
+		spec, found := c.Schedules[app]
+		if !found {
+			defaultApps = append(defaultApps, app)
+			continue
+		}
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("schedule for %s: %w", app, err)
+		}
+		jobs = append(jobs, scheduledJob{name: app, schedule: schedule, apps: []string{app}})
+	}
+	for app := range c.Schedules {
+		if _, found := c.Tables[app]; !found {
+			return nil, fmt.Errorf("schedule given for app %q, which is not in app-tables", app)
+		}
+	}
+	if c.Schedule != "" && len(defaultApps) > 0 {
+		schedule, err := ParseSchedule(c.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(defaultApps)
+		jobs = append(jobs, scheduledJob{name: DefaultJob, schedule: schedule, apps: defaultApps})
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].name < jobs[j].name
+	})
+	return jobs, nil
+}
+
+// Daemon runs backups on the schedules in its configuration. Backups run one at a time, so that a slow backup
+// delays the ones due after it rather than overlapping with them, and a job whose scheduled times pass while it
+// waits runs once, not once per missed time.
+type Daemon struct {
+	Reloader *ConfigReloader
+	// OutputPath is where the default job writes its backups. Apps with their own schedules are written to a
+	// directory named after the app next to it, so that each job has its own catalog.
+	OutputPath   string
+	DownloadPath string
+	// Client is used for every request; nil means a client with the configuration's HTTP settings.
+	Client *http.Client
+	// Clock decides when jobs are due, and is passed on to the backups unless their configuration has its own.
+	Clock clock.Clock
+	// Metrics, if not nil, accumulates statistics about every backup.
+	Metrics *Metrics
+	// OnRun, if not nil, is called with the outcome of every backup.
+	OnRun func(job string, err error)
+}
+
+// outputPath returns where a job writes its backups.
+func (d *Daemon) outputPath(job string) (string, error) {
+	if job == DefaultJob {
+		return d.OutputPath, nil
+	}
+	if isBucket(d.OutputPath) {
+		cut := strings.LastIndex(d.OutputPath, "/")
+		return d.OutputPath[:cut] + "/" + job + d.OutputPath[cut:], nil
+	}
+	dir := path.Join(path.Dir(d.OutputPath), job)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return path.Join(dir, path.Base(d.OutputPath)), nil
+}
+
+func (d *Daemon) runJob(ctx context.Context, config Config, job scheduledJob) error {
+	config, err := config.SelectApps(job.apps)
+	if err != nil {
+		return err
+	}
+	if config.Clock == nil {
+		config.Clock = d.Clock
+	}
+	outputPath, err := d.outputPath(job.name)
+	if err != nil {
+		return err
+	}
+	return Run(ctx, Options{
+		Config:       config,
+		Client:       d.Client,
+		OutputPath:   outputPath,
+		DownloadPath: d.DownloadPath,
+		Metrics:      d.Metrics,
+	})
+}
+
+var errNothingToSchedule = errors.New("nothing to schedule: set schedule, or schedules for individual apps")
+
+// checkSchedules rejects a configuration with nothing for the daemon to schedule.
+func checkSchedules(config Config) error {
+	jobs, err := config.jobs()
+	if err == nil && len(jobs) == 0 {
+		err = errNothingToSchedule
+	}
+	return err
+}
+
+// Serve runs scheduled backups until stop is closed, and then returns once any backup in progress finishes.
+// Cancelling ctx aborts that backup instead. The configuration is reloaded on SIGHUP, and the new schedules take
+// effect immediately; the next run of each job that stays on the same schedule is unchanged. A reloaded configuration
+// with nothing to schedule is rejected, and the previous one is kept.
+func (d *Daemon) Serve(ctx context.Context, stop <-chan struct{}) error {
+	c := clock.Or(d.Clock)
+	reloads := make(chan error, 1)
+	d.Reloader.Check = checkSchedules
+	defer d.Reloader.WatchSIGHUP(func(err error) {
+		select {
+		case reloads <- err:
+		default:
+		}
+	})()
+	// next holds when each job is due, keyed by its name and schedule, so that a changed schedule starts afresh
+	next := map[string]time.Time{}
+	for {
+		config := d.Reloader.Current()
+		jobs, err := config.jobs()
+		if err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return errNothingToSchedule
+		}
+		now := c.Now()
+		var due scheduledJob
+		for i, job := range jobs {
+			if _, found := next[job.key()]; !found {
+				if next[job.key()] = job.schedule.Next(now); next[job.key()].IsZero() {
+					return fmt.Errorf("schedule %q for job %s never runs", job.schedule, job.name)
+				}
+				loggerFrom(ctx).Info("Scheduled job", "job", job.name, "schedule", job.schedule.String(),
+					"next", next[job.key()])
+			}
+			if i == 0 || next[job.key()].Before(next[due.key()]) {
+				due = job
+			}
+		}
+		scheduled := next[due.key()]
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-reloads:
+			continue
+		case <-c.After(scheduled.Sub(now)):
+		}
+		logger := loggerFrom(ctx).With("job", due.name)
+		logger.Info("Starting scheduled backup")
+		err = d.runJob(WithLogger(ctx, logger), config, due)
+		if err != nil {
+			logger.Error("Scheduled backup failed", "error", err)
+		} else {
+			logger.Info("Finished scheduled backup")
+		}
+		if d.OnRun != nil {
+			d.OnRun(due.name, err)
+		}
+		finished := c.Now()
+		if due.schedule.Next(scheduled).Before(finished) {
+			logger.Warn("Backup overran its schedule; skipping the runs it overlapped")
+		}
+		next[due.key()] = due.schedule.Next(finished)
+	}
+}
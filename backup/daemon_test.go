@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestDaemonRunsJobsOnSchedule(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	dir := t.TempDir()
+	configPath := path.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"token": "`+testToken+`", "skip-schema": true,
+		"app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"], "appBBBBBBBBBBBBBB": ["tblBBBBBBBBBBBBBB"]},
+		"schedule": "0 * * * *", "schedules": {"appBBBBBBBBBBBBBB": "30 0 * * *"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := NewConfigReloader(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var runs []string
+	stop := make(chan struct{})
+	daemon := &Daemon{
+		Reloader:     reloader,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: downloadDir,
+		Client:       client,
+		Clock:        fakeClock,
+		OnRun: func(job string, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			runs = append(runs, job+" "+fakeClock.Now().Format("15:04"))
+			if len(runs) == 3 {
+				close(stop)
+			}
+		},
+	}
+	if err := daemon.Serve(context.Background(), stop); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"appBBBBBBBBBBBBBB 00:30", "default 01:00", "default 02:00"}
+	if !reflect.DeepEqual(runs, expected) {
+		t.Errorf("expected runs %v, got %v", expected, runs)
+	}
+	for _, backupPath := range []string{path.Join(dir, "backup.json"), path.Join(dir, "appBBBBBBBBBBBBBB", "backup.json")} {
+		backup, err := Load(backupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backup.Config) != 1 {
+			t.Errorf("each job should only back up its own apps: %v", backup.Config)
+		}
+	}
+}
+
+func TestConfigJobsRejectsUnknownApps(t *testing.T) {
+	config := Config{
+		Tables:    map[string][]string{"appAAAAAAAAAAAAAA": nil},
+		Schedules: map[string]string{"appBBBBBBBBBBBBBB": "@daily"},
+	}
+	if _, err := config.jobs(); err == nil {
+		t.Error("a schedule for an app that is not backed up should be rejected")
+	}
+}
+
+func TestDaemonRejectsReloadWithoutSchedules(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.json")
+	writeConfig := func(contents string) {
+		if err := os.WriteFile(configPath, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"token": "` + testToken + `", "app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"]},
+		"schedule": "@daily"}`)
+	reloader, err := NewConfigReloader(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Check = checkSchedules
+	writeConfig(`{"token": "` + testToken + `", "app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"]}}`)
+	if err := reloader.Reload(); !errors.Is(err, errNothingToSchedule) {
+		t.Errorf("expected a config with nothing to schedule to be rejected, got %v", err)
+	}
+	if reloader.Current().Schedule != "@daily" {
+		t.Errorf("expected the previous config to be kept, got %+v", reloader.Current())
+	}
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+type DuplicateSet struct {
+	Kept    string   `json:"kept"`
+	Removed []string `json:"removed"`
+}
+
+// DedupReport maps each table ID to the groups of records that were found to have identical fields.
+type DedupReport map[string][]DuplicateSet
+
+// DedupRecords removes records whose fields are identical to another record in the same table, ignoring IDs and
+// creation times. The earliest-created record of each group is kept, and the relative order of kept records is
+// preserved.
+func DedupRecords(tables map[string][]api.Record) (map[string][]api.Record, DedupReport, error) {
+	output := map[string][]api.Record{}
+	report := DedupReport{}
+	for table, records := range tables {
+		groups := map[string][]int{}
+		var keys []string
+		for i, record := range records {
+			// encoding/json sorts map keys, so this is a canonical form of the fields
+			encoded, err := json.Marshal(record.Fields)
+			if err != nil {
+				return nil, nil, err
+			}
+			key := string(encoded)
+			if _, found := groups[key]; !found {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], i)
+		}
+		keep := make([]bool, len(records))
+		for _, key := range keys {
+			group := groups[key]
+			sort.SliceStable(group, func(i, j int) bool {
+				a, b := records[group[i]], records[group[j]]
+				if a.CreatedTime != b.CreatedTime {
+					return a.CreatedTime < b.CreatedTime
+				}
+				return a.Id < b.Id
+			})
+			keep[group[0]] = true
+			if len(group) > 1 {
+				set := DuplicateSet{Kept: records[group[0]].Id}
+				for _, index := range group[1:] {
+					set.Removed = append(set.Removed, records[index].Id)
+				}
+				report[table] = append(report[table], set)
+			}
+		}
+		kept := make([]api.Record, 0, len(keys))
+		for i, record := range records {
+			if keep[i] {
+				kept = append(kept, record)
+			}
+		}
+		output[table] = kept
+	}
+	return output, report, nil
+}
+
+func (r DedupReport) Print(w io.Writer) {
+	r.each(func(table string, set DuplicateSet) {
+		_, _ = fmt.Fprintf(w, "Table %s: kept %s, removed %d duplicate(s): %v\n",
+			table, set.Kept, len(set.Removed), set.Removed)
+	})
+}
+
+// Log logs each set of duplicates that was removed.
+func (r DedupReport) Log(logger *slog.Logger) {
+	r.each(func(table string, set DuplicateSet) {
+		logger.Info("Removed duplicate records", "table", table, "kept", set.Kept, "removed", set.Removed)
+	})
+}
+
+func (r DedupReport) each(fn func(table string, set DuplicateSet)) {
+	tables := make([]string, 0, len(r))
+	for table := range r {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, set := range r[table] {
+			fn(table, set)
+		}
+	}
+}
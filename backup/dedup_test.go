@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestDedupRecords(t *testing.T) {
+	tables := map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {
+			{Id: "recCCCCCCCCCCCCCC", CreatedTime: "2023-01-03T00:00:00.000Z", Fields: map[string]interface{}{"Name": "a", "N": 1.0}},
+			{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z", Fields: map[string]interface{}{"N": 1.0, "Name": "a"}},
+			{Id: "recBBBBBBBBBBBBBB", CreatedTime: "2023-01-02T00:00:00.000Z", Fields: map[string]interface{}{"Name": "b"}},
+			{Id: "recDDDDDDDDDDDDDD", CreatedTime: "2023-01-04T00:00:00.000Z", Fields: map[string]interface{}{"Name": "a", "N": 1.0}},
+		},
+	}
+	deduped, report, err := DedupRecords(tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, record := range deduped["tblAAAAAAAAAAAAAA"] {
+		ids = append(ids, record.Id)
+	}
+	if !reflect.DeepEqual(ids, []string{"recAAAAAAAAAAAAAA", "recBBBBBBBBBBBBBB"}) {
+		t.Errorf("unexpected records after dedup: %v", ids)
+	}
+	expected := DedupReport{"tblAAAAAAAAAAAAAA": {{
+		Kept:    "recAAAAAAAAAAAAAA",
+		Removed: []string{"recCCCCCCCCCCCCCC", "recDDDDDDDDDDDDDD"},
+	}}}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("unexpected report: %v", report)
+	}
+	if len(tables["tblAAAAAAAAAAAAAA"]) != 4 {
+		t.Error("input should not have been modified")
+	}
+}
@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	FieldTypeText          = "text"
+	FieldTypeDate          = "date"
+	FieldTypeDateTime      = "dateTime"
+	FieldTypeNumber        = "number"
+	FieldTypeCheckbox      = "checkbox"
+	FieldTypeAttachments   = "attachments"
+	FieldTypeLinkedRecords = "linkedRecords"
+	FieldTypeMultiSelect   = "multipleSelects"
+	FieldTypeCollaborator  = "collaborator"
+	FieldTypeObject        = "object"
+	FieldTypeList          = "list"
+	FieldTypeMixed         = "mixed"
+)
+
+// InferFieldType guesses the AirTable field type that produced a decoded JSON value. The guess is only as good as
+// the data: for instance, a single-select field is indistinguishable from a text field.
+func InferFieldType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			return FieldTypeDate
+		}
+		if _, err := time.Parse(time.RFC3339, v); err == nil {
+			return FieldTypeDateTime
+		}
+		return FieldTypeText
+	case float64:
+		return FieldTypeNumber
+	case bool:
+		return FieldTypeCheckbox
+	case map[string]interface{}:
+		if _, ok := v["email"]; ok {
+			return FieldTypeCollaborator
+		}
+		return FieldTypeObject
+	case []interface{}:
+		return inferListType(v)
+	default:
+		return FieldTypeMixed
+	}
+}
+
+func inferListType(items []interface{}) string {
+	if len(items) == 0 {
+		return FieldTypeList
+	}
+	allAttachments, allLinks, allStrings := true, true, true
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			allAttachments = false
+			if !api.IsAirTableId(v) || !strings.HasPrefix(v, "rec") {
+				allLinks = false
+			}
+		case map[string]interface{}:
+			allLinks, allStrings = false, false
+			if !IsAttachment(v) {
+				allAttachments = false
+			}
+		default:
+			return FieldTypeList
+		}
+	}
+	switch {
+	case allLinks:
+		return FieldTypeLinkedRecords
+	case allStrings:
+		return FieldTypeMultiSelect
+	case allAttachments:
+		return FieldTypeAttachments
+	default:
+		return FieldTypeList
+	}
+}
+
+func mergeFieldTypes(existing, next string) string {
+	if existing == "" || existing == next {
+		return next
+	}
+	if existing == FieldTypeList && (next == FieldTypeLinkedRecords || next == FieldTypeMultiSelect || next == FieldTypeAttachments) {
+		return next
+	}
+	if next == FieldTypeList && (existing == FieldTypeLinkedRecords || existing == FieldTypeMultiSelect || existing == FieldTypeAttachments) {
+		return existing
+	}
+	if (existing == FieldTypeDate && next == FieldTypeText) || (existing == FieldTypeText && next == FieldTypeDate) {
+		return FieldTypeText
+	}
+	if (existing == FieldTypeDateTime && next == FieldTypeText) || (existing == FieldTypeText && next == FieldTypeDateTime) {
+		return FieldTypeText
+	}
+	return FieldTypeMixed
+}
+
+type DictionaryField struct {
+	Name   string      `json:"name"`
+	Type   string      `json:"type"`
+	Sample interface{} `json:"sample"`
+}
+
+// DataDictionary maps each table ID to the fields observed in its records, sorted by name.
+type DataDictionary map[string][]DictionaryField
+
+func BuildDataDictionary(tables map[string][]api.Record) DataDictionary {
+	dictionary := DataDictionary{}
+	for table, records := range tables {
+		fields := map[string]*DictionaryField{}
+		for _, record := range records {
+			for name, value := range record.Fields {
+				field, found := fields[name]
+				if !found {
+					field = &DictionaryField{Name: name}
+					fields[name] = field
+				}
+				field.Type = mergeFieldTypes(field.Type, InferFieldType(value))
+				if field.Sample == nil {
+					field.Sample = value
+				}
+			}
+		}
+		list := make([]DictionaryField, 0, len(fields))
+		for _, field := range fields {
+			list = append(list, *field)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name < list[j].Name
+		})
+		dictionary[table] = list
+	}
+	return dictionary
+}
+
+func (d DataDictionary) sortedTables() []string {
+	tables := make([]string, 0, len(d))
+	for table := range d {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (d DataDictionary) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+func escapeMarkdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+func (d DataDictionary) WriteMarkdown(w io.Writer) error {
+	for _, table := range d.sortedTables() {
+		if _, err := fmt.Fprintf(w, "## %s\n\n| Field | Type | Sample |\n| --- | --- | --- |\n", table); err != nil {
+			return err
+		}
+		for _, field := range d[table] {
+			sample, err := json.Marshal(field.Sample)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "| %s | %s | `%s` |\n",
+				escapeMarkdownCell(field.Name), field.Type, escapeMarkdownCell(string(sample))); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save writes the dictionary as Markdown if the path ends in .md, and as JSON otherwise.
+func (d DataDictionary) Save(outputPath string) error {
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(outputPath, ".md") {
+		err = d.WriteMarkdown(output)
+	} else {
+		err = d.WriteJSON(output)
+	}
+	if err != nil {
+		return multierror.Append(err, output.Close(), os.Remove(outputPath))
+	}
+	if err := output.Close(); err != nil {
+		return multierror.Append(err, os.Remove(outputPath))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestBuildDataDictionary(t *testing.T) {
+	tables := map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {
+			{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{
+				"Name":  "Widget",
+				"Count": 3.0,
+				"Done":  true,
+				"Due":   "2023-04-01",
+				"Links": []interface{}{"recBBBBBBBBBBBBBB"},
+			}},
+			{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{
+				"Name": "Gadget",
+				"Tags": []interface{}{"red", "blue"},
+				"Files": []interface{}{map[string]interface{}{
+					"id": "attAAAAAAAAAAAAAA", "url": DefaultAttachmentPrefixes[0] + "x", "size": 10.0, "filename": "x.txt",
+				}},
+				"Owner": map[string]interface{}{"id": "usrAAAAAAAAAAAAAA", "email": "a@example.com"},
+			}},
+		},
+	}
+	dictionary := BuildDataDictionary(tables)
+	expected := map[string]string{
+		"Count": FieldTypeNumber,
+		"Done":  FieldTypeCheckbox,
+		"Due":   FieldTypeDate,
+		"Files": FieldTypeAttachments,
+		"Links": FieldTypeLinkedRecords,
+		"Name":  FieldTypeText,
+		"Owner": FieldTypeCollaborator,
+		"Tags":  FieldTypeMultiSelect,
+	}
+	fields := dictionary["tblAAAAAAAAAAAAAA"]
+	if len(fields) != len(expected) {
+		t.Fatalf("expected %d fields, got %d: %v", len(expected), len(fields), fields)
+	}
+	for _, field := range fields {
+		if expected[field.Name] != field.Type {
+			t.Errorf("field %q: expected type %q, got %q", field.Name, expected[field.Name], field.Type)
+		}
+		if field.Sample == nil {
+			t.Errorf("field %q: missing sample", field.Name)
+		}
+	}
+	var buf bytes.Buffer
+	if err := dictionary.WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for name, fieldType := range expected {
+		if !strings.Contains(buf.String(), "| "+name+" | "+fieldType+" |") {
+			t.Errorf("markdown is missing field %q", name)
+		}
+	}
+}
+
+func TestMergeFieldTypes(t *testing.T) {
+	if mergeFieldTypes(FieldTypeDate, FieldTypeText) != FieldTypeText {
+		t.Error("dates mixed with text should be text")
+	}
+	if mergeFieldTypes(FieldTypeNumber, FieldTypeCheckbox) != FieldTypeMixed {
+		t.Error("numbers mixed with checkboxes should be mixed")
+	}
+	if mergeFieldTypes(FieldTypeList, FieldTypeMultiSelect) != FieldTypeMultiSelect {
+		t.Error("empty lists should not hide the element type")
+	}
+}
@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// FieldChange is a field whose value differs between two backups. A nil Old or New means that the field was empty.
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+type RecordChange struct {
+	Id      string
+	Changes []FieldChange
+}
+
+// TableDiff lists the records of one table that were added, removed, or modified between two backups.
+type TableDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []RecordChange
+}
+
+func (d *TableDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// BackupDiff maps table IDs to the changes in those tables. Tables without changes are left out.
+type BackupDiff map[string]*TableDiff
+
+// comparableValue strips the parts of a value that change on every backup without the data changing: AirTable hands
+// out new, expiring links to attachments and their thumbnails each time they are listed.
+func comparableValue(value interface{}) interface{} {
+	if InferFieldType(value) != FieldTypeAttachments {
+		return value
+	}
+	var stripped []interface{}
+	for _, item := range value.([]interface{}) {
+		itemMap := map[string]interface{}{}
+		for key, v := range item.(map[string]interface{}) {
+			if key != "url" && key != "thumbnails" {
+				itemMap[key] = v
+			}
+		}
+		stripped = append(stripped, itemMap)
+	}
+	return stripped
+}
+
+func diffRecord(old, new api.Record) []FieldChange {
+	names := map[string]bool{}
+	for name := range old.Fields {
+		names[name] = true
+	}
+	for name := range new.Fields {
+		names[name] = true
+	}
+	var changes []FieldChange
+	for name := range names {
+		oldValue, newValue := old.Fields[name], new.Fields[name]
+		if !reflect.DeepEqual(comparableValue(oldValue), comparableValue(newValue)) {
+			changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+func diffTable(old, new []api.Record) *TableDiff {
+	oldById := map[string]api.Record{}
+	for _, record := range old {
+		oldById[record.Id] = record
+	}
+	diff := &TableDiff{}
+	seen := map[string]bool{}
+	for _, record := range new {
+		seen[record.Id] = true
+		previous, found := oldById[record.Id]
+		if !found {
+			diff.Added = append(diff.Added, record.Id)
+		} else if changes := diffRecord(previous, record); len(changes) > 0 {
+			diff.Modified = append(diff.Modified, RecordChange{Id: record.Id, Changes: changes})
+		}
+	}
+	for _, record := range old {
+		if !seen[record.Id] {
+			diff.Removed = append(diff.Removed, record.Id)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Modified, func(i, j int) bool {
+		return diff.Modified[i].Id < diff.Modified[j].Id
+	})
+	return diff
+}
+
+// DiffBackups compares the records of two backups. A table that is only in one of the backups shows up as all of
+// its records having been added or removed.
+func DiffBackups(old, new *Backup) BackupDiff {
+	diff := BackupDiff{}
+	tables := map[string]bool{}
+	for table := range old.Tables {
+		tables[table] = true
+	}
+	for table := range new.Tables {
+		tables[table] = true
+	}
+	for table := range tables {
+		if tableDiff := diffTable(old.Tables[table], new.Tables[table]); !tableDiff.Empty() {
+			diff[table] = tableDiff
+		}
+	}
+	return diff
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return "(empty)"
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func (d BackupDiff) Print(w io.Writer) {
+	if len(d) == 0 {
+		_, _ = fmt.Fprintln(w, "No differences.")
+		return
+	}
+	tables := make([]string, 0, len(d))
+	for table := range d {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		diff := d[table]
+		_, _ = fmt.Fprintf(w, "Table %s: %d added, %d removed, %d modified\n",
+			table, len(diff.Added), len(diff.Removed), len(diff.Modified))
+		for _, id := range diff.Added {
+			_, _ = fmt.Fprintf(w, "  + %s\n", id)
+		}
+		for _, id := range diff.Removed {
+			_, _ = fmt.Fprintf(w, "  - %s\n", id)
+		}
+		for _, record := range diff.Modified {
+			_, _ = fmt.Fprintf(w, "  ~ %s\n", record.Id)
+			for _, change := range record.Changes {
+				_, _ = fmt.Fprintf(w, "      %s: %s -> %s\n",
+					change.Field, formatValue(change.Old), formatValue(change.New))
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestDiffBackups(t *testing.T) {
+	attachment := func(url string) []interface{} {
+		return []interface{}{map[string]interface{}{"id": "attAAAAAAAAAAAAAA", "url": url, "size": 11.0, "filename": "a.txt"}}
+	}
+	old := &Backup{Tables: map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {
+			{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "a", "Files": attachment("https://x/1")}},
+			{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "b", "Count": 1.0}},
+			{Id: "recCCCCCCCCCCCCCC", Fields: map[string]interface{}{"Name": "c"}},
+		},
+	}}
+	new := &Backup{Tables: map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {
+			// only the attachment link changed, which happens on every backup
+			{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "a", "Files": attachment("https://x/2")}},
+			{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "b2"}},
+			{Id: "recDDDDDDDDDDDDDD", Fields: map[string]interface{}{"Name": "d"}},
+		},
+	}}
+	var out bytes.Buffer
+	DiffBackups(old, new).Print(&out)
+	expected := "Table tblAAAAAAAAAAAAAA: 1 added, 1 removed, 1 modified\n" +
+		"  + recDDDDDDDDDDDDDD\n" +
+		"  - recCCCCCCCCCCCCCC\n" +
+		"  ~ recBBBBBBBBBBBBBB\n" +
+		"      Count: 1 -> (empty)\n" +
+		"      Name: \"b\" -> \"b2\"\n"
+	if out.String() != expected {
+		t.Errorf("unexpected diff:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+	if diff := DiffBackups(old, old); len(diff) != 0 {
+		t.Errorf("a backup should not differ from itself: %v", diff)
+	}
+}
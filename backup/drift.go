@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// TableDrift compares a table in a backup with the table as it is now.
+type TableDrift struct {
+	App   string `json:"app"`
+	Table string `json:"table"`
+	// BackedUp and Current count the records in the backup and in AirTable.
+	BackedUp int `json:"backed-up"`
+	Current  int `json:"current"`
+	// Added and Removed count the records created and deleted since the backup, and Modified the records in the
+	// backup that were modified since.
+	Added    int `json:"added"`
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+}
+
+// Changed reports whether the table differs from its backup.
+func (d TableDrift) Changed() bool {
+	return d.Added > 0 || d.Removed > 0 || d.Modified > 0
+}
+
+// DriftReport compares the latest backup with AirTable, table by table.
+type DriftReport struct {
+	Backup string `json:"backup"`
+	// BackupTime is when the backup started, so changes made while it ran are counted as drift.
+	BackupTime time.Time    `json:"backup-time"`
+	Checked    time.Time    `json:"checked"`
+	Tables     []TableDrift `json:"tables"`
+}
+
+// Age returns how long before the check the backup was made.
+func (r *DriftReport) Age() time.Duration {
+	return r.Checked.Sub(r.BackupTime)
+}
+
+// Changed reports whether any table differs from its backup.
+func (r *DriftReport) Changed() bool {
+	for _, table := range r.Tables {
+		if table.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckDrift compares the latest backup at OutputPath, as recorded in its catalog, with the records in AirTable now,
+// without writing anything. Only the record IDs are listed, along with the IDs of the records modified since the
+// backup, so a check costs far fewer requests and bytes than a backup.
+func CheckDrift(ctx context.Context, opts Options) (*DriftReport, error) {
+	config, client := opts.Config, opts.Client
+	if client == nil {
+		var err error
+		if client, err = config.HTTP.Client(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := config.Key()
+	if err != nil {
+		return nil, err
+	}
+	output, outputName, err := splitLocation(opts.OutputPath, client)
+	if err != nil {
+		return nil, err
+	}
+	catalog, err := loadCatalog(ctx, output)
+	if err != nil {
+		return nil, err
+	}
+	if len(catalog.Backups) == 0 {
+		return nil, fmt.Errorf("no backups are recorded in %s", output.Location(CatalogFilename))
+	}
+	latest := catalog.Backups[len(catalog.Backups)-1]
+	if !config.Retain.Enabled() && latest.Path != outputName {
+		loggerFrom(ctx).Warn("Latest backup in the catalog is not the configured output", "latest", latest.Path,
+			"output", outputName)
+	}
+	backup, err := loadBackup(ctx, output, latest.Path, key)
+	if err != nil {
+		return nil, err
+	}
+	report := &DriftReport{
+		Backup:     output.Location(latest.Path),
+		BackupTime: latest.Timestamp,
+		Checked:    clock.Or(config.Clock).Now(),
+	}
+	client = withAppRateLimit(client, config.AppRateLimit(), config.Clock)
+	apps := make([]string, 0, len(backup.Config))
+	for app := range backup.Config {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		clerk := api.NewClerk(app, config.ClerkConfig(app), client)
+		for _, table := range backup.Config[app] {
+			drift, err := checkTableDrift(ctx, clerk, backup, table, latest.Timestamp)
+			if err != nil {
+				return nil, &TableError{App: app, Table: table, Err: err}
+			}
+			report.Tables = append(report.Tables, drift)
+		}
+	}
+	return report, nil
+}
+
+func checkTableDrift(ctx context.Context, clerk *api.Clerk, backup *Backup, table string,
+	since time.Time) (TableDrift, error) {
+	drift := TableDrift{App: clerk.App, Table: table, BackedUp: len(backup.Tables[table])}
+	// tables backed up from a view are compared with the same view
+	opts := api.ListRecordsOptions{View: backup.Views[table]}
+	// listing only the primary field, which every table has, keeps the responses small
+	if schema := backup.Schemas[clerk.App]; schema != nil {
+		for _, tableSchema := range schema.Tables {
+			if tableSchema.Id == table {
+				opts.Fields = []string{tableSchema.PrimaryFieldId}
+			}
+		}
+	}
+	backedUp := map[string]bool{}
+	for _, record := range backup.Tables[table] {
+		backedUp[record.Id] = true
+	}
+	current := map[string]bool{}
+	err := clerk.ListRecordsPages(ctx, table, opts, func(page []api.Record) error {
+		for _, record := range page {
+			current[record.Id] = true
+			if !backedUp[record.Id] {
+				drift.Added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TableDrift{}, err
+	}
+	drift.Current = len(current)
+	for id := range backedUp {
+		if !current[id] {
+			drift.Removed++
+		}
+	}
+	opts.Formula = modifiedSinceFormula(since)
+	err = clerk.ListRecordsPages(ctx, table, opts, func(page []api.Record) error {
+		for _, record := range page {
+			if backedUp[record.Id] {
+				drift.Modified++
+			}
+		}
+		return nil
+	})
+	return drift, err
+}
+
+// Print writes the report in a human-readable form.
+func (r *DriftReport) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Latest backup: %s, from %s (%s ago)\n", r.Backup, r.BackupTime.Format(time.RFC3339),
+		r.Age().Round(time.Second))
+	for _, table := range r.Tables {
+		status := "up to date"
+		if table.Changed() {
+			status = fmt.Sprintf("stale: %d added, %d removed, %d modified", table.Added, table.Removed,
+				table.Modified)
+		}
+		_, _ = fmt.Fprintf(w, "  App %s, table %s: %d records backed up, %d now; %s\n", table.App, table.Table,
+			table.BackedUp, table.Current, status)
+	}
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestCheckDriftComparesWithTheLatestBackup(t *testing.T) {
+	live := `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "a"}},
+		{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {"Name": "b"}}]}`
+	modified := `{"records": []}`
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Query().Get("filterByFormula"), "IS_AFTER(LAST_MODIFIED_TIME()") {
+			_, _ = w.Write([]byte(modified))
+		} else {
+			_, _ = w.Write([]byte(live))
+		}
+	})
+	dir := t.TempDir()
+	opts := Options{
+		Config: Config{
+			Config:     api.Config{BearerToken: testToken},
+			Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	}
+	if _, err := CheckDrift(context.Background(), opts); err == nil {
+		t.Error("checking without any backup should fail")
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	report, err := CheckDrift(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Changed() || len(report.Tables) != 1 || report.Tables[0].Current != 2 {
+		t.Errorf("an unchanged table should be up to date: %+v", report.Tables)
+	}
+	// b was deleted, c was created, and a was modified
+	live = `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "a2"}},
+		{"id": "recCCCCCCCCCCCCCC", "createdTime": "", "fields": {"Name": "c"}}]}`
+	modified = live
+	report, err = CheckDrift(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := TableDrift{App: "appAAAAAAAAAAAAAA", Table: "tblAAAAAAAAAAAAAA", BackedUp: 2, Current: 2, Added: 1,
+		Removed: 1, Modified: 1}
+	if !report.Changed() || report.Tables[0] != expected {
+		t.Errorf("unexpected drift: %+v", report.Tables)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "stale: 1 added, 1 removed, 1 modified") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
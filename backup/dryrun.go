@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// DryRunReport describes what a backup would do.
+type DryRunReport struct {
+	// Output is where the backup would be written.
+	Output string
+	// Tables lists the tables that would be fetched in each app.
+	Tables map[string][]string
+	// Records counts the records listed in each table.
+	Records map[string]int
+	// Attachments counts the distinct attachments in the listed records that would be downloaded. Of those, Present
+	// are already in the download directory with the expected size, and Mismatched are there with another size,
+	// which would fail the backup.
+	Attachments int
+	Present     int
+	Mismatched  int
+	// DownloadBytes is the total size of the attachments that are not yet present.
+	DownloadBytes int64
+}
+
+// DryRun lists the tables of a backup and checks which of their attachments are already present, without writing
+// anything, not even the download directory's manifests. Only the records are fetched, not the attachments.
+func DryRun(ctx context.Context, opts Options) (*DryRunReport, error) {
+	config, client := opts.Config, opts.Client
+	if client == nil {
+		var err error
+		if client, err = config.HTTP.Client(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := config.Key()
+	if err != nil {
+		return nil, err
+	}
+	output, outputName, err := splitLocation(opts.OutputPath, client)
+	if err != nil {
+		return nil, err
+	}
+	if config.Retain.Enabled() {
+		outputName = snapshotName(outputName, clock.Or(config.Clock).Now())
+	}
+	downloads, err := OpenStorage(opts.DownloadPath, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckTokenScope(ctx, config, client); err != nil {
+		return nil, err
+	}
+	var schemas map[string]*api.BaseSchema
+	if !config.SkipSchema {
+		if schemas, err = FetchSchemas(ctx, config, client); err != nil {
+			return nil, err
+		}
+	}
+	if config, err = DiscoverTables(ctx, config, client, schemas); err != nil {
+		return nil, err
+	}
+	var base *incrementalBase
+	if config.Incremental {
+		if base, err = loadIncrementalBase(ctx, output, key); err != nil {
+			return nil, err
+		}
+	}
+	report := &DryRunReport{Output: output.Location(outputName), Tables: config.Tables, Records: map[string]int{}}
+	var mu sync.Mutex
+	attachments := map[string]Attachment{}
+	_, err = extractTables(ctx, config, client, base, nil, func(app, table string, records []api.Record) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Records[table] = len(records)
+		for _, record := range records {
+			found, _ := ExtractRecordAttachments(table, record, config.ExtractOptions)
+			for _, attachment := range found {
+				if !attachment.UnexpectedPrefix {
+					attachments[attachment.Id] = attachment
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	downloadOptions := config.DownloadOptions
+	downloadOptions.key = key
+	var manifest Manifest
+	if config.ContentAddressed {
+		if manifest, err = loadManifest(ctx, downloads); err != nil {
+			return nil, err
+		}
+	}
+	for _, attachment := range attachments {
+		var size int64
+		var found bool
+		if !config.ContentAddressed {
+			size, found, err = downloads.Stat(ctx, attachment.DownloadFilename(config.NamedFiles))
+		} else if known, recorded := manifest[attachment.Id]; recorded && isContentFilename(known.File) {
+			size, found, err = downloads.Stat(ctx, known.File)
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Attachments++
+		switch {
+		case !found:
+			report.DownloadBytes += attachment.Size
+		case size == downloadOptions.storedSize(attachment.Size):
+			report.Present++
+		default:
+			report.Mismatched++
+		}
+	}
+	return report, nil
+}
+
+// Print writes the report in a human-readable form.
+func (r *DryRunReport) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Would write the backup to %s, fetching:\n", r.Output)
+	apps := make([]string, 0, len(r.Tables))
+	for app := range r.Tables {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		_, _ = fmt.Fprintf(w, "  App %s:\n", app)
+		for _, table := range r.Tables[app] {
+			_, _ = fmt.Fprintf(w, "    Table %s: %d records\n", table, r.Records[table])
+		}
+	}
+	_, _ = fmt.Fprintf(w, "Attachments: %d in total, %d already present", r.Attachments, r.Present)
+	if r.Mismatched > 0 {
+		_, _ = fmt.Fprintf(w, ", %d present with the wrong size", r.Mismatched)
+	}
+	_, _ = fmt.Fprintf(w, ", %d to download (%s)\n", r.Attachments-r.Present-r.Mismatched, formatBytes(r.DownloadBytes))
+}
@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestDryRunWritesNothing(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v0/") {
+			t.Errorf("no attachment should be downloaded: %s", r.URL)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+			{"id": "attAAAAAAAAAAAAAA", "url": "%[1]sa", "size": 11, "filename": "a"},
+			{"id": "attBBBBBBBBBBBBBB", "url": "%[1]sb", "size": 2048, "filename": "b"},
+			{"id": "attCCCCCCCCCCCCCC", "url": "%[1]sc", "size": 5, "filename": "c"}
+		]}}]}`, DefaultAttachmentPrefixes[0])
+	})
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "attAAAAAAAAAAAAAA"), []byte("hello world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(dir, "attCCCCCCCCCCCCCC"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := DryRun(context.Background(), Options{
+		Config: Config{
+			Config:     api.Config{BearerToken: testToken},
+			Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	expected := "Would write the backup to " + path.Join(dir, "backup.json") + ", fetching:\n" +
+		"  App appAAAAAAAAAAAAAA:\n" +
+		"    Table tblAAAAAAAAAAAAAA: 1 records\n" +
+		"Attachments: 3 in total, 1 already present, 1 present with the wrong size, 1 to download (2.0 KiB)\n"
+	if out.String() != expected {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("a dry run should not write anything, but found %d files", len(entries))
+	}
+}
@@ -0,0 +1,240 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// EncryptionKeyEnv names the environment variable that holds the encryption key when the configuration does not.
+const EncryptionKeyEnv = "VACUUM_TABLE_ENCRYPTION_KEY"
+
+// Encrypted files start with encryptionMagic and a random nonce prefix, followed by the plaintext in chunks of
+// encryptionChunkSize, each sealed with AES-256-GCM. The nonce of each chunk is the prefix, the chunk's index, and a
+// flag marking the final chunk, so that chunks cannot be reordered and truncation is detected.
+const (
+	encryptionMagic       = "vacuum-table encrypted v1\n"
+	encryptionPrefixSize  = 7
+	encryptionChunkSize   = 64 * 1024
+	encryptionHeaderSize  = len(encryptionMagic) + encryptionPrefixSize
+	encryptionOverhead    = 16
+	encryptionSealedChunk = encryptionChunkSize + encryptionOverhead
+)
+
+var ErrNoEncryptionKey = errors.New("file is encrypted, but no encryption key is configured; set " + EncryptionKeyEnv)
+
+// EncryptionKey is a 256-bit AES key. A nil key means that files are stored unencrypted.
+type EncryptionKey []byte
+
+// ParseEncryptionKey decodes a base64 key, such as one generated by `openssl rand -base64 32`.
+func ParseEncryptionKey(encoded string) (EncryptionKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid encryption key: must be 32 bytes, encoded in base64")
+	}
+	return key, nil
+}
+
+// KeyFromEnvironment returns the key in EncryptionKeyEnv, or nil if it is not set.
+func KeyFromEnvironment() (EncryptionKey, error) {
+	encoded := os.Getenv(EncryptionKeyEnv)
+	if encoded == "" {
+		return nil, nil
+	}
+	return ParseEncryptionKey(encoded)
+}
+
+// EncryptedSize returns the size of a file of the given size once encrypted.
+func EncryptedSize(size int64) int64 {
+	chunks := (size + encryptionChunkSize - 1) / encryptionChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(encryptionHeaderSize) + size + chunks*encryptionOverhead
+}
+
+func chunkNonce(prefix []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixSize:], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptingWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+}
+
+// newEncryptingWriter encrypts everything written to it into w. Close must be called to write the final chunk, but
+// does not close w.
+func newEncryptingWriter(w io.Writer, key EncryptionKey) (io.WriteCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encryptionMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+func (e *encryptingWriter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.index, final), e.buf, nil)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, since the last chunk has to be marked as final
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+type decryptingReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	done   bool
+	buf    []byte
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		sealed := make([]byte, encryptionSealedChunk)
+		n, err := io.ReadFull(d.r, sealed)
+		if err == io.EOF {
+			return 0, errors.New("encrypted file is truncated")
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		final := err == io.ErrUnexpectedEOF
+		if !final {
+			if _, err := d.r.Peek(1); err == io.EOF {
+				final = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		plain, err := d.aead.Open(nil, chunkNonce(d.prefix, d.index, final), sealed[:n], nil)
+		if err != nil {
+			return 0, errors.New("encrypted file is corrupt, truncated, or was encrypted with a different key")
+		}
+		d.index++
+		d.done = final
+		d.buf = plain
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// openMaybeEncrypted returns a reader over the plaintext of r, decrypting it if it starts with the encryption
+// header. If key is nil, the key is taken from the environment when it is needed.
+func openMaybeEncrypted(r io.Reader, key EncryptionKey) (io.Reader, error) {
+	buffered := bufio.NewReaderSize(r, encryptionSealedChunk)
+	header, err := buffered.Peek(len(encryptionMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(header, []byte(encryptionMagic)) {
+		return buffered, nil
+	}
+	if key == nil {
+		if key, err = KeyFromEnvironment(); err != nil {
+			return nil, err
+		} else if key == nil {
+			return nil, ErrNoEncryptionKey
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := buffered.Discard(len(encryptionMagic)); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionPrefixSize)
+	if _, err := io.ReadFull(buffered, prefix); err != nil {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	return &decryptingReader{r: buffered, aead: aead, prefix: prefix}, nil
+}
+
+// DecryptFile writes the plaintext of an encrypted backup or attachment to outputPath. Unencrypted files are copied
+// as they are.
+func DecryptFile(inputPath, outputPath string, key EncryptionKey) error {
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = input.Close()
+	}()
+	plaintext, err := openMaybeEncrypted(input, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputPath, err)
+	}
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, plaintext); err != nil {
+		return multierror.Append(fmt.Errorf("%s: %w", inputPath, err), output.Close(), os.Remove(outputPath))
+	}
+	if err := output.Close(); err != nil {
+		return multierror.Append(err, os.Remove(outputPath))
+	}
+	return nil
+}
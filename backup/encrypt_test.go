@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func testKey(t *testing.T) EncryptionKey {
+	key, err := ParseEncryptionKey("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := testKey(t)
+	sizes := []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+		var sealed bytes.Buffer
+		w, err := newEncryptingWriter(&sealed, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// write in uneven pieces, to cross chunk boundaries
+		for rest := plaintext; len(rest) > 0; {
+			n := 1000
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if int64(sealed.Len()) != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: encrypted to %d bytes, but EncryptedSize says %d",
+				size, sealed.Len(), EncryptedSize(int64(size)))
+		}
+		r, err := openMaybeEncrypted(bytes.NewReader(sealed.Bytes()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: decrypted data does not match", size)
+		}
+		if size > encryptionChunkSize {
+			// dropping the final chunk must not go unnoticed
+			truncated := sealed.Bytes()[:encryptionHeaderSize+encryptionSealedChunk]
+			r, err := openMaybeEncrypted(bytes.NewReader(truncated), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Errorf("size %d: truncation should have been detected", size)
+			}
+		}
+	}
+}
+
+func TestEncryptedBackupAndAttachment(t *testing.T) {
+	key := testKey(t)
+	dir := t.TempDir()
+	backup := &Backup{Tables: map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Email": "a@example.com"}}},
+	}}
+	backupPath := path.Join(dir, "backup.json")
+	if err := backup.Save(backupPath, key); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(backupPath); err != nil || bytes.Contains(data, []byte("a@example.com")) {
+		t.Fatalf("backup should have been encrypted: %v", err)
+	}
+	t.Setenv(EncryptionKeyEnv, "")
+	if _, err := Load(backupPath); err == nil {
+		t.Error("loading an encrypted backup without a key should fail")
+	}
+	loaded, err := LoadWithKey(backupPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Tables["tblAAAAAAAAAAAAAA"][0].Fields["Email"] != "a@example.com" {
+		t.Errorf("unexpected backup contents: %v", loaded.Tables)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	opts := DownloadOptions{key: key}
+	for i := 0; i < 2; i++ {
+		// the second time, the existing encrypted file has to pass the size check
+		if err := DownloadAttachments(context.Background(), []Attachment{attachment}, dir, server.Client(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	decryptedPath := path.Join(dir, "decrypted")
+	if err := DecryptFile(path.Join(dir, attachment.Id), decryptedPath, key); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(decryptedPath); err != nil || string(data) != "hello world" {
+		t.Errorf("unexpected decrypted attachment: %q %v", data, err)
+	}
+}
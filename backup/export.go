@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Opener writes a file of an export. It calls write with a writer for the file's contents, and only keeps the file
+// if write succeeds. Formats with a file for each table name the files after the tables; formats with a single file
+// open it with the empty name.
+type Opener func(name string, write func(w io.Writer) error) error
+
+// PathOpener writes an export on local disk: the files of formats with a file for each table into the directory
+// exportPath, which is created if needed, and the file of other formats to exportPath itself. Each file is written
+// to a temporary name first, and replaces any existing file once complete.
+func PathOpener(exportPath string) Opener {
+	return func(name string, write func(w io.Writer) error) error {
+		dir, file := exportPath, name
+		if name == "" {
+			dir, file = path.Dir(exportPath), path.Base(exportPath)
+		}
+		_, err := putEncoded(context.Background(), LocalStorage(dir), file, write)
+		return err
+	}
+}
+
+// WriterOpener writes every file of an export to w, one after another, such as to pipe it into another program.
+func WriterOpener(w io.Writer) Opener {
+	return func(_ string, write func(w io.Writer) error) error {
+		return write(w)
+	}
+}
+
+// exporters convert a backup into another format, writing its files with open. Those that refer to the downloaded
+// attachments find them in downloadPath, if it is set, and link to them under linkDir.
+var exporters = map[string]func(backup *Backup, open Opener, downloadPath, linkDir string) error{
+	"csv":     withoutDownloads(WriteCSV),
+	"parquet": withoutDownloads(WriteParquet),
+	"sqlite":  withoutDownloads(WriteSQLite),
+	"xlsx":    WriteXLSX,
+}
+
+func withoutDownloads(export func(backup *Backup, open Opener) error) func(*Backup, Opener, string, string) error {
+	return func(backup *Backup, open Opener, _, _ string) error {
+		return export(backup, open)
+	}
+}
+
+func ExportFormats() string {
+	var formats []string
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return strings.Join(formats, ", ")
+}
+
+// Export converts the backup at backupPath into the named format, written to exportPath as PathOpener describes.
+// downloadPath is the directory its attachments were downloaded into, or empty if they are not needed.
+func Export(backupPath, exportPath, format, downloadPath string) error {
+	linkDir, err := attachmentLinkDir(exportPath, downloadPath)
+	if err != nil {
+		return err
+	}
+	return export(backupPath, PathOpener(exportPath), format, downloadPath, linkDir)
+}
+
+// ExportTo converts the backup at backupPath into the named format, writing its files with open. Links to the
+// attachments downloaded into downloadPath, if it is set, point at downloadPath as it is given.
+func ExportTo(backupPath string, open Opener, format, downloadPath string) error {
+	return export(backupPath, open, format, downloadPath, downloadPath)
+}
+
+func export(backupPath string, open Opener, format, downloadPath, linkDir string) error {
+	exporter, found := exporters[format]
+	if !found {
+		return fmt.Errorf("unknown export format %q; expected one of: %s", format, ExportFormats())
+	}
+	backup, err := Load(backupPath)
+	if err != nil {
+		return err
+	}
+	return exporter(backup, open, downloadPath, linkDir)
+}
+
+// attachmentLinkDir returns the directory that an export at exportPath links to the attachments in downloadPath
+// under. Relative links keep working when the export and the attachments are moved together.
+func attachmentLinkDir(exportPath, downloadPath string) (string, error) {
+	if downloadPath == "" {
+		return "", nil
+	}
+	if linkDir, err := filepath.Rel(filepath.Dir(exportPath), downloadPath); err == nil {
+		return linkDir, nil
+	}
+	return filepath.Abs(downloadPath)
+}
+
+// tableNames picks a name for each table in an export: the table's name from the backed-up schema when it is known
+// and unique, and otherwise its ID.
+func (b *Backup) tableNames() map[string]string {
+	names := map[string]string{}
+	uses := map[string]int{}
+	for _, schema := range b.Schemas {
+		for _, table := range schema.Tables {
+			if _, found := b.Tables[table.Id]; found {
+				names[table.Id] = table.Name
+				uses[table.Name]++
+			}
+		}
+	}
+	for table := range b.Tables {
+		if name, found := names[table]; !found || name == "" || uses[name] > 1 {
+			names[table] = table
+		}
+	}
+	return names
+}
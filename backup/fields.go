@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// FieldFilter selects which fields of a table's records are backed up. Include is passed to AirTable, so that only
+// those fields are listed at all, and may name fields by name or ID. Exclude is applied after listing, by field name,
+// and removes fields even if Include lists them.
+type FieldFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (f FieldFilter) validate() error {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return errors.New("neither include nor exclude is set")
+	}
+	for _, field := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if field == "" {
+			return errors.New("empty field name")
+		}
+	}
+	return nil
+}
+
+// apply removes the excluded fields from the records, in place.
+func (f FieldFilter) apply(records []api.Record) {
+	if len(f.Exclude) == 0 {
+		return
+	}
+	for _, record := range records {
+		for _, field := range f.Exclude {
+			delete(record.Fields, field)
+		}
+	}
+}
+
+func (c Config) validateTableFields() error {
+	for table, filter := range c.TableFields {
+		if err := filter.validate(); err != nil {
+			return fmt.Errorf("invalid table-fields for table %s: %w", table, err)
+		}
+	}
+	return nil
+}
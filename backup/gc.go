@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// GCReport describes the files that garbage collection removed from a download directory, or would remove.
+type GCReport struct {
+	// Backups counts the backups whose attachments were kept.
+	Backups int
+	// Kept counts the files that some backup references.
+	Kept int
+	// Removed lists the files that no backup references, and RemovedBytes is their total size.
+	Removed      []string
+	RemovedBytes int64
+	DryRun       bool
+}
+
+// CollectGarbage removes the attachments in a download directory that are no longer referenced: by the backup at
+// OutputPath or, with a retention policy, by any snapshot in its catalog. Their entries are also removed from the
+// directory's manifests. With dryRun, nothing is removed, and the report lists what would be. It must not run at the
+// same time as a backup into the same directory, whose new attachments are not referenced by any backup yet.
+func CollectGarbage(ctx context.Context, opts Options, dryRun bool) (*GCReport, error) {
+	client := opts.Client
+	if client == nil {
+		var err error
+		if client, err = opts.Config.HTTP.Client(); err != nil {
+			return nil, err
+		}
+	}
+	if isBucket(opts.DownloadPath) {
+		return nil, fmt.Errorf("garbage collection is only supported in local download directories, not %s",
+			opts.DownloadPath)
+	}
+	referenced, backups, err := referencedFiles(ctx, opts, client)
+	if err != nil {
+		return nil, err
+	}
+	files, err := downloadedFiles(opts.DownloadPath)
+	if err != nil {
+		return nil, err
+	}
+	downloads := LocalStorage(opts.DownloadPath)
+	report := &GCReport{Backups: backups, DryRun: dryRun}
+	removed := map[string]bool{}
+	var problems error
+	for _, name := range files {
+		if referenced[name] {
+			report.Kept++
+			continue
+		}
+		info, err := os.Stat(path.Join(opts.DownloadPath, name))
+		if err != nil {
+			problems = multierror.Append(problems, err)
+			continue
+		}
+		if !dryRun {
+			if err := downloads.Delete(ctx, name); err != nil {
+				problems = multierror.Append(problems, err)
+				continue
+			}
+			loggerFrom(ctx).Info("Removed unreferenced attachment", "file", name, "bytes", info.Size())
+		}
+		removed[name] = true
+		report.Removed = append(report.Removed, name)
+		report.RemovedBytes += info.Size()
+	}
+	if !dryRun && len(removed) > 0 {
+		if err := forgetFiles(opts.DownloadPath, removed); err != nil {
+			problems = multierror.Append(problems, err)
+		}
+	}
+	return report, problems
+}
+
+// referencedFiles returns the download filenames of the attachments of every backup that is still kept, and the
+// number of those backups.
+func referencedFiles(ctx context.Context, opts Options, client *http.Client) (map[string]bool, int, error) {
+	key, err := opts.Config.Key()
+	if err != nil {
+		return nil, 0, err
+	}
+	output, outputName, err := splitLocation(opts.OutputPath, client)
+	if err != nil {
+		return nil, 0, err
+	}
+	names := []string{outputName}
+	if opts.Config.Retain.Enabled() {
+		catalog, err := loadCatalog(ctx, output)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(catalog.Backups) == 0 {
+			// without any backups, every attachment would be removed, which is never what was meant
+			return nil, 0, fmt.Errorf("no backups are recorded in %s", output.Location(CatalogFilename))
+		}
+		names = names[:0]
+		for _, entry := range catalog.Backups {
+			names = append(names, entry.Path)
+		}
+	}
+	referenced := map[string]bool{}
+	for _, name := range names {
+		backup, err := loadBackup(ctx, output, name, key)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, attachment := range backup.Attachments {
+			if attachment.File != "" {
+				referenced[attachment.File] = true
+			} else {
+				// older backups did not record the filename, which could have been either of these
+				referenced[attachment.DownloadFilename(false)] = true
+				referenced[attachment.DownloadFilename(true)] = true
+			}
+		}
+	}
+	return referenced, len(names), nil
+}
+
+// forgetFiles removes the entries for deleted files from the manifests of a download directory.
+func forgetFiles(dir string, removed map[string]bool) error {
+	checksums, err := LoadChecksums(dir)
+	if err != nil {
+		return err
+	}
+	for filename := range checksums {
+		if removed[filename] {
+			delete(checksums, filename)
+		}
+	}
+	if err := checksums.Save(dir); err != nil {
+		return err
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	for id, attachment := range manifest {
+		if removed[attachment.File] {
+			delete(manifest, id)
+		}
+	}
+	return manifest.Save(dir)
+}
+
+func (r *GCReport) Print(w io.Writer) {
+	sort.Strings(r.Removed)
+	verb := "Removed"
+	if r.DryRun {
+		verb = "Would remove"
+	}
+	for _, name := range r.Removed {
+		_, _ = fmt.Fprintf(w, "%s %s\n", verb, name)
+	}
+	_, _ = fmt.Fprintf(w, "%s %d unreferenced files (%s); kept %d files referenced by %d backups\n", verb,
+		len(r.Removed), formatBytes(r.RemovedBytes), r.Kept, r.Backups)
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestCollectGarbageRemovesUnreferencedAttachments(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v0/") {
+			_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+				{"id": "attAAAAAAAAAAAAAA", "url": "https://dl.airtable.com/a", "size": 11, "filename": "a.txt"}]}}]}`))
+		} else {
+			_, _ = w.Write([]byte("hello world"))
+		}
+	})
+	dir := t.TempDir()
+	downloads := path.Join(dir, "downloads")
+	if err := os.Mkdir(downloads, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		Config: Config{
+			Config:     api.Config{BearerToken: testToken},
+			Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: downloads,
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	// an attachment since deleted from AirTable, left behind by an earlier backup
+	orphan := "attBBBBBBBBBBBBBB"
+	if err := os.WriteFile(path.Join(downloads, orphan), []byte("goodbye"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := LoadChecksums(downloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums[orphan] = strings.Repeat("0", 64)
+	if err := checksums.Save(downloads); err != nil {
+		t.Fatal(err)
+	}
+	report, err := CollectGarbage(context.Background(), opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0] != orphan || report.RemovedBytes != 7 || report.Kept != 1 {
+		t.Errorf("unexpected dry-run report: %+v", report)
+	}
+	if _, err := os.Stat(path.Join(downloads, orphan)); err != nil {
+		t.Errorf("a dry run should not remove anything: %v", err)
+	}
+	if _, err := CollectGarbage(context.Background(), opts, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(downloads, orphan)); !os.IsNotExist(err) {
+		t.Errorf("the unreferenced attachment should have been removed: %v", err)
+	}
+	if err := VerifyDownloads(downloads, false, &strings.Builder{}); err != nil {
+		t.Errorf("the removed attachment should have been dropped from the checksums: %v", err)
+	}
+	if _, err := os.Stat(path.Join(downloads, "attAAAAAAAAAAAAAA")); err != nil {
+		t.Errorf("the referenced attachment should have been kept: %v", err)
+	}
+	// with a retention policy, the catalog decides which backups are kept, and an empty one keeps nothing
+	opts.Config.Retain = RetentionPolicy{Daily: 7}
+	opts.OutputPath = path.Join(dir, "snapshots", "backup.json")
+	if _, err := CollectGarbage(context.Background(), opts, false); err == nil ||
+		!strings.Contains(err.Error(), "no backups are recorded") {
+		t.Errorf("expected garbage collection without any backups to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// BackupFormatVersion is the version of the backup format written by this version of the tool. Backups written
+// before the format was versioned have no version at all.
+const BackupFormatVersion = 1
+
+type BackupMetadata struct {
+	// FormatVersion is the version of the format the backup was written in, and ToolVersion the version of the tool
+	// that wrote it.
+	FormatVersion int    `json:"format-version,omitempty"`
+	ToolVersion   string `json:"tool-version,omitempty"`
+	// Started and Finished are when the backup began listing tables and when it finished downloading attachments.
+	// They are left out of backups written with canonical-output.
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Records counts the records in each table.
+	Records map[string]int `json:"records,omitempty"`
+	// ContentHash is the SHA-256 of the backup's canonical form; see Backup.ContentHash.
+	ContentHash string `json:"content-hash,omitempty"`
+	// FailedTables holds the error of each table that could not be backed up, with keep-going.
+	FailedTables map[string]string `json:"failed-tables,omitempty"`
+}
+
+// newMetadata describes a backup of the given tables, written by this version of the tool.
+func newMetadata(tables map[string][]api.Record) *BackupMetadata {
+	metadata := &BackupMetadata{
+		FormatVersion: BackupFormatVersion,
+		ToolVersion:   toolVersion(),
+		Records:       map[string]int{},
+	}
+	for table, records := range tables {
+		metadata.Records[table] = len(records)
+	}
+	return metadata
+}
+
+// toolVersion returns the version of the module that the running binary was built with, if it is known.
+func toolVersion() string {
+	return api.ModuleVersion()
+}
+
+// check confirms that a loaded backup is in a format this version of the tool understands, and that its tables have
+// neither lost records nor been changed since it was written.
+func (b *Backup) check() error {
+	if b.Metadata == nil {
+		return nil
+	}
+	if b.Metadata.FormatVersion > BackupFormatVersion {
+		return fmt.Errorf("backup is in format version %d, but only versions up to %d can be read; "+
+			"upgrade to a newer version of the tool", b.Metadata.FormatVersion, BackupFormatVersion)
+	}
+	for table, count := range b.Metadata.Records {
+		if actual := len(b.Tables[table]); actual != count {
+			return fmt.Errorf("backup is corrupted: table %s has %d records, but %d were written", table, actual, count)
+		}
+	}
+	if b.Metadata.ContentHash != "" {
+		hash, err := b.ContentHash()
+		if err != nil {
+			return err
+		}
+		if hash != b.Metadata.ContentHash {
+			return fmt.Errorf("backup is corrupted: its content hash is %s, but %s was written", hash,
+				b.Metadata.ContentHash)
+		}
+	}
+	return nil
+}
+
+// canonical returns a copy of the backup's data with every list sorted, so that the same data always serializes to
+// the same bytes. (encoding/json already sorts map keys.) Metadata is left out.
+func (b *Backup) canonical() Backup {
+	canonical := Backup{
+		Config:      map[string][]string{},
+		Tables:      map[string][]api.Record{},
+		Attachments: append([]Attachment(nil), b.Attachments...),
+		// the order of tables, fields, and views in a schema is meaningful, so schemas are kept as they are
+		Schemas: b.Schemas,
+		Views:   b.Views,
+		// comments are kept in the order AirTable lists them, newest first
+		Comments:    b.Comments,
+		Permissions: b.Permissions,
+	}
+	for app, tables := range b.Config {
+		sorted := append([]string(nil), tables...)
+		sort.Strings(sorted)
+		canonical.Config[app] = sorted
+	}
+	for table, records := range b.Tables {
+		sorted := append([]api.Record(nil), records...)
+		if _, fromView := b.Views[table]; fromView {
+			// the records are in the view's order
+			canonical.Tables[table] = sorted
+			continue
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Id < sorted[j].Id
+		})
+		canonical.Tables[table] = sorted
+	}
+	sort.Slice(canonical.Attachments, func(i, j int) bool {
+		a, b := canonical.Attachments[i], canonical.Attachments[j]
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		return a.Link < b.Link
+	})
+	return canonical
+}
+
+// withoutLinks returns a copy of the backup without the expiring links to attachments and their thumbnails that
+// AirTable hands out each time records are listed, so that backups of identical data are identical. Attachments can
+// then only be restored from the downloaded files.
+func (b *Backup) withoutLinks() Backup {
+	stripped := *b
+	stripped.Tables = map[string][]api.Record{}
+	for table, records := range b.Tables {
+		strippedRecords := make([]api.Record, len(records))
+		for i, record := range records {
+			fields := map[string]interface{}{}
+			for name, value := range record.Fields {
+				fields[name] = comparableValue(value)
+			}
+			strippedRecords[i] = api.Record{Id: record.Id, CreatedTime: record.CreatedTime, Fields: fields}
+		}
+		stripped.Tables[table] = strippedRecords
+	}
+	stripped.Attachments = make([]Attachment, len(b.Attachments))
+	for i, attachment := range b.Attachments {
+		attachment.Link = ""
+		stripped.Attachments[i] = attachment
+	}
+	return stripped
+}
+
+// ContentHash hashes the data in the backup, independent of the order in which tables, records, and attachments
+// happened to be listed. Two backups of identical data have identical hashes.
+func (b *Backup) ContentHash() (string, error) {
+	canonical := b.canonical()
+	data, err := json.Marshal(&canonical)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestContentHash(t *testing.T) {
+	makeBackup := func(reversed bool) *Backup {
+		records := []api.Record{
+			{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "a", "N": 1.0}},
+			{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "b"}},
+		}
+		tables := []string{"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}
+		if reversed {
+			records[0], records[1] = records[1], records[0]
+			tables[0], tables[1] = tables[1], tables[0]
+		}
+		return &Backup{
+			Config: map[string][]string{"appAAAAAAAAAAAAAA": tables},
+			Tables: map[string][]api.Record{"tblAAAAAAAAAAAAAA": records, "tblBBBBBBBBBBBBBB": nil},
+		}
+	}
+	first, err := makeBackup(false).ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered := makeBackup(true)
+	second, err := reordered.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("equal data should hash equally: %s vs %s", first, second)
+	}
+	if reordered.Tables["tblAAAAAAAAAAAAAA"][0].Id != "recBBBBBBBBBBBBBB" {
+		t.Error("hashing should not reorder the backup itself")
+	}
+	reordered.Metadata = &BackupMetadata{ContentHash: second}
+	if withMetadata, err := reordered.ContentHash(); err != nil || withMetadata != first {
+		t.Errorf("metadata should not affect the hash: %s, %v", withMetadata, err)
+	}
+	reordered.Tables["tblAAAAAAAAAAAAAA"][1].Fields["N"] = 2.0
+	changed, err := reordered.ContentHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first {
+		t.Error("changing a field value should change the hash")
+	}
+}
+
+func TestCanonicalOutputIsByteIdentical(t *testing.T) {
+	makeBackup := func(reversed bool, link string) *Backup {
+		files := []interface{}{map[string]interface{}{
+			"id": "attAAAAAAAAAAAAAA", "url": link, "size": 11.0, "filename": "a.txt",
+			"thumbnails": map[string]interface{}{"small": map[string]interface{}{"url": link + "/small"}},
+		}}
+		records := []api.Record{
+			{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "a", "Files": files}},
+			{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "b"}},
+		}
+		if reversed {
+			records[0], records[1] = records[1], records[0]
+		}
+		backup := &Backup{
+			Config:      map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			Tables:      map[string][]api.Record{"tblAAAAAAAAAAAAAA": records},
+			Attachments: []Attachment{{Id: "attAAAAAAAAAAAAAA", Link: link, Size: 11}},
+		}
+		stripped := backup.withoutLinks()
+		return &stripped
+	}
+	dir := t.TempDir()
+	first, second := path.Join(dir, "first.json"), path.Join(dir, "second.json")
+	if err := makeBackup(false, "https://example.com/1").Save(first, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeBackup(true, "https://example.com/2").Save(second, nil); err != nil {
+		t.Fatal(err)
+	}
+	firstData, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondData, err := os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstData, secondData) {
+		t.Errorf("backups of the same data should be identical:\n%s\n%s", firstData, secondData)
+	}
+	if bytes.Contains(firstData, []byte("example.com")) {
+		t.Errorf("links should have been left out:\n%s", firstData)
+	}
+}
+
+func TestLoadDetectsCorruptionAndNewerFormats(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "a"}}]}`))
+	})
+	dir := t.TempDir()
+	backupPath := path.Join(dir, "backup.json")
+	err := Run(context.Background(), Options{
+		Config: Config{
+			Config:     api.Config{BearerToken: testToken},
+			Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   backupPath,
+		DownloadPath: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Load(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := backup.Metadata
+	if metadata.FormatVersion != BackupFormatVersion || metadata.ToolVersion == "" || metadata.Started == nil ||
+		metadata.Finished == nil || metadata.Records["tblAAAAAAAAAAAAAA"] != 1 {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	original, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for change, expected := range map[[2]string]string{
+		{`"Name": "a"`, `"Name": "b"`}:                       "content hash",
+		{`"format-version": 1`, `"format-version": 2`}:       "format version 2",
+		{`"tblAAAAAAAAAAAAAA": 1`, `"tblAAAAAAAAAAAAAA": 2`}: "has 1 records, but 2 were written",
+	} {
+		if err := os.WriteFile(backupPath, bytes.Replace(original, []byte(change[0]), []byte(change[1]), 1),
+			0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(backupPath); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected an error about the %s, got %v", expected, err)
+		}
+	}
+}
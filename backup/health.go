@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+const DefaultReadyMaxAge = 25 * time.Hour
+
+// HealthServer tracks the outcome of backup runs and reports it over HTTP: /healthz answers whenever the process is
+// alive, /readyz only when the last successful backup is recent enough, and /metrics exposes the run history in
+// the Prometheus text format, along with any Metrics.
+type HealthServer struct {
+	MaxAge time.Duration
+	Clock  clock.Clock
+	// Metrics, if not nil, adds the statistics of the backup runs to /metrics.
+	Metrics *Metrics
+
+	mu          sync.Mutex
+	started     time.Time
+	lastSuccess time.Time
+	runs        int
+	failures    int
+}
+
+func NewHealthServer(maxAge time.Duration, c clock.Clock) *HealthServer {
+	c = clock.Or(c)
+	return &HealthServer{
+		MaxAge:  maxAge,
+		Clock:   c,
+		started: c.Now(),
+	}
+}
+
+func (h *HealthServer) RecordRun(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs++
+	if err != nil {
+		h.failures++
+	} else {
+		h.lastSuccess = h.Clock.Now()
+	}
+}
+
+func (h *HealthServer) serveHealth(w http.ResponseWriter, _ *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+func (h *HealthServer) serveReady(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	lastSuccess := h.lastSuccess
+	h.mu.Unlock()
+	if lastSuccess.IsZero() {
+		http.Error(w, "no successful backup yet", http.StatusServiceUnavailable)
+		return
+	}
+	if age := h.Clock.Now().Sub(lastSuccess); age > h.MaxAge {
+		http.Error(w, fmt.Sprintf("last successful backup is stale (%s old)", age.Round(time.Second)),
+			http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+func (h *HealthServer) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var lastSuccess float64
+	if !h.lastSuccess.IsZero() {
+		lastSuccess = float64(h.lastSuccess.UnixNano()) / 1e9
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_runs_total counter\nvacuum_table_runs_total %d\n", h.runs)
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_failed_runs_total counter\nvacuum_table_failed_runs_total %d\n", h.failures)
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_last_success_timestamp_seconds gauge\n"+
+		"vacuum_table_last_success_timestamp_seconds %.3f\n", lastSuccess)
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_start_timestamp_seconds gauge\n"+
+		"vacuum_table_start_timestamp_seconds %.3f\n", float64(h.started.UnixNano())/1e9)
+	if h.Metrics != nil {
+		h.Metrics.Print(w)
+	}
+}
+
+func (h *HealthServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.serveHealth)
+	mux.HandleFunc("/readyz", h.serveReady)
+	mux.HandleFunc("/metrics", h.serveMetrics)
+	return mux
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestHealthServer(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	health := NewHealthServer(time.Hour, fakeClock)
+	server := httptest.NewServer(health.Handler())
+	defer server.Close()
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz: expected 200, got %d", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz before any backup: expected 503, got %d", code)
+	}
+	health.RecordRun(errors.New("failed"))
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz after failed backup: expected 503, got %d", code)
+	}
+	health.RecordRun(nil)
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("readyz after fresh backup: expected 200, got %d", code)
+	}
+	fakeClock.Advance(2 * time.Hour)
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz after stale backup: expected 503, got %d", code)
+	}
+	code, body := get("/metrics")
+	if code != http.StatusOK {
+		t.Errorf("metrics: expected 200, got %d", code)
+	}
+	if !strings.Contains(body, "vacuum_table_runs_total 2\n") || !strings.Contains(body, "vacuum_table_failed_runs_total 1\n") {
+		t.Errorf("unexpected metrics: %s", body)
+	}
+}
@@ -0,0 +1,213 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// Kinds of HistoryEvent.
+const (
+	HistoryAdded    = "added"
+	HistoryRemoved  = "removed"
+	HistoryModified = "modified"
+)
+
+// HistoryEvent is one change to a record, found by comparing two successive snapshots in a catalog. The change
+// happened at some point after Previous, when the earlier snapshot was taken, and no later than Time.
+type HistoryEvent struct {
+	Time      time.Time `json:"time"`
+	Previous  time.Time `json:"previous"`
+	Table     string    `json:"table"`
+	TableName string    `json:"table-name,omitempty"`
+	Record    string    `json:"record"`
+	Kind      string    `json:"kind"`
+	// Field is the field that changed, for HistoryModified; there is one event for each field that changed.
+	Field string `json:"field,omitempty"`
+	// Old and New are the field's values before and after, or the whole record's fields for HistoryRemoved and
+	// HistoryAdded. A nil value means that the field was empty.
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// History is a change log of the records in a directory of snapshots, oldest first.
+type History []HistoryEvent
+
+// HistoryFilter selects the events of a History. Empty fields select everything.
+type HistoryFilter struct {
+	// Table selects a table by ID or by name.
+	Table  string
+	Record string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f HistoryFilter) includesTime(t time.Time) bool {
+	return inTimeRange(t, f.Since, f.Until)
+}
+
+func (f HistoryFilter) includesTable(table, name string) bool {
+	return f.Table == "" || f.Table == table || f.Table == name
+}
+
+// BuildHistory compares each snapshot in the catalog of dir with the one before it, and lists the changes selected by
+// filter. Only the snapshots needed to cover the filter's time range are loaded, two at a time.
+func BuildHistory(dir string, key EncryptionKey, filter HistoryFilter) (History, error) {
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(catalog.Backups) == 0 {
+		return nil, fmt.Errorf("no backups are recorded in the catalog of %q", dir)
+	}
+	history := History{}
+	var previous *Backup
+	for i, entry := range catalog.Backups {
+		if i == 0 || !filter.includesTime(entry.Timestamp) {
+			previous = nil
+			continue
+		}
+		if previous == nil {
+			if previous, err = LoadWithKey(path.Join(dir, catalog.Backups[i-1].Path), key); err != nil {
+				return nil, err
+			}
+		}
+		current, err := LoadWithKey(path.Join(dir, entry.Path), key)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, historyBetween(previous, current, catalog.Backups[i-1].Timestamp, entry.Timestamp,
+			filter)...)
+		previous = current
+	}
+	return history, nil
+}
+
+// historyBetween lists the changes between two successive snapshots, in the order of their tables' IDs.
+func historyBetween(before, after *Backup, then, now time.Time, filter HistoryFilter) History {
+	names := after.tableNames()
+	for table, name := range before.tableNames() {
+		if _, found := names[table]; !found {
+			names[table] = name
+		}
+	}
+	var events History
+	diff := DiffBackups(before, after)
+	tables := make([]string, 0, len(diff))
+	for table := range diff {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		if !filter.includesTable(table, names[table]) {
+			continue
+		}
+		tableDiff := diff[table]
+		event := func(record, kind string) HistoryEvent {
+			return HistoryEvent{Time: now, Previous: then, Table: table, TableName: names[table], Record: record,
+				Kind: kind}
+		}
+		included := func(record string) bool {
+			return filter.Record == "" || filter.Record == record
+		}
+		var added, removed map[string]map[string]interface{}
+		if len(tableDiff.Added) > 0 {
+			added = fieldsById(after.Tables[table])
+		}
+		if len(tableDiff.Removed) > 0 {
+			removed = fieldsById(before.Tables[table])
+		}
+		for _, record := range tableDiff.Added {
+			if included(record) {
+				addedEvent := event(record, HistoryAdded)
+				addedEvent.New = added[record]
+				events = append(events, addedEvent)
+			}
+		}
+		for _, record := range tableDiff.Removed {
+			if included(record) {
+				removedEvent := event(record, HistoryRemoved)
+				removedEvent.Old = removed[record]
+				events = append(events, removedEvent)
+			}
+		}
+		for _, record := range tableDiff.Modified {
+			if !included(record.Id) {
+				continue
+			}
+			for _, change := range record.Changes {
+				modified := event(record.Id, HistoryModified)
+				modified.Field, modified.Old, modified.New = change.Field, change.Old, change.New
+				events = append(events, modified)
+			}
+		}
+	}
+	return events
+}
+
+// fieldsById indexes the fields of records by their IDs.
+func fieldsById(records []api.Record) map[string]map[string]interface{} {
+	fields := make(map[string]map[string]interface{}, len(records))
+	for _, record := range records {
+		fields[record.Id] = record.Fields
+	}
+	return fields
+}
+
+// Print writes one line for each event, such as "2024-01-02T03:04:05Z  Tasks (tblX)  recY  Name: "a" -> "b"".
+func (h History) Print(w io.Writer) {
+	if len(h) == 0 {
+		_, _ = fmt.Fprintln(w, "No changes.")
+		return
+	}
+	for _, event := range h {
+		table := event.Table
+		if event.TableName != "" {
+			table = event.TableName + " (" + event.Table + ")"
+		}
+		change := event.Kind
+		if event.Kind == HistoryModified {
+			change = fmt.Sprintf("%s: %s -> %s", event.Field, formatValue(event.Old), formatValue(event.New))
+		}
+		_, _ = fmt.Fprintf(w, "%s  %s  %s  %s\n", event.Time.Format(time.RFC3339), table, event.Record, change)
+	}
+}
+
+// PrintJSON writes each event as a line of JSON.
+func (h History) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range h {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseTime parses one end of a time range, either as a time in RFC 3339 format or as a date, or as a duration
+// before now, such as "36h" or "7d".
+func ParseTime(spec string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", spec); err == nil {
+		return t, nil
+	}
+	if days, found := strings.CutSuffix(spec, "d"); found {
+		if count, err := strconv.Atoi(days); err == nil && count >= 0 {
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if duration, err := time.ParseDuration(spec); err == nil && duration >= 0 {
+		return now.Add(-duration), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a time like 2006-01-02T15:04:05Z, a date like "+
+		"2006-01-02, or a duration before now like 36h or 7d", spec)
+}
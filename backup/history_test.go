@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestBuildHistory(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := []map[string]string{
+		{"recAAAAAAAAAAAAAA": "a", "recBBBBBBBBBBBBBB": "b"},
+		{"recAAAAAAAAAAAAAA": "a2", "recBBBBBBBBBBBBBB": "b"},
+		{"recAAAAAAAAAAAAAA": "a2", "recCCCCCCCCCCCCCC": "c"},
+	}
+	for i, names := range snapshots {
+		backup := &Backup{Tables: map[string][]api.Record{"tblAAAAAAAAAAAAAA": nil}}
+		for id, name := range names {
+			backup.Tables["tblAAAAAAAAAAAAAA"] = append(backup.Tables["tblAAAAAAAAAAAAAA"],
+				api.Record{Id: id, Fields: map[string]interface{}{"Name": name}})
+		}
+		timestamp := start.AddDate(0, 0, 7*i)
+		output := path.Join(dir, snapshotName("backup.json", timestamp))
+		if err := backup.Save(output, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := AppendToCatalog(output, backup, timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := BuildHistory(dir, nil, HistoryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected three changes, got %+v", history)
+	}
+	modified := history[0]
+	if modified.Kind != HistoryModified || modified.Field != "Name" || modified.Old != "a" || modified.New != "a2" ||
+		!modified.Time.Equal(start.AddDate(0, 0, 7)) || !modified.Previous.Equal(start) {
+		t.Errorf("expected the first change to be the rename, got %+v", modified)
+	}
+	if history[1].Kind != HistoryAdded || history[1].Record != "recCCCCCCCCCCCCCC" ||
+		history[2].Kind != HistoryRemoved || history[2].Record != "recBBBBBBBBBBBBBB" {
+		t.Errorf("expected the last snapshot to add and remove records, got %+v", history[1:])
+	}
+
+	lastWeek, err := ParseTime("7d", start.AddDate(0, 0, 14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err = BuildHistory(dir, nil, HistoryFilter{Table: "tblAAAAAAAAAAAAAA", Since: lastWeek.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Kind != HistoryAdded {
+		t.Errorf("expected only the changes of the last week, got %+v", history)
+	}
+	history, err = BuildHistory(dir, nil, HistoryFilter{Record: "recAAAAAAAAAAAAAA", Table: "tblBBBBBBBBBBBBBB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Errorf("expected no changes in another table, got %+v", history)
+	}
+}
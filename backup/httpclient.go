@@ -0,0 +1,247 @@
+package backup
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// Defaults for HTTPSettings. There is no default limit on the time of a whole request, since a large attachment can
+// take as long as it takes; a response that stops arriving is instead cut off by the read timeout, and a connection
+// whose server has gone away is found by its keep-alive probes.
+const (
+	DefaultConnectTimeout        = 30 * time.Second
+	DefaultResponseHeaderTimeout = 2 * time.Minute
+	DefaultReadTimeout           = 2 * time.Minute
+	DefaultKeepAlive             = 30 * time.Second
+	DefaultMaxIdleConnections    = 16
+)
+
+// HTTPSettings configure the HTTP client that is used for every request to AirTable and its attachment hosts, when
+// none is given: its timeouts, and, for networks that require a proxy or intercept TLS, its proxy and certificates.
+type HTTPSettings struct {
+	// Proxy is the URL of an http, https, or socks5 proxy; empty means the proxy given by $HTTPS_PROXY, $HTTP_PROXY,
+	// and $NO_PROXY, if any.
+	Proxy string `json:"proxy,omitempty"`
+	// CABundle is a file of PEM certificates to trust in addition to the system's, such as the certificate of a proxy
+	// that intercepts TLS.
+	CABundle string `json:"ca-bundle,omitempty"`
+	// TLSMinVersion is the oldest version of TLS to accept, "1.2" or "1.3"; empty means 1.2.
+	TLSMinVersion string `json:"tls-min-version,omitempty"`
+	// Timeout limits each whole request, including reading its response; zero means no limit.
+	Timeout Duration `json:"timeout,omitempty"`
+	// ConnectTimeout limits the time to connect to a server; zero means DefaultConnectTimeout.
+	ConnectTimeout Duration `json:"connect-timeout,omitempty"`
+	// ResponseHeaderTimeout limits the time from sending a request to receiving its response's headers; zero means
+	// DefaultResponseHeaderTimeout.
+	ResponseHeaderTimeout Duration `json:"response-header-timeout,omitempty"`
+	// ReadTimeout limits how long reading a response's body may wait for more of it to arrive, which catches a server
+	// that is still connected but has stopped sending; zero means DefaultReadTimeout.
+	ReadTimeout Duration `json:"read-timeout,omitempty"`
+	// KeepAlive is the interval between TCP keep-alive probes, which close a connection whose server has gone away;
+	// zero means DefaultKeepAlive.
+	KeepAlive Duration `json:"keep-alive,omitempty"`
+	// MaxIdleConnections is how many idle connections to keep open to each host for later requests; zero means
+	// DefaultMaxIdleConnections.
+	MaxIdleConnections int `json:"max-idle-connections,omitempty"`
+	// UserAgent is sent with every request; empty means api.DefaultUserAgent.
+	UserAgent string `json:"user-agent,omitempty"`
+	// OnRequest and Trace, if not nil, instrument each request, for programs embedding the backup; see
+	// api.ClientOptions.
+	OnRequest api.RequestHook                                `json:"-"`
+	Trace     func(req *http.Request) *httptrace.ClientTrace `json:"-"`
+}
+
+// orDefault returns d, or fallback if d is zero.
+func orDefault(d Duration, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+	return time.Duration(d)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (s HTTPSettings) proxyURL() (*url.URL, error) {
+	proxy, err := url.Parse(s.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http proxy: %w", err)
+	}
+	if (proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") || proxy.Host == "" {
+		return nil, fmt.Errorf("invalid http proxy %q: expected a URL like http://proxy.example.com:3128", s.Proxy)
+	}
+	return proxy, nil
+}
+
+func (s HTTPSettings) rootCAs() (*x509.CertPool, error) {
+	pem, err := os.ReadFile(s.CABundle)
+	if err != nil {
+		return nil, fmt.Errorf("reading http ca-bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in http ca-bundle %q", s.CABundle)
+	}
+	return pool, nil
+}
+
+// validate checks the settings by building a transport with them, which also reads CABundle.
+func (s HTTPSettings) validate() error {
+	_, err := s.transport()
+	return err
+}
+
+// recordHTTPDir, if not empty, is where the clients from HTTPSettings record their requests; see RecordHTTP.
+var recordHTTPDir string
+
+// RecordHTTP makes the clients that HTTPSettings return from now on save every request and response in dir, without
+// their credentials, for debugging and for test fixtures; see api.NewRecordingTransport. An empty dir stops recording.
+func RecordHTTP(dir string) {
+	recordHTTPDir = dir
+}
+
+// Client returns a new HTTP client with these settings, and the defaults for those left out.
+func (s HTTPSettings) Client() (*http.Client, error) {
+	base, err := s.transport()
+	if err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper = &readTimeoutTransport{base: base,
+		timeout: orDefault(s.ReadTimeout, DefaultReadTimeout)}
+	if recordHTTPDir != "" {
+		if transport, err = api.NewRecordingTransport(transport, recordHTTPDir); err != nil {
+			return nil, err
+		}
+	}
+	userAgent := s.UserAgent
+	if userAgent == "" {
+		userAgent = api.DefaultUserAgent()
+	}
+	client := &http.Client{Transport: transport, Timeout: time.Duration(s.Timeout)}
+	return api.WithClientOptions(client, api.ClientOptions{UserAgent: userAgent, OnRequest: s.OnRequest,
+		Trace: s.Trace}), nil
+}
+
+func (s HTTPSettings) transport() (*http.Transport, error) {
+	if s.MaxIdleConnections < 0 {
+		return nil, fmt.Errorf("invalid http max-idle-connections: %d", s.MaxIdleConnections)
+	}
+	for name, d := range map[string]Duration{
+		"timeout":                 s.Timeout,
+		"connect-timeout":         s.ConnectTimeout,
+		"response-header-timeout": s.ResponseHeaderTimeout,
+		"read-timeout":            s.ReadTimeout,
+		"keep-alive":              s.KeepAlive,
+	} {
+		if d < 0 {
+			return nil, fmt.Errorf("invalid http %s: %s", name, time.Duration(d))
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   orDefault(s.ConnectTimeout, DefaultConnectTimeout),
+		KeepAlive: orDefault(s.KeepAlive, DefaultKeepAlive),
+	}
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = orDefault(s.ResponseHeaderTimeout, DefaultResponseHeaderTimeout)
+	transport.MaxIdleConnsPerHost = DefaultMaxIdleConnections
+	if s.MaxIdleConnections > 0 {
+		transport.MaxIdleConnsPerHost = s.MaxIdleConnections
+	}
+	if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	if s.Proxy != "" {
+		proxy, err := s.proxyURL()
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	minVersion, found := tlsVersions[s.TLSMinVersion]
+	if !found && s.TLSMinVersion != "" {
+		return nil, fmt.Errorf("invalid http tls-min-version %q: expected 1.2 or 1.3", s.TLSMinVersion)
+	} else if !found {
+		minVersion = tls.VersionTLS12
+	}
+	transport.TLSClientConfig = &tls.Config{MinVersion: minVersion}
+	if s.CABundle != "" {
+		var err error
+		if transport.TLSClientConfig.RootCAs, err = s.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	return transport, nil
+}
+
+// errReadTimeout reports that a response's body stopped arriving.
+var errReadTimeout = errors.New("timed out waiting for the response body")
+
+// readTimeoutTransport cancels each request whose response body does not receive anything for timeout while it is
+// being read. Time spent between reads, such as while the bandwidth limit holds them back, does not count.
+type readTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *readTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	body := &readTimeoutBody{body: resp.Body, cancel: cancel, timeout: t.timeout}
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+	body.timer.Stop()
+	resp.Body = body
+	return resp, nil
+}
+
+type readTimeoutBody struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func (b *readTimeoutBody) expire() {
+	b.expired.Store(true)
+	b.cancel()
+}
+
+func (b *readTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	if err != nil && b.expired.Load() {
+		return n, fmt.Errorf("%w after %s", errReadTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *readTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestHTTPSettingsProxy(t *testing.T) {
+	var proxied, userAgent string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied, userAgent = r.URL.String(), r.UserAgent()
+	}))
+	defer proxy.Close()
+	client, err := HTTPSettings{Proxy: proxy.URL}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://api.airtable.com/v0/meta/bases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if proxied != "http://api.airtable.com/v0/meta/bases" {
+		t.Errorf("expected the request to go through the proxy, got %q", proxied)
+	}
+	if userAgent != api.DefaultUserAgent() {
+		t.Errorf("expected the default user agent, got %q", userAgent)
+	}
+}
+
+func TestHTTPSettingsTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	bundle := path.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certificate, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	get := func(settings HTTPSettings) error {
+		client, err := settings.Client()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	if err := get(HTTPSettings{}); err == nil {
+		t.Error("expected the server's certificate to be untrusted without the bundle")
+	}
+	if err := get(HTTPSettings{CABundle: bundle}); err != nil {
+		t.Errorf("expected the bundle to be trusted: %v", err)
+	}
+	if err := get(HTTPSettings{CABundle: bundle, TLSMinVersion: "1.3"}); err == nil {
+		t.Error("expected a server limited to TLS 1.2 to be refused")
+	}
+}
+
+func TestHTTPSettingsTimeouts(t *testing.T) {
+	transport, err := HTTPSettings{}.transport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transport.ResponseHeaderTimeout != DefaultResponseHeaderTimeout ||
+		transport.MaxIdleConnsPerHost != DefaultMaxIdleConnections {
+		t.Errorf("expected the defaults, got a timeout of %s and %d idle connections",
+			transport.ResponseHeaderTimeout, transport.MaxIdleConnsPerHost)
+	}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	client, err := HTTPSettings{ResponseHeaderTimeout: Duration(10 * time.Millisecond)}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(server.URL); err == nil {
+		_ = resp.Body.Close()
+		t.Error("expected a server that never responds to time out")
+	}
+}
+
+func TestHTTPSettingsReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// send part of the body, and then stall
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	client, err := HTTPSettings{ReadTimeout: Duration(50 * time.Millisecond)}.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	// reads held back by the caller do not count against the timeout
+	time.Sleep(100 * time.Millisecond)
+	body, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, errReadTimeout) || string(body) != "partial" {
+		t.Errorf("expected the stalled body to time out after what was sent, got %q: %v", body, err)
+	}
+}
+
+func TestHTTPSettingsValidate(t *testing.T) {
+	for _, settings := range []HTTPSettings{
+		{Proxy: "proxy.example.com:3128"},
+		{Proxy: "ftp://proxy.example.com"},
+		{TLSMinVersion: "1.0"},
+		{CABundle: path.Join(t.TempDir(), "missing.pem")},
+		{MaxIdleConnections: -1},
+		{Timeout: -1},
+		{ConnectTimeout: Duration(-time.Second)},
+		{ReadTimeout: -1},
+		{KeepAlive: -1},
+	} {
+		if err := settings.validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", settings)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// incrementalBase is the previous backup that an incremental run starts from.
+type incrementalBase struct {
+	since  time.Time
+	tables map[string][]api.Record
+}
+
+// loadIncrementalBase finds the most recent backup in the catalog of the Storage that the backup will be written to.
+// It returns nil if there is no earlier backup, in which case a full backup is needed.
+func loadIncrementalBase(ctx context.Context, st Storage, key EncryptionKey) (*incrementalBase, error) {
+	catalog, err := loadCatalog(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	if len(catalog.Backups) == 0 {
+		return nil, nil
+	}
+	latest := catalog.Backups[len(catalog.Backups)-1]
+	backup, err := loadBackup(ctx, st, latest.Path, key)
+	if err != nil {
+		return nil, fmt.Errorf("loading previous backup for incremental mode: %w", err)
+	}
+	return &incrementalBase{since: latest.Timestamp, tables: backup.Tables}, nil
+}
+
+// modifiedSinceFormula matches the records that were created or modified after a point in time.
+func modifiedSinceFormula(since time.Time) string {
+	return fmt.Sprintf("IS_AFTER(LAST_MODIFIED_TIME(), '%s')", since.UTC().Format(time.RFC3339))
+}
+
+// mergeRecords replaces the records in previous with their changed versions, keeping their order, and appends the
+// changed records that are new.
+func mergeRecords(previous, changed []api.Record) []api.Record {
+	byId := map[string]api.Record{}
+	for _, record := range changed {
+		byId[record.Id] = record
+	}
+	merged := make([]api.Record, 0, len(previous)+len(changed))
+	for _, record := range previous {
+		if updated, found := byId[record.Id]; found {
+			record = updated
+			delete(byId, record.Id)
+		}
+		merged = append(merged, record)
+	}
+	for _, record := range changed {
+		if _, found := byId[record.Id]; found {
+			merged = append(merged, record)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestIncrementalMergesChangedRecords(t *testing.T) {
+	var formulas []string
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		formula := r.URL.Query().Get("filterByFormula")
+		formulas = append(formulas, formula)
+		if formula == "" {
+			_, _ = w.Write([]byte(`{"records": [
+				{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "a"}},
+				{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {"Name": "b"}}
+			]}`))
+		} else {
+			_, _ = w.Write([]byte(`{"records": [
+				{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {"Name": "b2"}},
+				{"id": "recCCCCCCCCCCCCCC", "createdTime": "", "fields": {"Name": "c"}}
+			]}`))
+		}
+	})
+	config := Config{
+		Config:      api.Config{BearerToken: testToken, Clock: clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))},
+		Tables:      map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		Incremental: true,
+		SkipSchema:  true,
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"first.json", "second.json"} {
+		opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, name), DownloadPath: downloadDir}
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(formulas) != 2 || formulas[0] != "" ||
+		formulas[1] != "IS_AFTER(LAST_MODIFIED_TIME(), '2023-01-01T00:00:00Z')" {
+		t.Errorf("unexpected formulas: %q", formulas)
+	}
+	backup, err := Load(path.Join(dir, "second.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []interface{}
+	for _, record := range backup.Tables["tblAAAAAAAAAAAAAA"] {
+		names = append(names, record.Fields["Name"])
+	}
+	if len(names) != 3 || names[0] != "a" || names[1] != "b2" || names[2] != "c" {
+		t.Errorf("unexpected merged records: %v", names)
+	}
+}
@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+const (
+	LayoutCombined = "combined"
+	LayoutPerTable = "per-table"
+	// LayoutNDJSON is LayoutPerTable, but with each table written as JSON Lines: one record per line, which streams
+	// into tools like jq and diffs record by record.
+	LayoutNDJSON = "ndjson"
+)
+
+// tableFile returns where a backup saved as name keeps the records of a table, when it is written with
+// LayoutPerTable or LayoutNDJSON: under a directory named after the backup, so that snapshots do not share table
+// files.
+func tableFile(name, app, table, ext string) string {
+	return path.Join(strings.TrimSuffix(name, path.Ext(name))+".tables", app, table+ext)
+}
+
+// savePerTable writes the records of each table into its own file, and then the rest of the backup, with TableFiles
+// pointing at those files, as name. It returns the total size of the files written, and fills in b.TableFiles.
+func (b *Backup) savePerTable(ctx context.Context, st Storage, name string, key EncryptionKey) (int64, error) {
+	return b.saveTableFiles(ctx, st, name, key, ".json", func(file string, records []api.Record) (int64, error) {
+		return putJSON(ctx, st, file, key, records)
+	})
+}
+
+// saveNDJSON is savePerTable, but writes each table as JSON Lines.
+func (b *Backup) saveNDJSON(ctx context.Context, st Storage, name string, key EncryptionKey) (int64, error) {
+	return b.saveTableFiles(ctx, st, name, key, ".jsonl", func(file string, records []api.Record) (int64, error) {
+		return putMaybeEncrypted(ctx, st, file, key, func(w io.Writer) error {
+			// json.Encoder ends each value with a newline, and does not indent it unless asked
+			encoder := json.NewEncoder(w)
+			for _, record := range records {
+				if err := encoder.Encode(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (b *Backup) saveTableFiles(ctx context.Context, st Storage, name string, key EncryptionKey, ext string,
+	put func(file string, records []api.Record) (int64, error)) (int64, error) {
+	manifest := b.canonical()
+	manifest.Metadata = b.Metadata
+	allRecords := manifest.Tables
+	manifest.Tables = nil
+	manifest.TableFiles = map[string]string{}
+	dir := path.Dir(name)
+	var total int64
+	for app, tables := range manifest.Config {
+		for _, table := range tables {
+			records, found := allRecords[table]
+			if !found {
+				continue
+			}
+			file := tableFile(name, app, table, ext)
+			size, err := put(file, records)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+			manifest.TableFiles[table] = strings.TrimPrefix(file, dir+"/")
+		}
+	}
+	size, err := putJSON(ctx, st, name, key, &manifest)
+	if err != nil {
+		return 0, err
+	}
+	b.TableFiles = manifest.TableFiles
+	return total + size, nil
+}
+
+// loadTableFiles reads the records of the tables kept in their own files, for a backup loaded from name.
+func (b *Backup) loadTableFiles(ctx context.Context, st Storage, name string, key EncryptionKey) error {
+	if len(b.TableFiles) == 0 {
+		return nil
+	}
+	if b.Tables == nil {
+		b.Tables = map[string][]api.Record{}
+	}
+	for table, file := range b.TableFiles {
+		file = path.Join(path.Dir(name), file)
+		records, err := loadTableFile(ctx, st, file, key)
+		if err != nil {
+			return err
+		}
+		b.Tables[table] = records
+	}
+	return nil
+}
+
+func loadTableFile(ctx context.Context, st Storage, name string, key EncryptionKey) ([]api.Record, error) {
+	f, err := st.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	plaintext, err := openMaybeEncrypted(f, key)
+	if err != nil {
+		return nil, fmt.Errorf("table file %q: %w", st.Location(name), err)
+	}
+	decoder := json.NewDecoder(plaintext)
+	var records []api.Record
+	if path.Ext(name) != ".jsonl" {
+		if err := decoder.Decode(&records); err != nil {
+			return nil, fmt.Errorf("invalid table file %q: %w", st.Location(name), err)
+		}
+		return records, nil
+	}
+	for {
+		var record api.Record
+		if err := decoder.Decode(&record); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid table file %q, record %d: %w", st.Location(name), len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// files lists the files besides the backup itself that a backup was saved in, relative to it.
+func (b *Backup) files() []string {
+	var files []string
+	for _, file := range b.TableFiles {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
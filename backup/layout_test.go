@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestPerTableLayout(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "x"}}]}`))
+	})
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := Config{
+		Config:     api.Config{BearerToken: testToken, Clock: fakeClock},
+		Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}},
+		SkipSchema: true,
+		Retain:     RetentionPolicy{Last: 1},
+		Layout:     LayoutPerTable,
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, "backup.json"), DownloadPath: downloadDir}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	tableFile := path.Join(dir, "backup-20240101T000000Z.tables", "appAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB.json")
+	if _, err := os.Stat(tableFile); err != nil {
+		t.Errorf("expected a table file: %v", err)
+	}
+	loaded, err := Load(path.Join(dir, "backup-20240101T000000Z.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Tables) != 2 || len(loaded.Tables["tblBBBBBBBBBBBBBB"]) != 1 {
+		t.Errorf("the tables should have been loaded from their files: %v", loaded.Tables)
+	}
+	if hash, err := loaded.ContentHash(); err != nil || hash != loaded.Metadata.ContentHash {
+		t.Errorf("content hash should match after loading: %s %v", hash, err)
+	}
+
+	fakeClock.Advance(time.Hour)
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"backup-20240101T010000Z.json", "backup-20240101T010000Z.tables", CatalogFilename, "dl"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("the pruned snapshot's table files should have been deleted, found %v", names)
+	}
+}
+
+func TestNDJSONLayout(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "x"}},
+			{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {"Name": "y\nz"}}]}`))
+	})
+	config := Config{
+		Config:     api.Config{BearerToken: testToken},
+		Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		SkipSchema: true,
+		Layout:     LayoutNDJSON,
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, "backup.json"), DownloadPath: downloadDir}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path.Join(dir, "backup.tables", "appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":"recAAAAAAAAAAAAAA","createdTime":"","fields":{"Name":"x"}}` + "\n" +
+		`{"id":"recBBBBBBBBBBBBBB","createdTime":"","fields":{"Name":"y\nz"}}` + "\n"
+	if string(data) != expected {
+		t.Errorf("expected one record per line, found:\n%s", data)
+	}
+	loaded, err := Load(path.Join(dir, "backup.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := loaded.Tables["tblAAAAAAAAAAAAAA"]; len(records) != 2 || records[1].Fields["Name"] != "y\nz" {
+		t.Errorf("the records should have been loaded from the table file: %v", records)
+	}
+}
@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose operations log to logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger of a context, or slog.Default() if it has none.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withRunId tags everything logged under the returned context with a new random ID, so that the messages of one
+// backup run can be picked out from those of others.
+func withRunId(ctx context.Context) context.Context {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return WithLogger(ctx, loggerFrom(ctx).With("run", hex.EncodeToString(id)))
+}
@@ -0,0 +1,543 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/apitest"
+)
+
+const testToken = "keyAAAAAAAAAAAAAA"
+
+// redirectTransport sends every request to a test server, regardless of the host it was addressed to.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newAirTableServer(t *testing.T, handler http.HandlerFunc) *http.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: redirectTransport{target: target}}
+}
+
+func TestRunAgainstFakeServer(t *testing.T) {
+	server := apitest.NewServer()
+	defer server.Close()
+	server.Token, server.PageSize = testToken, 2
+	var records []api.Record
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		records = append(records, api.Record{Id: fmt.Sprintf("rec%014d", i), Fields: map[string]interface{}{
+			"Name":  name,
+			"Files": server.AddAttachment(name+".txt", "text/plain", []byte("contents of "+name)),
+		}})
+	}
+	server.AddTable("appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA", "Tasks", records)
+	dir := t.TempDir()
+	opts := Options{
+		Config: Config{
+			Config:               api.Config{BearerToken: testToken},
+			Tables:               map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			ListWorkers:          1,
+			AppRequestsPerSecond: 1000,
+			DownloadOptions:      DownloadOptions{Workers: 2, RequestsPerSecond: 1000},
+		},
+		Client:       server.Client(),
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Load(opts.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.Tables["tblAAAAAAAAAAAAAA"]) != 5 || len(backup.Attachments) != 5 {
+		t.Fatalf("expected every record and attachment, got %d records and %d attachments",
+			len(backup.Tables["tblAAAAAAAAAAAAAA"]), len(backup.Attachments))
+	}
+	if backup.Schemas["appAAAAAAAAAAAAAA"] == nil {
+		t.Error("expected the schema to be backed up")
+	}
+	for _, attachment := range backup.Attachments {
+		data, err := os.ReadFile(path.Join(dir, attachment.DownloadFilename(false)))
+		if err != nil || !strings.HasPrefix(string(data), "contents of ") {
+			t.Errorf("expected attachment %s to be downloaded, got %q: %v", attachment.Id, data, err)
+		}
+	}
+}
+
+func TestPerTableTimeout(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tblSSSSSSSSSSSSSS") {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}}]}`))
+	})
+	config := Config{
+		Config: api.Config{BearerToken: testToken},
+		// the slow table comes first, so that timing out must not stop the fast table from being listed
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblSSSSSSSSSSSSSS", "tblFFFFFFFFFFFFFF"},
+		},
+		TableTimeout:  Duration(time.Minute),
+		TableTimeouts: map[string]Duration{"tblSSSSSSSSSSSSSS": Duration(50 * time.Millisecond)},
+	}
+	tables, err := ExtractAllTables(context.Background(), config, client)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "tblSSSSSSSSSSSSSS") {
+		t.Errorf("error should name the slow table: %v", err)
+	}
+	if len(tables["tblFFFFFFFFFFFFFF"]) != 1 {
+		t.Errorf("fast table should have been listed: %v", tables)
+	}
+	if _, found := tables["tblSSSSSSSSSSSSSS"]; found {
+		t.Error("slow table should not have been listed")
+	}
+}
+
+func TestDownloadRetriesTruncatedBody(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			_, _ = w.Write([]byte("hello"))
+		} else {
+			_, _ = w.Write([]byte("hello world"))
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	if err := DownloadAttachments(context.Background(), []Attachment{attachment}, dir, server.Client(), DownloadOptions{SizeMismatchRetries: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+	data, err := os.ReadFile(path.Join(dir, attachment.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("unexpected content %q", data)
+	}
+	if _, err := os.Stat(path.Join(dir, "TEMP."+attachment.Id)); !os.IsNotExist(err) {
+		t.Error("temporary file should have been removed")
+	}
+}
+
+func TestDownloadPersistentSizeMismatch(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	err := DownloadAttachments(context.Background(), []Attachment{attachment}, dir, server.Client(), DownloadOptions{SizeMismatchRetries: 2})
+	if err == nil || !strings.Contains(err.Error(), "persistent size mismatch") {
+		t.Fatalf("expected a persistent mismatch error, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+	if _, err := os.Stat(path.Join(dir, attachment.Id)); !os.IsNotExist(err) {
+		t.Error("mismatched download should not have been kept")
+	}
+}
+
+func TestSizeMismatchPolicies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	for policy, expected := range map[SizeMismatchPolicy]string{
+		"":                     "hello",
+		SizeMismatchAbort:      "hello",
+		SizeMismatchWarn:       "hello",
+		SizeMismatchRedownload: "hello world",
+	} {
+		dir := t.TempDir()
+		// left truncated by some earlier mishap
+		if err := os.WriteFile(path.Join(dir, attachment.Id), []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pool, err := StartDownloadPool(context.Background(), dir, server.Client(),
+			DownloadOptions{SizeMismatchPolicy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Add(attachment)
+		err = pool.Wait()
+		if aborts := policy == "" || policy == SizeMismatchAbort; aborts != (err != nil) {
+			t.Errorf("policy %q: unexpected result %v", policy, err)
+		}
+		mismatches := pool.SizeMismatches()
+		if len(mismatches) != 1 || mismatches[0].Found != 5 || mismatches[0].Expected != 11 ||
+			mismatches[0].Action != (DownloadOptions{SizeMismatchPolicy: policy}).sizeMismatchPolicy() {
+			t.Errorf("policy %q: unexpected mismatches %+v", policy, mismatches)
+		}
+		if data, err := os.ReadFile(path.Join(dir, attachment.Id)); err != nil || string(data) != expected {
+			t.Errorf("policy %q: expected the file to contain %q, found %q (%v)", policy, expected, data, err)
+		}
+		kept, found := pool.Downloaded(attachment.Id)
+		if policy == SizeMismatchWarn && (!found ||
+			kept.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824") {
+			t.Errorf("policy %q: expected the kept file to be hashed into the manifest, found %+v", policy, kept)
+		}
+	}
+	summary := RunSummary{Success: true, SizeMismatches: []SizeMismatch{
+		{Id: attachment.Id, File: attachment.Id, Found: 5, Expected: 11, Action: SizeMismatchWarn},
+	}}
+	if message := summary.Message(); !strings.Contains(message, "attAAAAAAAAAAAAAA (attAAAAAAAAAAAAAA) had 5 bytes "+
+		"instead of 11: warn") {
+		t.Errorf("the summary should report the mismatch: %s", message)
+	}
+}
+
+func TestDownloadPoolRejectsInvalidAttachmentId(t *testing.T) {
+	for _, contentAddressed := range []bool{false, true} {
+		dir := t.TempDir()
+		pool, err := StartDownloadPool(context.Background(), dir, http.DefaultClient,
+			DownloadOptions{ContentAddressed: contentAddressed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Add(Attachment{Link: DefaultAttachmentPrefixes[0] + "file", Id: "../escape", Size: 11})
+		if err := pool.Wait(); !errors.Is(err, ErrInvalidAttachmentId) {
+			t.Errorf("expected the download to fail with an invalid ID, got %v", err)
+		}
+		if _, found := pool.Downloaded("../escape"); found {
+			t.Error("expected the failed download to be left out of the manifest")
+		}
+	}
+}
+
+func TestDownloadResumesInterruptedTransfer(t *testing.T) {
+	var ranges []string
+	honorRanges := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			_, _ = w.Write([]byte("hello"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if !honorRanges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	if err := DownloadAttachment(context.Background(), attachment, dir, attachment.Id, server.Client(), nil); err == nil {
+		t.Fatal("interrupted download should have failed")
+	}
+	if _, err := os.Stat(path.Join(dir, attachment.Id)); !os.IsNotExist(err) {
+		t.Error("interrupted download should not have been kept")
+	}
+	for _, honor := range []bool{true, false} {
+		tempPath := path.Join(dir, "TEMP."+attachment.Id)
+		if data, err := os.ReadFile(tempPath); err != nil || string(data) != "hello" {
+			t.Fatalf("partial download should have been kept for resuming: %q %v", data, err)
+		}
+		honorRanges = honor
+		if err := DownloadAttachment(context.Background(), attachment, dir, attachment.Id, server.Client(), nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path.Join(dir, attachment.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hello world" {
+			t.Errorf("unexpected content with ranges honored=%v: %q", honor, data)
+		}
+		if err := os.WriteFile(tempPath, []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ranges) != 3 || ranges[1] != "bytes=5-" || ranges[2] != "bytes=5-" {
+		t.Errorf("unexpected Range headers: %q", ranges)
+	}
+}
+
+func TestDownloadChecksStatus(t *testing.T) {
+	var ranges []string
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if status != http.StatusOK && (status != http.StatusRequestedRangeNotSatisfiable || r.Header.Get("Range") != "") {
+			http.Error(w, "hello world", status)
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	tempPath := path.Join(dir, "TEMP.attAAAAAAAAAAAAAA")
+	attachment := Attachment{Link: server.URL + "/file", Id: "attAAAAAAAAAAAAAA", Size: 11}
+	if err := DownloadAttachment(context.Background(), attachment, dir, attachment.Id, server.Client(), nil); err == nil ||
+		!strings.Contains(err.Error(), "HTTP status 404") {
+		t.Errorf("expected the error status to be reported, not %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, attachment.Id)); !os.IsNotExist(err) {
+		t.Error("failed download should not have been kept")
+	}
+	// a partial download that the server will not resume is discarded, and the download starts over
+	if err := os.WriteFile(tempPath, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusRequestedRangeNotSatisfiable
+	ranges = nil
+	if err := DownloadAttachment(context.Background(), attachment, dir, attachment.Id, server.Client(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path.Join(dir, attachment.Id)); err != nil || string(data) != "hello world" {
+		t.Errorf("unexpected content: %q %v", data, err)
+	}
+	if len(ranges) != 2 || ranges[0] != "bytes=5-" || ranges[1] != "" {
+		t.Errorf("unexpected Range headers: %q", ranges)
+	}
+}
+
+func TestExtractAttachmentLenientPrefix(t *testing.T) {
+	item := map[string]interface{}{
+		"id":       "attAAAAAAAAAAAAAA",
+		"url":      "https://v9.example-cdn.com/file",
+		"size":     11.0,
+		"filename": "file.txt",
+	}
+	if found, _, err := ExtractAttachment(item, ExtractOptions{}); !found || !errors.Is(err, ErrUnexpectedPrefix) {
+		t.Errorf("strict mode should reject an unknown prefix: %v %v", found, err)
+	}
+	found, attachment, err := ExtractAttachment(item, ExtractOptions{LenientPrefixes: true})
+	if err != nil || !found || !attachment.UnexpectedPrefix || attachment.Id != "attAAAAAAAAAAAAAA" || attachment.Size != 11 {
+		t.Fatalf("unexpected lenient result: %v %+v %v", found, attachment, err)
+	}
+	if attachment.Link != "https://v9.example-cdn.com/file" {
+		t.Errorf("link should have been preserved, got %q", attachment.Link)
+	}
+	// the unknown host is never contacted
+	dir := t.TempDir()
+	if err := DownloadAttachments(context.Background(), []Attachment{attachment}, dir, &http.Client{Transport: failingTransport{t}}, DownloadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dir, attachment.Id)); !os.IsNotExist(err) {
+		t.Error("attachment with unexpected prefix should not have been downloaded")
+	}
+}
+
+func TestExtractAttachmentPrefixesAndShape(t *testing.T) {
+	item := map[string]interface{}{
+		"id":       "attAAAAAAAAAAAAAA",
+		"url":      "https://dl.airtable.com/.attachments/file",
+		"size":     11.0,
+		"filename": "file.txt",
+	}
+	if found, _, err := ExtractAttachment(item, ExtractOptions{}); !found || err != nil {
+		t.Errorf("older attachment hosts should be accepted by default: %v %v", found, err)
+	}
+	opts := ExtractOptions{AttachmentPrefixes: []string{"https://files.example/"}}
+	if _, _, err := ExtractAttachment(item, opts); !errors.Is(err, ErrUnexpectedPrefix) {
+		t.Errorf("configured prefixes should replace the defaults, got %v", err)
+	}
+	item["url"] = "https://files.example/file"
+	if found, attachment, err := ExtractAttachment(item, opts); !found || err != nil || attachment.UnexpectedPrefix {
+		t.Errorf("configured prefix should be accepted: %v %+v %v", found, attachment, err)
+	}
+	// a button field has a link, but is not an attachment
+	button := map[string]interface{}{"label": "Open", "url": "https://example.com/"}
+	if found, _, err := ExtractAttachment(button, ExtractOptions{}); found || err != nil {
+		t.Errorf("objects without the shape of an attachment should be ignored: %v %v", found, err)
+	}
+	if err := (ExtractOptions{AttachmentPrefixes: []string{"files.example"}}).validate(); err == nil {
+		t.Error("a prefix that is not an https URL should be rejected")
+	}
+}
+
+func TestInvalidAttachmentReport(t *testing.T) {
+	attachment := func(id string, size float64) map[string]interface{} {
+		return map[string]interface{}{"id": id, "url": DefaultAttachmentPrefixes[0] + id, "size": size, "filename": "f"}
+	}
+	tables := map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{
+			"Files": []interface{}{attachment("attAAAAAAAAAAAAAA", 11), attachment("../etc/passwd", 11)},
+			"Other": []interface{}{attachment("attBBBBBBBBBBBBBB", 1.5)},
+		}}},
+	}
+	attachments, report := ExtractAttachments(tables, ExtractOptions{})
+	if len(attachments) != 1 || attachments[0].Id != "attAAAAAAAAAAAAAA" {
+		t.Errorf("the valid attachment should still have been extracted: %v", attachments)
+	}
+	if len(report) != 2 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+	var out strings.Builder
+	if err := report.Check(slog.New(slog.NewTextHandler(&out, nil)), ExtractOptions{}); err == nil {
+		t.Error("invalid attachments should fail the run by default")
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `field=Files error="invalid attachment ID: ../etc/passwd"`) ||
+		!strings.Contains(lines[1], `field=Other error="invalid attachment size: 1.5"`) {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+	out.Reset()
+	report.Print(&out)
+	expected := "Invalid attachment: table tblAAAAAAAAAAAAAA -> record recAAAAAAAAAAAAAA -> field \"Files\": " +
+		"invalid attachment ID: ../etc/passwd\n" +
+		"Invalid attachment: table tblAAAAAAAAAAAAAA -> record recAAAAAAAAAAAAAA -> field \"Other\": " +
+		"invalid attachment size: 1.5\n"
+	if out.String() != expected {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if err := report.Check(discard, ExtractOptions{SkipInvalidAttachments: true}); err != nil {
+		t.Errorf("skipped attachments should not fail the run: %v", err)
+	}
+}
+
+type failingTransport struct {
+	t *testing.T
+}
+
+func (ft failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ft.t.Errorf("unexpected request to %s", req.URL)
+	return nil, errors.New("unexpected request")
+}
+
+func TestSelectApps(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[strings.Split(r.URL.Path, "/")[2]] = true
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	config := Config{
+		Config: api.Config{BearerToken: testToken},
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"},
+			"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+			"appCCCCCCCCCCCCCC": {"tblCCCCCCCCCCCCCC"},
+		},
+	}
+	selected, err := config.SelectApps(ParseList("appAAAAAAAAAAAAAA, appCCCCCCCCCCCCCC,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := ExtractAllTables(context.Background(), selected, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 || len(requested) != 2 || !requested["appAAAAAAAAAAAAAA"] || !requested["appCCCCCCCCCCCCCC"] {
+		t.Errorf("only the selected apps should have been processed: %v", requested)
+	}
+	if len(config.Tables) != 3 {
+		t.Error("the original config should not have been modified")
+	}
+	if _, err := config.SelectApps([]string{"appZZZZZZZZZZZZZZ"}); err == nil {
+		t.Error("selecting an unknown app should fail")
+	}
+}
+
+func TestSelectOnly(t *testing.T) {
+	config := Config{
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "Projects"},
+			"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+			"appCCCCCCCCCCCCCC": {},
+		},
+	}
+	schemas := map[string]*api.BaseSchema{
+		"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblPPPPPPPPPPPPPP", Name: "Projects"}}},
+		"appCCCCCCCCCCCCCC": {Tables: []api.TableSchema{
+			{Id: "tblCCCCCCCCCCCCCC", Name: "Tasks"},
+			{Id: "tblDDDDDDDDDDDDDD", Name: "Projects"},
+		}},
+	}
+	selectOnly := func(filters ...string) (map[string][]string, error) {
+		selected, err := config.SelectOnly(filters)
+		if err != nil {
+			return nil, err
+		}
+		discovered, err := DiscoverTables(context.Background(), selected, nil, schemas)
+		return discovered.Tables, err
+	}
+	selected, err := selectOnly("app:appBBBBBBBBBBBBBB", "table:Projects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"appAAAAAAAAAAAAAA": {"tblPPPPPPPPPPPPPP"},
+		"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+	}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("unexpected selection: %v", selected)
+	}
+	// an app that is selected whole is not narrowed by the table filters, and tables listed by name match their IDs
+	if selected, err = selectOnly("app:appCCCCCCCCCCCCCC", "table:tblPPPPPPPPPPPPPP"); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string][]string{
+		"appAAAAAAAAAAAAAA": {"tblPPPPPPPPPPPPPP"},
+		"appCCCCCCCCCCCCCC": {"tblCCCCCCCCCCCCCC", "tblDDDDDDDDDDDDDD"},
+	}
+	if !reflect.DeepEqual(selected, expected) {
+		t.Errorf("unexpected selection: %v", selected)
+	}
+	// a table not listed anywhere is looked for in the apps that discover their tables
+	if selected, err = selectOnly("table:tblDDDDDDDDDDDDDD"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selected, map[string][]string{"appCCCCCCCCCCCCCC": {"tblDDDDDDDDDDDDDD"}}) {
+		t.Errorf("unexpected selection: %v", selected)
+	}
+	if _, err := selectOnly("table:tblZZZZZZZZZZZZZZ"); err == nil {
+		t.Error("a table that no app has should be rejected")
+	}
+	for _, filters := range [][]string{{"base:appAAAAAAAAAAAAAA"}, {"app:appZZZZZZZZZZZZZZ"}, {"table:"}} {
+		if _, err := config.SelectOnly(filters); err == nil {
+			t.Errorf("filters %v should have been rejected", filters)
+		}
+	}
+	delete(config.Tables, "appCCCCCCCCCCCCCC")
+	if _, err := config.SelectOnly([]string{"table:Missing"}); err == nil {
+		t.Error("a table that no app could have should be rejected")
+	}
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ManifestFilename records every attachment in a download directory, with the file it was saved as and the
+// original filename and content type that AirTable reported for it.
+const ManifestFilename = "manifest.json"
+
+// ContentDirectory holds the attachments of a download directory saved with DownloadOptions.ContentAddressed, each
+// named by its SHA-256 and sharded by the first two digits of it, as in "sha256/ab/abcdef...".
+const ContentDirectory = "sha256"
+
+// maxSanitizedFilename bounds the part of a download filename taken from the original filename.
+const maxSanitizedFilename = 100
+
+// Manifest maps attachment IDs to their details.
+type Manifest map[string]Attachment
+
+func LoadManifest(dir string) (Manifest, error) {
+	return loadManifest(context.Background(), LocalStorage(dir))
+}
+
+func loadManifest(ctx context.Context, st Storage) (Manifest, error) {
+	manifest := Manifest{}
+	f, err := st.Open(ctx, ManifestFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest in %q: %w", st.Location(ManifestFilename), err)
+	}
+	return manifest, nil
+}
+
+// Save replaces the manifest in a directory, by way of a temporary file.
+func (m Manifest) Save(dir string) error {
+	return m.save(context.Background(), LocalStorage(dir))
+}
+
+func (m Manifest) save(ctx context.Context, st Storage) error {
+	_, err := putEncoded(ctx, st, ManifestFilename, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(m)
+	})
+	return err
+}
+
+// sanitizeFilename reduces a filename to ASCII letters, digits, dots, dashes, and underscores, so that it cannot
+// escape the download directory or confuse a shell.
+func sanitizeFilename(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	sanitized := strings.TrimLeft(b.String(), ".")
+	// keep the end, which has the extension
+	if len(sanitized) > maxSanitizedFilename {
+		sanitized = sanitized[len(sanitized)-maxSanitizedFilename:]
+	}
+	return sanitized
+}
+
+func contentFilename(sum string) string {
+	return path.Join(ContentDirectory, sum[:2], sum)
+}
+
+func isContentFilename(filename string) bool {
+	return strings.HasPrefix(filename, ContentDirectory+"/")
+}
+
+// DownloadFilename is the name an attachment is saved under: its ID, followed by its sanitized original filename if
+// named is set.
+func (a Attachment) DownloadFilename(named bool) string {
+	if named {
+		if sanitized := sanitizeFilename(a.Filename); sanitized != "" {
+			return a.Id + "_" + sanitized
+		}
+	}
+	return a.Id
+}
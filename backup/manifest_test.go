@@ -0,0 +1,146 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	for name, expected := range map[string]string{
+		"report.pdf":         "report.pdf",
+		"../../etc/passwd":   "_.._etc_passwd",
+		"Quarterly Plan.xls": "Quarterly_Plan.xls",
+		"résumé.doc":         "r_sum_.doc",
+		"...":                "",
+	} {
+		if sanitized := sanitizeFilename(name); sanitized != expected {
+			t.Errorf("sanitizeFilename(%q) = %q, expected %q", name, sanitized, expected)
+		}
+	}
+}
+
+func TestDownloadNamedFilesWritesManifest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	attachment := Attachment{
+		Link:     server.URL + "/file",
+		Id:       "attAAAAAAAAAAAAAA",
+		Size:     11,
+		Filename: "Meeting Notes.txt",
+		Type:     "text/plain",
+	}
+	opts := DownloadOptions{NamedFiles: true}
+	if err := DownloadAttachments(context.Background(), []Attachment{attachment}, dir, server.Client(), opts); err != nil {
+		t.Fatal(err)
+	}
+	const expectedFile = "attAAAAAAAAAAAAAA_Meeting_Notes.txt"
+	if _, err := os.Stat(path.Join(dir, expectedFile)); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := manifest[attachment.Id]
+	if entry.File != expectedFile || entry.Filename != "Meeting Notes.txt" || entry.Type != "text/plain" {
+		t.Errorf("unexpected manifest entry: %+v", entry)
+	}
+	if entry.SHA256 != "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Errorf("unexpected checksum %q", entry.SHA256)
+	}
+}
+
+func TestContentAddressedDownloadsAreDeduplicated(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	// the same file attached to two records
+	attachments := []Attachment{
+		{Link: server.URL + "/a", Id: "attAAAAAAAAAAAAAA", Size: 11},
+		{Link: server.URL + "/b", Id: "attBBBBBBBBBBBBBB", Size: 11},
+	}
+	opts := DownloadOptions{ContentAddressed: true}
+	if err := DownloadAttachments(context.Background(), attachments, dir, server.Client(), opts); err != nil {
+		t.Fatal(err)
+	}
+	const sum = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	const expectedFile = ContentDirectory + "/b9/" + sum
+	files, err := downloadedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != expectedFile {
+		t.Errorf("expected a single stored copy, found %v", files)
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attachment := range attachments {
+		if entry := manifest[attachment.Id]; entry.File != expectedFile || entry.SHA256 != sum {
+			t.Errorf("unexpected manifest entry: %+v", entry)
+		}
+	}
+	if err := VerifyDownloads(dir, false, &strings.Builder{}); err != nil {
+		t.Errorf("content-addressed directory should verify: %v", err)
+	}
+	// a later snapshot finds both attachments through the manifest
+	if err := DownloadAttachments(context.Background(), attachments, dir, server.Client(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected only the first run to download, but there were %d requests", requests.Load())
+	}
+}
+
+func TestContentAddressedRedownloadKeepsSharedFile(t *testing.T) {
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	attachments := []Attachment{
+		{Link: server.URL + "/a", Id: "attAAAAAAAAAAAAAA", Size: 11},
+		{Link: server.URL + "/b", Id: "attBBBBBBBBBBBBBB", Size: 11},
+	}
+	opts := DownloadOptions{ContentAddressed: true, SizeMismatchPolicy: SizeMismatchRedownload}
+	if err := DownloadAttachments(context.Background(), attachments, dir, server.Client(), opts); err != nil {
+		t.Fatal(err)
+	}
+	shared := path.Join(dir, ContentDirectory, "b9", "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9")
+	if err := os.WriteFile(shared, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	if err := DownloadAttachments(context.Background(), attachments[:1], dir, server.Client(), opts); err == nil {
+		t.Fatal("expected the download to fail")
+	}
+	if _, err := os.Stat(shared); err != nil {
+		t.Errorf("a failed download should not remove the file other attachments share: %v", err)
+	}
+	failing.Store(false)
+	if err := DownloadAttachments(context.Background(), attachments[:1], dir, server.Client(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(shared); err != nil || string(data) != "hello world" {
+		t.Errorf("expected the shared file to be replaced, found %q (%v)", data, err)
+	}
+}
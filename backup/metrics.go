@@ -0,0 +1,186 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request latency histogram.
+var requestDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range requestDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Metrics accumulates statistics across backup runs, for a long-running process to expose in the Prometheus text
+// format. A nil *Metrics records nothing.
+type Metrics struct {
+	mu               sync.Mutex
+	recordsFetched   map[[2]string]int64
+	requestDurations map[string]*histogram
+	retries          int64
+	attachmentBytes  int64
+	lastSuccess      map[string]time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		recordsFetched:   map[[2]string]int64{},
+		requestDurations: map[string]*histogram{},
+		lastSuccess:      map[string]time.Time{},
+	}
+}
+
+func (m *Metrics) recordRecords(app, table string, count int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recordsFetched[[2]string{app, table}] += int64(count)
+}
+
+func (m *Metrics) observeRequest(host string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.requestDurations[host]
+	if h == nil {
+		h = &histogram{counts: make([]int64, len(requestDurationBuckets))}
+		m.requestDurations[host] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func (m *Metrics) recordRetry(error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+func (m *Metrics) recordAttachmentBytes(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attachmentBytes += n
+}
+
+func (m *Metrics) recordSuccess(apps map[string][]string, timestamp time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for app := range apps {
+		m.lastSuccess[app] = timestamp
+	}
+}
+
+// metricLabel quotes a label value as the text format requires.
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Print writes the metrics in the Prometheus text format.
+func (m *Metrics) Print(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_records_fetched_total counter\n")
+	var tables [][2]string
+	for key := range m.recordsFetched {
+		tables = append(tables, key)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i][0] < tables[j][0] || tables[i][0] == tables[j][0] && tables[i][1] < tables[j][1]
+	})
+	for _, key := range tables {
+		_, _ = fmt.Fprintf(w, "vacuum_table_records_fetched_total{base=\"%s\",table=\"%s\"} %d\n",
+			metricLabel(key[0]), metricLabel(key[1]), m.recordsFetched[key])
+	}
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_request_duration_seconds histogram\n")
+	var hosts []string
+	for host := range m.requestDurations {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		h, label := m.requestDurations[host], metricLabel(host)
+		for i, bound := range requestDurationBuckets {
+			_, _ = fmt.Fprintf(w, "vacuum_table_request_duration_seconds_bucket{host=\"%s\",le=\"%g\"} %d\n",
+				label, bound, h.counts[i])
+		}
+		_, _ = fmt.Fprintf(w, "vacuum_table_request_duration_seconds_bucket{host=\"%s\",le=\"+Inf\"} %d\n",
+			label, h.count)
+		_, _ = fmt.Fprintf(w, "vacuum_table_request_duration_seconds_sum{host=\"%s\"} %.6f\n", label, h.sum)
+		_, _ = fmt.Fprintf(w, "vacuum_table_request_duration_seconds_count{host=\"%s\"} %d\n", label, h.count)
+	}
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_request_retries_total counter\nvacuum_table_request_retries_total %d\n",
+		m.retries)
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_attachment_bytes_downloaded_total counter\n"+
+		"vacuum_table_attachment_bytes_downloaded_total %d\n", m.attachmentBytes)
+	_, _ = fmt.Fprintf(w, "# TYPE vacuum_table_base_last_success_timestamp_seconds gauge\n")
+	var apps []string
+	for app := range m.lastSuccess {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		_, _ = fmt.Fprintf(w, "vacuum_table_base_last_success_timestamp_seconds{base=\"%s\"} %.3f\n",
+			metricLabel(app), float64(m.lastSuccess[app].UnixNano())/1e9)
+	}
+}
+
+// instrumentedTransport records the latency of every request it sends, by host.
+type instrumentedTransport struct {
+	base    http.RoundTripper
+	metrics *Metrics
+	clock   clock.Clock
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := t.clock.Now()
+	resp, err := base.RoundTrip(req)
+	t.metrics.observeRequest(req.URL.Host, t.clock.Now().Sub(start))
+	return resp, err
+}
+
+// withMetrics returns a copy of client that records its request latencies in metrics, or client itself if metrics is
+// nil.
+func withMetrics(client *http.Client, metrics *Metrics, c clock.Clock) *http.Client {
+	if metrics == nil {
+		return client
+	}
+	instrumented := *client
+	instrumented.Transport = instrumentedTransport{base: client.Transport, metrics: metrics, clock: clock.Or(c)}
+	return &instrumented
+}
@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestRunRecordsMetrics(t *testing.T) {
+	var listRequests int32
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v0/") {
+			if atomic.AddInt32(&listRequests, 1) == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+				{"id": "attAAAAAAAAAAAAAA", "url": "%sa", "size": 11, "filename": "a"}
+			]}}, {"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {}}]}`, DefaultAttachmentPrefixes[0])
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	})
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := Config{
+		Config:     api.Config{BearerToken: testToken, Retries: 1, Clock: fakeClock},
+		Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		SkipSchema: true,
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	opts := Options{
+		Config:       config,
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: downloadDir,
+		Metrics:      metrics,
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	metrics.Print(&out)
+	for _, line := range []string{
+		`vacuum_table_records_fetched_total{base="appAAAAAAAAAAAAAA",table="tblAAAAAAAAAAAAAA"} 2`,
+		`vacuum_table_request_duration_seconds_count{host="api.airtable.com"} 2`,
+		`vacuum_table_request_retries_total 1`,
+		`vacuum_table_attachment_bytes_downloaded_total 11`,
+		`vacuum_table_base_last_success_timestamp_seconds{base="appAAAAAAAAAAAAAA"} `,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in the metrics:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/hashicorp/go-multierror"
+)
+
+// mirrorDialect is the SQL that mirroring uses for one kind of database.
+type mirrorDialect struct {
+	// driver is the name of the database/sql driver.
+	driver string
+	quote  func(name string) string
+	// placeholder returns the placeholder for the i'th argument of a statement, counting from 0.
+	placeholder func(i int) string
+	// idType is the type of the primary key column, and columnTypes the type of the column for each kind of field,
+	// which is picked the same way as for Parquet: by the backed-up schema, or by the field's values without one.
+	idType      string
+	columnTypes map[string]string
+	// columnsQuery lists the columns of the table named by its one argument.
+	columnsQuery string
+	// upsert returns the statement that inserts a record into a table, or updates it if it is there.
+	upsert func(d *mirrorDialect, table string, columns []parquetColumn) string
+}
+
+// MirrorTarget is a database that tables are mirrored into.
+type MirrorTarget struct {
+	// Driver is MirrorPostgres or MirrorMySQL.
+	Driver string
+	// DSN is the connection string of the database, in the form the driver expects.
+	DSN string
+}
+
+// mirrorDialects holds the dialect of each database that can be mirrored into, by driver name.
+var mirrorDialects = map[string]*mirrorDialect{}
+
+func (t MirrorTarget) dialect() (*mirrorDialect, error) {
+	dialect, found := mirrorDialects[t.Driver]
+	if !found {
+		return nil, fmt.Errorf("unknown mirror database %q", t.Driver)
+	}
+	return dialect, nil
+}
+
+// mirrorValue converts a field value into a column value. Values that do not fit the column's type, such as the
+// errors of formula fields, are left null.
+func mirrorValue(column parquetColumn, value interface{}, present bool) (interface{}, error) {
+	if !present && column.kind == parquetBool {
+		// AirTable leaves unchecked boxes out of records entirely
+		return false, nil
+	}
+	if !present || value == nil {
+		return nil, nil
+	}
+	switch column.kind {
+	case parquetText:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case parquetNumber:
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+	case parquetBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case parquetDate:
+		if s, ok := value.(string); ok {
+			if _, err := time.Parse("2006-01-02", s); err == nil {
+				return s, nil
+			}
+		}
+	case parquetTimestamp:
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t.UTC(), nil
+			}
+		}
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(encoded), nil
+	}
+	return nil, nil
+}
+
+// mirrorRow converts a record into the values of the columns of a mirrored table.
+func mirrorRow(columns []parquetColumn, record api.Record) ([]interface{}, error) {
+	row := make([]interface{}, len(columns))
+	for i, column := range columns {
+		var value interface{}
+		var present bool
+		switch column.name {
+		case "_id":
+			value, present = record.Id, true
+		case "_created_time":
+			value, present = record.CreatedTime, record.CreatedTime != ""
+		default:
+			value, present = record.Fields[column.field]
+		}
+		converted, err := mirrorValue(column, value, present)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = converted
+	}
+	return row, nil
+}
+
+// createTable creates a table, or adds the columns it is missing.
+func (d *mirrorDialect) createTable(ctx context.Context, tx *sql.Tx, table string, columns []parquetColumn) error {
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s PRIMARY KEY)", d.quote(table), d.quote("_id"),
+		d.idType)
+	if _, err := tx.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("creating table %q: %w", table, err)
+	}
+	rows, err := tx.QueryContext(ctx, d.columnsQuery, table)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return multierror.Append(err, rows.Close())
+		}
+		existing[name] = true
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if existing[column.name] {
+			continue
+		}
+		alter := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", d.quote(table), d.quote(column.name),
+			d.columnTypes[column.kind])
+		if _, err := tx.ExecContext(ctx, alter); err != nil {
+			return fmt.Errorf("adding column %q to table %q: %w", column.name, table, err)
+		}
+	}
+	return nil
+}
+
+// deleteRemoved deletes the rows of the records that are no longer in a table.
+func (d *mirrorDialect) deleteRemoved(ctx context.Context, tx *sql.Tx, table string, records []api.Record) error {
+	kept := map[string]bool{}
+	for _, record := range records {
+		kept[record.Id] = true
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", d.quote("_id"), d.quote(table)))
+	if err != nil {
+		return err
+	}
+	var removed []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return multierror.Append(err, rows.Close())
+		}
+		if !kept[id] {
+			removed = append(removed, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for _, id := range removed {
+		remove := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", d.quote(table), d.quote("_id"), d.placeholder(0))
+		if _, err := tx.ExecContext(ctx, remove, id); err != nil {
+			return fmt.Errorf("deleting removed record %s from %q: %w", id, table, err)
+		}
+	}
+	return nil
+}
+
+// mirrorTable upserts every record of a table into the database, and deletes the rows of the records that no longer
+// exist.
+func (d *mirrorDialect) mirrorTable(ctx context.Context, tx *sql.Tx, table string, columns []parquetColumn,
+	records []api.Record) error {
+	// the primary key is created along with the table
+	if err := d.createTable(ctx, tx, table, columns[1:]); err != nil {
+		return err
+	}
+	upsert, err := tx.PrepareContext(ctx, d.upsert(d, table, columns))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = upsert.Close()
+	}()
+	for _, record := range records {
+		row, err := mirrorRow(columns, record)
+		if err != nil {
+			return err
+		}
+		if _, err := upsert.ExecContext(ctx, row...); err != nil {
+			return fmt.Errorf("upserting record %s into %q: %w", record.Id, table, err)
+		}
+	}
+	return d.deleteRemoved(ctx, tx, table, records)
+}
+
+func (d *mirrorDialect) mirror(ctx context.Context, db *sql.DB, backup *Backup) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	dictionary := BuildDataDictionary(backup.Tables)
+	names := backup.tableNames()
+	tables := make([]string, 0, len(backup.Tables))
+	for table := range backup.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		columns := backup.parquetColumns(table, dictionary[table])
+		if err := d.mirrorTable(ctx, tx, names[table], columns, backup.Tables[table]); err != nil {
+			return multierror.Append(err, tx.Rollback())
+		}
+		loggerFrom(ctx).Info("Mirrored table", "table", table, "into", names[table], "records",
+			len(backup.Tables[table]))
+	}
+	return tx.Commit()
+}
+
+// MirrorBackup upserts the records of a backup into a database, with one SQL table for each AirTable table, named like
+// the tables of an export. Each field becomes a column, typed by the backed-up schema, with lists and objects stored as
+// JSON. Columns are added as fields appear, and rows are deleted as their records are, so that mirroring each backup
+// keeps the database up to date. Everything is written in a single transaction, although MySQL commits it early
+// whenever a table or column is created.
+func MirrorBackup(ctx context.Context, target MirrorTarget, backup *Backup) error {
+	dialect, err := target.dialect()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open(dialect.driver, target.DSN)
+	if err != nil {
+		return err
+	}
+	if err := dialect.mirror(ctx, db, backup); err != nil {
+		return multierror.Append(err, db.Close())
+	}
+	return db.Close()
+}
+
+// Mirror lists the configured tables, without downloading their attachments or writing a backup, and mirrors them
+// into a database, as MirrorBackup does.
+func Mirror(ctx context.Context, opts Options, target MirrorTarget) error {
+	if _, err := target.dialect(); err != nil {
+		return err
+	}
+	config, client := opts.Config, opts.Client
+	if client == nil {
+		var err error
+		if client, err = config.HTTP.Client(); err != nil {
+			return err
+		}
+	}
+	var schemas map[string]*api.BaseSchema
+	if !config.SkipSchema {
+		var err error
+		if schemas, err = FetchSchemas(ctx, config, client); err != nil {
+			return err
+		}
+	}
+	config, err := DiscoverTables(ctx, config, client, schemas)
+	if err != nil {
+		return err
+	}
+	tables, err := ExtractAllTables(ctx, config, client)
+	if err != nil {
+		return err
+	}
+	return MirrorBackup(ctx, target, &Backup{Config: config.Tables, Tables: tables, Schemas: schemas})
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestMirrorRows(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z",
+				Fields: map[string]interface{}{"Name": "Widget", "Count": "#ERROR", "Tags": []interface{}{"a"}}}},
+		},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets",
+				Fields: []api.FieldSchema{
+					{Name: "Name", Type: "singleLineText"},
+					{Name: "Count", Type: "number"},
+					{Name: "Done", Type: "checkbox"},
+					{Name: "Tags", Type: "multipleSelects"},
+				}}}},
+		},
+	}
+	columns := backup.parquetColumns("tblAAAAAAAAAAAAAA", BuildDataDictionary(backup.Tables)["tblAAAAAAAAAAAAAA"])
+	expectedTypes := map[string][]string{
+		MirrorPostgres: {"TEXT", "TIMESTAMPTZ", "TEXT", "DOUBLE PRECISION", "BOOLEAN", "JSONB"},
+		MirrorMySQL:    {"LONGTEXT", "DATETIME(3)", "LONGTEXT", "DOUBLE", "BOOLEAN", "JSON"},
+	}
+	for driver, expected := range expectedTypes {
+		var types []string
+		for _, column := range columns {
+			types = append(types, mirrorDialects[driver].columnTypes[column.kind])
+		}
+		if !reflect.DeepEqual(types, expected) {
+			t.Errorf("unexpected %s column types: %v", driver, types)
+		}
+	}
+	row, err := mirrorRow(columns, backup.Tables["tblAAAAAAAAAAAAAA"][0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := []interface{}{"recAAAAAAAAAAAAAA", created, "Widget", nil, false, `["a"]`}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("unexpected row: %v", row)
+	}
+}
+
+func TestMirrorUpserts(t *testing.T) {
+	columns := []parquetColumn{{name: "_id"}, {name: "Name"}, {name: "Odd `name\""}}
+	expected := map[string]string{
+		MirrorPostgres: `INSERT INTO "Widgets" ("_id", "Name", "Odd ` + "`" + `name""") VALUES ($1, $2, $3) ` +
+			`ON CONFLICT ("_id") DO UPDATE SET "Name" = EXCLUDED."Name", "Odd ` + "`" + `name""" = EXCLUDED."Odd ` +
+			"`" + `name"""`,
+		MirrorMySQL: "INSERT INTO `Widgets` (`_id`, `Name`, `Odd ``name\"`) VALUES (?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE `Name` = VALUES(`Name`), `Odd ``name\"` = VALUES(`Odd ``name\"`)",
+	}
+	for driver, statement := range expected {
+		dialect := mirrorDialects[driver]
+		if upsert := dialect.upsert(dialect, "Widgets", columns); upsert != statement {
+			t.Errorf("unexpected %s upsert statement:\n%s\nexpected:\n%s", driver, upsert, statement)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// MirrorMySQL is the driver of MySQL and MariaDB mirror targets. Their connection strings are in the form
+// user:password@tcp(host:3306)/database.
+const MirrorMySQL = "mysql"
+
+func init() {
+	mirrorDialects[MirrorMySQL] = &mirrorDialect{
+		driver: "mysql",
+		quote: func(name string) string {
+			return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+		},
+		placeholder: func(int) string {
+			return "?"
+		},
+		idType: "VARCHAR(64)",
+		columnTypes: map[string]string{
+			// long text fields do not fit in TEXT, which holds at most 64 KiB
+			parquetText:   "LONGTEXT",
+			parquetNumber: "DOUBLE",
+			parquetBool:   "BOOLEAN",
+			parquetDate:   "DATE",
+			// timestamps are written in UTC, with AirTable's millisecond precision
+			parquetTimestamp: "DATETIME(3)",
+			// multiple selects, along with every other list and object
+			parquetJSON: "JSON",
+		},
+		columnsQuery: "SELECT column_name FROM information_schema.columns " +
+			"WHERE table_schema = DATABASE() AND table_name = ?",
+		upsert: mysqlUpsert,
+	}
+}
+
+func mysqlUpsert(d *mirrorDialect, table string, columns []parquetColumn) string {
+	var names, placeholders, updates []string
+	for i, column := range columns {
+		names = append(names, d.quote(column.name))
+		placeholders = append(placeholders, d.placeholder(i))
+		if column.name != "_id" {
+			// VALUES() is deprecated by MySQL in favor of a row alias, which MariaDB does not support
+			updates = append(updates, d.quote(column.name)+" = VALUES("+d.quote(column.name)+")")
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s", d.quote(table),
+		strings.Join(names, ", "), strings.Join(placeholders, ", "), strings.Join(updates, ", "))
+}
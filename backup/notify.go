@@ -0,0 +1,307 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	NotifyAlways  = "always"
+	NotifyFailure = "failure"
+)
+
+// SMTPPasswordEnv names the environment variable that holds the SMTP password when the configuration does not.
+const SMTPPasswordEnv = "VACUUM_TABLE_SMTP_PASSWORD"
+
+const (
+	WebhookGeneric = "generic"
+	WebhookSlack   = "slack"
+	WebhookDiscord = "discord"
+)
+
+// notifyTimeout bounds how long sending each notification may take, which is not cut short when the run itself is
+// cancelled, so that aborted runs are still reported.
+const notifyTimeout = 30 * time.Second
+
+type NotifyOptions struct {
+	// WebhookURL, if set, is sent a POST request with a summary of every run, whether it succeeded or failed.
+	WebhookURL string `json:"notify-webhook,omitempty"`
+	// WebhookFormat is WebhookSlack or WebhookDiscord to send the summary as a chat message, or WebhookGeneric to
+	// send the RunSummary as JSON. If it is empty, it is guessed from the URL.
+	WebhookFormat string `json:"notify-webhook-format,omitempty"`
+	// EmailTo, if not empty, is sent an email from EmailFrom through SMTPServer (a host:port) summarizing each run
+	// that EmailOn selects: NotifyFailure (the default) for only the runs that fail, or NotifyAlways for every run.
+	EmailTo   []string `json:"notify-email-to,omitempty"`
+	EmailFrom string   `json:"notify-email-from,omitempty"`
+	EmailOn   string   `json:"notify-email-on,omitempty"`
+	// SMTPServer is connected to with STARTTLS, if it supports it, and logged into with SMTPUsername, if set, and
+	// SMTPPassword, or else the password from SMTPPasswordEnv.
+	SMTPServer   string `json:"notify-smtp-server,omitempty"`
+	SMTPUsername string `json:"notify-smtp-username,omitempty"`
+	SMTPPassword string `json:"notify-smtp-password,omitempty"`
+
+	// sendMail sends an email; nil means sendMailContext.
+	sendMail func(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// RunSummary describes the outcome of a backup run.
+type RunSummary struct {
+	Output  string `json:"output"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Tables and Records count the tables listed and the records in them, even if the run then failed.
+	Tables  int `json:"tables"`
+	Records int `json:"records"`
+	// BytesDownloaded counts only the attachments downloaded, not those that were already present.
+	BytesDownloaded int64 `json:"bytes-downloaded"`
+	// SizeMismatches lists the already-downloaded attachments found with the wrong size, and what was done about each.
+	SizeMismatches []SizeMismatch `json:"size-mismatches,omitempty"`
+	// FailedTables holds the error of each table that could not be backed up, with keep-going.
+	FailedTables map[string]string `json:"failed-tables,omitempty"`
+	Started      time.Time         `json:"started"`
+	Duration     Duration          `json:"duration"`
+}
+
+func (s *RunSummary) finish(now time.Time, err error) {
+	s.Duration = Duration(now.Sub(s.Started).Round(time.Millisecond))
+	s.Success = err == nil
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Message describes the run in a few lines of text.
+func (s *RunSummary) Message() string {
+	var message string
+	if !s.Success {
+		message = fmt.Sprintf("Backup to %s failed after %s: %s", s.Output, time.Duration(s.Duration), s.Error)
+	} else {
+		message = fmt.Sprintf("Backup to %s succeeded in %s: %d tables, %d records, %s of attachments downloaded",
+			s.Output, time.Duration(s.Duration), s.Tables, s.Records, formatBytes(s.BytesDownloaded))
+	}
+	tables := make([]string, 0, len(s.FailedTables))
+	for table := range s.FailedTables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		message += fmt.Sprintf("\nTable %s was not backed up: %s", table, s.FailedTables[table])
+	}
+	for _, mismatch := range s.SizeMismatches {
+		message += fmt.Sprintf("\nAttachment %s (%s) had %d bytes instead of %d: %s", mismatch.Id, mismatch.File,
+			mismatch.Found, mismatch.Expected, mismatch.Action)
+	}
+	return message
+}
+
+func (o NotifyOptions) validate() error {
+	if o.WebhookURL != "" {
+		if u, err := url.Parse(o.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("invalid notify-webhook: %q", o.WebhookURL)
+		}
+	}
+	switch o.WebhookFormat {
+	case "", WebhookGeneric, WebhookSlack, WebhookDiscord:
+	default:
+		return fmt.Errorf("invalid notify-webhook-format: %q", o.WebhookFormat)
+	}
+	if o.EmailOn != "" && o.EmailOn != NotifyAlways && o.EmailOn != NotifyFailure {
+		return fmt.Errorf("invalid notify-email-on: %q", o.EmailOn)
+	}
+	if len(o.EmailTo) > 0 {
+		if o.EmailFrom == "" || o.SMTPServer == "" {
+			return errors.New("notify-email-to requires notify-email-from and notify-smtp-server")
+		}
+		if _, _, err := net.SplitHostPort(o.SMTPServer); err != nil {
+			return fmt.Errorf("invalid notify-smtp-server: %w", err)
+		}
+		for _, address := range append([]string{o.EmailFrom}, o.EmailTo...) {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return fmt.Errorf("invalid email address %q: %w", address, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (o NotifyOptions) webhookFormat() string {
+	if o.WebhookFormat != "" {
+		return o.WebhookFormat
+	}
+	switch u, _ := url.Parse(o.WebhookURL); {
+	case u == nil:
+		return WebhookGeneric
+	case u.Host == "hooks.slack.com":
+		return WebhookSlack
+	case strings.HasSuffix(u.Host, "discord.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return WebhookDiscord
+	default:
+		return WebhookGeneric
+	}
+}
+
+// send delivers the summary to every configured notification. Failures are logged rather than returned, so that they
+// do not mask the outcome of the run.
+func (o NotifyOptions) send(ctx context.Context, client *http.Client, summary *RunSummary) {
+	if client == nil {
+		client = &http.Client{}
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	if o.WebhookURL != "" {
+		if err := o.postWebhook(ctx, client, summary); err != nil {
+			loggerFrom(ctx).Error("Failed to send webhook notification", "error", err)
+		}
+	}
+	if len(o.EmailTo) > 0 && (o.EmailOn == NotifyAlways || !summary.Success) {
+		if err := o.sendEmail(ctx, summary); err != nil {
+			loggerFrom(ctx).Error("Failed to send email notification", "error", err)
+		}
+	}
+}
+
+func (o NotifyOptions) postWebhook(ctx context.Context, client *http.Client, summary *RunSummary) error {
+	var payload interface{}
+	switch o.webhookFormat() {
+	case WebhookSlack:
+		payload = map[string]string{"text": summary.Message()}
+	case WebhookDiscord:
+		payload = map[string]string{"content": summary.Message()}
+	default:
+		payload = summary
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+func (o NotifyOptions) sendEmail(ctx context.Context, summary *RunSummary) error {
+	var auth smtp.Auth
+	if o.SMTPUsername != "" {
+		password := o.SMTPPassword
+		if password == "" {
+			password = os.Getenv(SMTPPasswordEnv)
+		}
+		host, _, _ := net.SplitHostPort(o.SMTPServer)
+		auth = smtp.PlainAuth("", o.SMTPUsername, password, host)
+	}
+	outcome := "succeeded"
+	if !summary.Success {
+		outcome = "failed"
+	}
+	details, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	_, _ = fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\n", o.EmailFrom, strings.Join(o.EmailTo, ", "))
+	_, _ = fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Backup "+outcome+": "+summary.Output))
+	_, _ = fmt.Fprintf(&msg, "Date: %s\r\n", summary.Started.Add(time.Duration(summary.Duration)).Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n\r\n", summary.Message())
+	_, _ = msg.WriteString(strings.ReplaceAll(string(details), "\n", "\r\n") + "\r\n")
+	send := o.sendMail
+	if send == nil {
+		send = sendMailContext
+	}
+	addresses := make([]string, len(o.EmailTo))
+	for i, to := range o.EmailTo {
+		parsed, _ := mail.ParseAddress(to)
+		addresses[i] = parsed.Address
+	}
+	from, _ := mail.ParseAddress(o.EmailFrom)
+	return send(ctx, o.SMTPServer, auth, from.Address, addresses, msg.Bytes())
+}
+
+// sendMailContext is smtp.SendMail, but bounded by ctx: the connection is dialed with it, and is closed when it is
+// done, so that a stalled server cannot hold up the end of a run.
+func sendMailContext(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+	host, _, _ := net.SplitHostPort(addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP server does not support authentication")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
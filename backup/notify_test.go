@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/smtp"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestWebhookReceivesRunSummary(t *testing.T) {
+	var mu sync.Mutex
+	var summaries []RunSummary
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/notify" {
+			var summary RunSummary
+			if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			summaries = append(summaries, summary)
+			mu.Unlock()
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/tblBBBBBBBBBBBBBB") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}},
+			{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {}}]}`))
+	})
+	dir := t.TempDir()
+	opts := Options{
+		Config: Config{
+			Config:        api.Config{BearerToken: testToken},
+			Tables:        map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			SkipSchema:    true,
+			NotifyOptions: NotifyOptions{WebhookURL: "https://example.com/notify"},
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Config.Tables = map[string][]string{"appAAAAAAAAAAAAAA": {"tblBBBBBBBBBBBBBB"}}
+	if err := Run(context.Background(), opts); err == nil {
+		t.Fatal("expected the second run to fail")
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(summaries))
+	}
+	if s := summaries[0]; !s.Success || s.Tables != 1 || s.Records != 2 || s.Output != path.Join(dir, "backup.json") {
+		t.Errorf("unexpected summary of a successful run: %+v", s)
+	}
+	if s := summaries[1]; s.Success || !strings.Contains(s.Error, "404") {
+		t.Errorf("unexpected summary of a failed run: %+v", s)
+	}
+}
+
+func TestWebhookFormatIsGuessedFromURL(t *testing.T) {
+	for webhook, format := range map[string]string{
+		"https://hooks.slack.com/services/T000/B000/XXXX": WebhookSlack,
+		"https://discord.com/api/webhooks/123/abc":        WebhookDiscord,
+		"https://example.com/hook":                        WebhookGeneric,
+	} {
+		if actual := (NotifyOptions{WebhookURL: webhook}).webhookFormat(); actual != format {
+			t.Errorf("expected %s to be a %s webhook, not %s", webhook, format, actual)
+		}
+	}
+}
+
+func TestEmailIsSentOnFailureByDefault(t *testing.T) {
+	var sent []string
+	opts := NotifyOptions{
+		EmailTo:      []string{"Ops <ops@example.com>"},
+		EmailFrom:    "backups@example.com",
+		SMTPServer:   "smtp.example.com:587",
+		SMTPUsername: "backups",
+		SMTPPassword: "hunter2",
+		sendMail: func(_ context.Context, addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+			if addr != "smtp.example.com:587" || auth == nil || from != "backups@example.com" ||
+				!reflect.DeepEqual(to, []string{"ops@example.com"}) {
+				t.Errorf("unexpected email envelope: %s %v %s %v", addr, auth, from, to)
+			}
+			sent = append(sent, string(msg))
+			return nil
+		},
+	}
+	if err := opts.validate(); err != nil {
+		t.Fatal(err)
+	}
+	summary := &RunSummary{Output: "backup.json"}
+	summary.finish(summary.Started, nil)
+	opts.send(context.Background(), nil, summary)
+	if len(sent) != 0 {
+		t.Fatal("no email should be sent for a successful run")
+	}
+	summary.finish(summary.Started, errors.New("table not found"))
+	opts.send(context.Background(), nil, summary)
+	if len(sent) != 1 || !strings.Contains(sent[0], "Subject: Backup failed: backup.json\r\n") ||
+		!strings.Contains(sent[0], "table not found") {
+		t.Fatalf("expected an email about the failure, got %q", sent)
+	}
+	opts.EmailOn = NotifyAlways
+	summary = &RunSummary{Output: "backup.json"}
+	summary.finish(summary.Started, nil)
+	opts.send(context.Background(), nil, summary)
+	if len(sent) != 2 || !strings.Contains(sent[1], "Subject: Backup succeeded") {
+		t.Errorf("expected an email about the success, got %q", sent)
+	}
+}
+
+func TestStalledSMTPServerTimesOut(t *testing.T) {
+	// accepts connections, but never greets them
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					_ = conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- sendMailContext(ctx, listener.Addr().String(), nil, "backups@example.com",
+			[]string{"ops@example.com"}, []byte("Subject: test\r\n\r\ntest\r\n"))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected sending to a stalled server to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("sending to a stalled server did not time out")
+	}
+}
@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/parquet-go/parquet-go"
+)
+
+// The kinds of Parquet columns that fields are written as.
+const (
+	parquetText      = "text"
+	parquetNumber    = "number"
+	parquetBool      = "bool"
+	parquetDate      = "date"
+	parquetTimestamp = "timestamp"
+	parquetJSON      = "json"
+)
+
+// parquetColumnKind picks the kind of column for a field, by its type in the Metadata API's schema.
+func parquetColumnKind(fieldType string) string {
+	switch fieldType {
+	case "singleLineText", "multilineText", "richText", "email", "url", "phoneNumber", "singleSelect":
+		return parquetText
+	case "number", "percent", "currency", "rating", "duration", "count", "autoNumber":
+		return parquetNumber
+	case "checkbox":
+		return parquetBool
+	case "date":
+		return parquetDate
+	case "dateTime", "createdTime", "lastModifiedTime":
+		return parquetTimestamp
+	default:
+		return parquetJSON
+	}
+}
+
+// inferredColumnKind picks the kind of column for a field that is not in the backed-up schema, by the type inferred
+// from its values.
+func inferredColumnKind(fieldType string) string {
+	switch fieldType {
+	case FieldTypeText:
+		return parquetText
+	case FieldTypeNumber:
+		return parquetNumber
+	case FieldTypeCheckbox:
+		return parquetBool
+	case FieldTypeDate:
+		return parquetDate
+	case FieldTypeDateTime:
+		return parquetTimestamp
+	default:
+		return parquetJSON
+	}
+}
+
+type parquetColumn struct {
+	name  string
+	field string
+	kind  string
+}
+
+func (c parquetColumn) node() parquet.Node {
+	switch c.kind {
+	case parquetText:
+		return parquet.Optional(parquet.String())
+	case parquetNumber:
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case parquetBool:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case parquetDate:
+		return parquet.Optional(parquet.Date())
+	case parquetTimestamp:
+		return parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+	default:
+		return parquet.Optional(parquet.JSON())
+	}
+}
+
+// value converts a field value into a column value. Values that do not fit the column's type, such as the errors of
+// formula fields, are left null.
+func (c parquetColumn) value(value interface{}, present bool) (parquet.Value, error) {
+	if !present && c.kind == parquetBool {
+		// AirTable leaves unchecked boxes out of records entirely
+		return parquet.BooleanValue(false), nil
+	}
+	if !present || value == nil {
+		return parquet.NullValue(), nil
+	}
+	switch c.kind {
+	case parquetText:
+		if s, ok := value.(string); ok {
+			return parquet.ByteArrayValue([]byte(s)), nil
+		}
+	case parquetNumber:
+		if n, ok := value.(float64); ok {
+			return parquet.DoubleValue(n), nil
+		}
+	case parquetBool:
+		if b, ok := value.(bool); ok {
+			return parquet.BooleanValue(b), nil
+		}
+	case parquetDate:
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse("2006-01-02", s); err == nil {
+				return parquet.Int32Value(int32(t.Unix() / (24 * 60 * 60))), nil
+			}
+		}
+	case parquetTimestamp:
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return parquet.Int64Value(t.UnixMilli()), nil
+			}
+		}
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return parquet.Value{}, err
+		}
+		return parquet.ByteArrayValue(encoded), nil
+	}
+	return parquet.NullValue(), nil
+}
+
+// parquetColumns lists the columns of a table: the record's ID and creation time, and then each field, typed by the
+// backed-up schema when there is one.
+func (b *Backup) parquetColumns(table string, inferred []DictionaryField) []parquetColumn {
+	names := uniqueNames{}
+	columns := []parquetColumn{
+		{name: names.assign("_id"), kind: parquetText},
+		{name: names.assign("_created_time"), kind: parquetTimestamp},
+	}
+	known := map[string]bool{}
+	for _, schema := range b.Schemas {
+		for _, tableSchema := range schema.Tables {
+			if tableSchema.Id != table {
+				continue
+			}
+			for _, field := range tableSchema.Fields {
+				columns = append(columns, parquetColumn{
+					name:  names.assign(field.Name),
+					field: field.Name,
+					kind:  parquetColumnKind(field.Type),
+				})
+				known[field.Name] = true
+			}
+		}
+	}
+	for _, field := range inferred {
+		if !known[field.Name] {
+			columns = append(columns, parquetColumn{
+				name:  names.assign(field.Name),
+				field: field.Name,
+				kind:  inferredColumnKind(field.Type),
+			})
+		}
+	}
+	return columns
+}
+
+func writeTableParquet(output io.Writer, columns []parquetColumn, records []api.Record) error {
+	group := parquet.Group{}
+	for _, column := range columns {
+		group[column.name] = column.node()
+	}
+	schema := parquet.NewSchema("record", group)
+	// the columns of a Parquet group are in order of their names
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].name < columns[j].name
+	})
+	writer := parquet.NewWriter(output, schema, parquet.Compression(&parquet.Snappy))
+	if err := writeParquetRows(writer, columns, records); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func writeParquetRows(writer *parquet.Writer, columns []parquetColumn, records []api.Record) error {
+	for _, record := range records {
+		row := make(parquet.Row, len(columns))
+		for i, column := range columns {
+			var value interface{}
+			var present bool
+			switch column.name {
+			case "_id":
+				value, present = record.Id, true
+			case "_created_time":
+				value, present = record.CreatedTime, record.CreatedTime != ""
+			default:
+				value, present = record.Fields[column.field]
+			}
+			converted, err := column.value(value, present)
+			if err != nil {
+				return err
+			}
+			definition := 1
+			if converted.IsNull() {
+				definition = 0
+			}
+			row[i] = converted.Level(0, definition, i)
+		}
+		if _, err := writer.WriteRows([]parquet.Row{row}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportParquet writes each table of the backup to its own Parquet file in the directory exportPath, which is created
+// if needed, for loading into analytics tools. Fields are typed by the backed-up schema, or by their values if the
+// backup has no schema; lists and objects, such as attachments and linked records, are written as JSON.
+func ExportParquet(backup *Backup, exportPath string) error {
+	if err := os.MkdirAll(exportPath, 0o755); err != nil {
+		return err
+	}
+	return WriteParquet(backup, PathOpener(exportPath))
+}
+
+// WriteParquet writes each table of the backup as its own Parquet file, opened with open.
+func WriteParquet(backup *Backup, open Opener) error {
+	dictionary := BuildDataDictionary(backup.Tables)
+	names := backup.tableNames()
+	used := uniqueNames{}
+	for _, table := range dictionary.sortedTables() {
+		filename := used.assign(csvFilename(names[table])) + ".parquet"
+		columns := backup.parquetColumns(table, dictionary[table])
+		err := open(filename, func(w io.Writer) error {
+			return writeTableParquet(w, columns, backup.Tables[table])
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
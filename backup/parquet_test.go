@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExportParquet(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z", Fields: map[string]interface{}{
+					"Name":  "Widget",
+					"Count": 3.0,
+					"Done":  true,
+					"Due":   "2023-01-02",
+					"Tags":  []interface{}{"a", "b"},
+					"Extra": "not in the schema",
+				}},
+				{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "Gadget", "Count": "#ERROR"}},
+			},
+		},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets", Fields: []api.FieldSchema{
+				{Name: "Name", Type: "singleLineText"},
+				{Name: "Count", Type: "number"},
+				{Name: "Done", Type: "checkbox"},
+				{Name: "Due", Type: "date"},
+				{Name: "Tags", Type: "multipleSelects"},
+			}}}},
+		},
+	}
+	exportPath := t.TempDir()
+	if err := ExportParquet(backup, exportPath); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path.Join(exportPath, "Widgets.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	reader := parquet.NewReader(f)
+	schema := reader.Schema()
+	column := func(name string) int {
+		leaf, found := schema.Lookup(name)
+		if !found {
+			t.Fatalf("no column %q in %v", name, schema)
+		}
+		return leaf.ColumnIndex
+	}
+	rows := make([]parquet.Row, 3)
+	n, err := reader.ReadRows(rows)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+	first, second := rows[0], rows[1]
+	if first[column("_id")].String() != "recAAAAAAAAAAAAAA" || first[column("Name")].String() != "Widget" ||
+		first[column("Count")].Double() != 3 || !first[column("Done")].Boolean() ||
+		first[column("Tags")].String() != `["a","b"]` || first[column("Extra")].String() != "not in the schema" {
+		t.Errorf("unexpected first row: %v", first)
+	}
+	// 2023-01-02 is 19359 days after the epoch
+	if first[column("Due")].Int32() != 19359 ||
+		first[column("_created_time")].Int64() != 1672531200000 {
+		t.Errorf("unexpected dates in the first row: %v", first)
+	}
+	if !second[column("Count")].IsNull() || second[column("Done")].Boolean() ||
+		!second[column("_created_time")].IsNull() {
+		t.Errorf("unexpected second row: %v", second)
+	}
+}
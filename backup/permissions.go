@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// Permissions records who had access to the backed-up bases, for auditing. It is only available through the
+// enterprise metadata endpoints; see api.GetBaseCollaborators.
+type Permissions struct {
+	// Bases holds the details of each base, by app ID, with its collaborators and invite links.
+	Bases map[string]map[string]interface{} `json:"bases"`
+	// Shares holds the share links of each base and its views, by app ID.
+	Shares map[string][]map[string]interface{} `json:"shares"`
+	// Workspaces holds the details of the workspace of each base, by workspace ID, with its collaborators and invite
+	// links.
+	Workspaces map[string]map[string]interface{} `json:"workspaces,omitempty"`
+}
+
+// FetchPermissions fetches the collaborators, share links, and workspace membership of every configured app.
+func FetchPermissions(ctx context.Context, config Config, client *http.Client) (*Permissions, error) {
+	permissions := &Permissions{
+		Bases:      map[string]map[string]interface{}{},
+		Shares:     map[string][]map[string]interface{}{},
+		Workspaces: map[string]map[string]interface{}{},
+	}
+	apps := make([]string, 0, len(config.Tables))
+	for app := range config.Tables {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		clerk := api.NewClerk(app, config.ClerkConfig(app), client)
+		base, err := clerk.GetBaseCollaborators(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("fetching collaborators of app %s (permissions needs an enterprise plan): %w",
+				app, err)
+		}
+		permissions.Bases[app] = base
+		if permissions.Shares[app], err = clerk.ListShares(ctx); err != nil {
+			return nil, fmt.Errorf("fetching share links of app %s: %w", app, err)
+		}
+		workspace, _ := base["workspaceId"].(string)
+		if _, found := permissions.Workspaces[workspace]; workspace == "" || found {
+			continue
+		}
+		if permissions.Workspaces[workspace], err = clerk.GetWorkspace(ctx, workspace); err != nil {
+			return nil, fmt.Errorf("fetching workspace %s of app %s: %w", workspace, app, err)
+		}
+	}
+	return permissions, nil
+}
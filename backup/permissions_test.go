@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestBackupPermissions(t *testing.T) {
+	var workspaceRequests int
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v0/meta/bases/appAAAAAAAAAAAAAA", "/v0/meta/bases/appBBBBBBBBBBBBBB":
+			if include := r.URL.Query()["include"]; len(include) != 2 {
+				t.Errorf("expected collaborators and invite links to be included, got %v", include)
+			}
+			_, _ = w.Write([]byte(`{"id": "` + path.Base(r.URL.Path) + `", "workspaceId": "wspAAAAAAAAAAAAAA",
+				"individualCollaborators": {"baseCollaborators": [{"userId": "usrAAAAAAAAAAAAAA",
+				"email": "a@example.com", "permissionLevel": "create"}]}}`))
+		case "/v0/meta/bases/appAAAAAAAAAAAAAA/shares", "/v0/meta/bases/appBBBBBBBBBBBBBB/shares":
+			_, _ = w.Write([]byte(`{"shares": [{"shareId": "shrAAAAAAAAAAAAAA", "type": "view", "state": "enabled"}]}`))
+		case "/v0/meta/workspaces/wspAAAAAAAAAAAAAA":
+			workspaceRequests++
+			_, _ = w.Write([]byte(`{"id": "wspAAAAAAAAAAAAAA", "name": "Team"}`))
+		default:
+			_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}}]}`))
+		}
+	})
+	dir := t.TempDir()
+	opts := Options{
+		Config: Config{
+			Config: api.Config{BearerToken: testToken},
+			Tables: map[string][]string{
+				"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"},
+				"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+			},
+			SkipSchema:           true,
+			Permissions:          true,
+			AppRequestsPerSecond: 1000,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Load(opts.OutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions := backup.Permissions
+	if permissions == nil || len(permissions.Bases) != 2 || len(permissions.Shares["appBBBBBBBBBBBBBB"]) != 1 {
+		t.Fatalf("expected the permissions of both bases, got %+v", permissions)
+	}
+	if permissions.Workspaces["wspAAAAAAAAAAAAAA"]["name"] != "Team" || workspaceRequests != 1 {
+		t.Errorf("expected the shared workspace to be fetched once, got %+v after %d requests",
+			permissions.Workspaces, workspaceRequests)
+	}
+}
@@ -0,0 +1,550 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	DefaultListWorkers     = 4
+	DefaultDownloadWorkers = 4
+)
+
+// SizeMismatchPolicy decides what happens to an attachment that was already downloaded, but whose file is not the
+// size that AirTable now reports for it.
+type SizeMismatchPolicy string
+
+const (
+	// SizeMismatchAbort fails the run, which is the default.
+	SizeMismatchAbort SizeMismatchPolicy = "abort"
+	// SizeMismatchRedownload downloads the attachment again, replacing the file.
+	SizeMismatchRedownload SizeMismatchPolicy = "redownload"
+	// SizeMismatchWarn keeps the file as it is, and logs a warning.
+	SizeMismatchWarn SizeMismatchPolicy = "warn"
+)
+
+// SizeMismatch records an already-downloaded attachment whose file had the wrong size, and what was done about it.
+type SizeMismatch struct {
+	Id       string             `json:"id"`
+	Link     string             `json:"link"`
+	File     string             `json:"file"`
+	Found    int64              `json:"found"`
+	Expected int64              `json:"expected"`
+	Action   SizeMismatchPolicy `json:"action"`
+}
+
+// storedSizeError reports that an already-downloaded attachment has the wrong size.
+type storedSizeError struct {
+	attachment Attachment
+	found      int64
+	expected   int64
+}
+
+func (e *storedSizeError) Error() string {
+	return fmt.Sprintf("invalid size for already-downloaded attachment %q: %d instead of %d", e.attachment.Link,
+		e.found, e.expected)
+}
+
+type DownloadOptions struct {
+	SizeMismatchRetries int `json:"size-mismatch-retries"`
+	Workers             int `json:"download-workers"`
+	// SizeMismatchPolicy applies to attachments that were already downloaded with the wrong size; empty means
+	// SizeMismatchAbort. (SizeMismatchRetries applies to new downloads instead.)
+	SizeMismatchPolicy SizeMismatchPolicy `json:"size-mismatch-policy,omitempty"`
+	// RequestsPerSecond limits the downloads started against each host; zero means DefaultDownloadRequestsPerSecond.
+	RequestsPerSecond float64 `json:"download-requests-per-second,omitempty"`
+	// BytesPerSecond limits the speed of all downloads together, so that a backup does not saturate a shared
+	// connection; zero means no limit.
+	BytesPerSecond int64 `json:"download-bytes-per-second,omitempty"`
+	// NamedFiles saves attachments as <id>_<filename> instead of just <id>, to make the download directory easier to
+	// browse. Attachments already saved under the other name are downloaded again.
+	NamedFiles bool `json:"named-attachment-files,omitempty"`
+	// ContentAddressed saves attachments under their SHA-256 in ContentDirectory, so that identical files attached
+	// to several records, or downloaded again for a later snapshot, are only stored once. The manifest maps each
+	// attachment ID to its file. Encrypted attachments are never identical, so they are not deduplicated.
+	ContentAddressed bool `json:"content-addressed-attachments,omitempty"`
+	// FullVerify hashes every attachment that was already downloaded to check it against the checksum manifest.
+	// Otherwise, files whose size and modification time match the manifest are trusted without being read.
+	FullVerify bool `json:"full-verify,omitempty"`
+
+	// clock paces the rate limit; nil means the wall clock.
+	clock clock.Clock
+	// key encrypts the downloaded attachments, unless it is nil.
+	key EncryptionKey
+	// metrics counts the bytes downloaded, unless it is nil.
+	metrics *Metrics
+	// progress displays the downloads, unless it is nil.
+	progress *Progress
+}
+
+func (o DownloadOptions) RateLimit() float64 {
+	if o.RequestsPerSecond == 0 {
+		return DefaultDownloadRequestsPerSecond
+	}
+	return o.RequestsPerSecond
+}
+
+func (o DownloadOptions) sizeMismatchPolicy() SizeMismatchPolicy {
+	if o.SizeMismatchPolicy == "" {
+		return SizeMismatchAbort
+	}
+	return o.SizeMismatchPolicy
+}
+
+// storedSize returns the size of an attachment once downloaded, which is larger if it is encrypted.
+func (o DownloadOptions) storedSize(size int64) int64 {
+	if o.key != nil {
+		return EncryptedSize(size)
+	}
+	return size
+}
+
+type tableJob struct {
+	app   string
+	table string
+}
+
+// AppRateLimit returns the number of list requests per second allowed against each app.
+func (c Config) AppRateLimit() float64 {
+	if c.AppRequestsPerSecond == 0 {
+		return DefaultAppRequestsPerSecond
+	}
+	return c.AppRequestsPerSecond
+}
+
+// prepareRecords filters, redacts, and annotates listed records as configured, before they are written to a backup.
+func (c Config) prepareRecords(ctx context.Context, app, table string, records []api.Record) error {
+	c.TableFields[table].apply(records)
+	if err := redactTable(ctx, records, app, table, c.RedactOptions); err != nil {
+		return err
+	}
+	return AnnotateRecords(records, app, table, c.AnnotateOptions)
+}
+
+// extractTables lists every configured table on a pool of config.ListWorkers workers, sending no more than
+// config.AppRateLimit() requests per second to any one app. If listed is not nil, it is
+// called (possibly concurrently) with the records of each table as soon as that table has been listed. If base is not
+// nil, only the records changed since that backup are fetched for the tables it contains, and those are what listed
+// is called with. The pages listed are shown on progress, unless it is nil. Once ctx is cancelled, no further tables
+// are started.
+func extractTables(ctx context.Context, config Config, client *http.Client, base *incrementalBase, progress *Progress, listed func(app, table string, records []api.Record)) (map[string][]api.Record, error) {
+	jobs := make(chan tableJob)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allErrors error
+	outputMap := map[string][]api.Record{}
+	client = withAppRateLimit(client, config.AppRateLimit(), config.Clock)
+	config = progress.watchTables(config)
+	level := progress.logLevel()
+	workers := config.ListWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				clerk := api.NewClerk(job.app, config.ClerkConfig(job.app), client)
+				startTime := clock.Or(config.Clock).Now()
+				var previous []api.Record
+				var formula string
+				if base != nil {
+					var found bool
+					if previous, found = base.tables[job.table]; found {
+						formula = modifiedSinceFormula(base.since)
+					}
+				}
+				records, err := listTable(ctx, clerk, job.table, config.listOptions(job.table, formula),
+					config.TimeoutFor(job.table))
+				progress.finishTable(job.table)
+				if err == nil {
+					err = config.prepareRecords(ctx, job.app, job.table, records)
+				}
+				var tableErr *TableError
+				if err != nil && !errors.As(err, &tableErr) {
+					err = &TableError{App: job.app, Table: job.table, Err: err}
+				}
+				if err != nil {
+					mu.Lock()
+					allErrors = multierror.Append(allErrors, err)
+					mu.Unlock()
+					continue
+				}
+				loggerFrom(ctx).Log(ctx, level, "Listed records", "app", job.app, "table", job.table, "records", len(records),
+					"incremental", formula != "", "duration", clock.Or(config.Clock).Now().Sub(startTime))
+				merged := records
+				if formula != "" {
+					merged = mergeRecords(previous, records)
+				}
+				mu.Lock()
+				outputMap[job.table] = merged
+				mu.Unlock()
+				if listed != nil {
+					listed(job.app, job.table, records)
+				}
+			}
+		}()
+	}
+dispatch:
+	for app, tables := range config.Tables {
+		for _, table := range tables {
+			select {
+			case jobs <- tableJob{app: app, table: table}:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+	}
+	close(jobs)
+	if ctx.Err() != nil {
+		allErrors = multierror.Append(allErrors, ctx.Err())
+	}
+	wg.Wait()
+	return outputMap, allErrors
+}
+
+// DownloadPool downloads attachments on a fixed number of workers as they are added, while keeping the requests to
+// each host under the configured rate. Each attachment ID is only downloaded once, no matter how many times it is
+// added. The SHA-256 of each new download is recorded in the directory's checksum manifest, and attachments that
+// were already present are checked against it. Every attachment is also recorded in the directory's manifest.
+type DownloadPool struct {
+	ctx     context.Context
+	storage Storage
+	client  *http.Client
+	opts    DownloadOptions
+	queue   chan Attachment
+	wg      sync.WaitGroup
+
+	mu        sync.Mutex
+	seen      map[string]bool
+	checksums Checksums
+	manifest  Manifest
+	completed int
+	// bytesDownloaded counts only the attachments actually downloaded, not those that were already present.
+	bytesDownloaded int64
+	sizeMismatches  []SizeMismatch
+	errors          error
+}
+
+// StartDownloadPool starts downloading attachments into downloadLocation, which is either a local directory or an
+// s3:// or gs:// URL.
+func StartDownloadPool(ctx context.Context, downloadLocation string, client *http.Client, opts DownloadOptions) (*DownloadPool, error) {
+	if !isBucket(downloadLocation) {
+		if fi, err := os.Stat(downloadLocation); err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			return nil, errors.New("download directory is not a directory")
+		}
+	}
+	st, err := OpenStorage(downloadLocation, client)
+	if err != nil {
+		return nil, err
+	}
+	return NewDownloadPool(ctx, st, client, opts)
+}
+
+// NewDownloadPool starts downloading attachments into a Storage.
+func NewDownloadPool(ctx context.Context, st Storage, client *http.Client, opts DownloadOptions) (*DownloadPool, error) {
+	checksums, err := loadChecksums(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := loadManifest(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	// one limiter is shared by every worker, so that the bandwidth limit applies to all downloads together
+	limited := withBandwidthLimit(withHostRateLimit(client, opts.RateLimit(), opts.clock), opts.BytesPerSecond, opts.clock)
+	pool := &DownloadPool{
+		ctx:       ctx,
+		storage:   st,
+		client:    limited,
+		opts:      opts,
+		queue:     make(chan Attachment),
+		seen:      map[string]bool{},
+		checksums: checksums,
+		manifest:  manifest,
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.worker()
+	}
+	return pool, nil
+}
+
+func (p *DownloadPool) worker() {
+	defer p.wg.Done()
+	for attachment := range p.queue {
+		if p.ctx.Err() != nil {
+			// Drain the queue without starting any more downloads; Wait reports the cancellation once.
+			continue
+		}
+		var downloaded bool
+		var filename, sum string
+		var err error
+		if !api.IsAirTableId(attachment.Id) {
+			// the ID names the downloaded file, and attachments given to Add are not necessarily from ExtractAttachment
+			err = fmt.Errorf("%w: %q", ErrInvalidAttachmentId, attachment.Id)
+		} else if p.opts.ContentAddressed {
+			downloaded, filename, sum, err = p.ensureContentAddressed(attachment)
+		} else {
+			filename = attachment.DownloadFilename(p.opts.NamedFiles)
+			downloaded, sum, err = ensureAttachment(p.ctx, attachment, p.storage, filename, p.recorded(attachment.Id),
+				p.client, p.opts)
+		}
+		var mismatch *storedSizeError
+		if errors.As(err, &mismatch) {
+			downloaded, filename, sum, err = p.resolveSizeMismatch(filename, mismatch)
+		}
+		var stamp fileStamp
+		if stamped, ok := p.storage.(stampedStorage); ok && err == nil {
+			stamp, err = stamped.stamp(filename)
+		}
+		p.mu.Lock()
+		if err == nil && sum == "" {
+			// attachments already in remote storage are not read back to be hashed
+			sum = p.checksums[filename]
+		}
+		if err == nil && sum != "" {
+			if expected, found := p.checksums[filename]; found && !downloaded && sum != expected {
+				err = fmt.Errorf("checksum mismatch for already-downloaded attachment %q: expected %s, found %s",
+					attachment.Link, expected, sum)
+			} else {
+				p.checksums[filename] = sum
+				attachment.SHA256, attachment.File = sum, filename
+				attachment.StoredSize, attachment.ModTime = stamp.size, stamp.modTime
+				p.manifest[attachment.Id] = attachment
+			}
+		}
+		p.completed++
+		p.opts.progress.finishAttachment(attachment.Size, downloaded)
+		if err != nil && p.ctx.Err() == nil {
+			p.errors = multierror.Append(p.errors, err)
+		} else if downloaded {
+			p.opts.metrics.recordAttachmentBytes(attachment.Size)
+			p.bytesDownloaded += attachment.Size
+			loggerFrom(p.ctx).Log(p.ctx, p.opts.progress.logLevel(), "Downloaded attachment", "completed", p.completed, "total", len(p.seen),
+				"link", attachment.Link, "file", filename, "bytes", attachment.Size)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Add queues an attachment for download, blocking until a worker is free to take it.
+func (p *DownloadPool) Add(attachment Attachment) {
+	if attachment.UnexpectedPrefix {
+		loggerFrom(p.ctx).Warn("Skipping attachment with unexpected link prefix", "link", attachment.Link)
+		return
+	}
+	p.mu.Lock()
+	duplicate := p.seen[attachment.Id]
+	p.seen[attachment.Id] = true
+	p.mu.Unlock()
+	if !duplicate {
+		p.opts.progress.queueAttachment(attachment.Size)
+		p.queue <- attachment
+	}
+}
+
+// recorded returns the manifest entry of an attachment as it was before this run, or as this run has updated it.
+func (p *DownloadPool) recorded(id string) Attachment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.manifest[id]
+}
+
+// Downloaded returns the manifest entry of an attachment, including its SHA-256 and the file it was saved as, if it
+// has been downloaded.
+func (p *DownloadPool) Downloaded(id string) (Attachment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	attachment, found := p.manifest[id]
+	return attachment, found
+}
+
+// BytesDownloaded returns the total size of the attachments downloaded so far, not counting those already present.
+func (p *DownloadPool) BytesDownloaded() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bytesDownloaded
+}
+
+// SizeMismatches returns the already-downloaded attachments found with the wrong size so far, and what was done
+// about each.
+func (p *DownloadPool) SizeMismatches() []SizeMismatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]SizeMismatch(nil), p.sizeMismatches...)
+}
+
+// Wait finishes all queued downloads, saves the checksum manifest and the attachment manifest, and returns every
+// error encountered. No more attachments may be added.
+func (p *DownloadPool) Wait() error {
+	close(p.queue)
+	p.wg.Wait()
+	// saved even after cancellation, so that the downloads that did finish are recorded
+	ctx := context.Background()
+	if err := p.checksums.save(ctx, p.storage); err != nil {
+		p.errors = multierror.Append(p.errors, err)
+	}
+	if err := p.manifest.save(ctx, p.storage); err != nil {
+		p.errors = multierror.Append(p.errors, err)
+	}
+	if err := p.ctx.Err(); err != nil {
+		return multierror.Append(p.errors, err)
+	}
+	return p.errors
+}
+
+// resolveSizeMismatch applies the size mismatch policy to an already-downloaded attachment of the wrong size, and
+// records what it did.
+func (p *DownloadPool) resolveSizeMismatch(filename string, mismatch *storedSizeError) (downloaded bool,
+	file, sum string, err error) {
+	attachment, policy := mismatch.attachment, p.opts.sizeMismatchPolicy()
+	p.mu.Lock()
+	p.sizeMismatches = append(p.sizeMismatches, SizeMismatch{
+		Id:       attachment.Id,
+		Link:     attachment.Link,
+		File:     filename,
+		Found:    mismatch.found,
+		Expected: mismatch.expected,
+		Action:   policy,
+	})
+	p.mu.Unlock()
+	switch policy {
+	case SizeMismatchWarn:
+		loggerFrom(p.ctx).Warn("Keeping already-downloaded attachment with the wrong size", "link", attachment.Link,
+			"file", filename, "size", mismatch.found, "expected", mismatch.expected)
+		// the file is kept as it was recorded, so its checksum is only read if none was recorded
+		p.mu.Lock()
+		sum = p.checksums[filename]
+		p.mu.Unlock()
+		if sum == "" {
+			sum, err = hashStored(p.ctx, p.storage, filename)
+		}
+		return false, filename, sum, err
+	case SizeMismatchRedownload:
+		loggerFrom(p.ctx).Warn("Downloading attachment with the wrong size again", "link", attachment.Link,
+			"file", filename, "size", mismatch.found, "expected", mismatch.expected)
+		if p.opts.ContentAddressed {
+			// other attachments may share the file, so it is replaced in one step rather than deleted first
+			return p.downloadContentAddressed(attachment, true)
+		}
+		if err := p.storage.Delete(p.ctx, filename); err != nil {
+			return false, filename, "", err
+		}
+		downloaded, sum, err = ensureAttachment(p.ctx, attachment, p.storage, filename, Attachment{}, p.client, p.opts)
+		return downloaded, filename, sum, err
+	default:
+		return false, filename, "", mismatch
+	}
+}
+
+// ensureContentAddressed downloads an attachment into ContentDirectory unless the manifest records it as already
+// stored there, and returns the file it is stored in.
+func (p *DownloadPool) ensureContentAddressed(attachment Attachment) (downloaded bool, filename, sum string, err error) {
+	known := p.recorded(attachment.Id)
+	if isContentFilename(known.File) {
+		size, present, err := p.storage.Stat(p.ctx, known.File)
+		if err != nil {
+			return false, "", "", err
+		}
+		if expected := p.opts.storedSize(attachment.Size); present && size != expected {
+			return false, known.File, "", &storedSizeError{attachment: attachment, found: size, expected: expected}
+		} else if present {
+			if _, local := p.storage.(stampedStorage); local {
+				sum, err = storedSum(p.ctx, p.storage, known.File, known, p.opts)
+			}
+			return false, known.File, sum, err
+		}
+	}
+	return p.downloadContentAddressed(attachment, false)
+}
+
+// downloadContentAddressed downloads an attachment into ContentDirectory. Its hash is only known once it has been
+// downloaded, so it is downloaded under a temporary name first, and then moved into place. If the same content is
+// already stored, the download is deleted instead, unless replace is set.
+func (p *DownloadPool) downloadContentAddressed(attachment Attachment, replace bool) (downloaded bool, filename,
+	sum string, err error) {
+	temp := "TEMP." + attachment.Id
+	if err := p.storage.Delete(p.ctx, temp); err != nil {
+		return false, "", "", err
+	}
+	if _, sum, err = ensureAttachment(p.ctx, attachment, p.storage, temp, Attachment{}, p.client, p.opts); err != nil {
+		return false, "", "", err
+	}
+	filename = contentFilename(sum)
+	if _, present, err := p.storage.Stat(p.ctx, filename); err != nil {
+		return true, "", "", err
+	} else if present && !replace {
+		return true, filename, sum, p.storage.Delete(p.ctx, temp)
+	}
+	return true, filename, sum, p.storage.Rename(p.ctx, temp, filename)
+}
+
+// ensureAttachment downloads an attachment unless it is already present, and reports whether it downloaded it. It
+// returns the SHA-256 of the stored attachment, except for attachments that were already present in remote storage,
+// since reading those back would cost as much as downloading them again. Attachments already present are checked
+// against recorded, their entry in the manifest, as storedSum describes. Attachments are streamed straight into
+// storage, so an interrupted transfer starts over, unless the storage can download them itself.
+func ensureAttachment(ctx context.Context, attachment Attachment, st Storage, filename string, recorded Attachment,
+	client *http.Client, opts DownloadOptions) (downloaded bool, sum string, err error) {
+	// Make sure it's safe to use as a filename
+	if !api.IsAirTableId(attachment.Id) {
+		return false, "", fmt.Errorf("%w: %q", ErrInvalidAttachmentId, attachment.Id)
+	}
+	_, local := st.(stampedStorage)
+	size, found, err := st.Stat(ctx, filename)
+	if err != nil {
+		return false, "", err
+	} else if found {
+		if expected := opts.storedSize(attachment.Size); size != expected {
+			return false, "", &storedSizeError{attachment: attachment, found: size, expected: expected}
+		}
+		if !local {
+			return false, "", nil
+		}
+	} else if downloader, ok := st.(attachmentDownloader); ok {
+		if err := downloader.downloadAttachment(ctx, attachment, filename, client, opts); err != nil {
+			return false, "", err
+		}
+	} else {
+		err = retryOnSizeMismatch(ctx, attachment, opts.SizeMismatchRetries, func() error {
+			sum, err = streamAttachment(ctx, attachment, st, filename, client, opts.key)
+			return err
+		})
+		return err == nil, sum, err
+	}
+	if found {
+		sum, err = storedSum(ctx, st, filename, recorded, opts)
+	} else {
+		sum, err = hashStored(ctx, st, filename)
+	}
+	return !found, sum, err
+}
+
+// storedSum returns the SHA-256 of a file in local storage. It is taken from recorded, the manifest entry of an
+// attachment saved in the file, if the file has the same size and modification time as when it was hashed, unless
+// opts.FullVerify asks for every file to be read again.
+func storedSum(ctx context.Context, st Storage, filename string, recorded Attachment, opts DownloadOptions) (string,
+	error) {
+	if stamped, ok := st.(stampedStorage); ok && !opts.FullVerify && recorded.File == filename && recorded.SHA256 != "" {
+		if current, err := stamped.stamp(filename); err == nil && current == recorded.stamp() {
+			return recorded.SHA256, nil
+		}
+	}
+	return hashStored(ctx, st, filename)
+}
@@ -0,0 +1,252 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// concurrencyGauge records the largest number of simultaneous holders it has seen.
+type concurrencyGauge struct {
+	mu      sync.Mutex
+	current int
+	peak    int
+}
+
+func (g *concurrencyGauge) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current++
+	if g.current > g.peak {
+		g.peak = g.current
+	}
+}
+
+func (g *concurrencyGauge) exit() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current--
+}
+
+func TestPipelinePoolSizes(t *testing.T) {
+	var listing, downloading concurrencyGauge
+	var overlapMu sync.Mutex
+	overlapped := false
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v0/") {
+			listing.enter()
+			defer listing.exit()
+			time.Sleep(30 * time.Millisecond)
+			table := path.Base(r.URL.Path)
+			_, _ = fmt.Fprintf(w, `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+				{"id": "att%sA", "url": "%s%s/a", "size": 11, "filename": "a"},
+				{"id": "att%sB", "url": "%s%s/b", "size": 11, "filename": "b"}
+			]}}]}`, table[4:], DefaultAttachmentPrefixes[0], table, table[4:], DefaultAttachmentPrefixes[0], table)
+			return
+		}
+		downloading.enter()
+		defer downloading.exit()
+		listing.mu.Lock()
+		if listing.current > 0 {
+			overlapMu.Lock()
+			overlapped = true
+			overlapMu.Unlock()
+		}
+		listing.mu.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		_, _ = w.Write([]byte("hello world"))
+	})
+	var tables []string
+	for i := 0; i < 8; i++ {
+		tables = append(tables, fmt.Sprintf("tbl%014d", i))
+	}
+	config := Config{
+		Config:               api.Config{BearerToken: testToken},
+		Tables:               map[string][]string{"appAAAAAAAAAAAAAA": tables},
+		ListWorkers:          2,
+		AppRequestsPerSecond: 1000,
+		DownloadOptions:      DownloadOptions{Workers: 3, RequestsPerSecond: 1000},
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, "backup.json"), DownloadPath: downloadDir}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if listing.peak != 2 {
+		t.Errorf("expected 2 concurrent list requests, saw %d", listing.peak)
+	}
+	if downloading.peak != 3 {
+		t.Errorf("expected 3 concurrent downloads, saw %d", downloading.peak)
+	}
+	if !overlapped {
+		t.Error("downloads should have started while tables were still being listed")
+	}
+	entries, err := os.ReadDir(downloadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the attachments, plus the checksum manifest and the attachment manifest
+	if len(entries) != 18 {
+		t.Errorf("expected 16 downloaded attachments, found %d", len(entries)-2)
+	}
+}
+
+func TestExtractTablesRateLimitsEachApp(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := Config{
+		Config: api.Config{BearerToken: testToken, Clock: fakeClock},
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"},
+			"appBBBBBBBBBBBBBB": {"tblCCCCCCCCCCCCCC"},
+		},
+		ListWorkers:          1,
+		AppRequestsPerSecond: 2,
+	}
+	tables, err := ExtractAllTables(context.Background(), config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 3 {
+		t.Errorf("expected 3 tables, got %d", len(tables))
+	}
+	if sleeps := fakeClock.Sleeps(); len(sleeps) != 1 || sleeps[0] != 500*time.Millisecond {
+		t.Errorf("only the second request to the same app should have waited, got %v", sleeps)
+	}
+}
+
+func TestTablesListedFromViewsKeepTheirOrder(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tblAAAAAAAAAAAAAA") && r.URL.Query().Get("view") != "Curated" {
+			t.Errorf("expected the view to be requested: %s", r.URL)
+		}
+		if strings.HasSuffix(r.URL.Path, "/tblBBBBBBBBBBBBBB") && r.URL.Query().Has("view") {
+			t.Errorf("no view should be requested: %s", r.URL)
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {}},
+			{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {}}]}`))
+	})
+	dir := t.TempDir()
+	err := Run(context.Background(), Options{
+		Config: Config{
+			Config:     api.Config{BearerToken: testToken},
+			Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}},
+			Views:      map[string]string{"tblAAAAAAAAAAAAAA": "Curated"},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Load(path.Join(dir, "backup.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.Tables["tblAAAAAAAAAAAAAA"][0].Id != "recBBBBBBBBBBBBBB" {
+		t.Error("records listed from a view should keep the view's order")
+	}
+	if backup.Tables["tblBBBBBBBBBBBBBB"][0].Id != "recAAAAAAAAAAAAAA" {
+		t.Error("records of other tables should be sorted by ID")
+	}
+	if backup.Views["tblAAAAAAAAAAAAAA"] != "Curated" || len(backup.Views) != 1 {
+		t.Errorf("unexpected views: %v", backup.Views)
+	}
+}
+
+func TestTableFieldsAreIncludedAndExcluded(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if fields := r.URL.Query()["fields[]"]; !reflect.DeepEqual(fields, []string{"Name", "Notes", "Secret"}) {
+			t.Errorf("expected the included fields to be requested, got %v", fields)
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "",
+			"fields": {"Name": "a", "Notes": "b", "Secret": "c"}}]}`))
+	})
+	dir := t.TempDir()
+	err := Run(context.Background(), Options{
+		Config: Config{
+			Config: api.Config{BearerToken: testToken},
+			Tables: map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			TableFields: map[string]FieldFilter{"tblAAAAAAAAAAAAAA": {
+				Include: []string{"Name", "Notes", "Secret"},
+				Exclude: []string{"Secret"},
+			}},
+			SkipSchema: true,
+		},
+		Client:       client,
+		OutputPath:   path.Join(dir, "backup.json"),
+		DownloadPath: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Load(path.Join(dir, "backup.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := backup.Tables["tblAAAAAAAAAAAAAA"][0].Fields
+	if !reflect.DeepEqual(fields, map[string]interface{}{"Name": "a", "Notes": "b"}) {
+		t.Errorf("expected the excluded field to be removed, got %v", fields)
+	}
+}
+
+func TestKeepGoingRecordsFailedTables(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tblBBBBBBBBBBBBBB") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"type": "TABLE_NOT_FOUND", "message": "Could not find table"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Name": "a"}}]}`))
+	})
+	for _, stream := range []bool{false, true} {
+		dir := t.TempDir()
+		opts := Options{
+			Config: Config{
+				Config:       api.Config{BearerToken: testToken},
+				Tables:       map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}},
+				SkipSchema:   true,
+				StreamOutput: stream,
+			},
+			Client:       client,
+			OutputPath:   path.Join(dir, "backup.json"),
+			DownloadPath: dir,
+		}
+		if err := Run(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "tblBBBBBBBBBBBBBB") {
+			t.Errorf("stream=%v: without keep-going, the failed table should fail the backup, got %v", stream, err)
+		}
+		opts.Config.KeepGoing = true
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatalf("stream=%v: %v", stream, err)
+		}
+		backup, err := Load(opts.OutputPath)
+		if err != nil {
+			t.Fatalf("stream=%v: %v", stream, err)
+		}
+		if len(backup.Tables["tblAAAAAAAAAAAAAA"]) != 1 || len(backup.Tables["tblBBBBBBBBBBBBBB"]) != 0 {
+			t.Errorf("stream=%v: unexpected tables %v", stream, backup.Tables)
+		}
+		failed := backup.Metadata.FailedTables
+		if len(failed) != 1 || !strings.Contains(failed["tblBBBBBBBBBBBBBB"], "Could not find table") {
+			t.Errorf("stream=%v: unexpected failed tables %v", stream, failed)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MirrorPostgres is the driver of PostgreSQL mirror targets.
+const MirrorPostgres = "postgres"
+
+func init() {
+	mirrorDialects[MirrorPostgres] = &mirrorDialect{
+		driver: "postgres",
+		quote:  pq.QuoteIdentifier,
+		placeholder: func(i int) string {
+			return fmt.Sprintf("$%d", i+1)
+		},
+		idType: "TEXT",
+		columnTypes: map[string]string{
+			parquetText:      "TEXT",
+			parquetNumber:    "DOUBLE PRECISION",
+			parquetBool:      "BOOLEAN",
+			parquetDate:      "DATE",
+			parquetTimestamp: "TIMESTAMPTZ",
+			parquetJSON:      "JSONB",
+		},
+		columnsQuery: "SELECT column_name FROM information_schema.columns " +
+			"WHERE table_schema = current_schema() AND table_name = $1",
+		upsert: postgresUpsert,
+	}
+}
+
+func postgresUpsert(d *mirrorDialect, table string, columns []parquetColumn) string {
+	var names, placeholders, updates []string
+	for i, column := range columns {
+		names = append(names, d.quote(column.name))
+		placeholders = append(placeholders, d.placeholder(i))
+		if column.name != "_id" {
+			updates = append(updates, d.quote(column.name)+" = EXCLUDED."+d.quote(column.name))
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s", d.quote(table),
+		strings.Join(names, ", "), strings.Join(placeholders, ", "), d.quote("_id"), strings.Join(updates, ", "))
+}
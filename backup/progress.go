@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// progressInterval is how often the progress display is redrawn, at most.
+const progressInterval = 100 * time.Millisecond
+
+const progressBarWidth = 30
+
+type tableProgress struct {
+	pages   int
+	records int
+}
+
+// Progress draws the progress of a backup on a terminal: the pages listed so far of each table being listed, and a
+// progress bar of the attachments downloaded, with the transfer rate and the estimated time remaining. The total
+// size of the attachments grows as more tables are listed, so the estimate is only final once listing is done.
+// Anything else meant for the terminal, such as log messages, should be written through the Progress, which draws it
+// above the progress display. A nil *Progress draws nothing.
+type Progress struct {
+	w     io.Writer
+	clock clock.Clock
+
+	mu          sync.Mutex
+	drawn       int // lines of the last drawing, which the next one replaces
+	lastDraw    time.Time
+	finished    bool
+	tables      int
+	tablesDone  int
+	listing     map[string]*tableProgress
+	queued      int
+	done        int
+	bytesQueued int64
+	bytesDone   int64
+	// bytesFetched counts only the bytes actually downloaded, not attachments that were already present, and
+	// fetchStart is when the first download was queued.
+	bytesFetched int64
+	fetchStart   time.Time
+}
+
+// NewProgress returns a Progress that draws on w, which should be a terminal. A nil clock means the wall clock.
+func NewProgress(w io.Writer, c clock.Clock) *Progress {
+	return &Progress{w: w, clock: clock.Or(c), listing: map[string]*tableProgress{}}
+}
+
+// watchTables counts the tables of a configuration, and returns a copy of it that shows each page as it is listed.
+func (p *Progress) watchTables(config Config) Config {
+	if p == nil {
+		return config
+	}
+	p.mu.Lock()
+	for _, tables := range config.Tables {
+		p.tables += len(tables)
+	}
+	p.draw(false)
+	p.mu.Unlock()
+	onPage := config.OnPage
+	config.OnPage = func(table string, records int) {
+		p.listedPage(table, records)
+		if onPage != nil {
+			onPage(table, records)
+		}
+	}
+	return config
+}
+
+// logLevel returns the level for messages about each table and attachment, which would only repeat the progress
+// display if it is shown.
+func (p *Progress) logLevel() slog.Level {
+	if p == nil {
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+func (p *Progress) listedPage(table string, records int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.listing[table]
+	if t == nil {
+		t = &tableProgress{}
+		p.listing[table] = t
+	}
+	t.pages++
+	t.records = records
+	p.draw(false)
+}
+
+func (p *Progress) finishTable(table string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.listing, table)
+	p.tablesDone++
+	p.draw(false)
+}
+
+func (p *Progress) queueAttachment(size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queued == 0 {
+		p.fetchStart = p.clock.Now()
+	}
+	p.queued++
+	p.bytesQueued += size
+	p.draw(false)
+}
+
+func (p *Progress) finishAttachment(size int64, downloaded bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.bytesDone += size
+	if downloaded {
+		p.bytesFetched += size
+	}
+	p.draw(false)
+}
+
+// Write writes b above the progress display.
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.w.Write(b)
+	p.draw(true)
+	return n, err
+}
+
+// Finish draws the final state of the progress display and leaves it in place, so that later output follows it.
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw(true)
+	p.drawn = 0
+	p.finished = true
+}
+
+// clear erases the last drawing, leaving the cursor where it started.
+func (p *Progress) clear() {
+	if p.drawn > 0 {
+		_, _ = fmt.Fprintf(p.w, "\x1b[%dF\x1b[J", p.drawn)
+		p.drawn = 0
+	}
+}
+
+// draw replaces the last drawing, unless it was drawn too recently and force is false.
+func (p *Progress) draw(force bool) {
+	now := p.clock.Now()
+	if p.finished || !force && p.drawn > 0 && now.Sub(p.lastDraw) < progressInterval {
+		return
+	}
+	p.lastDraw = now
+	lines := p.lines(now)
+	p.clear()
+	_, _ = io.WriteString(p.w, strings.Join(lines, "\n")+"\n")
+	p.drawn = len(lines)
+}
+
+func (p *Progress) lines(now time.Time) []string {
+	lines := []string{fmt.Sprintf("Tables: %d/%d listed", p.tablesDone, p.tables)}
+	tables := make([]string, 0, len(p.listing))
+	for table := range p.listing {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		t := p.listing[table]
+		lines = append(lines, fmt.Sprintf("  %s: %d records in %d pages", table, t.records, t.pages))
+	}
+	if p.queued == 0 {
+		return lines
+	}
+	filled := progressBarWidth * p.done / p.queued
+	if p.bytesQueued > 0 {
+		filled = int(int64(progressBarWidth) * p.bytesDone / p.bytesQueued)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	line := fmt.Sprintf("Attachments: %d/%d [%s] %s/%s", p.done, p.queued, bar,
+		formatBytes(p.bytesDone), formatBytes(p.bytesQueued))
+	if elapsed := now.Sub(p.fetchStart).Seconds(); elapsed > 0 && p.bytesFetched > 0 {
+		rate := float64(p.bytesFetched) / elapsed
+		remaining := time.Duration(float64(p.bytesQueued-p.bytesDone) / rate * float64(time.Second))
+		line += fmt.Sprintf(" %s/s ETA %s", formatBytes(int64(rate)), remaining.Round(time.Second))
+	}
+	return append(lines, line)
+}
+
+// formatBytes formats a number of bytes with a binary unit, such as "1.5 MiB".
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < 4 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[unit])
+}
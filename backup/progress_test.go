@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestProgress(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var out bytes.Buffer
+	progress := NewProgress(&out, fakeClock)
+	progress.watchTables(Config{
+		Tables: map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}},
+	})
+	progress.listedPage("tblAAAAAAAAAAAAAA", 100)
+	progress.listedPage("tblAAAAAAAAAAAAAA", 150)
+	progress.queueAttachment(3 << 20)
+	progress.queueAttachment(1 << 20)
+	fakeClock.Advance(2 * time.Second)
+	progress.finishAttachment(3<<20, true)
+	out.Reset()
+	_, _ = progress.Write([]byte("a log message\n"))
+	expected := "\x1b[3F\x1b[Ja log message\n" +
+		"Tables: 0/2 listed\n" +
+		"  tblAAAAAAAAAAAAAA: 150 records in 2 pages\n" +
+		"Attachments: 1/2 [======================        ] 3.0 MiB/4.0 MiB 1.5 MiB/s ETA 1s\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+
+	progress.finishTable("tblAAAAAAAAAAAAAA")
+	progress.finishAttachment(1<<20, false)
+	progress.Finish()
+	out.Reset()
+	_, _ = progress.Write([]byte("after\n"))
+	if out.String() != "after\n" {
+		t.Errorf("nothing should be drawn after Finish: %q", out.String())
+	}
+	if formatBytes(1536) != "1.5 KiB" || formatBytes(12) != "12 B" {
+		t.Errorf("unexpected formatting: %s %s", formatBytes(1536), formatBytes(12))
+	}
+}
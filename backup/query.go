@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/itchyny/gojq"
+)
+
+// QueryBackup runs a jq program over a backup, such as '.tables.tblX[] | select(.fields.Status == "Open")', and calls
+// emit with each value it produces. The program sees the backup as it is written, but with the records of every
+// table in "tables" even when the backup keeps them in separate files. The variable $names maps each table's ID to
+// its name, as in exports.
+func QueryBackup(ctx context.Context, backup *Backup, program string, emit func(value interface{}) error) error {
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	code, err := gojq.Compile(query, gojq.WithVariables([]string{"$names"}))
+	if err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+	// gojq only accepts the types that encoding/json decodes into
+	encoded, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	var input interface{}
+	if err := json.Unmarshal(encoded, &input); err != nil {
+		return err
+	}
+	names := map[string]interface{}{}
+	for table, name := range backup.tableNames() {
+		names[table] = name
+	}
+	iter := code.RunWithContext(ctx, input, names)
+	for {
+		value, ok := iter.Next()
+		if !ok {
+			return nil
+		}
+		if err, ok := value.(error); ok {
+			var halt *gojq.HaltError
+			if errors.As(err, &halt) && halt.Value() == nil {
+				// 'halt' stops the program without an error
+				return nil
+			}
+			return err
+		}
+		if err := emit(value); err != nil {
+			return err
+		}
+	}
+}
+
+// PrintQuery writes each value that a query produces on its own line, as JSON, or as is for strings if raw is set.
+func PrintQuery(ctx context.Context, backup *Backup, program string, raw bool, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return QueryBackup(ctx, backup, program, func(value interface{}) error {
+		if s, ok := value.(string); ok && raw {
+			_, err := fmt.Fprintln(w, s)
+			return err
+		}
+		return encoder.Encode(value)
+	})
+}
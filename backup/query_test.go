@@ -0,0 +1,47 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestQueryBackup(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "First", "Status": "Open"}},
+				{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "Second", "Status": "Closed"}},
+				{Id: "recCCCCCCCCCCCCCC", Fields: map[string]interface{}{"Name": "<Third>", "Status": "Open"}},
+			},
+		},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Tasks"}}},
+		},
+	}
+	var out bytes.Buffer
+	err := PrintQuery(context.Background(), backup,
+		`.tables.tblAAAAAAAAAAAAAA[] | select(.fields.Status == "Open") | {id, name: .fields.Name}`, false, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":"recAAAAAAAAAAAAAA","name":"First"}` + "\n" + `{"id":"recCCCCCCCCCCCCCC","name":"<Third>"}` + "\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+	out.Reset()
+	if err := PrintQuery(context.Background(), backup, `$names[.tables | keys[]]`, true, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "Tasks\n" {
+		t.Errorf("unexpected raw output: %q", out.String())
+	}
+	if err := PrintQuery(context.Background(), backup, `.tables[`, false, &out); err == nil {
+		t.Error("expected an invalid program to fail")
+	}
+	if err := PrintQuery(context.Background(), backup, `error("stop")`, false, &out); err == nil {
+		t.Error("expected errors raised by the program to be returned")
+	}
+}
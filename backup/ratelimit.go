@@ -0,0 +1,229 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// DefaultDownloadRequestsPerSecond keeps attachment downloads comfortably below the point where AirTable's CDN starts
+// throttling a single client.
+const DefaultDownloadRequestsPerSecond = 10
+
+// DefaultAppRequestsPerSecond paces the listing of each app below AirTable's limit of 5 requests per second, with
+// enough headroom that the API's rate monitor does not warn during a normal backup.
+const DefaultAppRequestsPerSecond = 3
+
+// keyedLimiter spaces out requests so that no more than rate requests per second are sent for any one key, such as a
+// host or an app. Requests with different keys do not wait on each other.
+type keyedLimiter struct {
+	rate  float64
+	clock clock.Clock
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newKeyedLimiter(rate float64, c clock.Clock) *keyedLimiter {
+	return &keyedLimiter{
+		rate:  rate,
+		clock: clock.Or(c),
+		next:  map[string]time.Time{},
+	}
+}
+
+// Wait blocks until a request for key may be sent, or until ctx is cancelled.
+func (l *keyedLimiter) Wait(ctx context.Context, key string) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	slot := l.next[key]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[key] = slot.Add(time.Duration(float64(time.Second) / l.rate))
+	l.mu.Unlock()
+	if wait := slot.Sub(now); wait > 0 {
+		select {
+		case <-l.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// rateLimitedTransport waits for the limiter before every request it sends, keying each request with keyOf.
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *keyedLimiter
+	keyOf   func(req *http.Request) string
+}
+
+func (t rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context(), t.keyOf(req)); err != nil {
+		return nil, err
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+func withRateLimit(client *http.Client, rate float64, c clock.Clock, keyOf func(req *http.Request) string) *http.Client {
+	limited := *client
+	limited.Transport = rateLimitedTransport{base: client.Transport, limiter: newKeyedLimiter(rate, c), keyOf: keyOf}
+	return &limited
+}
+
+// withHostRateLimit returns a copy of client whose requests are limited to rate per second for each host.
+func withHostRateLimit(client *http.Client, rate float64, c clock.Clock) *http.Client {
+	return withRateLimit(client, rate, c, func(req *http.Request) string {
+		return req.URL.Host
+	})
+}
+
+// withAppRateLimit returns a copy of client whose AirTable API requests are limited to rate per second for each app,
+// as identified by the first component of the request path after the API version.
+func withAppRateLimit(client *http.Client, rate float64, c clock.Clock) *http.Client {
+	return withRateLimit(client, rate, c, func(req *http.Request) string {
+		parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 3)
+		if len(parts) < 2 {
+			return req.URL.Host
+		}
+		return req.URL.Host + "/" + parts[1]
+	})
+}
+
+// bandwidthLimiter is a token bucket that holds up to one second of bytes at rate bytes per second, and is shared by
+// every reader it throttles.
+type bandwidthLimiter struct {
+	rate  float64
+	clock clock.Clock
+
+	mu sync.Mutex
+	// next is when the bucket will be empty again after the bytes already taken; it is never more than a second
+	// before now, which caps how much is saved up while idle.
+	next time.Time
+}
+
+func newBandwidthLimiter(bytesPerSecond int64, c clock.Clock) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: float64(bytesPerSecond), clock: clock.Or(c)}
+}
+
+// burst is the most that one read may take from the bucket at once, so that the bytes received by several readers
+// are interleaved smoothly.
+func (l *bandwidthLimiter) burst() int {
+	burst := int(l.rate)
+	if burst > 32*1024 {
+		return 32 * 1024
+	} else if burst < 1 {
+		return 1
+	}
+	return burst
+}
+
+// WaitN blocks until n bytes have been taken from the bucket, or until ctx is cancelled.
+func (l *bandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.clock.Now()
+	if full := now.Add(-time.Second); l.next.Before(full) {
+		l.next = full
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	wait := l.next.Sub(now)
+	l.mu.Unlock()
+	if wait > 0 {
+		select {
+		case <-l.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// throttledBody reads a response body no faster than its limiter allows.
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *bandwidthLimiter
+}
+
+func (b throttledBody) Read(p []byte) (int, error) {
+	if burst := b.limiter.burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// bandwidthLimitedTransport throttles the bodies of the responses it receives with a shared limiter.
+type bandwidthLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *bandwidthLimiter
+}
+
+func (t bandwidthLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = throttledBody{ReadCloser: resp.Body, ctx: req.Context(), limiter: t.limiter}
+	return resp, nil
+}
+
+// withBandwidthLimit returns a copy of client whose responses, together, are received at no more than bytesPerSecond.
+// Zero means no limit.
+func withBandwidthLimit(client *http.Client, bytesPerSecond int64, c clock.Clock) *http.Client {
+	if bytesPerSecond <= 0 {
+		return client
+	}
+	limited := *client
+	limited.Transport = bandwidthLimitedTransport{base: client.Transport, limiter: newBandwidthLimiter(bytesPerSecond, c)}
+	return &limited
+}
+
+// ParseBandwidth parses a rate in bytes per second, such as "500000", or with a binary unit, such as "512K" or
+// "2MiB". An empty rate is zero, which means no limit.
+func ParseBandwidth(spec string) (int64, error) {
+	if spec == "" {
+		return 0, nil
+	}
+	number := strings.TrimRight(spec, "KMGkmgiB")
+	multiplier, knownUnit := bandwidthUnits[strings.ToUpper(spec[len(number):])]
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || !knownUnit || value < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q: expected bytes per second, such as 500000, 512K, or 2M", spec)
+	}
+	rate := int64(value * multiplier)
+	if rate == 0 && value > 0 {
+		// zero would mean no limit at all
+		return 0, fmt.Errorf("invalid bandwidth %q: less than one byte per second", spec)
+	}
+	return rate, nil
+}
+
+// bandwidthUnits are the multipliers of the units that ParseBandwidth accepts, in upper case.
+var bandwidthUnits = map[string]float64{
+	"": 1, "B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+}
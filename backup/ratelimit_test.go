@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestKeyedLimiterSpacesRequestsPerKey(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := newKeyedLimiter(2, fakeClock)
+	for _, host := range []string{"a.example", "a.example", "b.example", "a.example"} {
+		if err := limiter.Wait(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+	if sleeps := fakeClock.Sleeps(); !reflect.DeepEqual(sleeps, expected) {
+		t.Errorf("expected waits %v, got %v", expected, sleeps)
+	}
+}
+
+func TestBandwidthLimiterThrottlesReads(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	limiter := newBandwidthLimiter(1000, fakeClock)
+	body := throttledBody{ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, 3000))), ctx: context.Background(),
+		limiter: limiter}
+	if n, err := io.Copy(io.Discard, body); err != nil || n != 3000 {
+		t.Fatalf("expected to read the whole body, got %d bytes: %v", n, err)
+	}
+	// the first second's worth is already in the bucket
+	expected := []time.Duration{time.Second, time.Second}
+	if sleeps := fakeClock.Sleeps(); !reflect.DeepEqual(sleeps, expected) {
+		t.Errorf("expected waits %v, got %v", expected, sleeps)
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	for spec, expected := range map[string]int64{"": 0, "500000": 500000, "512K": 512 << 10, "2MiB": 2 << 20,
+		"1.5m": 3 << 19, "1G": 1 << 30, "4KB": 4 << 10, "100B": 100, "0": 0} {
+		if parsed, err := ParseBandwidth(spec); err != nil || parsed != expected {
+			t.Errorf("expected %q to be %d, got %d: %v", spec, expected, parsed, err)
+		}
+	}
+	for _, spec := range []string{"fast", "-5", "5X", "K", "2iB", "2KiBB", "0.5", "0.0001K"} {
+		if _, err := ParseBandwidth(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+const (
+	RedactHash = "hash"
+	RedactMask = "mask"
+	RedactDrop = "drop"
+)
+
+// RedactionRule redacts the values of the fields named Field, or whose names match the regular expression
+// FieldPattern, or, if neither is set, of every field. If ValuePattern is set, only the parts of text values that
+// match it are redacted, and other values are left alone. Action is RedactHash to replace each value with its
+// SHA-256 hash, RedactMask to replace each character with an asterisk, or RedactDrop to remove the field.
+type RedactionRule struct {
+	// Tables restricts the rule to the tables with these IDs; empty means every table.
+	Tables       []string `json:"tables,omitempty"`
+	Field        string   `json:"field,omitempty"`
+	FieldPattern string   `json:"field-pattern,omitempty"`
+	ValuePattern string   `json:"value-pattern,omitempty"`
+	Action       string   `json:"action"`
+}
+
+type RedactOptions struct {
+	// Redact lists the rules that redact values before they are written to the backup, applied in order.
+	Redact []RedactionRule `json:"redact,omitempty"`
+	// RedactHashKey, if set, keys the hashes of redacted values (with HMAC-SHA256), so that values that are easy to
+	// guess cannot be recovered by hashing guesses.
+	RedactHashKey string `json:"redact-hash-key,omitempty"`
+}
+
+type compiledRule struct {
+	RedactionRule
+	fieldPattern *regexp.Regexp
+	valuePattern *regexp.Regexp
+}
+
+func (o RedactOptions) validate() error {
+	_, err := o.compile()
+	return err
+}
+
+func (o RedactOptions) compile() ([]compiledRule, error) {
+	rules := make([]compiledRule, len(o.Redact))
+	for i, rule := range o.Redact {
+		if rule.Field != "" && rule.FieldPattern != "" {
+			return nil, fmt.Errorf("redact rule %d: field and field-pattern cannot both be set", i)
+		}
+		if rule.Field == "" && rule.FieldPattern == "" && rule.ValuePattern == "" {
+			return nil, fmt.Errorf("redact rule %d: one of field, field-pattern, or value-pattern must be set", i)
+		}
+		if rule.Action != RedactHash && rule.Action != RedactMask && rule.Action != RedactDrop {
+			return nil, fmt.Errorf("redact rule %d: invalid action: %q", i, rule.Action)
+		}
+		rules[i].RedactionRule = rule
+		var err error
+		if rule.FieldPattern != "" {
+			if rules[i].fieldPattern, err = regexp.Compile(rule.FieldPattern); err != nil {
+				return nil, fmt.Errorf("redact rule %d: invalid field-pattern: %w", i, err)
+			}
+		}
+		if rule.ValuePattern != "" {
+			if rules[i].valuePattern, err = regexp.Compile(rule.ValuePattern); err != nil {
+				return nil, fmt.Errorf("redact rule %d: invalid value-pattern: %w", i, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func (r compiledRule) appliesTo(table, field string) bool {
+	if len(r.Tables) > 0 && !containsString(r.Tables, table) {
+		return false
+	}
+	switch {
+	case r.Field != "":
+		return field == r.Field
+	case r.fieldPattern != nil:
+		return r.fieldPattern.MatchString(field)
+	default:
+		return true
+	}
+}
+
+// RedactRecords applies the redaction rules to the records of a table in place, and returns how many field values
+// were redacted.
+func RedactRecords(records []api.Record, table string, opts RedactOptions) (int, error) {
+	if len(opts.Redact) == 0 {
+		return 0, nil
+	}
+	rules, err := opts.compile()
+	if err != nil {
+		return 0, err
+	}
+	redacted := 0
+	for _, record := range records {
+		for field, value := range record.Fields {
+			changed := false
+			for _, rule := range rules {
+				if !rule.appliesTo(table, field) {
+					continue
+				}
+				var matched bool
+				if value, matched, err = rule.redact(value, opts.RedactHashKey); err != nil {
+					return 0, fmt.Errorf("record %s, field %q: %w", record.Id, field, err)
+				}
+				if matched && rule.Action == RedactDrop {
+					delete(record.Fields, field)
+					changed = true
+					break
+				}
+				if matched {
+					record.Fields[field] = value
+					changed = true
+				}
+			}
+			if changed {
+				redacted++
+			}
+		}
+	}
+	return redacted, nil
+}
+
+// redact returns the redacted value, and whether the rule matched it at all.
+func (r compiledRule) redact(value interface{}, hashKey string) (interface{}, bool, error) {
+	if r.valuePattern == nil {
+		text, ok := value.(string)
+		if !ok {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, false, err
+			}
+			text = string(data)
+		}
+		return r.replace(text, hashKey), true, nil
+	}
+	text, ok := value.(string)
+	if !ok || !r.valuePattern.MatchString(text) {
+		return value, false, nil
+	}
+	return r.valuePattern.ReplaceAllStringFunc(text, func(match string) string {
+		return r.replace(match, hashKey)
+	}), true, nil
+}
+
+func (r compiledRule) replace(text, hashKey string) string {
+	switch r.Action {
+	case RedactHash:
+		if hashKey == "" {
+			sum := sha256.Sum256([]byte(text))
+			return "sha256:" + hex.EncodeToString(sum[:])
+		}
+		mac := hmac.New(sha256.New, []byte(hashKey))
+		_, _ = mac.Write([]byte(text))
+		return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+	case RedactMask:
+		return strings.Repeat("*", utf8.RuneCountInString(text))
+	default:
+		return ""
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// redactTable redacts the records of a table, and reports how many values were redacted.
+func redactTable(ctx context.Context, records []api.Record, app, table string, opts RedactOptions) error {
+	redacted, err := RedactRecords(records, table, opts)
+	if err != nil {
+		return fmt.Errorf("app %s -> table %s: %w", app, table, err)
+	}
+	logRedactions(ctx, app, table, redacted)
+	return nil
+}
+
+func logRedactions(ctx context.Context, app, table string, redacted int) {
+	if redacted > 0 {
+		loggerFrom(ctx).Info("Redacted values", "app", app, "table", table, "values", redacted)
+	}
+}
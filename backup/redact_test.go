@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestRedactRecords(t *testing.T) {
+	records := []api.Record{{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{
+		"Name":       "Ada",
+		"SSN":        "123-45-6789",
+		"Phone Home": "555-0100",
+		"Notes":      "write to ada@example.com or ada@example.org",
+		"Age":        float64(36),
+	}}}
+	opts := RedactOptions{Redact: []RedactionRule{
+		{Field: "SSN", Action: RedactDrop},
+		{FieldPattern: "^Phone", Action: RedactMask},
+		{ValuePattern: `[a-z]+@example\.com`, Action: RedactMask},
+		{Field: "Name", Action: RedactHash},
+		{Field: "Age", Action: RedactHash, Tables: []string{"tblBBBBBBBBBBBBBB"}},
+	}}
+	redacted, err := RedactRecords(records, "tblAAAAAAAAAAAAAA", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("Ada"))
+	expected := map[string]interface{}{
+		"Name":       "sha256:" + hex.EncodeToString(sum[:]),
+		"Phone Home": "********",
+		"Notes":      "write to *************** or ada@example.org",
+		"Age":        float64(36),
+	}
+	if !reflect.DeepEqual(records[0].Fields, expected) {
+		t.Errorf("expected %v, got %v", expected, records[0].Fields)
+	}
+	if redacted != 4 {
+		t.Errorf("expected 4 redacted values, got %d", redacted)
+	}
+}
+
+func TestRedactHashKey(t *testing.T) {
+	hash := func(key string) interface{} {
+		records := []api.Record{{Fields: map[string]interface{}{"Name": "Ada"}}}
+		opts := RedactOptions{Redact: []RedactionRule{{Field: "Name", Action: RedactHash}}, RedactHashKey: key}
+		if _, err := RedactRecords(records, "tblAAAAAAAAAAAAAA", opts); err != nil {
+			t.Fatal(err)
+		}
+		return records[0].Fields["Name"]
+	}
+	if hash("one") == hash("two") || hash("one") != hash("one") || hash("") == hash("one") {
+		t.Error("keyed hashes should depend on the key, and only the key")
+	}
+}
+
+func TestRedactOptionsAreValidated(t *testing.T) {
+	for _, rule := range []RedactionRule{
+		{Action: RedactDrop},
+		{Field: "Name", FieldPattern: "Name", Action: RedactDrop},
+		{Field: "Name", Action: "shred"},
+		{FieldPattern: "(", Action: RedactMask},
+		{ValuePattern: "[", Action: RedactMask},
+	} {
+		if err := (RedactOptions{Redact: []RedactionRule{rule}}).validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", rule)
+		}
+	}
+}
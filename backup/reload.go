@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ConfigReloader holds the active configuration of a long-running process and replaces it whenever the process
+// receives SIGHUP. A reloaded configuration that fails to load or validate is rejected, and the previous one stays
+// in effect.
+type ConfigReloader struct {
+	path string
+	// Check, if not nil, must accept a reloaded configuration for it to take effect.
+	Check func(Config) error
+
+	mu      sync.Mutex
+	current Config
+}
+
+func NewConfigReloader(path string) (*ConfigReloader, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigReloader{
+		path:    path,
+		current: config,
+	}, nil
+}
+
+// Current returns the configuration that the next sync should use.
+func (r *ConfigReloader) Current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+func (r *ConfigReloader) Reload() error {
+	config, err := LoadConfig(r.path)
+	if err != nil {
+		return fmt.Errorf("rejected reloaded config %q: %w", r.path, err)
+	}
+	if r.Check != nil {
+		if err := r.Check(config); err != nil {
+			return fmt.Errorf("rejected reloaded config %q: %w", r.path, err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = config
+	return nil
+}
+
+// Watch reloads the configuration for every signal received, until stop is closed. The outcome of each reload is
+// logged, and also passed to reloaded if it is non-nil.
+func (r *ConfigReloader) Watch(signals <-chan os.Signal, stop <-chan struct{}, reloaded func(error)) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-signals:
+			err := r.Reload()
+			if err != nil {
+				slog.Warn("Keeping previous config", "error", err)
+			} else {
+				slog.Info("Reloaded config", "path", r.path)
+			}
+			if reloaded != nil {
+				reloaded(err)
+			}
+		}
+	}
+}
+
+// WatchSIGHUP starts watching for SIGHUP in the background, passing the outcome of each reload to reloaded if it is
+// non-nil. The returned function stops watching.
+func (r *ConfigReloader) WatchSIGHUP(reloaded func(error)) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+	go r.Watch(signals, done, reloaded)
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
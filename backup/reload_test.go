@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestConfigReloadOnSIGHUP(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.json")
+	writeConfig := func(contents string) {
+		if err := os.WriteFile(configPath, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"token": "keyAAAAAAAAAAAAAA", "app-tables": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"]}}`)
+	reloader, err := NewConfigReloader(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan os.Signal)
+	stop := make(chan struct{})
+	results := make(chan error)
+	go reloader.Watch(signals, stop, func(err error) {
+		results <- err
+	})
+	defer close(stop)
+
+	writeConfig(`{"token": "keyAAAAAAAAAAAAAA", "app-tables": {"appBBBBBBBBBBBBBB": ["tblBBBBBBBBBBBBBB"]}}`)
+	signals <- syscall.SIGHUP
+	if err := <-results; err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"}}
+	if !reflect.DeepEqual(reloader.Current().Tables, expected) {
+		t.Errorf("new config should have taken effect: %v", reloader.Current().Tables)
+	}
+
+	writeConfig(`{"token": "keyAAAAAAAAAAAAAA", "app-tables": {"not-an-app": ["tblCCCCCCCCCCCCCC"]}}`)
+	signals <- syscall.SIGHUP
+	if err := <-results; err == nil {
+		t.Fatal("invalid config should have been rejected")
+	}
+	if !reflect.DeepEqual(reloader.Current().Tables, expected) {
+		t.Errorf("previous config should have been kept: %v", reloader.Current().Tables)
+	}
+}
@@ -0,0 +1,396 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+type RestoreOptions struct {
+	// AttachmentBaseURL is where the downloaded attachments are being served from, so that AirTable can fetch them
+	// again. If empty, the original attachment links are used, which only works until AirTable expires them.
+	AttachmentBaseURL string
+	// DownloadPath is the directory the attachments were downloaded into. When it is set, and AttachmentBaseURL is
+	// not, the attachments are uploaded from there through AirTable's content upload API once their records have been
+	// created, so that nothing needs to be served.
+	DownloadPath string
+	// Key decrypts the downloaded attachments, if they were encrypted. If nil, the key is taken from the environment
+	// when it is needed.
+	Key EncryptionKey
+
+	// files maps attachment IDs to the names they were downloaded as.
+	files map[string]string
+}
+
+// writableValue converts a backed-up value into the form AirTable accepts when writing a field of the given inferred
+// type. Attachments that are not shaped like attachments are left out, and ok is false if nothing writable is left.
+func writableValue(value interface{}, fieldType string, opts RestoreOptions) (writable interface{}, ok bool) {
+	switch fieldType {
+	case FieldTypeAttachments:
+		items, _ := value.([]interface{})
+		var attachments []interface{}
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			link := itemMap["url"]
+			if opts.AttachmentBaseURL != "" {
+				file, ok := itemMap["id"].(string)
+				if !ok || file == "" {
+					continue
+				}
+				if named, found := opts.files[file]; found {
+					file = named
+				}
+				link = strings.TrimSuffix(opts.AttachmentBaseURL, "/") + "/" + file
+			}
+			attachment := map[string]interface{}{"url": link}
+			if filename, ok := itemMap["filename"]; ok {
+				attachment["filename"] = filename
+			}
+			attachments = append(attachments, attachment)
+		}
+		return attachments, len(attachments) > 0
+	case FieldTypeCollaborator:
+		collaborator, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		return map[string]interface{}{"email": collaborator["email"]}, true
+	default:
+		return value, true
+	}
+}
+
+// uploading reports whether attachments are uploaded after their records are created, rather than written with them.
+func (o RestoreOptions) uploading() bool {
+	return o.DownloadPath != "" && o.AttachmentBaseURL == ""
+}
+
+// WritableFields converts the fields of a backed-up record into a create payload for a table built by
+// CreateTablesFromDictionary or CreateTablesFromBackup. Fields that were not created in that table, such as linked
+// records, are left out.
+func WritableFields(record api.Record, fields []DictionaryField, opts RestoreOptions) map[string]interface{} {
+	types := map[string]string{}
+	for _, field := range fields {
+		if _, ok := fieldSpecFor(field); ok {
+			types[field.Name] = field.Type
+		}
+	}
+	writable := map[string]interface{}{}
+	for name, value := range record.Fields {
+		fieldType, found := types[name]
+		if !found {
+			continue
+		}
+		// Values that don't match their field, like empty lists, are skipped. Text fields also hold dates, which
+		// are written back as the strings they were read as.
+		if valueType := InferFieldType(value); valueType != fieldType && fieldType != FieldTypeText {
+			continue
+		}
+		if fieldType == FieldTypeAttachments && opts.uploading() {
+			continue
+		}
+		if converted, ok := writableValue(value, fieldType, opts); ok {
+			writable[name] = converted
+		}
+	}
+	return writable
+}
+
+// RestoredIdField is the field added to every restored table to hold the ID each record had in the backup. Records
+// are written by upserting on it, so that a write that is retried after a transient failure cannot duplicate them.
+const RestoredIdField = "Backed-up record ID"
+
+// Restore recreates the tables of a backup in the Clerk's base and creates their records there. If the backup has the
+// schema of its base, the tables are recreated from it, and the linked record fields are then recreated too, linking
+// the restored records to each other. Each restored table has an extra RestoredIdField. It
+// returns the mapping from original table IDs to restored table IDs, as far as it got.
+func Restore(ctx context.Context, clerk *api.Clerk, backup *Backup, opts RestoreOptions) (map[string]string, error) {
+	// typecasting fills in the choices of select fields, which are created empty; the caller's Clerk is left as it was
+	typecasting := *clerk
+	typecasting.Typecast = true
+	clerk = &typecasting
+	opts.files = map[string]string{}
+	for _, attachment := range backup.Attachments {
+		if attachment.File != "" {
+			opts.files[attachment.Id] = attachment.File
+		}
+	}
+	tableIds, dictionary, err := createTablesFromBackup(ctx, clerk, backup,
+		[]api.FieldSpec{{Name: RestoredIdField, Type: "singleLineText"}})
+	if err != nil {
+		return tableIds, err
+	}
+	// original record IDs to restored record IDs, across all tables
+	recordIds := map[string]string{}
+	for _, table := range dictionary.sortedTables() {
+		var payloads []map[string]interface{}
+		for _, record := range backup.Tables[table] {
+			payload := WritableFields(record, dictionary[table], opts)
+			payload[RestoredIdField] = record.Id
+			payloads = append(payloads, payload)
+		}
+		created, err := clerk.UpsertAllRecords(ctx, tableIds[table], []string{RestoredIdField}, payloads)
+		if err != nil {
+			return tableIds, fmt.Errorf("restoring table %s after %d of %d records: %w",
+				table, len(created), len(payloads), err)
+		}
+		if len(created) != len(payloads) {
+			return tableIds, fmt.Errorf("restoring table %s: %d records were created for %d backed-up records",
+				table, len(created), len(payloads))
+		}
+		loggerFrom(ctx).Info("Restored records", "table", table, "records", len(created), "into", tableIds[table])
+		for i, record := range backup.Tables[table] {
+			recordIds[record.Id] = created[i].Id
+		}
+		if !opts.uploading() {
+			continue
+		}
+		// records are upserted in the order they were given
+		for i, record := range backup.Tables[table] {
+			if err := uploadAttachments(ctx, clerk, created[i].Id, record, dictionary[table], opts); err != nil {
+				return tableIds, fmt.Errorf("restoring the attachments of record %s in table %s: %w", record.Id,
+					table, err)
+			}
+		}
+	}
+	return tableIds, restoreLinks(ctx, clerk, backup, tableIds, recordIds)
+}
+
+// linkField is a linked record field of a backed-up table.
+type linkField struct {
+	table       string
+	field       api.FieldSchema
+	linkedTable string
+	inverse     string
+}
+
+// linkFields lists the linked record fields of the backed-up tables, by the backed-up schema.
+func (b *Backup) linkFields() []linkField {
+	var fields []linkField
+	for _, schema := range b.Schemas {
+		for _, table := range schema.Tables {
+			if _, found := b.Tables[table.Id]; !found {
+				continue
+			}
+			for _, field := range table.Fields {
+				if field.Type != "multipleRecordLinks" {
+					continue
+				}
+				linkedTable, _ := field.Options["linkedTableId"].(string)
+				inverse, _ := field.Options["inverseLinkFieldId"].(string)
+				fields = append(fields, linkField{table: table.Id, field: field, linkedTable: linkedTable,
+					inverse: inverse})
+			}
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].table != fields[j].table {
+			return fields[i].table < fields[j].table
+		}
+		return fields[i].field.Id < fields[j].field.Id
+	})
+	return fields
+}
+
+// restoreLinks recreates the linked record fields of the restored tables, and fills them in with the restored IDs of
+// the records that were linked. Of two fields that link to each other, only one is created: AirTable creates the
+// other alongside it, and fills it in to match.
+func restoreLinks(ctx context.Context, clerk *api.Clerk, backup *Backup, tableIds, recordIds map[string]string) error {
+	if len(backup.Schemas) == 0 {
+		loggerFrom(ctx).Warn("Backup has no schema; linked records cannot be restored")
+		return nil
+	}
+	// the fields created as the inverses of other fields
+	inverses := map[string]bool{}
+	for _, link := range backup.linkFields() {
+		if inverses[link.field.Id] {
+			continue
+		}
+		if _, found := tableIds[link.linkedTable]; !found {
+			loggerFrom(ctx).Warn("Linked table was not restored; skipping field", "table", link.table,
+				"field", link.field.Name, "linked-table", link.linkedTable)
+			continue
+		}
+		created, err := clerk.CreateField(ctx, tableIds[link.table], api.FieldSpec{
+			Name:    link.field.Name,
+			Type:    "multipleRecordLinks",
+			Options: map[string]interface{}{"linkedTableId": tableIds[link.linkedTable]},
+		})
+		if err != nil {
+			return fmt.Errorf("creating linked record field %s in table %s: %w", link.field.Name, link.table, err)
+		}
+		if link.inverse != "" && link.linkedTable != link.table {
+			if err := restoreInverse(ctx, clerk, backup, link, created, tableIds); err != nil {
+				return err
+			}
+			inverses[link.inverse] = true
+		}
+		var updates []api.Record
+		missing := 0
+		for _, record := range backup.Tables[link.table] {
+			linked, ok := record.Fields[link.field.Name].([]interface{})
+			if !ok {
+				continue
+			}
+			var ids []string
+			for _, item := range linked {
+				id, _ := item.(string)
+				if restored, found := recordIds[id]; found {
+					ids = append(ids, restored)
+				} else {
+					missing++
+				}
+			}
+			if len(ids) > 0 {
+				updates = append(updates, api.Record{Id: recordIds[record.Id],
+					Fields: map[string]interface{}{link.field.Name: ids}})
+			}
+		}
+		if _, err := clerk.UpdateAllRecords(ctx, tableIds[link.table], updates); err != nil {
+			return fmt.Errorf("restoring linked records of field %s in table %s: %w", link.field.Name, link.table,
+				err)
+		}
+		if missing > 0 {
+			// such as records of a table listed from a view, which only backed up some of the records
+			loggerFrom(ctx).Warn("Links to records that were not restored were left out", "table", link.table,
+				"field", link.field.Name, "links", missing)
+		}
+		loggerFrom(ctx).Info("Restored linked records", "table", link.table, "field", link.field.Name,
+			"records", len(updates))
+	}
+	return nil
+}
+
+// restoreInverse gives the inverse field that AirTable created alongside a linked record field the name of the
+// backed-up field it stands for.
+func restoreInverse(ctx context.Context, clerk *api.Clerk, backup *Backup, link linkField, created *api.FieldSchema,
+	tableIds map[string]string) error {
+	inverseId, _ := created.Options["inverseLinkFieldId"].(string)
+	if inverseId == "" {
+		return nil
+	}
+	for _, schema := range backup.Schemas {
+		for _, table := range schema.Tables {
+			if table.Id != link.linkedTable {
+				continue
+			}
+			for _, field := range table.Fields {
+				if field.Id == link.inverse {
+					if err := clerk.RenameField(ctx, tableIds[table.Id], inverseId, field.Name); err != nil {
+						return fmt.Errorf("renaming linked record field %s in table %s: %w", field.Name, table.Id,
+							err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// errUploadTooLarge is returned for attachments too large for the content upload API.
+var errUploadTooLarge = fmt.Errorf("larger than the %d bytes that can be uploaded", api.MaxUploadSize)
+
+// uploadAttachments uploads the downloaded attachments of a backed-up record into the record restored from it.
+// Attachments that were not downloaded, or are too large to upload, are left out with a warning.
+func uploadAttachments(ctx context.Context, clerk *api.Clerk, restored string, record api.Record,
+	fields []DictionaryField, opts RestoreOptions) error {
+	for _, field := range fields {
+		if field.Type != FieldTypeAttachments {
+			continue
+		}
+		items, ok := record.Fields[field.Name].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := itemMap["id"].(string)
+			upload, err := loadUpload(itemMap, opts)
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, errUploadTooLarge) {
+				loggerFrom(ctx).Warn("Attachment not restored", "record", record.Id, "field", field.Name,
+					"attachment", id, "error", err)
+				continue
+			} else if err != nil {
+				return fmt.Errorf("attachment %s: %w", id, err)
+			}
+			if _, err := clerk.UploadAttachment(ctx, restored, field.Name, upload); err != nil {
+				return fmt.Errorf("uploading attachment %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadUpload reads a downloaded attachment, described by its value in a backed-up record.
+func loadUpload(attachment map[string]interface{}, opts RestoreOptions) (api.Upload, error) {
+	id, _ := attachment["id"].(string)
+	file := id
+	if named, found := opts.files[id]; found {
+		file = named
+	}
+	f, err := os.Open(path.Join(opts.DownloadPath, file))
+	if err != nil {
+		return api.Upload{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	plaintext, err := openMaybeEncrypted(f, opts.Key)
+	if err != nil {
+		return api.Upload{}, err
+	}
+	data, err := io.ReadAll(io.LimitReader(plaintext, api.MaxUploadSize+1))
+	if err != nil {
+		return api.Upload{}, err
+	}
+	if len(data) > api.MaxUploadSize {
+		return api.Upload{}, errUploadTooLarge
+	}
+	upload := api.Upload{File: data}
+	upload.Filename, _ = attachment["filename"].(string)
+	if upload.Filename == "" {
+		upload.Filename = id
+	}
+	if upload.ContentType, _ = attachment["type"].(string); upload.ContentType == "" {
+		upload.ContentType = http.DetectContentType(data)
+	}
+	return upload, nil
+}
+
+// RestoreConfigFile restores the backup at backupPath into the app targetApp, using the token and API settings from
+// the configuration at configPath.
+func RestoreConfigFile(ctx context.Context, configPath, backupPath, targetApp string, opts RestoreOptions) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if opts.Key == nil {
+		if opts.Key, err = config.Key(); err != nil {
+			return err
+		}
+	}
+	backup, err := LoadWithKey(backupPath, opts.Key)
+	if err != nil {
+		return err
+	}
+	client, err := config.HTTP.Client()
+	if err != nil {
+		return err
+	}
+	_, err = Restore(ctx, api.NewClerk(targetApp, config.ClerkConfig(targetApp), client), backup, opts)
+	return err
+}
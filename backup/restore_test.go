@@ -0,0 +1,404 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestRestore(t *testing.T) {
+	var written []map[string]interface{}
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v0/meta/bases/appNNNNNNNNNNNNNN/tables":
+			_, _ = w.Write([]byte(`{"id": "tblNNNNNNNNNNNNNN", "name": "x", "primaryFieldId": "fldNNNNNNNNNNNNNN", "fields": []}`))
+		case "/v0/appNNNNNNNNNNNNNN/tblNNNNNNNNNNNNNN":
+			var request struct {
+				Records []struct {
+					Fields map[string]interface{} `json:"fields"`
+				} `json:"records"`
+				Typecast bool `json:"typecast"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Fatal(err)
+			}
+			if !request.Typecast {
+				t.Error("restored records should be typecast")
+			}
+			var reply api.WriteRecordsReply
+			for _, record := range request.Records {
+				written = append(written, record.Fields)
+				reply.Records = append(reply.Records, api.Record{Id: "recNNNNNNNNNNNNNN", Fields: record.Fields})
+			}
+			_ = json.NewEncoder(w).Encode(reply)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {{
+				Id: "recAAAAAAAAAAAAAA",
+				Fields: map[string]interface{}{
+					"Name":  "Widget",
+					"Links": []interface{}{"recBBBBBBBBBBBBBB"},
+					"Files": []interface{}{map[string]interface{}{
+						"id": "attAAAAAAAAAAAAAA", "url": DefaultAttachmentPrefixes[0] + "a", "size": 11.0, "filename": "a.txt",
+					}},
+					"Owner": map[string]interface{}{"id": "usrAAAAAAAAAAAAAA", "email": "a@example.com", "name": "A"},
+				},
+			}},
+		},
+	}
+	clerk := api.NewClerk("appNNNNNNNNNNNNNN", api.Config{BearerToken: testToken}, client)
+	tableIds, err := Restore(context.Background(), clerk, backup, RestoreOptions{AttachmentBaseURL: "https://files.example/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tableIds["tblAAAAAAAAAAAAAA"] != "tblNNNNNNNNNNNNNN" {
+		t.Errorf("unexpected table mapping: %v", tableIds)
+	}
+	if clerk.Typecast {
+		t.Error("restoring should not change the caller's Clerk")
+	}
+	expected := []map[string]interface{}{{
+		"Name": "Widget",
+		"Files": []interface{}{
+			map[string]interface{}{"url": "https://files.example/attAAAAAAAAAAAAAA", "filename": "a.txt"},
+		},
+		"Owner":         map[string]interface{}{"email": "a@example.com"},
+		RestoredIdField: "recAAAAAAAAAAAAAA",
+	}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("unexpected restored records:\n%v\nexpected:\n%v", written, expected)
+	}
+}
+
+func TestRestoreRejectsMissingCreatedRecords(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v0/meta/bases/appNNNNNNNNNNNNNN/tables":
+			_, _ = w.Write([]byte(`{"id": "tblNNNNNNNNNNNNNN", "name": "x", "primaryFieldId": "fldNNNNNNNNNNNNNN", "fields": []}`))
+		case "/v0/appNNNNNNNNNNNNNN/tblNNNNNNNNNNNNNN":
+			// only the first record of the batch is reported back
+			_, _ = w.Write([]byte(`{"records": [{"id": "recNNNNNNNNNNNNNN", "fields": {}}]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "Widget"}},
+				{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "Gadget"}},
+			},
+		},
+	}
+	clerk := api.NewClerk("appNNNNNNNNNNNNNN", api.Config{BearerToken: testToken}, client)
+	_, err := Restore(context.Background(), clerk, backup, RestoreOptions{})
+	if err == nil || !strings.Contains(err.Error(), "1 records were created for 2") {
+		t.Errorf("expected an error about the missing record, not %v", err)
+	}
+}
+
+func TestRestoreRetriesWritesWithoutDuplicating(t *testing.T) {
+	var attempts []string
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v0/meta/bases/appNNNNNNNNNNNNNN/tables":
+			_, _ = w.Write([]byte(`{"id": "tblNNNNNNNNNNNNNN", "name": "x", "primaryFieldId": "fldNNNNNNNNNNNNNN", "fields": []}`))
+		case "/v0/appNNNNNNNNNNNNNN/tblNNNNNNNNNNNNNN":
+			var request struct {
+				Records []struct {
+					Fields map[string]interface{} `json:"fields"`
+				} `json:"records"`
+				PerformUpsert struct {
+					FieldsToMergeOn []string `json:"fieldsToMergeOn"`
+				} `json:"performUpsert"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Fatal(err)
+			}
+			attempts = append(attempts, fmt.Sprintf("%s %v %v", r.Method, request.PerformUpsert.FieldsToMergeOn,
+				request.Records[0].Fields[RestoredIdField]))
+			if len(attempts) == 1 {
+				// as if the write was applied, but the reply was lost
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte(`{"records": [{"id": "recNNNNNNNNNNNNNN", "fields": {}}]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	backup := &Backup{Tables: map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "Widget"}}},
+	}}
+	config := api.Config{BearerToken: testToken, Retries: 1, Clock: clock.NewFake(time.Time{})}
+	if _, err := Restore(context.Background(), api.NewClerk("appNNNNNNNNNNNNNN", config, client), backup,
+		RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// repeating the upsert matches the record the lost attempt created, rather than creating another
+	expected := []string{"PATCH [Backed-up record ID] recAAAAAAAAAAAAAA", "PATCH [Backed-up record ID] recAAAAAAAAAAAAAA"}
+	if !reflect.DeepEqual(attempts, expected) {
+		t.Errorf("expected the write to be retried as the same upsert, found %q", attempts)
+	}
+}
+
+func TestWritableFieldsSkipsMalformedAttachments(t *testing.T) {
+	record := api.Record{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{
+		"Files": []interface{}{
+			map[string]interface{}{"id": nil, "url": DefaultAttachmentPrefixes[0] + "a", "size": 11.0, "filename": "a.txt"},
+			map[string]interface{}{"id": "attBBBBBBBBBBBBBB", "url": DefaultAttachmentPrefixes[0] + "b", "size": 5.0,
+				"filename": "b.txt"},
+		},
+		"Nothing": []interface{}{
+			map[string]interface{}{"id": 7.0, "url": DefaultAttachmentPrefixes[0] + "c", "size": 1.0, "filename": "c"},
+		},
+	}}
+	fields := []DictionaryField{{Name: "Files", Type: FieldTypeAttachments}, {Name: "Nothing", Type: FieldTypeAttachments}}
+	writable := WritableFields(record, fields, RestoreOptions{AttachmentBaseURL: "https://files.example"})
+	expected := map[string]interface{}{
+		"Files": []interface{}{map[string]interface{}{"url": "https://files.example/attBBBBBBBBBBBBBB", "filename": "b.txt"}},
+	}
+	if !reflect.DeepEqual(writable, expected) {
+		t.Errorf("unexpected writable fields:\n%v\nexpected:\n%v", writable, expected)
+	}
+}
+
+func TestRestoreUploadsAttachments(t *testing.T) {
+	var written []map[string]interface{}
+	var uploads []api.Upload
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v0/meta/bases/appNNNNNNNNNNNNNN/tables":
+			_, _ = w.Write([]byte(`{"id": "tblNNNNNNNNNNNNNN", "name": "x", "primaryFieldId": "fldNNNNNNNNNNNNNN", "fields": []}`))
+		case "/v0/appNNNNNNNNNNNNNN/tblNNNNNNNNNNNNNN":
+			var request struct {
+				Records []struct {
+					Fields map[string]interface{} `json:"fields"`
+				} `json:"records"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Fatal(err)
+			}
+			written = append(written, request.Records[0].Fields)
+			_, _ = w.Write([]byte(`{"records": [{"id": "recNNNNNNNNNNNNNN", "fields": {}}]}`))
+		case "/v0/appNNNNNNNNNNNNNN/recNNNNNNNNNNNNNN/Files/uploadAttachment":
+			if r.Host != "content.airtable.com" {
+				t.Errorf("uploads should go to the content API, not %s", r.Host)
+			}
+			var upload api.Upload
+			if err := json.NewDecoder(r.Body).Decode(&upload); err != nil {
+				t.Fatal(err)
+			}
+			uploads = append(uploads, upload)
+			_, _ = w.Write([]byte(`{"id": "recNNNNNNNNNNNNNN", "fields": {}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {{
+				Id: "recAAAAAAAAAAAAAA",
+				Fields: map[string]interface{}{
+					"Name": "Widget",
+					"Files": []interface{}{
+						map[string]interface{}{"id": "attAAAAAAAAAAAAAA", "url": DefaultAttachmentPrefixes[0] + "a",
+							"size": 5.0, "filename": "a.txt", "type": "text/plain"},
+						map[string]interface{}{"id": "attBBBBBBBBBBBBBB", "url": DefaultAttachmentPrefixes[0] + "b",
+							"size": 5.0, "filename": "missing.txt"},
+					},
+				},
+			}},
+		},
+		Attachments: []Attachment{{Id: "attAAAAAAAAAAAAAA", File: "attAAAAAAAAAAAAAA_a.txt"}},
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "attAAAAAAAAAAAAAA_a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	clerk := api.NewClerk("appNNNNNNNNNNNNNN", api.Config{BearerToken: testToken}, client)
+	if _, err := Restore(context.Background(), clerk, backup, RestoreOptions{DownloadPath: dir}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{{"Name": "Widget", RestoredIdField: "recAAAAAAAAAAAAAA"}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("attachments should be uploaded after the records are created, not with them: %v", written)
+	}
+	expectedUploads := []api.Upload{{ContentType: "text/plain", File: []byte("hello"), Filename: "a.txt"}}
+	if !reflect.DeepEqual(uploads, expectedUploads) {
+		t.Errorf("expected only the downloaded attachment to be uploaded, found %v", uploads)
+	}
+}
+
+func TestRestoreRemapsLinkedRecords(t *testing.T) {
+	var fieldsCreated []api.FieldSpec
+	var renamed, updated []string
+	created := 0
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v0/")
+		switch {
+		case path == "meta/bases/appNNNNNNNNNNNNNN/tables":
+			var spec api.TableSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				t.Fatal(err)
+			}
+			_ = json.NewEncoder(w).Encode(api.CreatedTable{Id: "tblN" + spec.Name[4:]})
+		case strings.HasSuffix(path, "/fields"):
+			var spec api.FieldSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				t.Fatal(err)
+			}
+			fieldsCreated = append(fieldsCreated, spec)
+			_, _ = w.Write([]byte(`{"id": "fldNEWNEWNEWNEWNE", "name": "Project", "type": "multipleRecordLinks",
+				"options": {"linkedTableId": "tblNBBBBBBBBBBBBB", "inverseLinkFieldId": "fldINVINVINVINVIN"}}`))
+		case r.Method == http.MethodPatch && strings.HasPrefix(path, "meta/"):
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			renamed = append(renamed, path+" "+body["name"])
+			_, _ = w.Write([]byte(`{}`))
+		case r.Method == http.MethodPatch:
+			var request struct {
+				Records       []api.Record `json:"records"`
+				PerformUpsert interface{}  `json:"performUpsert"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Fatal(err)
+			}
+			var reply api.WriteRecordsReply
+			for _, record := range request.Records {
+				if request.PerformUpsert != nil {
+					created++
+					reply.Records = append(reply.Records, api.Record{Id: fmt.Sprintf("rec%014d", created)})
+					continue
+				}
+				updated = append(updated, fmt.Sprintf("%s %s %v", path, record.Id, record.Fields["Project"]))
+				reply.Records = append(reply.Records, record)
+			}
+			_ = json.NewEncoder(w).Encode(reply)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAA1", Fields: map[string]interface{}{
+				"Name": "Task", "Project": []interface{}{"recBBBBBBBBBBBBB1", "recCCCCCCCCCCCCCC"},
+			}}},
+			"tblBBBBBBBBBBBBBB": {
+				{Id: "recBBBBBBBBBBBBB1", Fields: map[string]interface{}{
+					"Name": "Project", "Tasks": []interface{}{"recAAAAAAAAAAAAA1"},
+				}},
+				{Id: "recBBBBBBBBBBBBB2", Fields: map[string]interface{}{"Name": "Empty"}},
+			},
+		},
+		Schemas: map[string]*api.BaseSchema{"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{
+			{Id: "tblAAAAAAAAAAAAAA", Fields: []api.FieldSchema{{Id: "fldAAAAAAAAAAAAAA", Name: "Project",
+				Type: "multipleRecordLinks", Options: map[string]interface{}{
+					"linkedTableId": "tblBBBBBBBBBBBBBB", "inverseLinkFieldId": "fldBBBBBBBBBBBBBB",
+				}}}},
+			{Id: "tblBBBBBBBBBBBBBB", Fields: []api.FieldSchema{{Id: "fldBBBBBBBBBBBBBB", Name: "Tasks",
+				Type: "multipleRecordLinks", Options: map[string]interface{}{
+					"linkedTableId": "tblAAAAAAAAAAAAAA", "inverseLinkFieldId": "fldAAAAAAAAAAAAAA",
+				}}}},
+		}}},
+	}
+	clerk := api.NewClerk("appNNNNNNNNNNNNNN", api.Config{BearerToken: testToken}, client)
+	if _, err := Restore(context.Background(), clerk, backup, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectedFields := []api.FieldSpec{{Name: "Project", Type: "multipleRecordLinks",
+		Options: map[string]interface{}{"linkedTableId": "tblNBBBBBBBBBBBBB"}}}
+	if !reflect.DeepEqual(fieldsCreated, expectedFields) {
+		t.Errorf("expected only one side of the link to be created, found %v", fieldsCreated)
+	}
+	expectedRenames := []string{"meta/bases/appNNNNNNNNNNNNNN/tables/tblNBBBBBBBBBBBBB/fields/fldINVINVINVINVIN Tasks"}
+	if !reflect.DeepEqual(renamed, expectedRenames) {
+		t.Errorf("expected the inverse field to be renamed, found %v", renamed)
+	}
+	expectedUpdates := []string{"appNNNNNNNNNNNNNN/tblNAAAAAAAAAAAAA rec00000000000001 [rec00000000000002]"}
+	if !reflect.DeepEqual(updated, expectedUpdates) {
+		t.Errorf("expected the links to be remapped to the restored records, found %v", updated)
+	}
+}
+
+func TestRestoreCreatesTablesFromSchema(t *testing.T) {
+	var specs []api.TableSpec
+	var written []map[string]interface{}
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v0/meta/bases/appNNNNNNNNNNNNNN/tables":
+			var spec api.TableSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				t.Fatal(err)
+			}
+			specs = append(specs, spec)
+			_ = json.NewEncoder(w).Encode(api.CreatedTable{Id: "tblNNNNNNNNNNNNNN"})
+		case "/v0/appNNNNNNNNNNNNNN/tblNNNNNNNNNNNNNN":
+			var request struct {
+				Records []api.Record `json:"records"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Fatal(err)
+			}
+			var reply api.WriteRecordsReply
+			for _, record := range request.Records {
+				written = append(written, record.Fields)
+				reply.Records = append(reply.Records, api.Record{Id: "recNNNNNNNNNNNNNN"})
+			}
+			_ = json.NewEncoder(w).Encode(reply)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	backup := &Backup{
+		Tables: map[string][]api.Record{"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{
+			"Title": "Widget", "Status": "Done", "Total": 3.0,
+		}}}},
+		Schemas: map[string]*api.BaseSchema{"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{
+			Id: "tblAAAAAAAAAAAAAA", PrimaryFieldId: "fldAAAAAAAAAAAAA1", Fields: []api.FieldSchema{
+				{Id: "fldAAAAAAAAAAAAA2", Name: "Status", Type: "singleSelect", Options: map[string]interface{}{
+					"choices": []interface{}{map[string]interface{}{"id": "selAAAAAAAAAAAAAA", "name": "Done",
+						"color": "greenLight2"}},
+				}},
+				{Id: "fldAAAAAAAAAAAAA1", Name: "Title", Type: "singleLineText"},
+				{Id: "fldAAAAAAAAAAAAA3", Name: "Total", Type: "formula", Options: map[string]interface{}{
+					"formula": "1 + 2",
+				}},
+			},
+		}}}},
+	}
+	clerk := api.NewClerk("appNNNNNNNNNNNNNN", api.Config{BearerToken: testToken}, client)
+	if _, err := Restore(context.Background(), clerk, backup, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectedSpecs := []api.TableSpec{{Name: "tblAAAAAAAAAAAAAA", Fields: []api.FieldSpec{
+		{Name: "Title", Type: "singleLineText"},
+		{Name: "Status", Type: "singleSelect", Options: map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"name": "Done", "color": "greenLight2"}},
+		}},
+		{Name: RestoredIdField, Type: "singleLineText"},
+	}}}
+	if !reflect.DeepEqual(specs, expectedSpecs) {
+		t.Errorf("expected the table to be created from its schema, found %+v", specs)
+	}
+	expected := []map[string]interface{}{{"Title": "Widget", "Status": "Done", RestoredIdField: "recAAAAAAAAAAAAAA"}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected only the created fields to be restored, found %v", written)
+	}
+}
@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// RetentionPolicy decides which backups in a catalog are kept when old snapshots are pruned. Each count keeps the
+// newest backup in each of that many of the most recent days, weeks, months, or years that have a backup, and Last
+// keeps the newest backups outright. A backup kept by any rule is kept, and the newest backup is always kept. A policy
+// with every count at zero disables pruning.
+type RetentionPolicy struct {
+	Last    int `json:"last,omitempty"`
+	Daily   int `json:"daily,omitempty"`
+	Weekly  int `json:"weekly,omitempty"`
+	Monthly int `json:"monthly,omitempty"`
+	Yearly  int `json:"yearly,omitempty"`
+}
+
+// Enabled reports whether the policy prunes anything, and therefore whether backups are written as snapshots.
+func (p RetentionPolicy) Enabled() bool {
+	return p != RetentionPolicy{}
+}
+
+// ParseRetentionPolicy parses a policy like "daily=7,weekly=4,monthly=12".
+func ParseRetentionPolicy(spec string) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	fields := map[string]*int{
+		"last":    &policy.Last,
+		"daily":   &policy.Daily,
+		"weekly":  &policy.Weekly,
+		"monthly": &policy.Monthly,
+		"yearly":  &policy.Yearly,
+	}
+	for _, rule := range ParseList(spec) {
+		name, value, found := strings.Cut(rule, "=")
+		field := fields[strings.TrimSpace(name)]
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		if !found || field == nil || err != nil || count < 0 {
+			return RetentionPolicy{}, fmt.Errorf("invalid retention rule %q: expected one of last, daily, weekly, "+
+				"monthly, or yearly, followed by =count", rule)
+		}
+		*field = count
+	}
+	return policy, nil
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.Last < 0 || p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 || p.Yearly < 0 {
+		return fmt.Errorf("invalid retention policy %+v: counts cannot be negative", p)
+	}
+	return nil
+}
+
+// retentionRule keeps the newest backup in each of the count most recent periods that have a backup.
+type retentionRule struct {
+	count  int
+	period func(t time.Time) string
+}
+
+func (p RetentionPolicy) rules() []retentionRule {
+	return []retentionRule{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// Select splits the backups of a catalog into those that the policy keeps and those that it prunes, both oldest
+// first.
+func (p RetentionPolicy) Select(backups []CatalogEntry) (keep, prune []CatalogEntry) {
+	if !p.Enabled() {
+		return backups, nil
+	}
+	newestFirst := make([]int, len(backups))
+	for i := range newestFirst {
+		newestFirst[i] = i
+	}
+	sort.SliceStable(newestFirst, func(i, j int) bool {
+		return backups[newestFirst[i]].Timestamp.After(backups[newestFirst[j]].Timestamp)
+	})
+	kept := make([]bool, len(backups))
+	for i, index := range newestFirst {
+		if i == 0 || i < p.Last {
+			kept[index] = true
+		}
+	}
+	for _, rule := range p.rules() {
+		remaining, last := rule.count, ""
+		for _, index := range newestFirst {
+			if remaining == 0 {
+				break
+			}
+			if period := rule.period(backups[index].Timestamp.UTC()); period != last {
+				kept[index] = true
+				remaining--
+				last = period
+			}
+		}
+	}
+	for i, entry := range backups {
+		if kept[i] {
+			keep = append(keep, entry)
+		} else {
+			prune = append(prune, entry)
+		}
+	}
+	return keep, prune
+}
+
+// snapshotName inserts a timestamp into the name of a backup, before its extension, so that each run writes a new
+// snapshot: "backup.json" becomes "backup-20240102T030405Z.json".
+func snapshotName(name string, timestamp time.Time) string {
+	stamp := "-" + timestamp.UTC().Format("20060102T150405Z")
+	if dot := strings.Index(name, "."); dot > 0 {
+		return name[:dot] + stamp + name[dot:]
+	}
+	return name + stamp
+}
+
+// pruneBackups deletes the backups in the catalog of a Storage that the policy does not keep, and removes them from
+// the catalog. Backups whose files cannot be deleted stay in the catalog, to be tried again next time.
+func pruneBackups(ctx context.Context, st Storage, policy RetentionPolicy) error {
+	catalog, err := loadCatalog(ctx, st)
+	if err != nil {
+		return err
+	}
+	keep, prune := policy.Select(catalog.Backups)
+	if len(prune) == 0 {
+		return nil
+	}
+	var allErrors error
+	for _, entry := range prune {
+		// the backup itself goes last, so that a failed prune can be retried from the catalog
+		var err error
+		for _, file := range entry.Files {
+			if err = st.Delete(ctx, path.Join(path.Dir(entry.Path), file)); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = st.Delete(ctx, entry.Path)
+		}
+		if err != nil {
+			allErrors = multierror.Append(allErrors, err)
+			keep = append(keep, entry)
+			continue
+		}
+		loggerFrom(ctx).Info("Pruned backup", "location", st.Location(entry.Path), "timestamp", entry.Timestamp)
+	}
+	sort.SliceStable(keep, func(i, j int) bool {
+		return keep[i].Timestamp.Before(keep[j].Timestamp)
+	})
+	catalog.Backups = keep
+	if err := catalog.save(ctx, st); err != nil {
+		allErrors = multierror.Append(allErrors, err)
+	}
+	return allErrors
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	policy, err := ParseRetentionPolicy("daily=7, weekly=4,monthly=12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (RetentionPolicy{Daily: 7, Weekly: 4, Monthly: 12}); policy != expected {
+		t.Errorf("expected %+v, got %+v", expected, policy)
+	}
+	if policy, err := ParseRetentionPolicy(""); err != nil || policy.Enabled() {
+		t.Errorf("an empty policy should disable pruning: %+v %v", policy, err)
+	}
+	for _, spec := range []string{"daily", "hourly=3", "weekly=-1", "monthly=x"} {
+		if _, err := ParseRetentionPolicy(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestRetentionPolicySelect(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var backups []CatalogEntry
+	// two backups a day, for 60 days
+	for day := 0; day < 60; day++ {
+		for _, hour := range []int{0, 6} {
+			timestamp := start.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+			backups = append(backups, CatalogEntry{Timestamp: timestamp, Path: timestamp.Format(time.RFC3339)})
+		}
+	}
+	keep, prune := RetentionPolicy{Daily: 3, Monthly: 2}.Select(backups)
+	var kept []string
+	for _, entry := range keep {
+		kept = append(kept, entry.Path)
+	}
+	expected := []string{
+		// the newest of January, which also covers the first of the two months
+		"2024-01-31T18:00:00Z",
+		// the newest of each of the last three days, the last of which is also the newest of February
+		"2024-02-27T18:00:00Z",
+		"2024-02-28T18:00:00Z",
+		"2024-02-29T18:00:00Z",
+	}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("expected to keep %v, kept %v", expected, kept)
+	}
+	if len(keep)+len(prune) != len(backups) {
+		t.Errorf("every backup should be either kept or pruned")
+	}
+	if keep, _ := (RetentionPolicy{Last: 1}).Select(backups[:1]); len(keep) != 1 {
+		t.Error("the only backup should be kept")
+	}
+}
+
+func TestRunPrunesSnapshots(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	fakeClock := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	config := Config{
+		Config:     api.Config{BearerToken: testToken, Clock: fakeClock},
+		Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		SkipSchema: true,
+		Retain:     RetentionPolicy{Daily: 2},
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, "backup.json"), DownloadPath: downloadDir}
+	for i := 0; i < 4; i++ {
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		fakeClock.Advance(24 * time.Hour)
+	}
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, entry := range catalog.Backups {
+		paths = append(paths, entry.Path)
+	}
+	expected := []string{"backup-20240103T000000Z.json", "backup-20240104T000000Z.json"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected the catalog to list %v, got %v", expected, paths)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if expected := append(expected, CatalogFilename, "dl"); !reflect.DeepEqual(names, expected) {
+		t.Errorf("pruned snapshots should have been deleted, found %v", names)
+	}
+}
@@ -0,0 +1,140 @@
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron-style schedule: five fields for the minute, hour, day of the month, month, and day of the week,
+// each of which may be "*", a number, a range like "1-5", a step like "*/15" or "0-30/10", or a comma-separated list
+// of these. As in cron, when both the day of the month and the day of the week are restricted, a day matching either
+// one is enough. Sunday is both 0 and 7. The shorthands @hourly, @daily, @weekly, @monthly, and @yearly are also
+// accepted. Times are matched in the location of the time passed to Next.
+type Schedule struct {
+	spec                                   string
+	minutes, hours, days, months, weekdays []bool
+	anyDay, anyWeekday                     bool
+}
+
+var scheduleShorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+func ParseSchedule(spec string) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if shorthand, found := scheduleShorthands[expanded]; found {
+		expanded = shorthand
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected five fields (minute hour day month weekday)", spec)
+	}
+	s := &Schedule{spec: spec}
+	var err error
+	if s.minutes, _, err = parseScheduleField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute in schedule %q: %w", spec, err)
+	}
+	if s.hours, _, err = parseScheduleField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour in schedule %q: %w", spec, err)
+	}
+	if s.days, s.anyDay, err = parseScheduleField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of the month in schedule %q: %w", spec, err)
+	}
+	if s.months, _, err = parseScheduleField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month in schedule %q: %w", spec, err)
+	}
+	if s.weekdays, s.anyWeekday, err = parseScheduleField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of the week in schedule %q: %w", spec, err)
+	}
+	if s.weekdays[7] {
+		s.weekdays[0] = true
+	}
+	return s, nil
+}
+
+// parseScheduleField returns the values that a field matches, indexed by value, and whether it is a bare "*".
+func parseScheduleField(field string, min, max int) ([]bool, bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, false, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(lowPart); err != nil {
+				return nil, false, fmt.Errorf("invalid value %q", part)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highPart); err != nil {
+					return nil, false, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				high = max
+			}
+			if low < min || high > max || low > high {
+				return nil, false, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+			}
+		}
+		for v := low; v <= high; v += step {
+			matches[v] = true
+		}
+	}
+	return matches, field == "*", nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	day, weekday := s.days[t.Day()], s.weekdays[t.Weekday()]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// Next returns the first time after the given time that matches the schedule, or the zero time if there is none
+// within the next five years, as for February 30th.
+func (s *Schedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.months[t.Month()]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !s.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !s.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,41 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	start := time.Date(2024, 1, 31, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2024, 2, 1, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 0", time.Date(2024, 2, 4, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2024, 2, 4, 3, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// when both days are restricted, either one matches
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"5,10 9-11/2 * * *", time.Date(2024, 1, 31, 11, 5, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Errorf("%q: %v", c.spec, err)
+			continue
+		}
+		if next := schedule.Next(start); !next.Equal(c.expected) {
+			t.Errorf("%q: expected %v, got %v", c.spec, c.expected, next)
+		}
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@often"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// fetchSchemas fetches the schema of every configured app, keyed by app ID.
+func FetchSchemas(ctx context.Context, config Config, client *http.Client) (map[string]*api.BaseSchema, error) {
+	schemas := map[string]*api.BaseSchema{}
+	for app := range config.Tables {
+		schema, err := api.NewClerk(app, config.ClerkConfig(app), client).GetBaseSchema(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("fetching schema of app %s (set skip-schema if the token cannot read schemas): %w",
+				app, err)
+		}
+		schemas[app] = schema
+	}
+	return schemas, nil
+}
+
+// matchesTable reports whether a table's name or ID is in the list.
+func matchesTable(table api.TableSchema, list []string) bool {
+	for _, entry := range list {
+		if entry == table.Id || entry == table.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// isTableId reports whether a configured table is given by ID rather than by name.
+func isTableId(table string) bool {
+	return strings.HasPrefix(table, "tbl") && api.IsAirTableId(table)
+}
+
+// DiscoverTables fills in the tables of every app that is configured with an empty table list, using the app's
+// schema: every table is backed up, except those filtered out by include-tables and exclude-tables. Tables configured
+// by name are resolved to their IDs, including in the settings for individual tables, and the tables are then
+// filtered by Config.SelectOnly. Schemas that are missing from schemas are fetched.
+func DiscoverTables(ctx context.Context, config Config, client *http.Client, schemas map[string]*api.BaseSchema) (Config, error) {
+	resolved := map[string][]string{}
+	// names maps each table name that was resolved to the IDs it was resolved to
+	names := map[string][]string{}
+	// labels maps each resolved table ID to the names it was configured or discovered by
+	labels := map[string][]string{}
+	for app, tables := range config.Tables {
+		var schema *api.BaseSchema
+		getSchema := func() (*api.BaseSchema, error) {
+			if schema == nil {
+				schema = schemas[app]
+			}
+			if schema == nil {
+				var err error
+				if schema, err = api.NewClerk(app, config.ClerkConfig(app), client).GetBaseSchema(ctx); err != nil {
+					return nil, fmt.Errorf("fetching schema of app %s to resolve its tables: %w", app, err)
+				}
+			}
+			return schema, nil
+		}
+		if len(tables) > 0 {
+			for _, table := range tables {
+				if isTableId(table) {
+					resolved[app] = append(resolved[app], table)
+					continue
+				}
+				schema, err := getSchema()
+				if err != nil {
+					return Config{}, err
+				}
+				id, err := resolveTableName(schema, app, table)
+				if err != nil {
+					return Config{}, err
+				}
+				resolved[app] = append(resolved[app], id)
+				names[table] = append(names[table], id)
+				labels[id] = append(labels[id], table)
+			}
+			continue
+		}
+		schema, err := getSchema()
+		if err != nil {
+			return Config{}, err
+		}
+		for _, table := range schema.Tables {
+			if len(config.IncludeTables) > 0 && !matchesTable(table, config.IncludeTables) {
+				continue
+			}
+			if matchesTable(table, config.ExcludeTables) {
+				continue
+			}
+			resolved[app] = append(resolved[app], table.Id)
+			labels[table.Id] = append(labels[table.Id], table.Name)
+		}
+		if len(resolved[app]) == 0 {
+			return Config{}, fmt.Errorf("no tables to back up were discovered in app %s", app)
+		}
+		loggerFrom(ctx).Info("Discovered tables", "app", app, "tables", len(resolved[app]))
+	}
+	if config.only != nil {
+		var err error
+		if resolved, err = config.only.apply(resolved, labels); err != nil {
+			return Config{}, err
+		}
+		config.only = nil
+	}
+	config.Tables = resolved
+	if len(names) > 0 {
+		config.Views = renameTables(config.Views, names)
+		config.TableTimeouts = renameTables(config.TableTimeouts, names)
+		config.TableFields = renameTables(config.TableFields, names)
+		rules := make([]RedactionRule, len(config.Redact))
+		for i, rule := range config.Redact {
+			rules[i] = rule
+			rules[i].Tables = nil
+			for _, table := range rule.Tables {
+				if ids, found := names[table]; found {
+					rules[i].Tables = append(rules[i].Tables, ids...)
+				} else {
+					rules[i].Tables = append(rules[i].Tables, table)
+				}
+			}
+		}
+		config.Redact = rules
+	}
+	return config, nil
+}
+
+// resolveTableName returns the ID of the table with the given name, or an error that suggests the tables with
+// similar names.
+func resolveTableName(schema *api.BaseSchema, app, name string) (string, error) {
+	var suggestions []string
+	for _, table := range schema.Tables {
+		if table.Name == name {
+			return table.Id, nil
+		}
+		distance := editDistance(strings.ToLower(table.Name), strings.ToLower(name))
+		if distance <= 2 || distance <= len(name)/3 {
+			suggestions = append(suggestions, strconv.Quote(table.Name))
+		}
+	}
+	if len(suggestions) == 0 {
+		return "", fmt.Errorf("app %s has no table named %q", app, name)
+	}
+	return "", fmt.Errorf("app %s has no table named %q; did you mean %s?", app, name,
+		strings.Join(suggestions, " or "))
+}
+
+// editDistance returns the Levenshtein distance between two strings, counted in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// renameTables returns a copy of a map of settings for individual tables, with the keys that are table names replaced
+// by the IDs those names were resolved to.
+func renameTables[V any](settings map[string]V, names map[string][]string) map[string]V {
+	if settings == nil {
+		return nil
+	}
+	renamed := map[string]V{}
+	for table, value := range settings {
+		if ids, found := names[table]; found {
+			for _, id := range ids {
+				renamed[id] = value
+			}
+		} else {
+			renamed[table] = value
+		}
+	}
+	return renamed
+}
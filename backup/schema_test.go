@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestBackupIncludesSchema(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v0/meta/bases/appAAAAAAAAAAAAAA/tables" {
+			_, _ = w.Write([]byte(`{"tables": [{"id": "tblAAAAAAAAAAAAAA", "name": "Widgets",
+				"primaryFieldId": "fldAAAAAAAAAAAAAA", "fields": [
+					{"id": "fldAAAAAAAAAAAAAA", "name": "Name", "type": "singleLineText"},
+					{"id": "fldBBBBBBBBBBBBBB", "name": "Color", "type": "singleSelect",
+						"options": {"choices": [{"id": "selAAAAAAAAAAAAAA", "name": "Red"}]}}
+				], "views": [{"id": "viwAAAAAAAAAAAAAA", "name": "Grid view", "type": "grid"}]}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"records": []}`))
+	})
+	config := Config{
+		Config: api.Config{BearerToken: testToken},
+		Tables: map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Config: config, Client: client, OutputPath: path.Join(dir, "backup.json"), DownloadPath: downloadDir}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := Load(path.Join(dir, "backup.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := backup.Schemas["appAAAAAAAAAAAAAA"]
+	if schema == nil || len(schema.Tables) != 1 {
+		t.Fatalf("expected the schema of one table, got %+v", schema)
+	}
+	table := schema.Tables[0]
+	if table.Name != "Widgets" || len(table.Fields) != 2 || len(table.Views) != 1 {
+		t.Errorf("unexpected table schema: %+v", table)
+	}
+	if table.Fields[1].Type != "singleSelect" || table.Fields[1].Options["choices"] == nil {
+		t.Errorf("select options should have been kept: %+v", table.Fields[1])
+	}
+}
+
+func TestDiscoverTables(t *testing.T) {
+	schemas := map[string]*api.BaseSchema{
+		"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{
+			{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets"},
+			{Id: "tblBBBBBBBBBBBBBB", Name: "Archive"},
+			{Id: "tblCCCCCCCCCCCCCC", Name: "Gadgets"},
+		}},
+	}
+	config := Config{
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {},
+			"appBBBBBBBBBBBBBB": {"tblDDDDDDDDDDDDDD"},
+		},
+		ExcludeTables: []string{"Archive"},
+	}
+	discovered, err := DiscoverTables(context.Background(), config, &http.Client{Transport: failingTransport{t}}, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblCCCCCCCCCCCCCC"},
+		"appBBBBBBBBBBBBBB": {"tblDDDDDDDDDDDDDD"},
+	}
+	if !reflect.DeepEqual(discovered.Tables, expected) {
+		t.Errorf("expected %v, got %v", expected, discovered.Tables)
+	}
+	config.IncludeTables = []string{"tblCCCCCCCCCCCCCC", "Archive"}
+	if discovered, err = DiscoverTables(context.Background(), config, nil, schemas); err != nil {
+		t.Fatal(err)
+	}
+	if tables := discovered.Tables["appAAAAAAAAAAAAAA"]; len(tables) != 1 || tables[0] != "tblCCCCCCCCCCCCCC" {
+		t.Errorf("exclusions should win over inclusions, got %v", tables)
+	}
+}
+
+func TestTableNamesAreResolved(t *testing.T) {
+	schemas := map[string]*api.BaseSchema{
+		"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{
+			{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets"},
+			{Id: "tblBBBBBBBBBBBBBB", Name: "Gadgets"},
+		}},
+	}
+	config := Config{
+		Tables:        map[string][]string{"appAAAAAAAAAAAAAA": {"Widgets", "tblBBBBBBBBBBBBBB"}},
+		Views:         map[string]string{"Widgets": "Curated"},
+		TableTimeouts: map[string]Duration{"tblBBBBBBBBBBBBBB": Duration(time.Minute)},
+	}
+	resolved, err := DiscoverTables(context.Background(), config, nil, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}; !reflect.DeepEqual(
+		resolved.Tables["appAAAAAAAAAAAAAA"], expected) {
+		t.Errorf("expected %v, got %v", expected, resolved.Tables)
+	}
+	if !reflect.DeepEqual(resolved.Views, map[string]string{"tblAAAAAAAAAAAAAA": "Curated"}) {
+		t.Errorf("settings given by table name should be keyed by ID: %v", resolved.Views)
+	}
+	if resolved.TimeoutFor("tblBBBBBBBBBBBBBB") != time.Minute {
+		t.Errorf("settings given by table ID should be kept: %v", resolved.TableTimeouts)
+	}
+
+	config.Tables = map[string][]string{"appAAAAAAAAAAAAAA": {"widget"}}
+	_, err = DiscoverTables(context.Background(), config, nil, schemas)
+	if err == nil || !strings.Contains(err.Error(), `did you mean "Widgets"?`) {
+		t.Errorf("expected a suggestion of the similar name, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+const (
+	ScopeCheckOff   = ""
+	ScopeCheckWarn  = "warn"
+	ScopeCheckError = "error"
+)
+
+// CheckTokenScope confirms that the tokens can access every configured base before any records are fetched, so that
+// a token scoped to the wrong bases produces a clear message rather than a 403 halfway through a run.
+func CheckTokenScope(ctx context.Context, config Config, client *http.Client) error {
+	if config.ScopeCheck == ScopeCheckOff {
+		return nil
+	}
+	// each token is only asked for its bases once, however many apps it is used for
+	appsByToken := map[string][]string{}
+	for app := range config.Tables {
+		token := config.ClerkConfig(app).BearerToken
+		appsByToken[token] = append(appsByToken[token], app)
+	}
+	var missing []string
+	for _, apps := range appsByToken {
+		bases, err := api.NewClerk("", config.ClerkConfig(apps[0]), client).ListBases(ctx)
+		if err != nil {
+			return fmt.Errorf("could not list the bases accessible to the token for %s: %w", apps[0], err)
+		}
+		accessible := map[string]bool{}
+		for _, base := range bases {
+			accessible[base.Id] = true
+		}
+		for _, app := range apps {
+			if !accessible[app] {
+				missing = append(missing, app)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	err := fmt.Errorf("token does not grant access to configured base(s): %s", strings.Join(missing, ", "))
+	if config.ScopeCheck == ScopeCheckWarn {
+		loggerFrom(ctx).Warn("Token scope check failed", "error", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestCheckTokenScope(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v0/meta/bases" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("offset") == "" {
+			_, _ = w.Write([]byte(`{"bases": [{"id": "appAAAAAAAAAAAAAA", "name": "A", "permissionLevel": "read"}], "offset": "page2"}`))
+		} else {
+			_, _ = w.Write([]byte(`{"bases": [{"id": "appBBBBBBBBBBBBBB", "name": "B", "permissionLevel": "read"}]}`))
+		}
+	})
+	config := Config{
+		Config: api.Config{BearerToken: testToken},
+		Tables: map[string][]string{
+			"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"},
+			"appBBBBBBBBBBBBBB": {"tblBBBBBBBBBBBBBB"},
+			"appCCCCCCCCCCCCCC": {"tblCCCCCCCCCCCCCC"},
+		},
+		ScopeCheck: ScopeCheckError,
+	}
+	err := CheckTokenScope(context.Background(), config, client)
+	if err == nil || !strings.Contains(err.Error(), "appCCCCCCCCCCCCCC") {
+		t.Errorf("expected an error naming the inaccessible base, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "appBBBBBBBBBBBBBB") {
+		t.Errorf("accessible base on the second page should not be reported: %v", err)
+	}
+	config.ScopeCheck = ScopeCheckWarn
+	if err := CheckTokenScope(context.Background(), config, client); err != nil {
+		t.Errorf("warn mode should not fail: %v", err)
+	}
+	delete(config.Tables, "appCCCCCCCCCCCCCC")
+	config.ScopeCheck = ScopeCheckError
+	if err := CheckTokenScope(context.Background(), config, client); err != nil {
+		t.Errorf("all bases are accessible, but got %v", err)
+	}
+}
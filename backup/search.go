@@ -0,0 +1,300 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// SearchIndexSuffix is appended to the path of a backup to name the search index saved next to it.
+const SearchIndexSuffix = ".search-index"
+
+// searchSnippetLength is the length of the text shown around a match.
+const searchSnippetLength = 80
+
+// SearchHit is a field of a record that matched a search.
+type SearchHit struct {
+	Table     string `json:"table"`
+	TableName string `json:"table-name"`
+	Record    string `json:"record"`
+	Field     string `json:"field"`
+	// Snippet is the field's text around the first word of the query that it contains.
+	Snippet string `json:"snippet"`
+}
+
+type searchField struct {
+	Table  string `json:"table"`
+	Record string `json:"record"`
+	Field  string `json:"field"`
+	Text   string `json:"text"`
+}
+
+// SearchIndex maps the words in the text of a backup's fields to the fields that contain them.
+type SearchIndex struct {
+	// BackupSHA256 is the checksum of the backup files that the index was built from, so that a saved index is only
+	// used until the backup changes.
+	BackupSHA256 string `json:"backup-sha256,omitempty"`
+	// TableNames holds the name of each table, as in exports.
+	TableNames map[string]string `json:"table-names"`
+	Fields     []searchField     `json:"fields"`
+	// Words holds the indexes into Fields of the fields that contain each word.
+	Words map[string][]int `json:"words"`
+}
+
+// searchWords splits text into lowercase words of letters and digits.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchText returns the text of a field value that is searched: text, the items of lists of text such as multiple
+// selects, the filenames of attachments, and the names and emails of collaborators.
+func searchText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			if text := searchText(item); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	case map[string]interface{}:
+		var parts []string
+		for _, key := range []string{"filename", "name", "email"} {
+			if text, ok := v[key].(string); ok && text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// BuildSearchIndex indexes the text fields of every record in a backup.
+func BuildSearchIndex(backup *Backup) *SearchIndex {
+	index := &SearchIndex{TableNames: backup.tableNames(), Words: map[string][]int{}}
+	tables := make([]string, 0, len(backup.Tables))
+	for table := range backup.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		for _, record := range backup.Tables[table] {
+			index.addRecord(table, record)
+		}
+	}
+	return index
+}
+
+func (s *SearchIndex) addRecord(table string, record api.Record) {
+	names := make([]string, 0, len(record.Fields))
+	for name := range record.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		text := searchText(record.Fields[name])
+		words := searchWords(text)
+		if len(words) == 0 {
+			continue
+		}
+		i := len(s.Fields)
+		s.Fields = append(s.Fields, searchField{Table: table, Record: record.Id, Field: name, Text: text})
+		seen := map[string]bool{}
+		for _, word := range words {
+			if !seen[word] {
+				seen[word] = true
+				s.Words[word] = append(s.Words[word], i)
+			}
+		}
+	}
+}
+
+// Search finds the records that contain every word of the query, in any of their fields, ignoring case. Each field of
+// those records that contains one of the words is a hit. Hits are sorted by table, record, and field.
+func (s *SearchIndex) Search(query string) SearchResults {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+	type recordKey struct{ table, record string }
+	// matched holds the words of the query found in each record, and fields the fields they were found in
+	matched := map[recordKey]map[string]bool{}
+	fields := map[recordKey]map[int]string{}
+	for _, word := range words {
+		for _, i := range s.Words[word] {
+			key := recordKey{s.Fields[i].Table, s.Fields[i].Record}
+			if matched[key] == nil {
+				matched[key], fields[key] = map[string]bool{}, map[int]string{}
+			}
+			matched[key][word] = true
+			if _, found := fields[key][i]; !found {
+				fields[key][i] = word
+			}
+		}
+	}
+	unique := map[string]bool{}
+	for _, word := range words {
+		unique[word] = true
+	}
+	var hits SearchResults
+	for key, found := range matched {
+		if len(found) < len(unique) {
+			continue
+		}
+		for i, word := range fields[key] {
+			field := s.Fields[i]
+			hits = append(hits, SearchHit{Table: field.Table, TableName: s.TableNames[field.Table],
+				Record: field.Record, Field: field.Field, Snippet: searchSnippet(field.Text, word)})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Table != hits[j].Table {
+			return hits[i].Table < hits[j].Table
+		}
+		if hits[i].Record != hits[j].Record {
+			return hits[i].Record < hits[j].Record
+		}
+		return hits[i].Field < hits[j].Field
+	})
+	return hits
+}
+
+// SearchResults are the hits of a search.
+type SearchResults []SearchHit
+
+func (r SearchResults) Print(w io.Writer) {
+	if len(r) == 0 {
+		_, _ = fmt.Fprintln(w, "No matches.")
+		return
+	}
+	for _, hit := range r {
+		_, _ = fmt.Fprintf(w, "%s (%s) %s %s: %s\n", hit.TableName, hit.Table, hit.Record, hit.Field, hit.Snippet)
+	}
+}
+
+// searchSnippet returns the part of text around the first place that word appears, on a single line.
+func searchSnippet(text, word string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	// lowered rune by rune, so that positions in lower are positions in runes
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	start := 0
+	if at := strings.Index(string(lower), word); at >= 0 {
+		start = max(utf8.RuneCountInString(string(lower)[:at])-searchSnippetLength/4, 0)
+	}
+	end := min(start+searchSnippetLength, len(runes))
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// Save writes the index to indexPath, encrypted with key unless it is nil, since it holds the text of the backup.
+func (s *SearchIndex) Save(indexPath string, key EncryptionKey) error {
+	st := LocalStorage(path.Dir(indexPath))
+	_, err := putMaybeEncrypted(context.Background(), st, path.Base(indexPath), key, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(s)
+	})
+	return err
+}
+
+// LoadSearchIndex reads an index written by Save, decrypting it with key if it is encrypted. A nil key means the key
+// from EncryptionKeyEnv.
+func LoadSearchIndex(indexPath string, key EncryptionKey) (*SearchIndex, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	plaintext, err := openMaybeEncrypted(f, key)
+	if err != nil {
+		return nil, fmt.Errorf("search index in %q: %w", indexPath, err)
+	}
+	var index SearchIndex
+	if err := json.NewDecoder(plaintext).Decode(&index); err != nil {
+		return nil, fmt.Errorf("invalid search index in %q: %w", indexPath, err)
+	}
+	return &index, nil
+}
+
+// OpenSearchIndex returns the index of a backup. The index saved next to the backup is used if it was built from the
+// backup as it is now; otherwise, the backup is loaded and indexed, and the index is saved next to it if persist is
+// set, encrypted with key unless it is nil.
+func OpenSearchIndex(backupPath string, key EncryptionKey, persist bool) (*SearchIndex, error) {
+	sum, err := backupChecksum(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	indexPath := backupPath + SearchIndexSuffix
+	// a saved index is only a cache, so one that cannot be read is built again
+	if index, err := LoadSearchIndex(indexPath, key); err == nil && index.BackupSHA256 == sum {
+		return index, nil
+	}
+	backup, err := LoadWithKey(backupPath, key)
+	if err != nil {
+		return nil, err
+	}
+	index := BuildSearchIndex(backup)
+	index.BackupSHA256 = sum
+	if persist {
+		if err := index.Save(indexPath, key); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// backupChecksum combines the checksums of a backup file and the table files written alongside it.
+func backupChecksum(backupPath string) (string, error) {
+	ctx, st := context.Background(), LocalStorage(path.Dir(backupPath))
+	name := path.Base(backupPath)
+	files := []string{name}
+	// the directory that tableFile puts each app's directory in
+	tablesDir := strings.TrimSuffix(name, path.Ext(name)) + ".tables"
+	err := fs.WalkDir(os.DirFS(string(st)), tablesDir, func(file string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			files = append(files, file)
+		}
+		if os.IsNotExist(err) {
+			return fs.SkipDir
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, file := range files {
+		sum, err := hashStored(ctx, st, file)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(hash, "%s %s\n", sum, file)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
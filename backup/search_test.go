@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestSearch(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "Invoice for March",
+					"Notes": "Paid in 2023.", "Total": 2023.0}},
+				{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "Invoice for April",
+					"Notes": "Due in 2024."}},
+			},
+			"tblBBBBBBBBBBBBBB": {
+				{Id: "recCCCCCCCCCCCCCC", Fields: map[string]interface{}{"Tags": []interface{}{"invoice", "2023"},
+					"Scan": []interface{}{map[string]interface{}{"id": "attAAAAAAAAAAAAAA", "filename": "march.pdf"}}}},
+			},
+		},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Invoices"}}},
+		},
+	}
+	index := BuildSearchIndex(backup)
+	expected := SearchResults{
+		{Table: "tblAAAAAAAAAAAAAA", TableName: "Invoices", Record: "recAAAAAAAAAAAAAA", Field: "Name",
+			Snippet: "Invoice for March"},
+		{Table: "tblAAAAAAAAAAAAAA", TableName: "Invoices", Record: "recAAAAAAAAAAAAAA", Field: "Notes",
+			Snippet: "Paid in 2023."},
+		{Table: "tblBBBBBBBBBBBBBB", TableName: "tblBBBBBBBBBBBBBB", Record: "recCCCCCCCCCCCCCC", Field: "Tags",
+			Snippet: "invoice 2023"},
+	}
+	if hits := index.Search(`INVOICE "2023"`); !reflect.DeepEqual(hits, expected) {
+		t.Errorf("unexpected hits: %+v", hits)
+	}
+	if hits := index.Search("march.pdf"); len(hits) != 1 || hits[0].Field != "Scan" {
+		t.Errorf("expected attachment filenames to be searched: %+v", hits)
+	}
+	if hits := index.Search("invoice 2025"); len(hits) != 0 {
+		t.Errorf("expected every word to be required: %+v", hits)
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	text := "A long note that goes on and on,\nfor a while, before it gets to the word we want: Needle. " +
+		"And then it keeps going for some time after that, until it finally stops."
+	expected := "…o the word we want: Needle. And then it keeps going for some time after that, un…"
+	if snippet := searchSnippet(text, "needle"); snippet != expected {
+		t.Errorf("unexpected snippet: %q", snippet)
+	}
+}
+
+func TestOpenSearchIndex(t *testing.T) {
+	backupPath := path.Join(t.TempDir(), "backup.json")
+	backup := &Backup{Tables: map[string][]api.Record{
+		"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", Fields: map[string]interface{}{"Name": "first"}}},
+	}}
+	if err := backup.Save(backupPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSearchIndex(backupPath, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backupPath + SearchIndexSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the index to only be saved when asked: %v", err)
+	}
+	index, err := OpenSearchIndex(backupPath, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadSearchIndex(backupPath+SearchIndexSuffix, nil)
+	if err != nil || !reflect.DeepEqual(saved, index) {
+		t.Fatalf("expected the index to be saved: %v", err)
+	}
+	// a saved index is used as long as it matches the backup
+	saved.Fields[0].Text = "from the index"
+	if err := saved.Save(backupPath+SearchIndexSuffix, nil); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := OpenSearchIndex(backupPath, nil, false); err != nil || index.Fields[0].Text != "from the index" {
+		t.Errorf("expected the saved index to be used: %v", err)
+	}
+	backup.Tables["tblAAAAAAAAAAAAAA"][0].Fields["Name"] = "second"
+	if err := backup.Save(backupPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	index, err = OpenSearchIndex(backupPath, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits := index.Search("second"); len(hits) != 1 {
+		t.Errorf("expected the index to be rebuilt once the backup changed: %+v", hits)
+	}
+}
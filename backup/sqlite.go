@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/hashicorp/go-multierror"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteAttachmentsTable lists every attachment in the backup. Attachment fields in the other tables hold a JSON list
+// of the IDs of their attachments, which are also the filenames in the download directory.
+const sqliteAttachmentsTable = "_attachments"
+
+func sqliteQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func sqliteColumnType(fieldType string) string {
+	switch fieldType {
+	case FieldTypeNumber:
+		return "REAL"
+	case FieldTypeCheckbox:
+		return "INTEGER"
+	default:
+		return "TEXT"
+	}
+}
+
+// sqliteValue converts a field value into a column value. Values that SQLite has no type for, like lists and
+// objects, are stored as JSON text.
+func sqliteValue(value interface{}, fieldType string) (interface{}, error) {
+	switch v := value.(type) {
+	case string, float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if fieldType == FieldTypeAttachments {
+		// a column of attachments can still hold other values in some records, which are stored as they are
+		if ids, ok := attachmentIds(value); ok {
+			value = ids
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// attachmentIds returns the IDs of the attachments in a field value, if it is a list of attachments.
+func attachmentIds(value interface{}) ([]string, bool) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	ids := []string{}
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok || !IsAttachment(itemMap) {
+			return nil, false
+		}
+		id, ok := itemMap["id"].(string)
+		if !ok {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// uniqueNames assigns each name a distinct identifier, comparing them case-insensitively, since SQLite (like some
+// filesystems) does, and since a field could be named the same as one of the fixed columns.
+type uniqueNames map[string]bool
+
+func (u uniqueNames) assign(name string) string {
+	unique := name
+	for i := 2; u[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s (%d)", name, i)
+	}
+	u[strings.ToLower(unique)] = true
+	return unique
+}
+
+func writeSQLiteTable(tx *sql.Tx, name string, fields []DictionaryField, records []api.Record) error {
+	columns := uniqueNames{}
+	definitions := []string{
+		sqliteQuote(columns.assign("_id")) + " TEXT PRIMARY KEY",
+		sqliteQuote(columns.assign("_created_time")) + " TEXT",
+	}
+	placeholders := []string{"?", "?"}
+	for _, field := range fields {
+		definitions = append(definitions, sqliteQuote(columns.assign(field.Name))+" "+sqliteColumnType(field.Type))
+		placeholders = append(placeholders, "?")
+	}
+	create := fmt.Sprintf("CREATE TABLE %s (%s)", sqliteQuote(name), strings.Join(definitions, ", "))
+	if _, err := tx.Exec(create); err != nil {
+		return fmt.Errorf("creating table %q: %w", name, err)
+	}
+	insert, err := tx.Prepare(
+		fmt.Sprintf("INSERT INTO %s VALUES (%s)", sqliteQuote(name), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = insert.Close()
+	}()
+	for _, record := range records {
+		row := []interface{}{record.Id, record.CreatedTime}
+		for _, field := range fields {
+			value, found := record.Fields[field.Name]
+			if !found {
+				row = append(row, nil)
+				continue
+			}
+			converted, err := sqliteValue(value, field.Type)
+			if err != nil {
+				return err
+			}
+			row = append(row, converted)
+		}
+		if _, err := insert.Exec(row...); err != nil {
+			return fmt.Errorf("inserting record %s into %q: %w", record.Id, name, err)
+		}
+	}
+	return nil
+}
+
+func writeSQLiteAttachments(tx *sql.Tx, backup *Backup, names map[string]string) error {
+	if _, err := tx.Exec("CREATE TABLE " + sqliteQuote(sqliteAttachmentsTable) + ` ("id" TEXT, "table" TEXT, ` +
+		`"record_id" TEXT, "field" TEXT, "filename" TEXT, "size" INTEGER, "link" TEXT)`); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO " + sqliteQuote(sqliteAttachmentsTable) + " VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = insert.Close()
+	}()
+	for table, records := range backup.Tables {
+		for _, record := range records {
+			for field, value := range record.Fields {
+				if InferFieldType(value) != FieldTypeAttachments {
+					continue
+				}
+				for _, item := range value.([]interface{}) {
+					itemMap := item.(map[string]interface{})
+					if _, err := insert.Exec(itemMap["id"], names[table], record.Id, field, itemMap["filename"],
+						itemMap["size"], itemMap["url"]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func writeSQLite(db *sql.DB, backup *Backup) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	dictionary := BuildDataDictionary(backup.Tables)
+	used := uniqueNames{}
+	used.assign(sqliteAttachmentsTable)
+	names := backup.tableNames()
+	for _, table := range dictionary.sortedTables() {
+		names[table] = used.assign(names[table])
+	}
+	for _, table := range dictionary.sortedTables() {
+		if err := writeSQLiteTable(tx, names[table], dictionary[table], backup.Tables[table]); err != nil {
+			return multierror.Append(err, tx.Rollback())
+		}
+	}
+	if err := writeSQLiteAttachments(tx, backup, names); err != nil {
+		return multierror.Append(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// ExportSQLite writes the backup as a SQLite database, with one SQL table for each AirTable table. Each field becomes
+// a column, alongside the record's ID and creation time, and every attachment is listed in the _attachments table.
+// The database replaces any existing file at exportPath once complete.
+func ExportSQLite(backup *Backup, exportPath string) error {
+	return WriteSQLite(backup, PathOpener(exportPath))
+}
+
+// WriteSQLite writes the backup as a SQLite database, opened with open. SQLite can only write to a file on disk, so
+// the database is assembled in a temporary file, and copied once complete.
+func WriteSQLite(backup *Backup, open Opener) error {
+	temp, err := os.CreateTemp("", "vacuum-table-*.sqlite")
+	if err != nil {
+		return err
+	}
+	tempPath := temp.Name()
+	defer func() {
+		_ = os.Remove(tempPath)
+	}()
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", tempPath)
+	if err != nil {
+		return err
+	}
+	if err := writeSQLite(db, backup); err != nil {
+		return multierror.Append(err, db.Close())
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	return open("", func(w io.Writer) error {
+		f, err := os.Open(tempPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"database/sql"
+	"path"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestExportSQLite(t *testing.T) {
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {
+				{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z", Fields: map[string]interface{}{
+					"Name":  "Widget",
+					"name":  "lowercase",
+					"Count": 3.0,
+					"Done":  true,
+					"Files": []interface{}{map[string]interface{}{
+						"id": "attAAAAAAAAAAAAAA", "url": DefaultAttachmentPrefixes[0] + "a", "size": 11.0, "filename": "a.txt",
+					}},
+				}},
+				{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Name": "Gadget"}},
+			},
+		},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets"}}},
+		},
+	}
+	exportPath := path.Join(t.TempDir(), "backup.sqlite")
+	if err := ExportSQLite(backup, exportPath); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	var name, lowercase, files string
+	var count float64
+	var done int
+	row := db.QueryRow(`SELECT "Name", "name (2)", "Count", "Done", "Files" FROM "Widgets" WHERE "_id" = ?`,
+		"recAAAAAAAAAAAAAA")
+	if err := row.Scan(&name, &lowercase, &count, &done, &files); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Widget" || lowercase != "lowercase" || count != 3 || done != 1 || files != `["attAAAAAAAAAAAAAA"]` {
+		t.Errorf("unexpected row: %q %q %v %v %q", name, lowercase, count, done, files)
+	}
+	var missing sql.NullFloat64
+	if err := db.QueryRow(`SELECT "Count" FROM "Widgets" WHERE "_id" = ?`, "recBBBBBBBBBBBBBB").Scan(&missing); err != nil {
+		t.Fatal(err)
+	}
+	if missing.Valid {
+		t.Error("missing field should be NULL")
+	}
+	var filename, record string
+	if err := db.QueryRow(`SELECT "filename", "record_id" FROM "_attachments" WHERE "id" = ?`,
+		"attAAAAAAAAAAAAAA").Scan(&filename, &record); err != nil {
+		t.Fatal(err)
+	}
+	if filename != "a.txt" || record != "recAAAAAAAAAAAAAA" {
+		t.Errorf("unexpected attachment row: %q %q", filename, record)
+	}
+}
+
+func TestSQLiteValueOfMixedAttachmentColumn(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{[]interface{}{map[string]interface{}{"id": "attAAAAAAAAAAAAAA", "url": "u", "size": 1.0, "filename": "a"}},
+			`["attAAAAAAAAAAAAAA"]`},
+		{"not an attachment", "not an attachment"},
+		{[]interface{}{"recAAAAAAAAAAAAAA"}, `["recAAAAAAAAAAAAAA"]`},
+		{[]interface{}{map[string]interface{}{"id": 1.0}}, `[{"id":1}]`},
+		{map[string]interface{}{"id": "attAAAAAAAAAAAAAA"}, `{"id":"attAAAAAAAAAAAAAA"}`},
+	} {
+		converted, err := sqliteValue(c.value, FieldTypeAttachments)
+		if err != nil || converted != c.expected {
+			t.Errorf("expected %v to be stored as %v, got %v: %v", c.value, c.expected, converted, err)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/s3"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Storage is somewhere that backups and attachments are kept, such as a local directory or a prefix in a bucket.
+// Names are slash-separated paths, whose directories are created as needed. A file put into a Storage only appears
+// once all of it has been written.
+type Storage interface {
+	// Stat returns the size of a file, or found=false if there is no such file.
+	Stat(ctx context.Context, name string) (size int64, found bool, err error)
+	// Open reads a file. A missing file is reported as an error wrapping fs.ErrNotExist.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Put writes r to a file, replacing it if it exists. If reading r fails, the file is left as it was.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Rename moves a file to a new name, replacing any file already there.
+	Rename(ctx context.Context, oldName, newName string) error
+	// Delete removes a file. Deleting a file that does not exist is not an error.
+	Delete(ctx context.Context, name string) error
+	// Location describes a file in the Storage, for messages.
+	Location(name string) string
+}
+
+// stampedStorage is implemented by a Storage on local disk, which can cheaply tell whether a file has changed since
+// it was hashed. Files in other storage are not read back to be hashed at all, since that would cost as much as
+// downloading them again.
+type stampedStorage interface {
+	stamp(name string) (fileStamp, error)
+}
+
+type fileStamp struct {
+	size    int64
+	modTime int64
+}
+
+// stamp returns the stamp recorded for the file of a manifest entry.
+func (a Attachment) stamp() fileStamp {
+	return fileStamp{size: a.StoredSize, modTime: a.ModTime}
+}
+
+// attachmentDownloader is implemented by a Storage that has its own way to download attachments into itself, which
+// the download pool uses instead of streaming each attachment through Put.
+type attachmentDownloader interface {
+	downloadAttachment(ctx context.Context, attachment Attachment, filename string, client *http.Client, opts DownloadOptions) error
+}
+
+// isBucket reports whether a location is in object storage, rather than on local disk.
+func isBucket(location string) bool {
+	return strings.HasPrefix(location, "s3://") || strings.HasPrefix(location, "gs://")
+}
+
+// OpenStorage returns the Storage for a local directory, an s3:// URL, or a gs:// URL. S3 buckets are accessed with
+// the credentials from the standard AWS_* environment variables, and Google Cloud Storage buckets through its
+// S3-compatible API, with the HMAC key from GCS_ACCESS_KEY_ID and GCS_SECRET_ACCESS_KEY.
+func OpenStorage(location string, client *http.Client) (Storage, error) {
+	if !isBucket(location) {
+		return LocalStorage(location), nil
+	}
+	bucket, prefix, err := s3.ParseURL(location)
+	if err != nil {
+		return nil, err
+	}
+	config := s3.ConfigFromEnvironment()
+	if strings.HasPrefix(location, "gs://") {
+		config = s3.GCSConfigFromEnvironment()
+	}
+	return &bucketStorage{
+		client: s3.NewClient(config, client),
+		scheme: location[:strings.Index(location, ":")],
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+// splitLocation returns the Storage containing a file, and the file's name within it.
+func splitLocation(location string, client *http.Client) (Storage, string, error) {
+	if isBucket(location) {
+		cut := strings.LastIndex(location, "/")
+		st, err := OpenStorage(location[:cut], client)
+		return st, location[cut+1:], err
+	}
+	st, err := OpenStorage(path.Dir(location), client)
+	return st, path.Base(location), err
+}
+
+// LocalStorage keeps files in a directory on local disk.
+type LocalStorage string
+
+func (d LocalStorage) Stat(_ context.Context, name string) (int64, bool, error) {
+	fi, err := os.Stat(path.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return fi.Size(), true, nil
+}
+
+// stamp returns the size and modification time of a file, which change whenever it is rewritten.
+func (d LocalStorage) stamp(name string) (fileStamp, error) {
+	fi, err := os.Stat(path.Join(string(d), name))
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{size: fi.Size(), modTime: fi.ModTime().UnixNano()}, nil
+}
+
+func (d LocalStorage) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(path.Join(string(d), name))
+}
+
+// Put writes to a temporary file first, and renames it into place.
+func (d LocalStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	tempName := path.Join(path.Dir(name), "TEMP."+path.Base(name))
+	tempPath := path.Join(string(d), tempName)
+	if err := os.MkdirAll(path.Dir(tempPath), 0o755); err != nil {
+		return err
+	}
+	output, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, r); err != nil {
+		return multierror.Append(err, output.Close(), os.Remove(tempPath))
+	}
+	if err := output.Close(); err != nil {
+		return multierror.Append(err, os.Remove(tempPath))
+	}
+	if err := d.Rename(ctx, tempName, name); err != nil {
+		return multierror.Append(err, os.Remove(tempPath))
+	}
+	return nil
+}
+
+func (d LocalStorage) Rename(_ context.Context, oldName, newName string) error {
+	if err := os.MkdirAll(path.Dir(path.Join(string(d), newName)), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(path.Join(string(d), oldName), path.Join(string(d), newName))
+}
+
+// Delete also removes the directories that the file was in, if that leaves them empty.
+func (d LocalStorage) Delete(_ context.Context, name string) error {
+	if err := os.Remove(path.Join(string(d), name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if os.Remove(path.Join(string(d), dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (d LocalStorage) Location(name string) string {
+	return path.Join(string(d), name)
+}
+
+// downloadAttachment downloads into a temporary file, so that interrupted transfers can be resumed.
+func (d LocalStorage) downloadAttachment(ctx context.Context, attachment Attachment, filename string, client *http.Client, opts DownloadOptions) error {
+	return DownloadAttachmentRetrying(ctx, attachment, string(d), filename, client, opts.SizeMismatchRetries, opts.key)
+}
+
+// bucketStorage keeps files in an S3 bucket, or in a Google Cloud Storage bucket by way of its S3-compatible API.
+type bucketStorage struct {
+	client *s3.Client
+	scheme string
+	bucket string
+	prefix string
+}
+
+func (s *bucketStorage) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *bucketStorage) Stat(ctx context.Context, name string) (int64, bool, error) {
+	return s.client.Head(ctx, s.bucket, s.key(name))
+}
+
+func (s *bucketStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.client.Get(ctx, s.bucket, s.key(name))
+}
+
+func (s *bucketStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	return s.client.Put(ctx, s.bucket, s.key(name), r)
+}
+
+// Rename copies the object and then deletes the original, since buckets cannot move objects.
+func (s *bucketStorage) Rename(ctx context.Context, oldName, newName string) error {
+	if err := s.client.Copy(ctx, s.bucket, s.key(oldName), s.key(newName)); err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, s.bucket, s.key(oldName))
+}
+
+func (s *bucketStorage) Delete(ctx context.Context, name string) error {
+	return s.client.Delete(ctx, s.bucket, s.key(name))
+}
+
+func (s *bucketStorage) Location(name string) string {
+	return s.scheme + "://" + s.bucket + "/" + s.key(name)
+}
+
+// putEncoded streams the output of encode into a file in a Storage, and returns the file's size.
+func putEncoded(ctx context.Context, st Storage, name string, encode func(w io.Writer) error) (int64, error) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(encode(pw))
+	}()
+	counter := &countingReader{r: pr}
+	err := st.Put(ctx, name, counter)
+	// unblock the encoder, if the storage stopped reading early, and let it finish
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	return counter.n, err
+}
+
+// hashStored returns the SHA-256 of a file in a Storage.
+func hashStored(ctx context.Context, st Storage, name string) (string, error) {
+	f, err := st.Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/s3/s3test"
+)
+
+func TestRunToS3(t *testing.T) {
+	bucket := s3test.NewServer(t)
+	s3Config := bucket.ClientConfig()
+	t.Setenv("AWS_ACCESS_KEY_ID", s3Config.AccessKeyId)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", s3Config.SecretAccessKey)
+	t.Setenv("AWS_REGION", s3Config.Region)
+	t.Setenv("AWS_ENDPOINT_URL", s3Config.Endpoint)
+	var attachmentRequests int32
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/bucket/"):
+			bucket.Config.Handler.ServeHTTP(w, r)
+		case r.URL.Path == "/attAAAAAAAAAAAAAA":
+			atomic.AddInt32(&attachmentRequests, 1)
+			_, _ = w.Write([]byte("hello world"))
+		default:
+			_, _ = fmt.Fprintf(w, `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+				{"id": "attAAAAAAAAAAAAAA", "url": "%sattAAAAAAAAAAAAAA", "size": 11, "filename": "a.txt"}
+			]}}]}`, DefaultAttachmentPrefixes[0])
+		}
+	})
+	config := Config{
+		Config:     api.Config{BearerToken: testToken},
+		Tables:     map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+		SkipSchema: true,
+	}
+	for _, name := range []string{"first.json", "second.json"} {
+		opts := Options{
+			Config:       config,
+			Client:       client,
+			OutputPath:   "s3://bucket/backups/" + name,
+			DownloadPath: "s3://bucket/attachments",
+		}
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if attachmentRequests != 1 {
+		t.Errorf("the attachment should only have been downloaded once, not %d times", attachmentRequests)
+	}
+	expected := []string{
+		"bucket/attachments/SHA256SUMS",
+		"bucket/attachments/attAAAAAAAAAAAAAA",
+		"bucket/attachments/manifest.json",
+		"bucket/backups/catalog.json",
+		"bucket/backups/first.json",
+		"bucket/backups/second.json",
+	}
+	if names := bucket.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected objects: %v", names)
+	}
+	if data, _ := bucket.Object("bucket/attachments/attAAAAAAAAAAAAAA"); string(data) != "hello world" {
+		t.Errorf("unexpected attachment contents %q", data)
+	}
+	data, _ := bucket.Object("bucket/backups/second.json")
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.Attachments) != 1 || backup.Attachments[0].SHA256 == "" {
+		t.Errorf("the checksum should have been carried over to the second backup: %+v", backup.Attachments)
+	}
+	data, _ = bucket.Object("bucket/backups/catalog.json")
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil || len(catalog.Backups) != 2 {
+		t.Errorf("expected both backups in the catalog: %v %+v", err, catalog)
+	}
+}
+
+func TestGCSStorage(t *testing.T) {
+	bucket := s3test.NewServer(t)
+	t.Setenv("GCS_ACCESS_KEY_ID", "GOOGAAAAAAAAAAAAAAAA")
+	t.Setenv("GCS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("GCS_ENDPOINT_URL", bucket.URL)
+	var scopes []string
+	client := &http.Client{Transport: recordingTransport(func(req *http.Request) {
+		scopes = append(scopes, strings.Split(req.Header.Get("Authorization"), "/")[2])
+	})}
+	st, err := OpenStorage("gs://bucket/prefix", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := st.Put(ctx, "file", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if size, found, err := st.Stat(ctx, "file"); err != nil || !found || size != 5 {
+		t.Errorf("unexpected stat result: %d %v %v", size, found, err)
+	}
+	if _, found, err := st.Stat(ctx, "missing"); err != nil || found {
+		t.Errorf("missing file should not be found: %v %v", found, err)
+	}
+	if location := st.Location("file"); location != "gs://bucket/prefix/file" {
+		t.Errorf("unexpected location %q", location)
+	}
+	if _, found := bucket.Object("bucket/prefix/file"); !found {
+		t.Error("the file should have been stored in the bucket")
+	}
+	if err := st.Rename(ctx, "file", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if names := bucket.Names(); !reflect.DeepEqual(names, []string{"bucket/prefix/renamed"}) {
+		t.Errorf("the file should have been renamed: %v", names)
+	}
+	if len(scopes) == 0 || scopes[0] != "auto" {
+		t.Errorf("requests to Google Cloud Storage should be signed for the auto region: %v", scopes)
+	}
+}
+
+type recordingTransport func(req *http.Request)
+
+func (rt recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt(req)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// memoryStorage is a Storage that keeps its files in memory.
+type memoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (m *memoryStorage) Stat(_ context.Context, name string) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.files[name]
+	return int64(len(data)), found, nil
+}
+
+func (m *memoryStorage) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.files[name]
+	if !found {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryStorage) Put(_ context.Context, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = data
+	return nil
+}
+
+func (m *memoryStorage) Rename(_ context.Context, oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, found := m.files[oldName]
+	if !found {
+		return fmt.Errorf("%s: %w", oldName, fs.ErrNotExist)
+	}
+	delete(m.files, oldName)
+	m.files[newName] = data
+	return nil
+}
+
+func (m *memoryStorage) Delete(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memoryStorage) Location(name string) string {
+	return "memory:" + name
+}
+
+func TestDownloadPoolToMemoryStorage(t *testing.T) {
+	var requests int32
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte("hello world"))
+	})
+	attachment := Attachment{Id: "attAAAAAAAAAAAAAA", Link: DefaultAttachmentPrefixes[0] + "a", Size: 11}
+	st := &memoryStorage{files: map[string][]byte{}}
+	for i := 0; i < 2; i++ {
+		pool, err := NewDownloadPool(context.Background(), st, client, DownloadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Add(attachment)
+		if err := pool.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("the attachment should only have been downloaded once, not %d times", requests)
+	}
+	if string(st.files[attachment.Id]) != "hello world" {
+		t.Errorf("unexpected attachment contents %q", st.files[attachment.Id])
+	}
+	checksums, err := loadChecksums(context.Background(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 1 || checksums[attachment.Id] == "" {
+		t.Errorf("expected a checksum for the attachment: %v", checksums)
+	}
+}
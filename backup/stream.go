@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/hashicorp/go-multierror"
+)
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := token.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("malformed backup: expected %q but found %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue consumes the next JSON value from the decoder token-by-token, so that large values are never held in
+// memory all at once.
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// StreamAttachments walks the tables of a saved backup one record at a time and calls yield for each attachment
+// found, without ever decoding the entire backup into memory. Attachments that cannot be extracted are returned in
+// the report instead.
+func StreamAttachments(backupPath string, opts ExtractOptions, yield func(Attachment) error) (report AttachmentReport, errOut error) {
+	f, err := os.Open(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			errOut = multierror.Append(errOut, err)
+		}
+	}()
+	plaintext, err := openMaybeEncrypted(f, nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(plaintext)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return report, err
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return report, err
+		}
+		if key != "tables" {
+			if err := skipValue(decoder); err != nil {
+				return report, err
+			}
+			continue
+		}
+		if err := expectDelim(decoder, '{'); err != nil {
+			return report, err
+		}
+		for decoder.More() {
+			tableToken, err := decoder.Token()
+			if err != nil {
+				return report, err
+			}
+			table, ok := tableToken.(string)
+			if !ok {
+				return report, fmt.Errorf("malformed backup: expected a table ID but found %v", tableToken)
+			}
+			if err := expectDelim(decoder, '['); err != nil {
+				return report, err
+			}
+			for decoder.More() {
+				var record api.Record
+				if err := decoder.Decode(&record); err != nil {
+					return report, err
+				}
+				attachments, problems := ExtractRecordAttachments(table, record, opts)
+				report = append(report, problems...)
+				for _, attachment := range attachments {
+					if err := yield(attachment); err != nil {
+						return report, err
+					}
+				}
+			}
+			if err := expectDelim(decoder, ']'); err != nil {
+				return report, err
+			}
+		}
+		if err := expectDelim(decoder, '}'); err != nil {
+			return report, err
+		}
+	}
+	return report, expectDelim(decoder, '}')
+}
+
+// DownloadAttachmentsFromBackup downloads the attachments of an existing backup, encrypting them with the key from
+// EncryptionKeyEnv if it is set. Each attachment is handed to the download pool as soon as it is read, so neither the
+// backup nor its list of attachments is ever held in memory; the attachments read before any error in the backup are
+// still downloaded.
+func DownloadAttachmentsFromBackup(ctx context.Context, backupPath, downloadDir string, client *http.Client, extractOpts ExtractOptions, downloadOpts DownloadOptions) error {
+	if err := extractOpts.validate(); err != nil {
+		return err
+	}
+	key, err := KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	downloadOpts.key = key
+	pool, err := StartDownloadPool(ctx, downloadDir, client, downloadOpts)
+	if err != nil {
+		return err
+	}
+	report, streamErr := StreamAttachments(backupPath, extractOpts, func(attachment Attachment) error {
+		pool.Add(attachment)
+		return ctx.Err()
+	})
+	downloadErr := pool.Wait()
+	if streamErr != nil {
+		return multierror.Append(streamErr, downloadErr)
+	}
+	if err := report.Check(loggerFrom(ctx), extractOpts); err != nil {
+		return multierror.Append(err, downloadErr)
+	}
+	return downloadErr
+}
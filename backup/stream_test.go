@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func writeLargeBackup(t *testing.T, backupPath string, records int) {
+	f, err := os.Create(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	_, _ = fmt.Fprint(w, `{"config": {"appAAAAAAAAAAAAAA": ["tblAAAAAAAAAAAAAA"]}, "tables": {"tblAAAAAAAAAAAAAA": [`)
+	filler := strings.Repeat("x", 1024)
+	for i := 0; i < records; i++ {
+		if i > 0 {
+			_, _ = fmt.Fprint(w, ",")
+		}
+		record := api.Record{
+			Id: fmt.Sprintf("rec%014d", i),
+			Fields: map[string]interface{}{
+				"Notes": filler,
+				"Files": []interface{}{map[string]interface{}{
+					"id":       fmt.Sprintf("att%014d", i),
+					"url":      DefaultAttachmentPrefixes[0] + fmt.Sprint(i),
+					"size":     100,
+					"filename": "notes.txt",
+				}},
+			},
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(data)
+	}
+	_, _ = fmt.Fprint(w, `]}, "attachments": [{"link": "ignored", "id": "attZZZZZZZZZZZZZZ", "size": 1}]}`)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamAttachments(t *testing.T) {
+	const records = 20000
+	backupPath := path.Join(t.TempDir(), "backup.json")
+	writeLargeBackup(t, backupPath, records)
+	fi, err := os.Stat(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var maxHeap uint64
+	seen := map[string]bool{}
+	report, err := StreamAttachments(backupPath, ExtractOptions{}, func(attachment Attachment) error {
+		if attachment.Size != 100 {
+			t.Errorf("unexpected size %d", attachment.Size)
+		}
+		seen[attachment.Id] = true
+		if len(seen)%2000 == 0 {
+			var stats runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > maxHeap {
+				maxHeap = stats.HeapAlloc
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) > 0 {
+		t.Errorf("unexpected invalid attachments: %v", report)
+	}
+	if len(seen) != records {
+		t.Errorf("expected %d attachments, got %d", records, len(seen))
+	}
+	if seen["attZZZZZZZZZZZZZZ"] {
+		t.Error("attachment list should not have been consulted")
+	}
+	if maxHeap > uint64(fi.Size())/2 {
+		t.Errorf("heap grew to %d bytes while streaming a %d byte backup", maxHeap, fi.Size())
+	}
+}
+
+func TestDownloadAttachmentsFromBackupStreams(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	})
+	backupPath := path.Join(t.TempDir(), "backup.json")
+	writeLargeBackup(t, backupPath, 3)
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut the backup off partway through its last record
+	truncated := data[:bytes.Index(data, []byte(`"rec00000000000002"`))+10]
+	if err := os.WriteFile(backupPath, truncated, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = DownloadAttachmentsFromBackup(context.Background(), backupPath, dir, client, ExtractOptions{},
+		DownloadOptions{Workers: 1})
+	if err == nil {
+		t.Fatal("expected the truncated backup to be reported")
+	}
+	// the attachments read before the truncation were downloaded as they were found
+	for _, id := range []string{"att00000000000000", "att00000000000001"} {
+		if _, err := os.Stat(path.Join(dir, id)); err != nil {
+			t.Errorf("expected %s to have been downloaded: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+	"github.com/hashicorp/go-multierror"
+)
+
+// backupStream writes a backup while its tables are being listed, so that only one page of records is held in
+// memory at a time, rather than every record of every base. The file has the same format as a backup written all at
+// once, and is still only put into place once it is complete.
+type backupStream struct {
+	config   Config
+	client   *http.Client
+	pool     *DownloadPool
+	schemas  map[string]*api.BaseSchema
+	metrics  *Metrics
+	progress *Progress
+
+	// filled in as the backup is written
+	attachments []Attachment
+	report      AttachmentReport
+	tables      int
+	records     int
+	redacted    int // in the table being written
+	metadata    *BackupMetadata
+	waited      bool
+	downloadErr error
+}
+
+// run writes the backup as name, and records it in the catalog.
+func (s *backupStream) run(ctx context.Context, output Storage, name string, key EncryptionKey, startTime time.Time) error {
+	s.metadata = newMetadata(nil)
+	s.metadata.FailedTables = map[string]string{}
+	s.metadata.Started = &startTime
+	size, err := putMaybeEncrypted(ctx, output, name, key, func(w io.Writer) error {
+		return s.write(ctx, w)
+	})
+	if !s.waited {
+		s.downloadErr = s.pool.Wait()
+	}
+	s.progress.Finish()
+	if err != nil {
+		return multierror.Append(err, s.downloadErr)
+	}
+	if s.downloadErr != nil {
+		return s.downloadErr
+	}
+	entry := newCatalogEntry(name, size, &Backup{Config: s.config.Tables, Attachments: s.attachments}, startTime)
+	entry.Tables, entry.Records = s.tables, s.records
+	if err := addCatalogEntry(ctx, output, entry); err != nil {
+		return err
+	}
+	s.metrics.recordSuccess(s.config.Tables, clock.Or(s.config.Clock).Now())
+	return pruneBackups(ctx, output, s.config.Retain)
+}
+
+// write lays out the backup the way json.Encoder would indent it, writing each record as soon as it is listed.
+func (s *backupStream) write(ctx context.Context, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	if err := writeJSONField(buffered, "{\n  ", "config", s.config.Tables); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(buffered, ",\n  \"tables\": {"); err != nil {
+		return err
+	}
+	config := s.progress.watchTables(s.config)
+	client := withAppRateLimit(s.client, config.AppRateLimit(), config.Clock)
+	apps := make([]string, 0, len(config.Tables))
+	for app := range config.Tables {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		for _, table := range config.Tables[app] {
+			if err := s.writeTable(ctx, buffered, api.NewClerk(app, config.ClerkConfig(app), client), table); err != nil {
+				err := &TableError{App: app, Table: table, Err: err}
+				if !config.KeepGoing || ctx.Err() != nil {
+					return err
+				}
+				// the records listed before the failure stay in the backup, but are not counted as complete
+				s.metadata.FailedTables[table] = err.Err.Error()
+				loggerFrom(ctx).Error("Table failed; backing up the others", "app", app, "table", table,
+					"error", err.Err)
+			}
+		}
+	}
+	closing := "}"
+	if s.tables > 0 {
+		closing = "\n  }"
+	}
+	if _, err := io.WriteString(buffered, closing); err != nil {
+		return err
+	}
+	s.downloadErr, s.waited = s.pool.Wait(), true
+	if err := s.report.Check(loggerFrom(ctx), config.ExtractOptions); err != nil {
+		return err
+	}
+	for i := range s.attachments {
+		if downloaded, found := s.pool.Downloaded(s.attachments[i].Id); found {
+			s.attachments[i].SHA256 = downloaded.SHA256
+			s.attachments[i].File = downloaded.File
+		}
+	}
+	if err := writeJSONField(buffered, ",\n  ", "attachments", s.attachments); err != nil {
+		return err
+	}
+	if len(s.schemas) > 0 {
+		if err := writeJSONField(buffered, ",\n  ", "schemas", s.schemas); err != nil {
+			return err
+		}
+	}
+	if views := s.config.views(); views != nil {
+		if err := writeJSONField(buffered, ",\n  ", "views", views); err != nil {
+			return err
+		}
+	}
+	finished := clock.Or(s.config.Clock).Now()
+	s.metadata.Finished = &finished
+	if err := writeJSONField(buffered, ",\n  ", "metadata", s.metadata); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(buffered, "\n}\n"); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func (s *backupStream) writeTable(ctx context.Context, w io.Writer, clerk *api.Clerk, table string) error {
+	if timeout := s.config.TimeoutFor(table); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	separator := "\n    "
+	if s.tables > 0 {
+		separator = ",\n    "
+	}
+	tableKey, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s%s: [", separator, tableKey); err != nil {
+		return err
+	}
+	s.tables++
+	startTime := clock.Or(s.config.Clock).Now()
+	count := 0
+	s.redacted = 0
+	err = clerk.ListRecordsPages(ctx, table, s.config.listOptions(table, ""), func(page []api.Record) error {
+		s.config.TableFields[table].apply(page)
+		redacted, err := RedactRecords(page, table, s.config.RedactOptions)
+		if err != nil {
+			return err
+		}
+		s.redacted += redacted
+		if err := AnnotateRecords(page, clerk.App, table, s.config.AnnotateOptions); err != nil {
+			return err
+		}
+		for _, record := range page {
+			attachments, problems := ExtractRecordAttachments(table, record, s.config.ExtractOptions)
+			for _, attachment := range attachments {
+				s.pool.Add(attachment)
+			}
+			s.attachments = append(s.attachments, attachments...)
+			s.report = append(s.report, problems...)
+			data, err := json.MarshalIndent(record, "      ", "  ")
+			if err != nil {
+				return err
+			}
+			separator := "\n      "
+			if count > 0 {
+				separator = ",\n      "
+			}
+			if _, err := fmt.Fprintf(w, "%s%s", separator, data); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	s.progress.finishTable(table)
+	closing := "]"
+	if count > 0 {
+		closing = "\n    ]"
+	}
+	// the table is closed even if it failed, so that a keep-going backup is still valid
+	if _, closeErr := io.WriteString(w, closing); err == nil {
+		err = closeErr
+	}
+	s.records += count
+	if err != nil {
+		return err
+	}
+	s.metadata.Records[table] = count
+	s.metrics.recordRecords(clerk.App, table, count)
+	loggerFrom(ctx).Log(ctx, s.progress.logLevel(), "Listed records", "app", clerk.App, "table", table,
+		"records", count, "duration", clock.Or(s.config.Clock).Now().Sub(startTime))
+	logRedactions(ctx, clerk.App, table, s.redacted)
+	return nil
+}
+
+// writeJSONField writes a field of the top-level object, after the given separator.
+func writeJSONField(w io.Writer, separator, key string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%q: %s", separator, key, data)
+	return err
+}
@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestStreamOutput(t *testing.T) {
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/tblAAAAAAAAAAAAAA") && r.URL.Query().Get("offset") == "":
+			_, _ = fmt.Fprintf(w, `{"records": [{"id": "recAAAAAAAAAAAAAA", "createdTime": "", "fields": {"Files": [
+				{"id": "attAAAAAAAAAAAAAA", "url": "%sa", "size": 11, "filename": "a"}
+			]}}], "offset": "next"}`, DefaultAttachmentPrefixes[0])
+		case strings.HasSuffix(r.URL.Path, "/tblAAAAAAAAAAAAAA"):
+			_, _ = w.Write([]byte(`{"records": [{"id": "recBBBBBBBBBBBBBB", "createdTime": "", "fields": {"N": 1}}]}`))
+		case strings.HasPrefix(r.URL.Path, "/v0/"):
+			_, _ = w.Write([]byte(`{"records": []}`))
+		default:
+			_, _ = w.Write([]byte("hello world"))
+		}
+	})
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	backupPath := path.Join(dir, "backup.json")
+	err := Run(context.Background(), Options{
+		Config: Config{
+			Config:       api.Config{BearerToken: testToken},
+			Tables:       map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA", "tblBBBBBBBBBBBBBB"}},
+			SkipSchema:   true,
+			StreamOutput: true,
+		},
+		Client:       client,
+		OutputPath:   backupPath,
+		DownloadPath: downloadDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Tables["tblAAAAAAAAAAAAAA"]) != 2 || len(loaded.Tables["tblBBBBBBBBBBBBBB"]) != 0 {
+		t.Errorf("unexpected tables: %v", loaded.Tables)
+	}
+	if len(loaded.Attachments) != 1 || loaded.Attachments[0].SHA256 == "" {
+		t.Errorf("unexpected attachments: %v", loaded.Attachments)
+	}
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if encoded.String() != string(data) {
+		t.Errorf("streamed backup should be laid out like an encoded one:\n%s\nvs\n%s", data, encoded.String())
+	}
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Backups) != 1 || catalog.Backups[0].Records != 2 || catalog.Backups[0].Tables != 2 {
+		t.Errorf("unexpected catalog: %+v", catalog.Backups)
+	}
+}
@@ -0,0 +1,435 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/clock"
+	"github.com/hashicorp/go-multierror"
+)
+
+// SyncStateFilename is the file in a sync directory that records the last sync.
+const SyncStateFilename = "sync-state.json"
+
+// How Sync resolves conflicts, where a record was changed both locally and in AirTable since the last sync.
+const (
+	// SyncPreferNone leaves conflicting records alone: the local edits stay in the working copy, and are reported as
+	// conflicts by every sync until they are resolved.
+	SyncPreferNone = ""
+	// SyncPreferLocal pushes the local edits over the changes made in AirTable.
+	SyncPreferLocal = "local"
+	// SyncPreferRemote discards the local edits in favor of the changes made in AirTable.
+	SyncPreferRemote = "remote"
+)
+
+// syncState is the state of every synced table as of the last sync, which both local edits and changes in AirTable
+// are found relative to.
+type syncState struct {
+	Tables map[string]*syncedTable `json:"tables"`
+	// Conflicts lists the records whose conflicts have not been resolved yet.
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+type syncedTable struct {
+	// Synced is when the table was last listed.
+	Synced time.Time `json:"synced"`
+	// Records holds the fields of each record, by ID.
+	Records map[string]map[string]interface{} `json:"records"`
+}
+
+// SyncConflict is a record that was changed both locally and in AirTable.
+type SyncConflict struct {
+	Table  string
+	Record string
+	Reason string
+}
+
+// SyncReport counts the changes that a sync made.
+type SyncReport struct {
+	// Updated, Created, and Deleted count the records pushed to AirTable.
+	Updated, Created, Deleted int
+	// Pulled counts the records that had changed in AirTable, including those created and deleted there.
+	Pulled    int
+	Conflicts []SyncConflict
+}
+
+// SyncOptions describes a sync between AirTable and a local working copy.
+type SyncOptions struct {
+	Config Config
+	// Client is used for every request to AirTable; nil means a client with the configuration's HTTP settings.
+	Client *http.Client
+	// Dir holds the working copy: a JSON Lines file of records for each table, at <app>/<table>.jsonl, in the format
+	// of LayoutNDJSON, along with the sync state.
+	Dir string
+	// Prefer is SyncPreferNone, SyncPreferLocal, or SyncPreferRemote.
+	Prefer string
+}
+
+// Sync makes the working copy in Dir and the configured tables in AirTable match. Records edited, added (with an
+// empty ID), or removed in the working copy since the last sync are pushed to AirTable, and then every table is
+// pulled into the working copy again. A record changed locally that was also modified in AirTable since the last sync,
+// by its last modified time, is a conflict, which is resolved as Prefer says. The first sync only pulls.
+func Sync(ctx context.Context, opts SyncOptions) (*SyncReport, error) {
+	config, client := opts.Config, opts.Client
+	if client == nil {
+		var err error
+		if client, err = config.HTTP.Client(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Prefer != SyncPreferNone && opts.Prefer != SyncPreferLocal && opts.Prefer != SyncPreferRemote {
+		return nil, fmt.Errorf("invalid conflict preference %q", opts.Prefer)
+	}
+	config, err := DiscoverTables(ctx, config, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	dir := LocalStorage(opts.Dir)
+	state, err := loadSyncState(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	client = withAppRateLimit(client, config.AppRateLimit(), config.Clock)
+	report := &SyncReport{}
+	// tables that are not synced, such as after a failure, keep their last state
+	next := &syncState{Tables: map[string]*syncedTable{}}
+	for table, synced := range state.Tables {
+		next.Tables[table] = synced
+	}
+	err = syncTables(ctx, dir, config, client, opts.Prefer, state, next, report)
+	conflicts := map[string]bool{}
+	if err != nil {
+		// the conflicts in the tables that were not reached remain
+		for _, id := range state.Conflicts {
+			conflicts[id] = true
+		}
+	}
+	for _, conflict := range report.Conflicts {
+		conflicts[conflict.Record] = true
+	}
+	for id := range conflicts {
+		next.Conflicts = append(next.Conflicts, id)
+	}
+	sort.Strings(next.Conflicts)
+	if _, saveErr := putJSON(ctx, dir, SyncStateFilename, nil, next); err == nil {
+		err = saveErr
+	}
+	return report, err
+}
+
+func syncTables(ctx context.Context, dir LocalStorage, config Config, client *http.Client, prefer string,
+	state, next *syncState, report *SyncReport) error {
+	apps := make([]string, 0, len(config.Tables))
+	for app := range config.Tables {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	unresolved := map[string]bool{}
+	for _, id := range state.Conflicts {
+		unresolved[id] = true
+	}
+	for _, app := range apps {
+		clerk := api.NewClerk(app, config.ClerkConfig(app), client)
+		// typecasting fills in the choices of select fields that were typed in locally
+		clerk.Typecast = true
+		for _, table := range config.Tables[app] {
+			s := &tableSync{
+				clerk:      clerk,
+				config:     config,
+				file:       path.Join(app, table+".jsonl"),
+				table:      table,
+				prefer:     prefer,
+				unresolved: unresolved,
+				report:     report,
+			}
+			if err := s.sync(ctx, dir, state, next); err != nil {
+				return &TableError{App: app, Table: table, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+func loadSyncState(ctx context.Context, dir LocalStorage) (*syncState, error) {
+	f, err := dir.Open(ctx, SyncStateFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &syncState{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var state syncState
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, fmt.Errorf("invalid sync state %q: %w", dir.Location(SyncStateFilename), err)
+	}
+	return &state, nil
+}
+
+// tableSync syncs one table.
+type tableSync struct {
+	clerk      *api.Clerk
+	config     Config
+	file       string
+	table      string
+	prefer     string
+	unresolved map[string]bool
+	report     *SyncReport
+
+	// the local versions of the records whose conflicts remain, which stay in the working copy
+	kept map[string]*api.Record
+}
+
+func (s *tableSync) sync(ctx context.Context, dir LocalStorage, state, next *syncState) error {
+	base, found := state.Tables[s.table]
+	if !found {
+		if _, exists, err := dir.Stat(ctx, s.file); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("%s has not been synced before; move it aside to start over",
+				dir.Location(s.file))
+		}
+	} else if err := s.push(ctx, dir, base); err != nil {
+		return err
+	}
+	// changes made after the table is listed, but not those pushed before, are found by the next sync
+	synced := clock.Or(s.config.Clock).Now()
+	records, err := s.clerk.ListRecords(ctx, s.table, s.config.listOptions(s.table, ""))
+	if err != nil {
+		return err
+	}
+	if !found {
+		s.report.Pulled += len(records)
+	}
+	fields := map[string]map[string]interface{}{}
+	var local []api.Record
+	for _, record := range records {
+		fields[record.Id] = record.Fields
+		if kept, found := s.kept[record.Id]; found {
+			if kept != nil {
+				local = append(local, *kept)
+			}
+			delete(s.kept, record.Id)
+			continue
+		}
+		local = append(local, record)
+	}
+	// records edited locally but deleted in AirTable stay conflicts, with their last synced fields as their base
+	var deleted []string
+	for id, kept := range s.kept {
+		if kept != nil {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(deleted)
+	for _, id := range deleted {
+		local = append(local, *s.kept[id])
+		fields[id] = base.Records[id]
+	}
+	if err := s.save(ctx, dir, local); err != nil {
+		return err
+	}
+	next.Tables[s.table] = &syncedTable{Synced: synced, Records: fields}
+	return nil
+}
+
+// save replaces the working copy of the table.
+func (s *tableSync) save(ctx context.Context, dir LocalStorage, records []api.Record) error {
+	_, err := putMaybeEncrypted(ctx, dir, s.file, nil, func(w io.Writer) error {
+		// json.Encoder writes each record on a line of its own
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// push writes the local edits made since the last sync to AirTable, apart from the conflicts that are left alone. If
+// pushing fails partway, what was pushed is recorded in table and in the working copy, with the IDs of the records
+// created, so that the next sync neither pushes it again nor takes it for a conflict.
+func (s *tableSync) push(ctx context.Context, dir LocalStorage, table *syncedTable) error {
+	base := table.Records
+	local, err := loadTableFile(ctx, dir, s.file, nil)
+	if err != nil {
+		return err
+	}
+	remote := map[string]map[string]interface{}{}
+	err = s.clerk.ListRecordsPages(ctx, s.table, s.config.listOptions(s.table, ""), func(page []api.Record) error {
+		for _, record := range page {
+			remote[record.Id] = record.Fields
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	modified := map[string]bool{}
+	err = s.clerk.ListRecordsPages(ctx, s.table, s.config.listOptions(s.table, modifiedSinceFormula(table.Synced)),
+		func(page []api.Record) error {
+			for _, record := range page {
+				modified[record.Id] = true
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	for id, fields := range remote {
+		if baseFields, found := base[id]; !found || !reflect.DeepEqual(fields, baseFields) {
+			s.report.Pulled++
+		}
+	}
+	for id := range base {
+		if _, found := remote[id]; !found {
+			s.report.Pulled++
+		}
+	}
+	s.kept = map[string]*api.Record{}
+	// conflicted reports whether a local change to a record conflicts with a change in AirTable, and so whether it
+	// should be left alone
+	conflicted := func(record api.Record, reason string) bool {
+		_, exists := remote[record.Id]
+		if !modified[record.Id] && exists && !s.unresolved[record.Id] {
+			return false
+		}
+		switch s.prefer {
+		case SyncPreferLocal:
+			return false
+		case SyncPreferRemote:
+			return true
+		}
+		s.report.Conflicts = append(s.report.Conflicts, SyncConflict{Table: s.table, Record: record.Id, Reason: reason})
+		return true
+	}
+	var updates []api.Record
+	var creates []map[string]interface{}
+	// createdFrom holds the index in local of each record in creates
+	var createdFrom []int
+	var deletes []string
+	seen := map[string]bool{}
+	for i, record := range local {
+		if record.Id == "" {
+			creates, createdFrom = append(creates, record.Fields), append(createdFrom, i)
+			continue
+		}
+		seen[record.Id] = true
+		baseFields, found := base[record.Id]
+		if !found {
+			return fmt.Errorf("record %s in the working copy was not synced from this table", record.Id)
+		}
+		changes := changedFields(baseFields, record.Fields)
+		if len(changes) == 0 {
+			continue
+		}
+		if conflicted(record, "edited locally and changed in AirTable") {
+			if s.prefer == SyncPreferNone {
+				s.kept[record.Id] = &local[i]
+			}
+			continue
+		}
+		if _, exists := remote[record.Id]; !exists {
+			// deleted in AirTable, but the local edits are preferred
+			creates, createdFrom = append(creates, record.Fields), append(createdFrom, i)
+			continue
+		}
+		updates = append(updates, api.Record{Id: record.Id, Fields: changes})
+	}
+	for id := range base {
+		if seen[id] {
+			continue
+		}
+		if _, exists := remote[id]; !exists {
+			continue
+		}
+		if conflicted(api.Record{Id: id}, "removed locally and changed in AirTable") {
+			if s.prefer == SyncPreferNone {
+				s.kept[id] = nil
+			}
+			continue
+		}
+		deletes = append(deletes, id)
+	}
+	sort.Strings(deletes)
+	localFields := map[string]map[string]interface{}{}
+	for _, record := range local {
+		localFields[record.Id] = record.Fields
+	}
+	updated, err := s.clerk.UpdateAllRecords(ctx, s.table, updates)
+	for _, record := range updated {
+		base[record.Id] = localFields[record.Id]
+	}
+	s.report.Updated += len(updated)
+	if err != nil {
+		return s.keepPushed(ctx, dir, local, fmt.Errorf("pushing edited records: %w", err))
+	}
+	created, err := s.clerk.CreateAllRecords(ctx, s.table, creates)
+	for i, record := range created {
+		from := &local[createdFrom[i]]
+		delete(base, from.Id)
+		from.Id = record.Id
+		base[record.Id] = from.Fields
+	}
+	s.report.Created += len(created)
+	if err != nil {
+		return s.keepPushed(ctx, dir, local, fmt.Errorf("pushing new records: %w", err))
+	}
+	deleted, err := s.clerk.DeleteAllRecords(ctx, s.table, deletes)
+	for _, id := range deleted {
+		delete(base, id)
+	}
+	s.report.Deleted += len(deleted)
+	if err != nil {
+		return s.keepPushed(ctx, dir, local, fmt.Errorf("pushing removed records: %w", err))
+	}
+	return nil
+}
+
+// keepPushed saves the working copy, with the IDs of the records that were created, after pushing failed with err.
+// The synced state, which push has updated, is saved by Sync.
+func (s *tableSync) keepPushed(ctx context.Context, dir LocalStorage, local []api.Record, err error) error {
+	if saveErr := s.save(ctx, dir, local); saveErr != nil {
+		return multierror.Append(err, saveErr)
+	}
+	return err
+}
+
+// changedFields returns the fields that differ between two versions of a record, with the fields that were removed
+// set to nil, which clears them.
+func changedFields(before, after map[string]interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			changes[name] = value
+		}
+	}
+	for name := range before {
+		if _, found := after[name]; !found {
+			changes[name] = nil
+		}
+	}
+	return changes
+}
+
+// Print writes the report in a human-readable form.
+func (r *SyncReport) Print(w io.Writer) {
+	for _, conflict := range r.Conflicts {
+		_, _ = fmt.Fprintf(w, "Conflict: table %s, record %s: %s\n", conflict.Table, conflict.Record, conflict.Reason)
+	}
+	_, _ = fmt.Fprintf(w, "Pushed %d edited, %d new, and %d removed records; pulled %d changed records; %d conflicts\n",
+		r.Updated, r.Created, r.Deleted, r.Pulled, len(r.Conflicts))
+}
@@ -0,0 +1,204 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// fakeTable serves a single table that records can be listed from, written to, and deleted from.
+type fakeTable struct {
+	t        *testing.T
+	records  map[string]map[string]interface{}
+	modified map[string]bool
+	// failDeletes rejects requests to delete records
+	failDeletes bool
+}
+
+func (f *fakeTable) serve(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var reply api.ListRecordsReply
+		for id, fields := range f.records {
+			if r.URL.Query().Get("filterByFormula") == "" || f.modified[id] {
+				reply.Records = append(reply.Records, api.Record{Id: id, Fields: fields})
+			}
+		}
+		sort.Slice(reply.Records, func(i, j int) bool {
+			return reply.Records[i].Id < reply.Records[j].Id
+		})
+		_ = json.NewEncoder(w).Encode(reply)
+	case http.MethodPatch, http.MethodPost:
+		var request struct {
+			Records []api.Record `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			f.t.Fatal(err)
+		}
+		for i, record := range request.Records {
+			if r.Method == http.MethodPost {
+				record.Id = "recNEWNEWNEWNEWNE"
+				f.records[record.Id] = map[string]interface{}{}
+			}
+			for name, value := range record.Fields {
+				f.records[record.Id][name] = value
+			}
+			f.modified[record.Id] = true
+			request.Records[i] = api.Record{Id: record.Id, Fields: f.records[record.Id]}
+		}
+		_ = json.NewEncoder(w).Encode(api.WriteRecordsReply{Records: request.Records})
+	case http.MethodDelete:
+		if f.failDeletes {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"error": {"type": "INVALID_REQUEST_UNKNOWN", "message": "rejected"}}`))
+			return
+		}
+		var deleted []map[string]interface{}
+		for _, id := range r.URL.Query()["records[]"] {
+			delete(f.records, id)
+			deleted = append(deleted, map[string]interface{}{"id": id, "deleted": true})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"records": deleted})
+	}
+}
+
+func TestSync(t *testing.T) {
+	remote := &fakeTable{t: t, modified: map[string]bool{}, records: map[string]map[string]interface{}{
+		"recAAAAAAAAAAAAAA": {"Name": "a"},
+		"recBBBBBBBBBBBBBB": {"Name": "b"},
+		"recCCCCCCCCCCCCCC": {"Name": "c"},
+	}}
+	client := newAirTableServer(t, remote.serve)
+	dir := t.TempDir()
+	opts := SyncOptions{
+		Config: Config{
+			Config:               api.Config{BearerToken: testToken},
+			Tables:               map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			AppRequestsPerSecond: 1000,
+		},
+		Client: client,
+		Dir:    dir,
+	}
+	report, err := Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pulled != 3 {
+		t.Errorf("the first sync should pull every record: %+v", report)
+	}
+
+	file := path.Join(dir, "appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA.jsonl")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// edit a and b, remove c, and add d, while b is also changed in AirTable
+	edited := strings.NewReplacer(`"Name":"a"`, `"Name":"a2"`, `"Name":"b"`, `"Name":"b-local"`).Replace(string(data))
+	lines := strings.Split(strings.TrimSpace(edited), "\n")
+	edited = lines[0] + "\n" + lines[1] + "\n" + `{"id":"","fields":{"Name":"d"}}` + "\n"
+	if err := os.WriteFile(file, []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remote.records["recBBBBBBBBBBBBBB"]["Name"] = "b-remote"
+	remote.modified["recBBBBBBBBBBBBBB"] = true
+	report, err = Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Created != 1 || report.Deleted != 1 || len(report.Conflicts) != 1 ||
+		report.Conflicts[0].Record != "recBBBBBBBBBBBBBB" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	expected := map[string]map[string]interface{}{
+		"recAAAAAAAAAAAAAA": {"Name": "a2"},
+		"recBBBBBBBBBBBBBB": {"Name": "b-remote"},
+		"recNEWNEWNEWNEWNE": {"Name": "d"},
+	}
+	if !reflect.DeepEqual(remote.records, expected) {
+		t.Errorf("unexpected records in AirTable: %v", remote.records)
+	}
+	local, err := loadTableFile(context.Background(), LocalStorage(dir), path.Join("appAAAAAAAAAAAAAA",
+		"tblAAAAAAAAAAAAAA.jsonl"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != 3 || local[1].Fields["Name"] != "b-local" {
+		t.Errorf("the conflicting edit should stay in the working copy: %v", local)
+	}
+
+	// the conflict remains until it is resolved
+	report, err = Sync(context.Background(), opts)
+	if err != nil || len(report.Conflicts) != 1 {
+		t.Errorf("the conflict should be reported again: %+v %v", report, err)
+	}
+	opts.Prefer = SyncPreferRemote
+	report, err = Sync(context.Background(), opts)
+	if err != nil || len(report.Conflicts) != 0 || report.Updated != 0 {
+		t.Errorf("the conflict should be resolved: %+v %v", report, err)
+	}
+	local, err = loadTableFile(context.Background(), LocalStorage(dir), path.Join("appAAAAAAAAAAAAAA",
+		"tblAAAAAAAAAAAAAA.jsonl"), nil)
+	if err != nil || len(local) != 3 || local[1].Fields["Name"] != "b-remote" {
+		t.Errorf("the remote version should have been pulled: %v %v", local, err)
+	}
+}
+
+func TestSyncKeepsWhatWasPushedBeforeFailing(t *testing.T) {
+	remote := &fakeTable{t: t, modified: map[string]bool{}, records: map[string]map[string]interface{}{
+		"recAAAAAAAAAAAAAA": {"Name": "a"},
+		"recBBBBBBBBBBBBBB": {"Name": "b"},
+	}}
+	opts := SyncOptions{
+		Config: Config{
+			Config:               api.Config{BearerToken: testToken},
+			Tables:               map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}},
+			AppRequestsPerSecond: 1000,
+		},
+		Client: newAirTableServer(t, remote.serve),
+		Dir:    t.TempDir(),
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	// edit a, remove b, and add c, but fail to remove b
+	file := path.Join(opts.Dir, "appAAAAAAAAAAAAAA", "tblAAAAAAAAAAAAAA.jsonl")
+	edited := `{"id":"recAAAAAAAAAAAAAA","createdTime":"","fields":{"Name":"a2"}}` + "\n" +
+		`{"id":"","fields":{"Name":"c"}}` + "\n"
+	if err := os.WriteFile(file, []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remote.failDeletes = true
+	report, err := Sync(context.Background(), opts)
+	if err == nil || report.Updated != 1 || report.Created != 1 || report.Deleted != 0 {
+		t.Fatalf("expected the edit and the new record to be pushed before failing: %+v %v", report, err)
+	}
+	local, err := loadTableFile(context.Background(), LocalStorage(opts.Dir), path.Join("appAAAAAAAAAAAAAA",
+		"tblAAAAAAAAAAAAAA.jsonl"), nil)
+	if err != nil || len(local) != 2 || local[1].Id != "recNEWNEWNEWNEWNE" {
+		t.Errorf("expected the new record to have its ID in the working copy: %v %v", local, err)
+	}
+
+	remote.failDeletes = false
+	report, err = Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 0 || report.Created != 0 || report.Deleted != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("expected only the removal to be pushed again: %+v", report)
+	}
+	expected := map[string]map[string]interface{}{
+		"recAAAAAAAAAAAAAA": {"Name": "a2"},
+		"recNEWNEWNEWNEWNE": {"Name": "c"},
+	}
+	if !reflect.DeepEqual(remote.records, expected) {
+		t.Errorf("unexpected records in AirTable: %v", remote.records)
+	}
+}
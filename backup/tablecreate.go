@@ -0,0 +1,252 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+// primaryFieldTypes are the field types that AirTable allows as the primary field of a table.
+var primaryFieldTypes = map[string]bool{
+	"singleLineText": true,
+	"multilineText":  true,
+	"number":         true,
+	"date":           true,
+	"dateTime":       true,
+}
+
+// fieldSpecFor maps an inferred field type onto a field that AirTable can create. Linked records are not supported,
+// because the table they link to may not exist yet; Restore adds them once every table exists.
+func fieldSpecFor(field DictionaryField) (api.FieldSpec, bool) {
+	spec := api.FieldSpec{Name: field.Name}
+	switch field.Type {
+	case FieldTypeText:
+		spec.Type = "singleLineText"
+		if s, ok := field.Sample.(string); ok && strings.Contains(s, "\n") {
+			spec.Type = "multilineText"
+		}
+	case FieldTypeNumber:
+		spec.Type = "number"
+		spec.Options = map[string]interface{}{"precision": 8}
+	case FieldTypeCheckbox:
+		spec.Type = "checkbox"
+		spec.Options = map[string]interface{}{"icon": "check", "color": "greenBright"}
+	case FieldTypeDate:
+		spec.Type = "date"
+		spec.Options = map[string]interface{}{"dateFormat": map[string]interface{}{"name": "iso"}}
+	case FieldTypeDateTime:
+		spec.Type = "dateTime"
+		spec.Options = map[string]interface{}{
+			"dateFormat": map[string]interface{}{"name": "iso"},
+			"timeFormat": map[string]interface{}{"name": "24hour"},
+			"timeZone":   "utc",
+		}
+	case FieldTypeMultiSelect:
+		// the choices are filled in by typecasting when the records are restored
+		spec.Type = "multipleSelects"
+		spec.Options = map[string]interface{}{"choices": []interface{}{}}
+	case FieldTypeAttachments:
+		spec.Type = "multipleAttachments"
+	case FieldTypeCollaborator:
+		spec.Type = "singleCollaborator"
+	default:
+		return api.FieldSpec{}, false
+	}
+	return spec, true
+}
+
+// schemaFieldTypes are the field types that can be created from a backed-up schema, and whether their options can be
+// written back the way they were read. The other fields are computed by AirTable, or are linked records, which
+// Restore adds once every table exists.
+var schemaFieldTypes = map[string]bool{
+	"singleLineText":        false,
+	"multilineText":         false,
+	"richText":              false,
+	"email":                 false,
+	"url":                   false,
+	"phoneNumber":           false,
+	"barcode":               false,
+	"multipleAttachments":   false,
+	"singleCollaborator":    false,
+	"multipleCollaborators": false,
+	"number":                true,
+	"percent":               true,
+	"currency":              true,
+	"duration":              true,
+	"rating":                true,
+	"checkbox":              true,
+	"date":                  true,
+	"dateTime":              true,
+	"singleSelect":          true,
+	"multipleSelects":       true,
+}
+
+// writableOptions copies the options of a backed-up field for creating it again. The IDs of select choices are left
+// out, since AirTable assigns new ones.
+func writableOptions(options map[string]interface{}) map[string]interface{} {
+	if options == nil {
+		return nil
+	}
+	writable := map[string]interface{}{}
+	for key, value := range options {
+		writable[key] = value
+	}
+	if choices, ok := options["choices"].([]interface{}); ok {
+		var named []interface{}
+		for _, choice := range choices {
+			if choiceMap, ok := choice.(map[string]interface{}); ok {
+				copied := map[string]interface{}{}
+				for key, value := range choiceMap {
+					if key != "id" {
+						copied[key] = value
+					}
+				}
+				choice = copied
+			}
+			named = append(named, choice)
+		}
+		writable["choices"] = named
+	}
+	return writable
+}
+
+// TableSpecFromSchema builds a table definition from the backed-up schema of a table, named after its ID, keeping the
+// types and options of its fields. It lists the fields that cannot be created, apart from linked records. A primary
+// field that cannot be created, such as a formula, is created as text.
+func TableSpecFromSchema(table api.TableSchema) (spec api.TableSpec, unsupported []string) {
+	spec.Name, spec.Description = table.Id, table.Description
+	primary := api.FieldSpec{Name: "Name", Type: "singleLineText"}
+	for _, field := range table.Fields {
+		keepOptions, ok := schemaFieldTypes[field.Type]
+		fieldSpec := api.FieldSpec{Name: field.Name, Type: field.Type, Description: field.Description}
+		if keepOptions {
+			fieldSpec.Options = writableOptions(field.Options)
+		}
+		if field.Id == table.PrimaryFieldId {
+			if !ok || !primaryFieldTypes[field.Type] {
+				fieldSpec.Type, fieldSpec.Options = "singleLineText", nil
+			}
+			primary = fieldSpec
+			continue
+		}
+		if !ok {
+			if field.Type != "multipleRecordLinks" {
+				unsupported = append(unsupported, fmt.Sprintf("%s (%s)", field.Name, field.Type))
+			}
+			continue
+		}
+		spec.Fields = append(spec.Fields, fieldSpec)
+	}
+	spec.Fields = append([]api.FieldSpec{primary}, spec.Fields...)
+	return spec, unsupported
+}
+
+// TableSpecFromDictionary builds a table definition from the fields observed in a backup, and lists the fields
+// that could not be mapped to a creatable type.
+func TableSpecFromDictionary(name string, fields []DictionaryField) (spec api.TableSpec, unsupported []string) {
+	spec.Name = name
+	primary := -1
+	for _, field := range fields {
+		fieldSpec, ok := fieldSpecFor(field)
+		if !ok {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", field.Name, field.Type))
+			continue
+		}
+		if primaryFieldTypes[fieldSpec.Type] && (primary < 0 || fieldSpec.Name == "Name") {
+			primary = len(spec.Fields)
+		}
+		spec.Fields = append(spec.Fields, fieldSpec)
+	}
+	if primary < 0 {
+		spec.Fields = append([]api.FieldSpec{{Name: "Name", Type: "singleLineText"}}, spec.Fields...)
+	} else {
+		spec.Fields[0], spec.Fields[primary] = spec.Fields[primary], spec.Fields[0]
+	}
+	return spec, unsupported
+}
+
+// CreateTablesFromDictionary creates one table in the Clerk's base for each table in the dictionary, named after
+// the ID of the original table, and returns the mapping from original table IDs to new table IDs.
+func CreateTablesFromDictionary(ctx context.Context, clerk *api.Clerk, dictionary DataDictionary) (map[string]string, error) {
+	created := map[string]string{}
+	for _, table := range dictionary.sortedTables() {
+		spec, unsupported := TableSpecFromDictionary(table, dictionary[table])
+		for _, field := range unsupported {
+			loggerFrom(ctx).Warn("Cannot create field; skipping it", "table", table, "field", field)
+		}
+		result, err := clerk.CreateTable(ctx, spec)
+		if err != nil {
+			return created, fmt.Errorf("creating table %s: %w", table, err)
+		}
+		created[table] = result.Id
+	}
+	return created, nil
+}
+
+// CreateTablesFromBackup creates one table in the Clerk's base for each table in a backup, named after the ID of the
+// original table. Tables are built from the backed-up schema, or from the data dictionary for backups taken without
+// one. It returns the mapping from original table IDs to new table IDs, and the dictionary of the fields that were
+// created in each table.
+func CreateTablesFromBackup(ctx context.Context, clerk *api.Clerk, backup *Backup) (map[string]string, DataDictionary,
+	error) {
+	return createTablesFromBackup(ctx, clerk, backup, nil)
+}
+
+// createTablesFromBackup is CreateTablesFromBackup, with extra fields added to every table. The extra fields are not
+// part of the returned dictionary.
+func createTablesFromBackup(ctx context.Context, clerk *api.Clerk, backup *Backup, extra []api.FieldSpec) (
+	map[string]string, DataDictionary, error) {
+	schemas := map[string]api.TableSchema{}
+	for _, schema := range backup.Schemas {
+		for _, table := range schema.Tables {
+			schemas[table.Id] = table
+		}
+	}
+	dictionary := BuildDataDictionary(backup.Tables)
+	created := map[string]string{}
+	for _, table := range dictionary.sortedTables() {
+		schema, found := schemas[table]
+		spec, unsupported := TableSpecFromDictionary(table, dictionary[table])
+		if found {
+			spec, unsupported = TableSpecFromSchema(schema)
+		}
+		for _, field := range unsupported {
+			loggerFrom(ctx).Warn("Cannot create field; skipping it", "table", table, "field", field)
+		}
+		for _, field := range extra {
+			for _, existing := range spec.Fields {
+				if existing.Name == field.Name {
+					return created, dictionary, fmt.Errorf("creating table %s: it already has a field named %q",
+						table, field.Name)
+				}
+			}
+		}
+		spec.Fields = append(spec.Fields, extra...)
+		result, err := clerk.CreateTable(ctx, spec)
+		if err != nil {
+			return created, dictionary, fmt.Errorf("creating table %s: %w", table, err)
+		}
+		created[table] = result.Id
+		if found {
+			dictionary[table] = createdFields(dictionary[table], spec)
+		}
+	}
+	return created, dictionary, nil
+}
+
+// createdFields keeps the fields of a dictionary that were created in a table.
+func createdFields(fields []DictionaryField, spec api.TableSpec) []DictionaryField {
+	names := map[string]bool{}
+	for _, field := range spec.Fields {
+		names[field.Name] = true
+	}
+	var kept []DictionaryField
+	for _, field := range fields {
+		if names[field.Name] {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+)
+
+func TestCreateTablesFromDictionary(t *testing.T) {
+	var specs []api.TableSpec
+	client := newAirTableServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v0/meta/bases/appNNNNNNNNNNNNNN/tables" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var spec api.TableSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, spec)
+		_, _ = w.Write([]byte(`{"id": "tblNNNNNNNNNNNNNN", "name": "x", "primaryFieldId": "fldNNNNNNNNNNNNNN", "fields": []}`))
+	})
+	dictionary := DataDictionary{
+		"tblAAAAAAAAAAAAAA": {
+			{Name: "Count", Type: FieldTypeNumber, Sample: 1.0},
+			{Name: "Done", Type: FieldTypeCheckbox, Sample: true},
+			{Name: "Links", Type: FieldTypeLinkedRecords, Sample: []interface{}{"recAAAAAAAAAAAAAA"}},
+			{Name: "Name", Type: FieldTypeText, Sample: "Widget"},
+			{Name: "Weird", Type: FieldTypeMixed, Sample: 1.0},
+		},
+	}
+	clerk := api.NewClerk("appNNNNNNNNNNNNNN", api.Config{BearerToken: testToken}, client)
+	created, err := CreateTablesFromDictionary(context.Background(), clerk, dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created["tblAAAAAAAAAAAAAA"] != "tblNNNNNNNNNNNNNN" {
+		t.Errorf("unexpected mapping: %v", created)
+	}
+	if len(specs) != 1 {
+		t.Fatalf("expected one table to be created, got %d", len(specs))
+	}
+	types := map[string]string{}
+	for _, field := range specs[0].Fields {
+		types[field.Name] = field.Type
+	}
+	expected := map[string]string{"Name": "singleLineText", "Count": "number", "Done": "checkbox"}
+	if len(types) != len(expected) {
+		t.Errorf("unexpected fields: %v", types)
+	}
+	for name, fieldType := range expected {
+		if types[name] != fieldType {
+			t.Errorf("field %q: expected %q, got %q", name, fieldType, types[name])
+		}
+	}
+	if specs[0].Fields[0].Name != "Name" {
+		t.Errorf("Name should be the primary field, not %q", specs[0].Fields[0].Name)
+	}
+	_, unsupported := TableSpecFromDictionary("t", dictionary["tblAAAAAAAAAAAAAA"])
+	if len(unsupported) != 2 {
+		t.Errorf("expected linked records and mixed fields to be unsupported, got %v", unsupported)
+	}
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSheetName picks a unique sheet name for a table, since sheet names are limited to 31 characters, cannot
+// contain some punctuation, and are compared without regard to case.
+func xlsxSheetName(used uniqueNames, name string) string {
+	name = strings.NewReplacer(":", "_", "\\", "_", "/", "_", "?", "_", "*", "_", "[", "(", "]", ")").Replace(name)
+	name = strings.Trim(name, "'")
+	if name == "" {
+		name = "_"
+	}
+	unique := truncateRunes(name, excelize.MaxSheetNameLength)
+	for i := 2; used[strings.ToLower(unique)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		unique = truncateRunes(name, excelize.MaxSheetNameLength-len(suffix)) + suffix
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// listedAttachments returns the attachments that a field value lists, if it is an attachment field.
+func listedAttachments(value interface{}, attachments map[string]Attachment) ([]Attachment, bool) {
+	items, ok := value.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+	var found []Attachment
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, _ := itemMap["id"].(string)
+		attachment, ok := attachments[id]
+		if !ok {
+			return nil, false
+		}
+		found = append(found, attachment)
+	}
+	return found, true
+}
+
+// xlsxWorkbook writes the tables of a backup into the sheets of a workbook.
+type xlsxWorkbook struct {
+	file        *excelize.File
+	attachments map[string]Attachment
+	// downloadPath is the directory holding the downloaded attachments, or empty to not link to them, and linkDir is
+	// the same directory relative to the workbook where possible.
+	downloadPath string
+	linkDir      string
+}
+
+func (w *xlsxWorkbook) writeSheet(sheet string, fields []DictionaryField, records []api.Record) error {
+	header := []interface{}{"id", "createdTime"}
+	for _, field := range fields {
+		header = append(header, field.Name)
+	}
+	if err := w.file.SetSheetRow(sheet, "A1", &header); err != nil {
+		return err
+	}
+	for i, record := range records {
+		row := []interface{}{record.Id, record.CreatedTime}
+		var links []string
+		for _, field := range fields {
+			value, link, err := w.cell(record.Fields[field.Name])
+			if err != nil {
+				return err
+			}
+			row = append(row, value)
+			links = append(links, link)
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err := w.file.SetSheetRow(sheet, cell, &row); err != nil {
+			return err
+		}
+		for j, link := range links {
+			if link == "" {
+				continue
+			}
+			cell, err := excelize.CoordinatesToCellName(j+3, i+2)
+			if err != nil {
+				return err
+			}
+			if err := w.file.SetCellHyperLink(sheet, cell, link, "External"); err != nil {
+				return err
+			}
+		}
+	}
+	// the header stays in view while scrolling
+	panes := &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}
+	return w.file.SetPanes(sheet, panes)
+}
+
+// cell converts a field value into a cell's value, and a link to the downloaded attachment it lists, if any. Text,
+// numbers, and checkboxes are written as they are; attachments as their filenames; and everything else as JSON.
+func (w *xlsxWorkbook) cell(value interface{}) (interface{}, string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, "", nil
+	case float64, bool:
+		return v, "", nil
+	}
+	if attachments, ok := listedAttachments(value, w.attachments); ok {
+		var filenames []string
+		for _, attachment := range attachments {
+			if attachment.Filename != "" {
+				filenames = append(filenames, attachment.Filename)
+			} else {
+				filenames = append(filenames, attachment.Id)
+			}
+		}
+		// a cell holds only one link, so it leads to the first attachment
+		return strings.Join(filenames, "\n"), w.link(attachments[0]), nil
+	}
+	text, err := csvCell(value)
+	if err != nil {
+		return nil, "", err
+	}
+	// longer text cannot be stored in a cell
+	return truncateRunes(text, excelize.TotalCellChars), "", nil
+}
+
+// link returns the link to a downloaded attachment, or "" if it was not downloaded.
+func (w *xlsxWorkbook) link(attachment Attachment) string {
+	if w.downloadPath == "" || attachment.UnexpectedPrefix {
+		return ""
+	}
+	file := attachment.File
+	if file == "" {
+		file = attachment.DownloadFilename(false)
+	}
+	if _, err := os.Stat(filepath.Join(w.downloadPath, filepath.FromSlash(file))); err != nil {
+		return ""
+	}
+	return filepath.ToSlash(filepath.Join(w.linkDir, filepath.FromSlash(file)))
+}
+
+// ExportXLSX writes the backup as an Excel workbook at exportPath, with a sheet for each table. When downloadPath is
+// the directory the backup's attachments were downloaded into, attachment cells link to the downloaded files.
+func ExportXLSX(backup *Backup, exportPath, downloadPath string) error {
+	linkDir, err := attachmentLinkDir(exportPath, downloadPath)
+	if err != nil {
+		return err
+	}
+	return WriteXLSX(backup, PathOpener(exportPath), downloadPath, linkDir)
+}
+
+// WriteXLSX writes the backup as an Excel workbook, opened with open. Attachment cells link to the files downloaded
+// into downloadPath, if it is set, under linkDir.
+func WriteXLSX(backup *Backup, open Opener, downloadPath, linkDir string) error {
+	workbook := &xlsxWorkbook{
+		file:         excelize.NewFile(),
+		attachments:  map[string]Attachment{},
+		downloadPath: downloadPath,
+		linkDir:      linkDir,
+	}
+	defer func() {
+		_ = workbook.file.Close()
+	}()
+	for _, attachment := range backup.Attachments {
+		workbook.attachments[attachment.Id] = attachment
+	}
+	dictionary := BuildDataDictionary(backup.Tables)
+	names := backup.tableNames()
+	used := uniqueNames{}
+	// a new workbook starts with one empty sheet, which is renamed for the first table
+	defaultSheet := workbook.file.GetSheetName(0)
+	for i, table := range dictionary.sortedTables() {
+		sheet := xlsxSheetName(used, names[table])
+		if i == 0 {
+			if err := workbook.file.SetSheetName(defaultSheet, sheet); err != nil {
+				return err
+			}
+		} else if _, err := workbook.file.NewSheet(sheet); err != nil {
+			return err
+		}
+		if err := workbook.writeSheet(sheet, dictionary[table], backup.Tables[table]); err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+	}
+	return open("", func(w io.Writer) error {
+		return workbook.file.Write(w)
+	})
+}
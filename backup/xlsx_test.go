@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestExportXLSX(t *testing.T) {
+	attachment := map[string]interface{}{"id": "attAAAAAAAAAAAAAA", "url": "https://dl.airtable.com/a",
+		"filename": "photo.png", "size": 4.0}
+	backup := &Backup{
+		Tables: map[string][]api.Record{
+			"tblAAAAAAAAAAAAAA": {{Id: "recAAAAAAAAAAAAAA", CreatedTime: "2023-01-01T00:00:00.000Z",
+				Fields: map[string]interface{}{"Name": "Widget", "Count": 3.0, "Photo": []interface{}{attachment}}}},
+			"tblBBBBBBBBBBBBBB": {{Id: "recBBBBBBBBBBBBBB", Fields: map[string]interface{}{"Tags": []interface{}{"a"}}}},
+		},
+		Attachments: []Attachment{{Link: "https://dl.airtable.com/a", Id: "attAAAAAAAAAAAAAA", Size: 4,
+			Filename: "photo.png", File: "attAAAAAAAAAAAAAA"}},
+		Schemas: map[string]*api.BaseSchema{
+			"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets: all"}}},
+		},
+	}
+	dir := t.TempDir()
+	downloadDir := path.Join(dir, "dl")
+	if err := os.Mkdir(downloadDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(downloadDir, "attAAAAAAAAAAAAAA"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exportPath := path.Join(dir, "export.xlsx")
+	if err := ExportXLSX(backup, exportPath, downloadDir); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if sheets := f.GetSheetList(); !reflect.DeepEqual(sheets, []string{"Widgets_ all", "tblBBBBBBBBBBBBBB"}) {
+		t.Errorf("expected a sheet per table, found %v", sheets)
+	}
+	rows, err := f.GetRows("Widgets_ all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"id", "createdTime", "Count", "Name", "Photo"},
+		{"recAAAAAAAAAAAAAA", "2023-01-01T00:00:00.000Z", "3", "Widget", "photo.png"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+	found, link, err := f.GetCellHyperLink("Widgets_ all", "E2")
+	if err != nil || !found || link != "dl/attAAAAAAAAAAAAAA" {
+		t.Errorf("expected a link to the downloaded attachment, found %v %q %v", found, link, err)
+	}
+	if rows, err := f.GetRows("tblBBBBBBBBBBBBBB"); err != nil || rows[1][2] != `["a"]` {
+		t.Errorf("lists should be written as JSON: %v %v", rows, err)
+	}
+}
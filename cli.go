@@ -0,0 +1,880 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/backup"
+)
+
+// errUsage is returned by a command whose flags were invalid, after it has already printed its usage.
+var errUsage = errors.New("invalid usage")
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, name string, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"backup", "back up the configured tables and download their attachments", backupCommand},
+		{"serve", "run backups on the schedules in the configuration until stopped", serveCommand},
+		{"download", "download the attachments referenced by an existing backup", downloadCommand},
+		{"restore", "recreate the tables and records of a backup in another app", restoreCommand},
+		{"sync", "push the edits made to a local working copy to AirTable, and pull the changes made there",
+			syncCommand},
+		{"continuous", "keep an existing backup up to date with the changes that webhooks report",
+			continuousCommand},
+		{"verify", "check a backup and its attachments offline, or a download directory against its checksums",
+			verifyCommand},
+		{"check", "compare the latest backup with the records in AirTable now, without backing up", checkCommand},
+		{"gc", "remove downloaded attachments that no retained backup references", gcCommand},
+		{"list-tables", "list the tables in each configured app, and whether they are backed up", listTablesCommand},
+		{"diff", "report the records added, removed, and modified between two backups", diffCommand},
+		{"history", "list the changes to each record across the snapshots in a directory's catalog", historyCommand},
+		{"export", "convert an existing backup into another format", exportCommand},
+		{"search", "find the records of an existing backup that contain some words", searchCommand},
+		{"query", "print the parts of an existing backup that a jq program selects", queryCommand},
+		{"browse", "serve a read-only web page for looking through an existing backup", browseCommand},
+		{"mirror", "upsert the configured tables, or an existing backup, into a PostgreSQL or MySQL database",
+			mirrorCommand},
+		{"list-snapshots", "list the backups recorded in a directory's catalog", listSnapshotsCommand},
+		{"decrypt", "decrypt an encrypted backup or attachment, using $" + backup.EncryptionKeyEnv, decryptCommand},
+		{"oauth-login", "authorize the configured OAuth integration, and save its token", oauthLoginCommand},
+	}
+}
+
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-12s %s\n", c.name, c.summary)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// newFlagSet returns the flag set of a command, with the logging and debugging flags that every command accepts.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0]+" "+name, flag.ContinueOnError)
+	fs.String("log-format", "text", "format of the log messages: text or json")
+	fs.String("log-level", "info", "least severe level of log messages to print: debug, info, warn, or error")
+	fs.String("record-http", "", "directory to save every HTTP request and response in, without credentials, for "+
+		"debugging")
+	return fs
+}
+
+// listFlag is a flag that may be given more than once, collecting every value.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// newLogger returns a logger that writes to w in the given format, omitting messages below the given level.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var leveler slog.Level
+	if err := leveler.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: leveler}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// parseFlags parses the arguments of a command, none of which may be positional, and checks that every required
+// flag was given.
+func parseFlags(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		_, _ = fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return errUsage
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, name := range required {
+		if !set[name] {
+			_, _ = fmt.Fprintf(fs.Output(), "missing required flag: -%s\n", name)
+			fs.Usage()
+			return errUsage
+		}
+	}
+	if err := setLogOutput(fs, os.Stderr); err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), err.Error())
+		return errUsage
+	}
+	backup.RecordHTTP(fs.Lookup("record-http").Value.String())
+	return nil
+}
+
+// setLogOutput sends log messages to w, in the format and at the level chosen by the flags.
+func setLogOutput(fs *flag.FlagSet, w io.Writer) error {
+	logger, err := newLogger(w, fs.Lookup("log-format").Value.String(), fs.Lookup("log-level").Value.String())
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// isTerminal reports whether f is a terminal, rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// startHealthServer serves the health endpoints on listen, if it is not empty.
+func startHealthServer(listen string, health *backup.HealthServer) {
+	if listen == "" {
+		return
+	}
+	go func() {
+		if err := http.ListenAndServe(listen, health.Handler()); err != nil {
+			slog.Error("Health server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+}
+
+func backupCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "", "path to write the backup to, or an s3:// or gs:// URL such as s3://bucket/prefix/name.json")
+	downloads := fs.String("downloads", "", "directory to download attachments into, or an s3:// or gs:// URL")
+	apps := fs.String("apps", os.Getenv("VACUUM_TABLE_APPS"),
+		"comma-separated list of apps from the config to back up (default $VACUUM_TABLE_APPS, or all)")
+	var only listFlag
+	fs.Var(&only, "only", "back up only the app or table given as app:<app ID> or table:<table name or ID>; "+
+		"may be repeated")
+	listWorkers := fs.Int("list-workers", 0, "number of tables to list at once (overrides the config)")
+	downloadWorkers := fs.Int("download-workers", 0,
+		"number of attachments to download at once (overrides the config)")
+	bwlimit := fs.String("bwlimit", "", "most bytes per second to download attachments at, across all workers, "+
+		"such as 512K or 2M (overrides the config)")
+	listen := fs.String("listen", "", "address on which to serve /healthz, /readyz, and /metrics (e.g. :8080)")
+	readyMaxAge := fs.Duration("ready-max-age", backup.DefaultReadyMaxAge,
+		"maximum age of the last successful backup for /readyz")
+	retain := fs.String("retain", "", "write timestamped snapshots and prune old ones, keeping e.g. "+
+		"\"daily=7,weekly=4,monthly=12\" (also last=N and yearly=N; overrides the config)")
+	dryRun := fs.Bool("dry-run", false, "list the tables and check which attachments are already downloaded, "+
+		"printing what the backup would fetch, without writing anything")
+	keepGoing := fs.Bool("keep-going", false, "back up the other tables when some fail, recording the failures "+
+		"in the backup's metadata (overrides the config)")
+	fullVerify := fs.Bool("full-verify", false, "hash every attachment already downloaded, instead of trusting the "+
+		"checksums of files unchanged since they were downloaded (overrides the config)")
+	noProgress := fs.Bool("no-progress", false, "log each table and attachment instead of showing progress bars, "+
+		"even when stderr is a terminal")
+	if err := parseFlags(fs, args, "config", "output", "downloads"); err != nil {
+		return err
+	}
+	retention, err := backup.ParseRetentionPolicy(*retain)
+	if err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), err.Error())
+		fs.Usage()
+		return errUsage
+	}
+	bytesPerSecond, err := backup.ParseBandwidth(*bwlimit)
+	if err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), err.Error())
+		fs.Usage()
+		return errUsage
+	}
+	overrides := backup.Overrides{
+		Apps:            backup.ParseList(*apps),
+		ListWorkers:     *listWorkers,
+		DownloadWorkers: *downloadWorkers,
+		BytesPerSecond:  bytesPerSecond,
+		Retain:          retention,
+		KeepGoing:       *keepGoing,
+		FullVerify:      *fullVerify,
+		Only:            only,
+	}
+	if *dryRun {
+		opts, err := backup.LoadOptions(*configPath, *output, *downloads, overrides)
+		if err != nil {
+			return err
+		}
+		report, err := backup.DryRun(ctx, opts)
+		if err != nil {
+			return err
+		}
+		report.Print(os.Stdout)
+		return nil
+	}
+	if isTerminal(os.Stderr) && !*noProgress {
+		overrides.Progress = backup.NewProgress(os.Stderr, nil)
+		if err := setLogOutput(fs, overrides.Progress); err != nil {
+			return err
+		}
+	}
+	health := backup.NewHealthServer(*readyMaxAge, nil)
+	startHealthServer(*listen, health)
+	err = backup.RunConfigFile(ctx, *configPath, *output, *downloads, overrides)
+	overrides.Progress.Finish()
+	health.RecordRun(err)
+	return err
+}
+
+// serveCommand runs the backup daemon. The first Ctrl-C or SIGTERM stops scheduling backups and waits for the
+// running one to finish; a second one aborts it.
+func serveCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file, which is reloaded on SIGHUP")
+	output := fs.String("output", "", "path to write backups to, or an s3:// or gs:// URL; apps with their own "+
+		"schedules are written to a directory named after the app next to it")
+	downloads := fs.String("downloads", "", "directory to download attachments into, or an s3:// or gs:// URL")
+	listen := fs.String("listen", "", "address on which to serve /healthz, /readyz, and /metrics, with "+
+		"per-table record counts, request latencies, retries, and bytes downloaded (e.g. :8080)")
+	readyMaxAge := fs.Duration("ready-max-age", backup.DefaultReadyMaxAge,
+		"maximum age of the last successful backup for /readyz")
+	if err := parseFlags(fs, args, "config", "output", "downloads"); err != nil {
+		return err
+	}
+	reloader, err := backup.NewConfigReloader(*configPath)
+	if err != nil {
+		return err
+	}
+	health := backup.NewHealthServer(*readyMaxAge, nil)
+	health.Metrics = backup.NewMetrics()
+	startHealthServer(*listen, health)
+	runCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	go func() {
+		<-ctx.Done()
+		slog.Info("Shutting down after the current backup; interrupt again to abort it")
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			abort()
+		case <-runCtx.Done():
+		}
+	}()
+	daemon := &backup.Daemon{
+		Reloader:     reloader,
+		OutputPath:   *output,
+		DownloadPath: *downloads,
+		Metrics:      health.Metrics,
+		OnRun: func(_ string, err error) {
+			health.RecordRun(err)
+		},
+	}
+	return daemon.Serve(runCtx, ctx.Done())
+}
+
+func downloadCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	backupPath := fs.String("backup", "", "path to an existing backup")
+	downloads := fs.String("downloads", "", "directory to download attachments into, or an s3:// or gs:// URL")
+	prefixes := fs.String("attachment-prefixes", "",
+		"comma-separated link prefixes to download attachments from (default: the known AirTable hosts)")
+	lenientPrefixes := fs.Bool("lenient-attachment-prefixes", false,
+		"skip attachments with unrecognized link prefixes instead of failing")
+	skipInvalid := fs.Bool("skip-invalid-attachments", false,
+		"skip attachments that cannot be extracted instead of failing, after reporting them")
+	workers := fs.Int("download-workers", backup.DefaultDownloadWorkers, "number of attachments to download at once")
+	namedFiles := fs.Bool("named-files", false, "save attachments as <id>_<filename> instead of just <id>")
+	bwlimit := fs.String("bwlimit", "", "most bytes per second to download attachments at, across all workers, "+
+		"such as 512K or 2M")
+	fullVerify := fs.Bool("full-verify", false, "hash every attachment already downloaded, instead of trusting the "+
+		"checksums of files unchanged since they were downloaded")
+	if err := parseFlags(fs, args, "backup", "downloads"); err != nil {
+		return err
+	}
+	bytesPerSecond, err := backup.ParseBandwidth(*bwlimit)
+	if err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), err.Error())
+		fs.Usage()
+		return errUsage
+	}
+	client, err := backup.HTTPSettings{}.Client()
+	if err != nil {
+		return err
+	}
+	return backup.DownloadAttachmentsFromBackup(ctx, *backupPath, *downloads, client,
+		backup.ExtractOptions{
+			AttachmentPrefixes:     backup.ParseList(*prefixes),
+			LenientPrefixes:        *lenientPrefixes,
+			SkipInvalidAttachments: *skipInvalid,
+		},
+		backup.DownloadOptions{
+			SizeMismatchRetries: backup.DefaultSizeMismatchRetries,
+			Workers:             *workers,
+			NamedFiles:          *namedFiles,
+			BytesPerSecond:      bytesPerSecond,
+			FullVerify:          *fullVerify,
+		})
+}
+
+func restoreCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file, for the token and API settings")
+	backupPath := fs.String("backup", "", "path to the backup to restore")
+	app := fs.String("app", "", "ID of the app to restore into")
+	attachmentBaseURL := fs.String("attachment-base-url", "",
+		"URL under which the downloaded attachments are served for re-upload")
+	downloads := fs.String("downloads", "", "directory the attachments were downloaded into, to upload them from "+
+		"(unless -attachment-base-url is given)")
+	if err := parseFlags(fs, args, "config", "backup", "app"); err != nil {
+		return err
+	}
+	return backup.RestoreConfigFile(ctx, *configPath, *backupPath, *app,
+		backup.RestoreOptions{AttachmentBaseURL: *attachmentBaseURL, DownloadPath: *downloads})
+}
+
+func syncCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file")
+	dir := fs.String("dir", "", "directory holding the working copy, with a JSON Lines file for each table")
+	prefer := fs.String("prefer", "", "resolve records changed both locally and in AirTable by keeping the local "+
+		"or remote version; by default, they are reported as conflicts and left alone")
+	var only listFlag
+	fs.Var(&only, "only", "sync only the app or table given as app:<app ID> or table:<table name or ID>; "+
+		"may be repeated")
+	if err := parseFlags(fs, args, "config", "dir"); err != nil {
+		return err
+	}
+	config, err := backup.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if len(only) > 0 {
+		if config, err = config.SelectOnly(only); err != nil {
+			return err
+		}
+	}
+	report, err := backup.Sync(ctx, backup.SyncOptions{Config: config, Dir: *dir, Prefer: *prefer})
+	if report != nil {
+		report.Print(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Conflicts) > 0 {
+		return fmt.Errorf("%d records have conflicts; sync again with -prefer local or -prefer remote to resolve them",
+			len(report.Conflicts))
+	}
+	return nil
+}
+
+func continuousCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file")
+	backupPath := fs.String("backup", "", "path to a local backup of the configured tables, which is updated in place")
+	notificationURL := fs.String("url", "", "public URL at which AirTable can reach the listener, for the webhooks")
+	listen := fs.String("listen", ":8080", "address on which to receive webhook notifications")
+	unregister := fs.Bool("unregister", false, "delete the webhooks registered for the backup, and stop")
+	if err := parseFlags(fs, args, "config", "backup"); err != nil {
+		return err
+	}
+	config, err := backup.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *unregister {
+		return backup.UnregisterContinuous(ctx, config, nil, *backupPath)
+	}
+	continuous, err := backup.StartContinuous(ctx, backup.ContinuousOptions{
+		Config:          config,
+		BackupPath:      *backupPath,
+		NotificationURL: *notificationURL,
+	})
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: *listen, Handler: continuous.Handler()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Notification listener failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+	err = continuous.Run(ctx)
+	_ = server.Close()
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func verifyCommand(_ context.Context, name string, args []string) error {
+	// 'verify <backup.json> <download dir>' is shorthand for the flags
+	if len(args) == 2 && !strings.HasPrefix(args[0], "-") && !strings.HasPrefix(args[1], "-") {
+		args = []string{"-backup", args[0], "-downloads", args[1]}
+	}
+	fs := newFlagSet(name)
+	backupPath := fs.String("backup", "", "backup to check, along with the attachments it references")
+	downloads := fs.String("downloads", "", "download directory to check")
+	fullVerify := fs.Bool("full-verify", false, "hash every file, even those unchanged since they were downloaded")
+	if err := parseFlags(fs, args, "downloads"); err != nil {
+		return err
+	}
+	if *backupPath == "" {
+		return backup.VerifyDownloads(*downloads, *fullVerify, os.Stdout)
+	}
+	key, err := backup.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	return backup.VerifyBackup(*backupPath, *downloads, key, os.Stdout)
+}
+
+func checkCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "", "path the backups are written to, as given to backup")
+	maxAge := fs.Duration("max-age", 0, "fail if any table has changed and the backup is older than this")
+	if err := parseFlags(fs, args, "config", "output"); err != nil {
+		return err
+	}
+	config, err := backup.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	report, err := backup.CheckDrift(ctx, backup.Options{Config: config, OutputPath: *output})
+	if err != nil {
+		return err
+	}
+	report.Print(os.Stdout)
+	if *maxAge > 0 && report.Changed() && report.Age() > *maxAge {
+		return fmt.Errorf("backup has changed tables and is older than %s", *maxAge)
+	}
+	return nil
+}
+
+func gcCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file")
+	output := fs.String("output", "", "path the backups are written to, as given to backup")
+	downloads := fs.String("downloads", "", "download directory to collect garbage from")
+	retain := fs.String("retain", "", "retention policy the backups are written with, if not the config's; "+
+		"every snapshot in the catalog is then kept")
+	dryRun := fs.Bool("dry-run", false, "print the files that would be removed, without removing them")
+	if err := parseFlags(fs, args, "config", "output", "downloads"); err != nil {
+		return err
+	}
+	retention, err := backup.ParseRetentionPolicy(*retain)
+	if err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), err.Error())
+		fs.Usage()
+		return errUsage
+	}
+	opts, err := backup.LoadOptions(*configPath, *output, *downloads, backup.Overrides{Retain: retention})
+	if err != nil {
+		return err
+	}
+	report, err := backup.CollectGarbage(ctx, opts, *dryRun)
+	if report != nil {
+		report.Print(os.Stdout)
+	}
+	return err
+}
+
+func listTablesCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file")
+	if err := parseFlags(fs, args, "config"); err != nil {
+		return err
+	}
+	config, err := backup.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	client, err := config.HTTP.Client()
+	if err != nil {
+		return err
+	}
+	schemas, err := backup.FetchSchemas(ctx, config, client)
+	if err != nil {
+		return err
+	}
+	discovered, err := backup.DiscoverTables(ctx, config, client, schemas)
+	if err != nil {
+		return err
+	}
+	return printTables(os.Stdout, schemas, discovered.Tables)
+}
+
+func oauthLoginCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file, with the oauth settings")
+	if err := parseFlags(fs, args, "config"); err != nil {
+		return err
+	}
+	config, err := backup.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if config.OAuth == nil {
+		return errors.New("the configuration has no oauth settings")
+	}
+	client, err := config.HTTP.Client()
+	if err != nil {
+		return err
+	}
+	return oauthLogin(ctx, config.OAuth, client, os.Stdin, os.Stdout)
+}
+
+// oauthLogin has the user authorize the integration in their browser and paste back the address it redirected to,
+// and then saves the token that it grants.
+func oauthLogin(ctx context.Context, settings *backup.OAuthSettings, client *http.Client, in io.Reader,
+	out io.Writer) error {
+	verifier, err := api.NewCodeVerifier()
+	if err != nil {
+		return err
+	}
+	state, err := api.NewCodeVerifier()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "Open this page to authorize the integration:\n\n  %s\n\n"+
+		"Then paste the address that it redirects to: ", settings.AuthorizeURL(state, verifier))
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	redirect, err := url.Parse(strings.TrimSpace(line))
+	if err != nil {
+		return err
+	}
+	query := redirect.Query()
+	if query.Get("error") != "" {
+		return fmt.Errorf("authorization failed: %s: %s", query.Get("error"), query.Get("error_description"))
+	}
+	if query.Get("state") != state || query.Get("code") == "" {
+		return errors.New("the address is not the redirect from this authorization")
+	}
+	token, err := settings.Exchange(ctx, client, query.Get("code"), verifier, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := settings.Store().Save(token); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "Saved the token to %s\n", settings.TokenStore)
+	return nil
+}
+
+func printTables(w io.Writer, schemas map[string]*api.BaseSchema, backedUp map[string][]string) error {
+	apps := make([]string, 0, len(schemas))
+	for app := range schemas {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "APP\tTABLE\tNAME\tBACKED UP")
+	for _, app := range apps {
+		included := map[string]bool{}
+		for _, table := range backedUp[app] {
+			included[table] = true
+		}
+		for _, table := range schemas[app].Tables {
+			status := "no"
+			if included[table.Id] {
+				status = "yes"
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", app, table.Id, table.Name, status)
+		}
+	}
+	return tw.Flush()
+}
+
+func diffCommand(_ context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	oldPath := fs.String("old", "", "path to the earlier backup")
+	newPath := fs.String("new", "", "path to the later backup")
+	if err := parseFlags(fs, args, "old", "new"); err != nil {
+		return err
+	}
+	old, err := backup.Load(*oldPath)
+	if err != nil {
+		return err
+	}
+	new, err := backup.Load(*newPath)
+	if err != nil {
+		return err
+	}
+	backup.DiffBackups(old, new).Print(os.Stdout)
+	return nil
+}
+
+// parseTimeRange parses the -since and -until flags of a command, either of which may be empty to leave that end of
+// the range open.
+func parseTimeRange(since, until string) (sinceTime, untilTime time.Time, err error) {
+	now := time.Now()
+	if since != "" {
+		if sinceTime, err = backup.ParseTime(since, now); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if until != "" {
+		if untilTime, err = backup.ParseTime(until, now); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return sinceTime, untilTime, nil
+}
+
+func historyCommand(_ context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	dir := fs.String("dir", "", "directory containing the snapshots and their catalog")
+	table := fs.String("table", "", "only list the changes to this table, by ID or name")
+	record := fs.String("record", "", "only list the changes to this record")
+	since := fs.String("since", "", "only list the changes found by snapshots taken since this time, date, or "+
+		"duration ago, such as 7d")
+	until := fs.String("until", "", "only list the changes found by snapshots taken until this time, date, or "+
+		"duration ago")
+	asJSON := fs.Bool("json", false, "print each change as a line of JSON")
+	if err := parseFlags(fs, args, "dir"); err != nil {
+		return err
+	}
+	filter := backup.HistoryFilter{Table: *table, Record: *record}
+	var err error
+	if filter.Since, filter.Until, err = parseTimeRange(*since, *until); err != nil {
+		return err
+	}
+	key, err := backup.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	history, err := backup.BuildHistory(*dir, key, filter)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return history.PrintJSON(os.Stdout)
+	}
+	history.Print(os.Stdout)
+	return nil
+}
+
+func exportCommand(_ context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	backupPath := fs.String("backup", "", "path to an existing backup")
+	output := fs.String("output", "", "path to write the export to (a directory, for csv and parquet), or - for "+
+		"standard output")
+	format := fs.String("format", "sqlite", "format to convert to ("+backup.ExportFormats()+")")
+	downloads := fs.String("downloads", "", "directory the backup's attachments were downloaded into, to link to "+
+		"them (xlsx only)")
+	if err := parseFlags(fs, args, "backup", "output"); err != nil {
+		return err
+	}
+	if *output == "-" {
+		return backup.ExportTo(*backupPath, backup.WriterOpener(os.Stdout), *format, *downloads)
+	}
+	return backup.Export(*backupPath, *output, *format, *downloads)
+}
+
+func searchCommand(_ context.Context, name string, args []string) error {
+	// 'search <backup.json> <query>' is shorthand for the flags
+	if len(args) == 2 && !strings.HasPrefix(args[0], "-") && !strings.HasPrefix(args[1], "-") {
+		args = []string{"-backup", args[0], "-query", args[1]}
+	}
+	fs := newFlagSet(name)
+	backupPath := fs.String("backup", "", "path to an existing backup")
+	query := fs.String("query", "", "words that every matching record contains, in any of its text fields")
+	saveIndex := fs.Bool("save-index", false, "save the index next to the backup, as <backup>"+
+		backup.SearchIndexSuffix+", to answer later searches without loading the backup")
+	if err := parseFlags(fs, args, "backup", "query"); err != nil {
+		return err
+	}
+	key, err := backup.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	index, err := backup.OpenSearchIndex(*backupPath, key, *saveIndex)
+	if err != nil {
+		return err
+	}
+	index.Search(*query).Print(os.Stdout)
+	return nil
+}
+
+func queryCommand(ctx context.Context, name string, args []string) error {
+	// 'query <backup.json> <program>' is shorthand for the flags
+	if len(args) == 2 && !strings.HasPrefix(args[0], "-") && !strings.HasPrefix(args[1], "-") {
+		args = []string{"-backup", args[0], "-program", args[1]}
+	}
+	fs := newFlagSet(name)
+	backupPath := fs.String("backup", "", "path to an existing backup")
+	program := fs.String("program", "", "jq program to run over the backup, such as "+
+		"'.tables.tblX[] | select(.fields.Status == \"Open\")'")
+	raw := fs.Bool("raw", false, "print strings as they are, instead of as JSON")
+	if err := parseFlags(fs, args, "backup", "program"); err != nil {
+		return err
+	}
+	existing, err := backup.Load(*backupPath)
+	if err != nil {
+		return err
+	}
+	return backup.PrintQuery(ctx, existing, *program, *raw, os.Stdout)
+}
+
+func browseCommand(_ context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	backupPath := fs.String("backup", "", "path to an existing backup")
+	downloads := fs.String("downloads", "", "directory the backup's attachments were downloaded into, to show them")
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve the web page on")
+	if err := parseFlags(fs, args, "backup"); err != nil {
+		return err
+	}
+	key, err := backup.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	existing, err := backup.LoadWithKey(*backupPath, key)
+	if err != nil {
+		return err
+	}
+	slog.Info("Serving backup", "backup", *backupPath, "url", "http://"+*listen+"/")
+	return http.ListenAndServe(*listen, backup.NewBrowser(existing, *downloads, key).Handler())
+}
+
+func mirrorCommand(ctx context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	configPath := fs.String("config", "", "path to the configuration file, to list the configured tables")
+	backupPath := fs.String("backup", "", "path to an existing backup to mirror, instead of listing the tables")
+	postgres := fs.String("postgres", "", "connection string of the PostgreSQL database to mirror into")
+	mysql := fs.String("mysql", "", "connection string of the MySQL or MariaDB database to mirror into, "+
+		"as user:password@tcp(host:3306)/database")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*configPath == "") == (*backupPath == "") {
+		return errors.New("exactly one of -config and -backup is required")
+	}
+	target := backup.MirrorTarget{Driver: backup.MirrorPostgres, DSN: *postgres}
+	if *mysql != "" {
+		target = backup.MirrorTarget{Driver: backup.MirrorMySQL, DSN: *mysql}
+	}
+	if (*postgres == "") == (*mysql == "") {
+		return errors.New("exactly one of -postgres and -mysql is required")
+	}
+	if *backupPath != "" {
+		existing, err := backup.Load(*backupPath)
+		if err != nil {
+			return err
+		}
+		return backup.MirrorBackup(ctx, target, existing)
+	}
+	config, err := backup.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	return backup.Mirror(ctx, backup.Options{Config: config}, target)
+}
+
+func listSnapshotsCommand(_ context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	dir := fs.String("dir", "", "directory containing the backups")
+	since := fs.String("since", "", "only list the backups taken since this time, date, or duration ago, such as 7d")
+	until := fs.String("until", "", "only list the backups taken until this time, date, or duration ago")
+	asJSON := fs.Bool("json", false, "print each backup as a line of JSON, with its path relative to the directory")
+	if err := parseFlags(fs, args, "dir"); err != nil {
+		return err
+	}
+	sinceTime, untilTime, err := parseTimeRange(*since, *until)
+	if err != nil {
+		return err
+	}
+	catalog, err := backup.LoadCatalog(*dir)
+	if err != nil {
+		return err
+	}
+	catalog = catalog.Between(sinceTime, untilTime)
+	if *asJSON {
+		return catalog.PrintJSON(os.Stdout)
+	}
+	catalog.Print(os.Stdout)
+	return nil
+}
+
+func decryptCommand(_ context.Context, name string, args []string) error {
+	fs := newFlagSet(name)
+	input := fs.String("input", "", "path to the encrypted file")
+	output := fs.String("output", "", "path to write the decrypted file to")
+	if err := parseFlags(fs, args, "input", "output"); err != nil {
+		return err
+	}
+	key, err := backup.KeyFromEnvironment()
+	if err != nil {
+		return err
+	}
+	return backup.DecryptFile(*input, *output, key)
+}
+
+// runCLI runs the command named by the first argument and returns the process's exit code. For compatibility with
+// earlier versions, three positional arguments are still accepted as a backup.
+func runCLI(args []string) int {
+	if len(args) == 3 && !strings.HasPrefix(args[0], "-") {
+		if _, err := os.Stat(args[0]); err == nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: positional arguments are deprecated; use '%s backup -config %s "+
+				"-output %s -downloads %s'\n", os.Args[0], args[0], args[1], args[2])
+			args = []string{"backup", "-config", args[0], "-output", args[1], "-downloads", args[2]}
+		}
+	}
+	if len(args) > 0 && args[0] == "catalog" {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: the catalog command is deprecated; use '%s list-snapshots'\n",
+			os.Args[0])
+		args = append([]string{"list-snapshots"}, args[1:]...)
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		// On Ctrl-C or SIGTERM, in-flight requests are cancelled and no backup file is written. Partial downloads
+		// are left in their temporary files, to be resumed by the next run.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := c.run(ctx, c.name, args[1:])
+		if errors.Is(err, errUsage) {
+			return 2
+		} else if err != nil {
+			slog.Error("Command failed", "command", c.name, "error", err)
+			return 1
+		}
+		return 0
+	}
+	if args[0] != "-h" && args[0] != "-help" && args[0] != "--help" && args[0] != "help" {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	}
+	usage()
+	return 2
+}
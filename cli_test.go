@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/api"
+	"github.com/celskeggs/vacuum-table/backup"
+)
+
+func TestParseFlagsRequiresFlags(t *testing.T) {
+	fs := newFlagSet("test")
+	fs.SetOutput(io.Discard)
+	fs.String("config", "", "")
+	fs.String("output", "", "")
+	if err := parseFlags(fs, []string{"-config", "c.json"}, "config", "output"); !errors.Is(err, errUsage) {
+		t.Errorf("missing -output should be a usage error, got %v", err)
+	}
+	fs = newFlagSet("test")
+	fs.SetOutput(io.Discard)
+	fs.String("config", "", "")
+	if err := parseFlags(fs, []string{"-config", "c.json", "extra"}, "config"); !errors.Is(err, errUsage) {
+		t.Errorf("positional arguments should be a usage error, got %v", err)
+	}
+	fs = newFlagSet("test")
+	config := fs.String("config", "", "")
+	if err := parseFlags(fs, []string{"-config", "c.json"}, "config"); err != nil || *config != "c.json" {
+		t.Errorf("unexpected result: %q %v", *config, err)
+	}
+}
+
+func TestPrintTables(t *testing.T) {
+	schemas := map[string]*api.BaseSchema{
+		"appAAAAAAAAAAAAAA": {Tables: []api.TableSchema{
+			{Id: "tblAAAAAAAAAAAAAA", Name: "Widgets"},
+			{Id: "tblBBBBBBBBBBBBBB", Name: "Archive"},
+		}},
+	}
+	var out bytes.Buffer
+	if err := printTables(&out, schemas, map[string][]string{"appAAAAAAAAAAAAAA": {"tblAAAAAAAAAAAAAA"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "APP                TABLE              NAME     BACKED UP\n" +
+		"appAAAAAAAAAAAAAA  tblAAAAAAAAAAAAAA  Widgets  yes\n" +
+		"appAAAAAAAAAAAAAA  tblBBBBBBBBBBBBBB  Archive  no\n"
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := newLogger(&out, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "table", "tblAAAAAAAAAAAAAA")
+	if !strings.Contains(out.String(), `"msg":"shown","table":"tblAAAAAAAAAAAAAA"`) ||
+		strings.Contains(out.String(), "hidden") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+	if _, err := newLogger(&out, "xml", "info"); err == nil {
+		t.Error("unknown formats should be rejected")
+	}
+	if _, err := newLogger(&out, "text", "loud"); err == nil {
+		t.Error("unknown levels should be rejected")
+	}
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOAuthLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "granted" || r.FormValue("code_verifier") == "" {
+			t.Errorf("unexpected token request: %v", r.Form)
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := &backup.OAuthSettings{
+		OAuthConfig: api.OAuthConfig{ClientId: "client", RedirectURI: "http://localhost/done"},
+		TokenStore:  path.Join(t.TempDir(), "token.json"),
+	}
+	var out bytes.Buffer
+	// the redirect can only be pasted once the authorization page, with its state, has been printed
+	in := readerFunc(func(p []byte) (int, error) {
+		page := strings.Fields(out.String())[7]
+		parsed, err := url.Parse(page)
+		if err != nil {
+			return 0, err
+		}
+		redirect := "http://localhost/done?code=granted&state=" + url.QueryEscape(parsed.Query().Get("state")) + "\n"
+		return copy(p, redirect), io.EOF
+	})
+	client := &http.Client{Transport: redirectTransport{target: target}}
+	if err := oauthLogin(context.Background(), settings, client, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	token, err := settings.Store().Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == nil || token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("expected the token to be saved, got %v", token)
+	}
+}
@@ -0,0 +1,82 @@
+// Package clock abstracts over the passage of time, so that retries, rate limits, and timestamps can be tested
+// without real sleeps.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (Real) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Or returns c, or the wall clock if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real{}
+	}
+	return c
+}
+
+// Fake is a clock that only moves when told to. Sleeping or waiting on it advances it immediately by the requested
+// duration, and each such wait is recorded.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sleeps = append(f.sleeps, d)
+	f.now = f.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- f.now
+	return ch
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Sleeps returns every duration that has been slept or waited for, in order.
+func (f *Fake) Sleeps() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Duration(nil), f.sleeps...)
+}
@@ -0,0 +1,45 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeAdvancesWhenWaitedOn(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFake(start)
+	fake.Sleep(time.Second)
+	if !fake.Now().Equal(start.Add(time.Second)) {
+		t.Errorf("sleeping should advance the clock, but it reads %v", fake.Now())
+	}
+	select {
+	case fired := <-fake.After(time.Minute):
+		if !fired.Equal(start.Add(time.Minute + time.Second)) {
+			t.Errorf("After should deliver the time it fires at, not %v", fired)
+		}
+	default:
+		t.Fatal("After should fire immediately")
+	}
+	if !fake.Now().Equal(start.Add(time.Minute + time.Second)) {
+		t.Errorf("waiting should advance the clock, but it reads %v", fake.Now())
+	}
+	fake.Advance(time.Hour)
+	if !fake.Now().Equal(start.Add(time.Hour + time.Minute + time.Second)) {
+		t.Errorf("Advance should move the clock, but it reads %v", fake.Now())
+	}
+	// only sleeps and waits are recorded, not advances
+	if sleeps := fake.Sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{time.Second, time.Minute}) {
+		t.Errorf("unexpected sleeps: %v", sleeps)
+	}
+}
+
+func TestOrDefaultsToTheWallClock(t *testing.T) {
+	if _, ok := Or(nil).(Real); !ok {
+		t.Error("a nil clock should be the wall clock")
+	}
+	fake := NewFake(time.Time{})
+	if Or(fake) != Clock(fake) {
+		t.Error("a clock that is given should be kept")
+	}
+}
@@ -0,0 +1,110 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/celskeggs/vacuum-table/s3"
+	"github.com/celskeggs/vacuum-table/s3/s3test"
+)
+
+type failingReader struct {
+	r io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestPutAndGet(t *testing.T) {
+	server := s3test.NewServer(t)
+	client := s3.NewClient(server.ClientConfig(), server.Client())
+	ctx := context.Background()
+	large := bytes.Repeat([]byte("0123456789"), s3.PartSize/10*2+7)
+	for key, data := range map[string][]byte{"small file.txt": []byte("hello"), "large": large} {
+		if err := client.Put(ctx, "bucket", "prefix/"+key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		size, found, err := client.Head(ctx, "bucket", "prefix/"+key)
+		if err != nil || !found || size != int64(len(data)) {
+			t.Errorf("%s: unexpected head result: %d %v %v", key, size, found, err)
+		}
+		body, err := client.Get(ctx, "bucket", "prefix/"+key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := io.ReadAll(body)
+		_ = body.Close()
+		if err != nil || !bytes.Equal(read, data) {
+			t.Errorf("%s: unexpected contents (%d bytes): %v", key, len(read), err)
+		}
+	}
+	if server.Requests("POST") != 2 {
+		t.Errorf("the large object should have been sent as a multipart upload")
+	}
+	if _, found, err := client.Head(ctx, "bucket", "missing"); found || err != nil {
+		t.Errorf("missing object should not be found: %v %v", found, err)
+	}
+	if _, err := client.Get(ctx, "bucket", "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+	err := client.Put(ctx, "bucket", "broken", failingReader{bytes.NewReader(large)})
+	if err == nil {
+		t.Error("a failed read should fail the upload")
+	}
+	if _, found := server.Object("bucket/broken"); found || server.PendingUploads() != 0 {
+		t.Error("a failed upload should have been aborted")
+	}
+}
+
+func TestKeysAreEscaped(t *testing.T) {
+	server := s3test.NewServer(t)
+	client := s3.NewClient(server.ClientConfig(), server.Client())
+	ctx := context.Background()
+	for _, key := range []string{"what?.txt", "100%.txt", "#1 file.txt"} {
+		if err := client.Put(ctx, "bucket", "prefix/"+key, bytes.NewReader([]byte("hello"))); err != nil {
+			t.Fatal(err)
+		}
+		if _, found := server.Object("bucket/prefix/" + key); !found {
+			t.Errorf("expected %q to be stored under its own name, found %v", key, server.Names())
+		}
+	}
+}
+
+// cancellingReader cancels the upload's context once its first part has been read.
+type cancellingReader struct {
+	r      io.Reader
+	read   int
+	cancel context.CancelFunc
+}
+
+func (c *cancellingReader) Read(p []byte) (int, error) {
+	if c.read >= s3.PartSize {
+		c.cancel()
+	}
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestCancelledUploadIsAborted(t *testing.T) {
+	server := s3test.NewServer(t)
+	client := s3.NewClient(server.ClientConfig(), server.Client())
+	ctx, cancel := context.WithCancel(context.Background())
+	large := bytes.Repeat([]byte("0123456789"), s3.PartSize/10*2+7)
+	reader := &cancellingReader{r: bytes.NewReader(large), cancel: cancel}
+	if err := client.Put(ctx, "bucket", "cancelled", reader); err == nil {
+		t.Fatal("a cancelled upload should fail")
+	}
+	if server.PendingUploads() != 0 {
+		t.Error("a cancelled upload should have been aborted")
+	}
+}
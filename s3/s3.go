@@ -0,0 +1,391 @@
+// Package s3 is a minimal client for S3-compatible object storage, covering just what backups need: checking for,
+// reading, and streaming objects. Requests are signed with AWS Signature Version 4.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celskeggs/vacuum-table/clock"
+)
+
+// PartSize is the size of each part of a multipart upload. Objects smaller than this are sent in a single request.
+const PartSize = 8 * 1024 * 1024
+
+// abortTimeout bounds the request that aborts a failed multipart upload, which is sent even if the upload failed
+// because its context was cancelled.
+const abortTimeout = 30 * time.Second
+
+type Config struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	// Endpoint, if set, is the base URL of an S3-compatible service such as MinIO. Buckets are then addressed by
+	// path instead of by hostname.
+	Endpoint string
+	// Clock provides the signing time; nil means the wall clock.
+	Clock clock.Clock
+}
+
+// GCSEndpoint is the interoperable XML API of Google Cloud Storage, which accepts these requests when they are signed
+// with an HMAC key.
+const GCSEndpoint = "https://storage.googleapis.com"
+
+// ConfigFromEnvironment reads the standard AWS_* environment variables.
+func ConfigFromEnvironment() Config {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return Config{
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          region,
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL"),
+	}
+}
+
+type Client struct {
+	Config
+	Client *http.Client
+}
+
+func NewClient(config Config, client *http.Client) *Client {
+	return &Client{Config: config, Client: client}
+}
+
+// Error is an error response from the service.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s (HTTP status %d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// escapePath percent-encodes everything but unreserved characters and slashes, as signing requires.
+func escapePath(p string) string {
+	var b strings.Builder
+	for _, c := range []byte(p) {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectURL addresses an object. The key is added to the path after parsing, so that characters like '?' and '%' in it
+// are escaped rather than interpreted.
+func (c *Client) objectURL(bucket, key string, query url.Values) (*url.URL, error) {
+	base, prefix := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, c.Region), "/"
+	if c.Endpoint != "" {
+		base, prefix = c.Endpoint, "/"+bucket+"/"
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + prefix + key
+	u.RawPath = escapePath(u.Path)
+	// url.Values.Encode writes spaces as "+", which signing does not allow
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	return u, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds the AWS Signature Version 4 headers to a request whose body has the given SHA-256.
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	now := clock.Or(c.Clock).Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyId, scope, signedHeaders, signature))
+}
+
+// do sends a signed request with the given body, and returns the response if its status is a success.
+func (c *Client) do(ctx context.Context, method, bucket, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u, err := c.objectURL(bucket, key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+	c.sign(req, hashHex(body))
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		s3Err := &Error{StatusCode: resp.StatusCode}
+		if data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024)); err == nil {
+			_ = xml.Unmarshal(data, s3Err)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("s3://%s/%s: %w (%v)", bucket, key, fs.ErrNotExist, s3Err)
+		}
+		return nil, s3Err
+	}
+	return resp, nil
+}
+
+// Head returns the size of an object, or found=false if there is no such object.
+func (c *Client) Head(ctx context.Context, bucket, key string) (size int64, found bool, err error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, key, nil, nil, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	_ = resp.Body.Close()
+	return resp.ContentLength, true, nil
+}
+
+// Get opens an object for reading. A missing object is reported as an error wrapping fs.ErrNotExist.
+func (c *Client) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put streams r into an object. Small objects are sent in one request, and anything larger than PartSize as a
+// multipart upload, so that only one part is held in memory at a time. The object is only created if r is read to
+// its end without an error; otherwise, any partial upload is aborted.
+func (c *Client) Put(ctx context.Context, bucket, key string, r io.Reader) error {
+	part := make([]byte, PartSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, part[:n], nil)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	} else if err != nil {
+		return err
+	}
+	uploadId, err := c.createMultipartUpload(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := c.uploadParts(ctx, bucket, key, uploadId, part, r); err != nil {
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+		defer cancel()
+		resp, abortErr := c.do(abortCtx, http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadId}}, nil, nil)
+		if abortErr == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// Copy copies an object within a bucket, without downloading it. Objects larger than 5GiB cannot be copied this way.
+func (c *Client) Copy(ctx context.Context, bucket, sourceKey, destKey string) error {
+	header := http.Header{"X-Amz-Copy-Source": {escapePath("/" + bucket + "/" + sourceKey)}}
+	resp, err := c.do(ctx, http.MethodPut, bucket, destKey, nil, nil, header)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	// like a multipart completion, a copy can fail after the 200 status has been sent
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3Err := &Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, s3Err)
+		return s3Err
+	}
+	return nil
+}
+
+// Delete removes an object. Deleting an object that does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, bucket, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, bucket, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) createMultipartUpload(ctx context.Context, bucket, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var result struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.UploadId == "" {
+		return "", errors.New("s3: no upload ID in response")
+	}
+	return result.UploadId, nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts uploads the first part, which has already been read, and then the rest of r, before completing the
+// upload.
+func (c *Client) uploadParts(ctx context.Context, bucket, key, uploadId string, part []byte, r io.Reader) error {
+	var parts []completedPart
+	for number := 1; len(part) > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId}}
+		resp, err := c.do(ctx, http.MethodPut, bucket, key, query, part, nil)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		n, err := io.ReadFull(r, part[:cap(part)])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		part = part[:n]
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadId}}, body, header)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	// a completion can fail after the 200 status has been sent, in which case the body holds the error
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3Err := &Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, s3Err)
+		return s3Err
+	}
+	return nil
+}
+
+// GCSConfigFromEnvironment reads the HMAC key for Google Cloud Storage from GCS_ACCESS_KEY_ID and
+// GCS_SECRET_ACCESS_KEY. GCS_ENDPOINT_URL overrides GCSEndpoint.
+func GCSConfigFromEnvironment() Config {
+	endpoint := os.Getenv("GCS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = GCSEndpoint
+	}
+	return Config{
+		AccessKeyId:     os.Getenv("GCS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("GCS_SECRET_ACCESS_KEY"),
+		Region:          "auto",
+		Endpoint:        endpoint,
+	}
+}
+
+// ParseURL splits a URL like s3://bucket/prefix or gs://bucket/prefix into its bucket and key prefix.
+func ParseURL(location string) (bucket, prefix string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+		return "", "", fmt.Errorf("not a valid s3:// or gs:// URL: %q", location)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}